@@ -3,12 +3,17 @@ import { io, Socket } from 'socket.io-client';
 
 export interface FaceDetectionAlert {
   id: string;
+  eventId?: string;
   cameraId: string;
+  tenantId?: string;
+  siteId?: string;
   cameraName: string;
   faceCount: number;
   confidence: number;
   imageData: string; // base64
   detectedAt: string;
+  localTime?: string; // detectedAt in the camera's timezone, with offset
+  timezone?: string;
   metadata?: {
     faces?: Array<{
       x: number;
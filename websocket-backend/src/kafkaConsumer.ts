@@ -1,12 +1,18 @@
 import { Kafka, Consumer, EachMessagePayload } from 'kafkajs';
 
 interface FaceDetectionAlert {
+  eventId?: string; // Unique per alert; used to drop redelivered messages
   cameraId: string;
+  tenantId?: string;
+  siteId?: string;
   cameraName: string;
   faceCount: number;
   confidence: number;
   imageData: string;
-  detectedAt: string;
+  detectedAt: string; // UTC
+  localTime?: string; // detectedAt in the camera's timezone, with offset
+  timezone?: string;
+  clipPath?: string;
   metadata?: {
     faces?: Array<{
       x: number;
@@ -22,6 +28,9 @@ export class KafkaConsumerService {
   private consumer: Consumer;
   private isRunning: boolean = false;
   private messageHandler?: (alert: FaceDetectionAlert) => void;
+  // Recently seen event IDs, oldest first; Kafka delivers at-least-once
+  private recentEventIds: Set<string> = new Set();
+  private static readonly MAX_RECENT_EVENT_IDS = 1000;
 
   constructor(brokers: string[], groupId: string, topic: string) {
     console.log('[Kafka] Initializing Kafka consumer...');
@@ -95,9 +104,27 @@ export class KafkaConsumerService {
 
       const alertData: FaceDetectionAlert = JSON.parse(messageString);
 
+      if (alertData.eventId) {
+        if (this.recentEventIds.has(alertData.eventId)) {
+          console.log('[Kafka] ⏭️ Skipping duplicate alert:', alertData.eventId);
+          return;
+        }
+        this.recentEventIds.add(alertData.eventId);
+        if (this.recentEventIds.size > KafkaConsumerService.MAX_RECENT_EVENT_IDS) {
+          const oldest = this.recentEventIds.values().next().value;
+          if (oldest !== undefined) {
+            this.recentEventIds.delete(oldest);
+          }
+        }
+      }
+
       console.log('[Kafka] ✓ Parsed alert data:');
+      console.log('[Kafka]   Event ID:', alertData.eventId);
       console.log('[Kafka]   Camera ID:', alertData.cameraId);
       console.log('[Kafka]   Camera Name:', alertData.cameraName);
+      if (alertData.tenantId || alertData.siteId) {
+        console.log('[Kafka]   Tenant/Site:', alertData.tenantId || '-', '/', alertData.siteId || '-');
+      }
       console.log('[Kafka]   Face Count:', alertData.faceCount);
       console.log('[Kafka]   Confidence:', alertData.confidence);
       console.log('[Kafka]   Detected At:', alertData.detectedAt);
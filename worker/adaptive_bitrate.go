@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultVideoBitrateKbps is the fixed maxrate used when adaptive bitrate is off
+const defaultVideoBitrateKbps = 1500
+
+// AdaptiveBitrateConfig controls loss-driven bitrate changes. FFmpeg can't change
+// its bitrate live, so every change is a controlled restart of the encoder.
+type AdaptiveBitrateConfig struct {
+	Enabled     bool
+	MinKbps     int
+	MaxKbps     int           // Also the starting bitrate
+	StepPercent int           // How far one adjustment moves the bitrate
+	LossHigh    float64       // Step down when loss stays at or above this percentage
+	LossLow     float64       // Step up when loss stays at or below this percentage
+	Interval    time.Duration // How often WebRTC reader stats are sampled
+	Sustain     time.Duration // How long loss must stay past a threshold before acting
+	Cooldown    time.Duration // Minimum time between restarts of one camera
+}
+
+// adaptiveBitrateConfig is loaded at startup; the zero value keeps adaptation off
+var adaptiveBitrateConfig AdaptiveBitrateConfig
+
+// loadAdaptiveBitrateConfig reads ADAPTIVE_BITRATE_* from the environment
+func loadAdaptiveBitrateConfig() AdaptiveBitrateConfig {
+	config := AdaptiveBitrateConfig{
+		Enabled:     os.Getenv("ADAPTIVE_BITRATE_ENABLED") == "true",
+		MinKbps:     getEnvInt("ADAPTIVE_BITRATE_MIN_KBPS", 400),
+		MaxKbps:     getEnvInt("ADAPTIVE_BITRATE_MAX_KBPS", defaultVideoBitrateKbps),
+		StepPercent: getEnvInt("ADAPTIVE_BITRATE_STEP_PERCENT", 25),
+		LossHigh:    getEnvFloat("ADAPTIVE_BITRATE_LOSS_HIGH", 5),
+		LossLow:     getEnvFloat("ADAPTIVE_BITRATE_LOSS_LOW", 1),
+		Interval:    getEnvDuration("ADAPTIVE_BITRATE_INTERVAL", 10*time.Second),
+		Sustain:     getEnvDuration("ADAPTIVE_BITRATE_SUSTAIN", time.Minute),
+		Cooldown:    getEnvDuration("ADAPTIVE_BITRATE_COOLDOWN", 5*time.Minute),
+	}
+
+	if config.MinKbps <= 0 || config.MaxKbps < config.MinKbps {
+		log.Printf("Invalid adaptive bitrate bounds %dk-%dk, using 400k-%dk", config.MinKbps, config.MaxKbps, defaultVideoBitrateKbps)
+		config.MinKbps, config.MaxKbps = 400, defaultVideoBitrateKbps
+	}
+	if config.StepPercent <= 0 || config.StepPercent >= 100 {
+		log.Printf("Invalid ADAPTIVE_BITRATE_STEP_PERCENT %d, using 25", config.StepPercent)
+		config.StepPercent = 25
+	}
+	if config.LossLow >= config.LossHigh {
+		log.Printf("ADAPTIVE_BITRATE_LOSS_LOW (%.1f) must be below ADAPTIVE_BITRATE_LOSS_HIGH (%.1f), using 1/5", config.LossLow, config.LossHigh)
+		config.LossLow, config.LossHigh = 1, 5
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	return config
+}
+
+// adaptiveBitrates holds each camera's current target in kbit/s so it survives the
+// restart that applies it
+var (
+	adaptiveBitrates      = make(map[string]int)
+	adaptiveBitratesMutex sync.RWMutex
+)
+
+// videoBitrateKbps returns the maxrate the camera's next encoder should use: ceilingKbps
+// (see EncodingProfile.maxrateKbps) unless adaptive bitrate has lowered it
+func videoBitrateKbps(cameraID string, ceilingKbps int) int {
+	if !adaptiveBitrateConfig.Enabled {
+		return ceilingKbps
+	}
+	adaptiveBitratesMutex.RLock()
+	defer adaptiveBitratesMutex.RUnlock()
+	if kbps, exists := adaptiveBitrates[cameraID]; exists {
+		return min(kbps, ceilingKbps)
+	}
+	return ceilingKbps
+}
+
+// forgetAdaptiveBitrates drops cameras that no longer run; caller holds processMutex
+func forgetAdaptiveBitrates() int {
+	adaptiveBitratesMutex.Lock()
+	defer adaptiveBitratesMutex.Unlock()
+
+	removed := 0
+	for cameraID := range adaptiveBitrates {
+		if _, running := activeProcesses[cameraID]; !running {
+			delete(adaptiveBitrates, cameraID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// mediamtxWebRTCSession is the subset of a MediaMTX WebRTC session the worker inspects
+type mediamtxWebRTCSession struct {
+	State          string `json:"state"`
+	Path           string `json:"path"`
+	RTPPacketsSent uint64 `json:"rtpPacketsSent"`
+	RTPPacketsLost uint64 `json:"rtpPacketsLost"` // As reported by the viewers' RTCP receiver reports
+}
+
+// getWebRTCReaderPackets sums RTP packets sent to and lost by the path's WebRTC viewers
+func getWebRTCReaderPackets(instance *MediaMTXInstance, pathName string) (sent, lost uint64, readers int, err error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := mediamtxGet(client, instance.apiBaseURL()+"/v3/webrtcsessions/list?itemsPerPage=1000")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, 0, fmt.Errorf("MediaMTX API returned status %d for WebRTC sessions", resp.StatusCode)
+	}
+
+	var list struct {
+		Items []mediamtxWebRTCSession `json:"items"`
+	}
+	if err := decodeMediaMTXJSON(resp, &list); err != nil {
+		return 0, 0, 0, err
+	}
+	for _, session := range list.Items {
+		if session.Path != pathName || session.State != "read" {
+			continue
+		}
+		sent += session.RTPPacketsSent
+		lost += session.RTPPacketsLost
+		readers++
+	}
+	return sent, lost, readers, nil
+}
+
+// nextBitrate returns the bitrate one step down (or up) from current, clamped to
+// MinKbps and the camera's ceiling, which its encoding profile may set above MaxKbps
+func (c AdaptiveBitrateConfig) nextBitrate(current, ceilingKbps int, down bool) int {
+	if down {
+		return max(min(c.MinKbps, ceilingKbps), current*(100-c.StepPercent)/100)
+	}
+	return min(ceilingKbps, current*(100+c.StepPercent)/100)
+}
+
+// runAdaptiveBitrate watches packet loss reported by the stream's WebRTC viewers and
+// restarts the encoder one step lower when loss stays high, or one step higher when
+// it stays low. Loss between the thresholds resets both timers, and the cooldown
+// (counted from the stream's start) keeps a restart from triggering the next one.
+func runAdaptiveBitrate(ctx context.Context, process *ReencodingProcess) {
+	config := adaptiveBitrateConfig
+	if !config.Enabled || process.Output.Type() != outputTypeRTSP {
+		return
+	}
+	if process.VideoMode.Mode == videoModeCopy {
+		return // No encoder whose bitrate could change
+	}
+
+	pathName := cameraPathName(process.CameraID)
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	var lastSent, lastLost uint64
+	var highSince, lowSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sent, lost, readers, err := getWebRTCReaderPackets(process.Options.MediaMTX, pathName)
+		if err != nil || readers == 0 || sent < lastSent || lost < lastLost {
+			// No viewers (or a viewer left and the totals dropped): nothing to judge by
+			lastSent, lastLost = sent, lost
+			highSince, lowSince = time.Time{}, time.Time{}
+			continue
+		}
+
+		sentDelta, lostDelta := sent-lastSent, lost-lastLost
+		lastSent, lastLost = sent, lost
+		if sentDelta == 0 {
+			continue
+		}
+		lossPercent := 100 * float64(lostDelta) / float64(sentDelta)
+
+		now := time.Now()
+		switch {
+		case lossPercent >= config.LossHigh:
+			lowSince = time.Time{}
+			if highSince.IsZero() {
+				highSince = now
+			}
+		case lossPercent <= config.LossLow:
+			highSince = time.Time{}
+			if lowSince.IsZero() {
+				lowSince = now
+			}
+		default:
+			highSince, lowSince = time.Time{}, time.Time{}
+			continue
+		}
+
+		down := !highSince.IsZero()
+		since := lowSince
+		if down {
+			since = highSince
+		}
+		if now.Sub(since) < config.Sustain || now.Sub(process.StartedAt) < config.Cooldown {
+			continue
+		}
+
+		ceiling := process.Options.Encoding.maxrateKbps()
+		current := videoBitrateKbps(process.CameraID, ceiling)
+		target := config.nextBitrate(current, ceiling, down)
+		if target == current {
+			continue
+		}
+
+		processMutex.RLock()
+		active := activeProcesses[process.CameraID]
+		processMutex.RUnlock()
+		if active != process || ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Adaptive bitrate: camera %s viewer loss %.1f%% sustained for %v, restarting encoder at %dk (was %dk)",
+			process.CameraID, lossPercent, now.Sub(since).Round(time.Second), target, current)
+
+		adaptiveBitratesMutex.Lock()
+		adaptiveBitrates[process.CameraID] = target
+		adaptiveBitratesMutex.Unlock()
+
+		streamEvents.Publish(StreamEvent{
+			Type:     streamEventBitrateChanged,
+			CameraID: process.CameraID,
+			Reason:   fmt.Sprintf("viewer packet loss %.1f%% sustained for %v", lossPercent, now.Sub(since).Round(time.Second)),
+			Details: map[string]interface{}{
+				"fromKbps": current,
+				"toKbps":   target,
+				"readers":  readers,
+			},
+		})
+
+		// startReencodingProcess replaces this process; its monitor sees the replacement and exits quietly
+		if err := startReencodingProcess(process.Store, process.CameraID, process.SourceURL, process.Options); err != nil {
+			log.Printf("Adaptive bitrate: failed to restart camera %s at %dk: %v", process.CameraID, target, err)
+		}
+		return
+	}
+}
+
+// AdaptiveBitrateStats is the per-camera bitrate view returned by /metrics
+type AdaptiveBitrateStats struct {
+	Enabled bool           `json:"enabled"`
+	MinKbps int            `json:"minKbps"`
+	MaxKbps int            `json:"maxKbps"`
+	Cameras map[string]int `json:"cameras"` // Cameras whose bitrate has been adjusted
+}
+
+// adaptiveBitrateStats reports the configured bounds and the adjusted cameras
+func adaptiveBitrateStats() AdaptiveBitrateStats {
+	adaptiveBitratesMutex.RLock()
+	defer adaptiveBitratesMutex.RUnlock()
+
+	cameras := make(map[string]int, len(adaptiveBitrates))
+	for cameraID, kbps := range adaptiveBitrates {
+		cameras[cameraID] = kbps
+	}
+	return AdaptiveBitrateStats{
+		Enabled: adaptiveBitrateConfig.Enabled,
+		MinKbps: adaptiveBitrateConfig.MinKbps,
+		MaxKbps: adaptiveBitrateConfig.MaxKbps,
+		Cameras: cameras,
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertQueueSize = 100
+	// alertQueueDrainTimeout bounds how long shutdown waits for queued alerts to publish
+	alertQueueDrainTimeout = 10 * time.Second
+)
+
+// AlertQueueStats reports the alert queue's counters
+type AlertQueueStats struct {
+	Capacity  int    `json:"capacity"`
+	Queued    int    `json:"queued"`
+	Published uint64 `json:"published"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"` // Oldest alerts discarded because the queue was full
+}
+
+// queuedAlert is a pending publish; the closure carries the alert and its destination
+type queuedAlert struct {
+	cameraID string
+	publish  func() error
+}
+
+// AlertQueue sits between detection and Kafka so a slow broker never blocks
+// detection. A single background goroutine publishes in order; when the queue is
+// full the oldest alert is dropped to make room for the newest.
+type AlertQueue struct {
+	alerts chan queuedAlert
+	done   chan struct{}
+
+	published uint64
+	failed    uint64
+	dropped   uint64
+	closed    bool
+	mu        sync.Mutex
+}
+
+// NewAlertQueue starts the publisher goroutine; size comes from ALERT_QUEUE_SIZE
+func NewAlertQueue() *AlertQueue {
+	size := getEnvInt("ALERT_QUEUE_SIZE", defaultAlertQueueSize)
+	if size <= 0 {
+		size = defaultAlertQueueSize
+	}
+
+	q := &AlertQueue{
+		alerts: make(chan queuedAlert, size),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Enqueue adds a publish without blocking, evicting the oldest queued alert if full
+func (q *AlertQueue) Enqueue(cameraID string, publish func() error) {
+	alert := queuedAlert{cameraID: cameraID, publish: publish}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		q.dropped++
+		return
+	}
+
+	for {
+		select {
+		case q.alerts <- alert:
+			return
+		default:
+		}
+
+		// Full: the publisher may drain concurrently, so the receive is non-blocking too
+		select {
+		case oldest := <-q.alerts:
+			q.dropped++
+			if q.dropped == 1 || q.dropped%100 == 0 {
+				log.Printf("Alert queue full, dropped alert for camera %s (%d dropped so far)", oldest.cameraID, q.dropped)
+			}
+		default:
+		}
+	}
+}
+
+// run publishes queued alerts until the queue is closed and drained
+func (q *AlertQueue) run() {
+	defer close(q.done)
+	for alert := range q.alerts {
+		err := alert.publish()
+		q.mu.Lock()
+		if err != nil {
+			q.failed++
+		} else {
+			q.published++
+		}
+		q.mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to publish alert for camera %s: %v", alert.cameraID, err)
+		}
+	}
+}
+
+// Stats returns the queue's current counters
+func (q *AlertQueue) Stats() AlertQueueStats {
+	if q == nil {
+		return AlertQueueStats{}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return AlertQueueStats{
+		Capacity:  cap(q.alerts),
+		Queued:    len(q.alerts),
+		Published: q.published,
+		Failed:    q.failed,
+		Dropped:   q.dropped,
+	}
+}
+
+// Close stops accepting alerts and waits briefly for the queue to drain
+func (q *AlertQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.alerts)
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+	case <-time.After(alertQueueDrainTimeout):
+		log.Printf("Alert queue did not drain within %v, %d alert(s) not published", alertQueueDrainTimeout, len(q.alerts))
+	}
+}
+
+// faceDetectorAlertQueueStats reports the face detector's queue, if it has one
+func faceDetectorAlertQueueStats() AlertQueueStats {
+	if faceDetector == nil {
+		return AlertQueueStats{}
+	}
+	return faceDetector.alertQueue.Stats()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertSerializer encodes face detection alerts into Kafka message values
+type AlertSerializer interface {
+	Serialize(alert FaceDetectionAlert) ([]byte, error)
+	ContentType() string
+}
+
+// NewAlertSerializer builds the serializer selected by KAFKA_SERIALIZATION_FORMAT (json | avro)
+func NewAlertSerializer(topic string) (AlertSerializer, error) {
+	format := strings.ToLower(os.Getenv("KAFKA_SERIALIZATION_FORMAT"))
+	switch format {
+	case "", "json":
+		return jsonAlertSerializer{}, nil
+	case "avro":
+		registryURL := os.Getenv("SCHEMA_REGISTRY_URL")
+		if registryURL == "" {
+			return nil, fmt.Errorf("KAFKA_SERIALIZATION_FORMAT=avro requires SCHEMA_REGISTRY_URL")
+		}
+		return &avroAlertSerializer{
+			registryURL: strings.TrimRight(registryURL, "/"),
+			subject:     topic + "-value", // Confluent TopicNameStrategy
+			username:    os.Getenv("SCHEMA_REGISTRY_USER"),
+			password:    os.Getenv("SCHEMA_REGISTRY_PASS"),
+			client:      &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported KAFKA_SERIALIZATION_FORMAT %q (expected json or avro)", format)
+	}
+}
+
+// jsonAlertSerializer is the default plain-JSON encoding
+type jsonAlertSerializer struct{}
+
+func (jsonAlertSerializer) Serialize(alert FaceDetectionAlert) ([]byte, error) {
+	return json.Marshal(alert)
+}
+
+func (jsonAlertSerializer) ContentType() string {
+	return "application/json"
+}
+
+// faceDetectionAlertAvroSchema mirrors FaceDetectionAlert. Metadata is free-form,
+// so it is carried as a JSON string rather than an Avro map of unions.
+const faceDetectionAlertAvroSchema = `{
+  "type": "record",
+  "name": "FaceDetectionAlert",
+  "namespace": "com.skylark.worker",
+  "fields": [
+    {"name": "cameraId", "type": "string"},
+    {"name": "cameraName", "type": "string"},
+    {"name": "faceCount", "type": "int"},
+    {"name": "confidence", "type": "double"},
+    {"name": "imageData", "type": "string"},
+    {"name": "detectedAt", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "metadata", "type": "string"},
+    {"name": "clipPath", "type": "string", "default": ""},
+    {"name": "eventId", "type": "string", "default": ""},
+    {"name": "tenantId", "type": "string", "default": ""},
+    {"name": "siteId", "type": "string", "default": ""},
+    {"name": "localTime", "type": "string", "default": ""},
+    {"name": "timezone", "type": "string", "default": ""}
+  ]
+}`
+
+// avroAlertSerializer encodes alerts as Avro in the Confluent wire format:
+// magic byte 0x0, 4-byte big-endian schema ID, then the Avro binary payload
+type avroAlertSerializer struct {
+	registryURL string
+	subject     string
+	username    string
+	password    string
+	client      *http.Client
+
+	schemaID int32
+	mu       sync.Mutex
+}
+
+func (s *avroAlertSerializer) ContentType() string {
+	return "application/vnd.confluent.avro"
+}
+
+// Serialize registers the schema on first use and encodes the alert
+func (s *avroAlertSerializer) Serialize(alert FaceDetectionAlert) ([]byte, error) {
+	schemaID, err := s.getSchemaID()
+	if err != nil {
+		return nil, err
+	}
+
+	metadataJSON, err := json.Marshal(alert.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal alert metadata: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(0) // Confluent magic byte
+	binary.Write(&buf, binary.BigEndian, schemaID)
+
+	// Fields must be written in schema order
+	writeAvroString(&buf, alert.CameraID)
+	writeAvroString(&buf, alert.CameraName)
+	writeAvroLong(&buf, int64(alert.FaceCount))
+	writeAvroDouble(&buf, alert.Confidence)
+	writeAvroString(&buf, alert.ImageData)
+	writeAvroLong(&buf, alert.DetectedAt.UnixMilli())
+	writeAvroString(&buf, string(metadataJSON))
+	writeAvroString(&buf, alert.ClipPath)
+	writeAvroString(&buf, alert.EventID)
+	writeAvroString(&buf, alert.TenantID)
+	writeAvroString(&buf, alert.SiteID)
+	writeAvroString(&buf, alert.LocalTime)
+	writeAvroString(&buf, alert.Timezone)
+
+	return buf.Bytes(), nil
+}
+
+// getSchemaID registers (or looks up) the alert schema and caches its ID.
+// Registering an identical schema is idempotent in Schema Registry.
+func (s *avroAlertSerializer) getSchemaID() (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.schemaID != 0 {
+		return s.schemaID, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"schema": faceDetectionAlertAvroSchema})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/subjects/%s/versions", s.registryURL, s.subject)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create schema registry request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register schema: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		ID int32 `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return 0, fmt.Errorf("failed to parse schema registry response: %w", err)
+	}
+	if result.ID == 0 {
+		return 0, fmt.Errorf("schema registry returned no schema id")
+	}
+
+	s.schemaID = result.ID
+	return s.schemaID, nil
+}
+
+// writeAvroLong writes a zigzag-encoded variable-length long (also used for int)
+func writeAvroLong(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64((v<<1)^(v>>63)))
+	buf.Write(tmp[:n])
+}
+
+// writeAvroString writes a length-prefixed UTF-8 string
+func writeAvroString(buf *bytes.Buffer, v string) {
+	writeAvroLong(buf, int64(len(v)))
+	buf.WriteString(v)
+}
+
+// writeAvroDouble writes an IEEE 754 double in little-endian order
+func writeAvroDouble(buf *bytes.Buffer, v float64) {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+	buf.Write(tmp[:])
+}
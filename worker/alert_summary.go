@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// AlertSummaryConfig controls the periodic face alert rollup
+type AlertSummaryConfig struct {
+	Enabled bool
+	Window  time.Duration // Alerts are aggregated over this long, then published as one message
+	Topic   string
+	// SummariesOnly stops individual alerts from being published to Kafka; detections
+	// are still counted, stored and recorded
+	SummariesOnly bool
+}
+
+// loadAlertSummaryConfig reads ALERT_SUMMARY_ENABLED, ALERT_SUMMARY_WINDOW,
+// KAFKA_ALERT_SUMMARY_TOPIC and ALERT_SUMMARY_ONLY
+func loadAlertSummaryConfig() AlertSummaryConfig {
+	config := AlertSummaryConfig{
+		Enabled:       os.Getenv("ALERT_SUMMARY_ENABLED") == "true",
+		Window:        getEnvDuration("ALERT_SUMMARY_WINDOW", time.Minute),
+		Topic:         os.Getenv("KAFKA_ALERT_SUMMARY_TOPIC"),
+		SummariesOnly: os.Getenv("ALERT_SUMMARY_ONLY") == "true",
+	}
+	if config.Topic == "" {
+		config.Topic = "face-alert-summaries"
+	}
+	if config.Window < time.Second {
+		config.Window = time.Minute
+	}
+	if config.SummariesOnly && !config.Enabled {
+		log.Printf("ALERT_SUMMARY_ONLY needs ALERT_SUMMARY_ENABLED=true, publishing individual alerts")
+		config.SummariesOnly = false
+	}
+	return config
+}
+
+// CameraAlertSummary is one camera's share of a summary window
+type CameraAlertSummary struct {
+	CameraID   string    `json:"cameraId"`
+	CameraName string    `json:"cameraName"`
+	TenantID   string    `json:"tenantId,omitempty"`
+	SiteID     string    `json:"siteId,omitempty"`
+	Events     int       `json:"events"`    // Face alerts raised
+	Faces      int       `json:"faces"`     // Faces across those alerts
+	PeakFaces  int       `json:"peakFaces"` // Most faces in a single alert
+	FirstAt    time.Time `json:"firstAt"`   // First alert in the window
+	LastAt     time.Time `json:"lastAt"`    // Last alert in the window
+}
+
+// FaceAlertSummary is the rollup message published to KAFKA_ALERT_SUMMARY_TOPIC, e.g.
+// 12 face events across 5 cameras in the last minute. Windows without alerts publish
+// nothing.
+type FaceAlertSummary struct {
+	SummaryID   string               `json:"summaryId"`
+	WindowStart time.Time            `json:"windowStart"`
+	WindowEnd   time.Time            `json:"windowEnd"`
+	Events      int                  `json:"events"`
+	Cameras     int                  `json:"cameras"`
+	PeakFaces   int                  `json:"peakFaces"` // Most faces in a single alert on any camera
+	PerCamera   []CameraAlertSummary `json:"perCamera"` // Sorted by camera ID
+}
+
+// AlertSummaryStats is the alertSummary entry of /metrics
+type AlertSummaryStats struct {
+	Enabled       bool       `json:"enabled"`
+	SummariesOnly bool       `json:"summariesOnly"`
+	WindowStart   *time.Time `json:"windowStart,omitempty"`
+	PendingEvents int        `json:"pendingEvents"` // Alerts counted in the current window
+	Published     uint64     `json:"published"`
+	Failed        uint64     `json:"failed"`
+}
+
+// AlertSummarizer aggregates face alerts per window and publishes one rollup per
+// window alongside (or instead of) the individual alerts
+type AlertSummarizer struct {
+	config AlertSummaryConfig
+	writer *kafka.Writer
+
+	windowStart time.Time
+	cameras     map[string]*CameraAlertSummary
+	published   uint64
+	failed      uint64
+	stop        chan struct{}
+	done        chan struct{}
+	mu          sync.Mutex
+}
+
+// NewAlertSummarizer creates a summarizer; Run starts its window timer
+func NewAlertSummarizer(config AlertSummaryConfig) *AlertSummarizer {
+	return &AlertSummarizer{
+		config:      config,
+		windowStart: time.Now(),
+		cameras:     make(map[string]*CameraAlertSummary),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+var alertSummaries = NewAlertSummarizer(AlertSummaryConfig{})
+
+// AttachKafka publishes summaries to the configured topic on the producer's brokers
+func (s *AlertSummarizer) AttachKafka(producer *KafkaProducer) {
+	if !s.config.Enabled || producer == nil || producer.writer == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writer = &kafka.Writer{
+		Addr:         producer.writer.Addr,
+		Topic:        s.config.Topic,
+		Balancer:     &kafka.LeastBytes{},
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+	}
+	log.Printf("Face alert summaries will be published to Kafka topic '%s' every %v", s.config.Topic, s.config.Window)
+}
+
+// SummariesOnly reports whether individual alerts are held back from Kafka
+func (s *AlertSummarizer) SummariesOnly() bool {
+	return s.config.SummariesOnly
+}
+
+// Record counts an alert in the current window
+func (s *AlertSummarizer) Record(alert FaceDetectionAlert) {
+	if !s.config.Enabled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	camera, exists := s.cameras[alert.CameraID]
+	if !exists {
+		camera = &CameraAlertSummary{
+			CameraID: alert.CameraID,
+			TenantID: alert.TenantID,
+			SiteID:   alert.SiteID,
+			FirstAt:  alert.DetectedAt,
+		}
+		s.cameras[alert.CameraID] = camera
+	}
+	camera.CameraName = alert.CameraName
+	camera.Events++
+	camera.Faces += alert.FaceCount
+	if alert.FaceCount > camera.PeakFaces {
+		camera.PeakFaces = alert.FaceCount
+	}
+	camera.LastAt = alert.DetectedAt
+}
+
+// Run publishes a summary at the end of every window until Close
+func (s *AlertSummarizer) Run() {
+	defer close(s.done)
+	if !s.config.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.flush() // The partial last window
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush closes the current window and publishes its summary
+func (s *AlertSummarizer) flush() {
+	now := time.Now()
+	s.mu.Lock()
+	cameras := s.cameras
+	summary := FaceAlertSummary{
+		SummaryID:   newEventID(),
+		WindowStart: s.windowStart.UTC(),
+		WindowEnd:   now.UTC(),
+		Cameras:     len(cameras),
+		PerCamera:   make([]CameraAlertSummary, 0, len(cameras)),
+	}
+	s.cameras = make(map[string]*CameraAlertSummary)
+	s.windowStart = now
+	writer := s.writer
+	s.mu.Unlock()
+
+	if len(cameras) == 0 {
+		return
+	}
+	for _, camera := range cameras {
+		summary.Events += camera.Events
+		if camera.PeakFaces > summary.PeakFaces {
+			summary.PeakFaces = camera.PeakFaces
+		}
+		summary.PerCamera = append(summary.PerCamera, *camera)
+	}
+	sort.Slice(summary.PerCamera, func(i, j int) bool { return summary.PerCamera[i].CameraID < summary.PerCamera[j].CameraID })
+
+	if writer == nil {
+		log.Printf("Kafka producer not available, skipping face alert summary (%d events across %d cameras)", summary.Events, summary.Cameras)
+		return
+	}
+	err := s.publish(writer, summary)
+	s.mu.Lock()
+	if err != nil {
+		s.failed++
+	} else {
+		s.published++
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to publish face alert summary: %v", err)
+		return
+	}
+	log.Printf("Published face alert summary: %d events across %d cameras since %s",
+		summary.Events, summary.Cameras, summary.WindowStart.Format(time.RFC3339))
+}
+
+// publish writes one summary message
+func (s *AlertSummarizer) publish(writer *kafka.Writer, summary FaceAlertSummary) error {
+	value, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(summary.SummaryID),
+		Value: value,
+		Time:  summary.WindowEnd,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte("application/json")},
+			{Key: "event-id", Value: []byte(summary.SummaryID)},
+		},
+	})
+}
+
+// Stats returns the summarizer's state and counters
+func (s *AlertSummarizer) Stats() AlertSummaryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := AlertSummaryStats{
+		Enabled:       s.config.Enabled,
+		SummariesOnly: s.config.SummariesOnly,
+		Published:     s.published,
+		Failed:        s.failed,
+	}
+	if s.config.Enabled {
+		windowStart := s.windowStart
+		stats.WindowStart = &windowStart
+	}
+	for _, camera := range s.cameras {
+		stats.PendingEvents += camera.Events
+	}
+	return stats
+}
+
+// Close publishes the current window's summary and stops the timer; call before the
+// Kafka producer is closed
+func (s *AlertSummarizer) Close() {
+	if !s.config.Enabled {
+		return
+	}
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	writer := s.writer
+	s.mu.Unlock()
+	if writer != nil {
+		writer.Close()
+	}
+}
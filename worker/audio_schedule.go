@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// muteScheduleLookahead is how far ahead boundaries are searched; every valid window
+// recurs at least weekly
+const muteScheduleLookahead = 8
+
+var weekdaysByName = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// AudioMuteWindow is a daily period, in the camera's timezone, during which the camera's
+// audio is dropped. An End at or before Start runs past midnight (22:00-06:00); Days
+// are the days the window starts on, all of them when empty.
+type AudioMuteWindow struct {
+	Days  []string `json:"days,omitempty"` // sun, mon, tue, wed, thu, fri, sat
+	Start string   `json:"start"`          // HH:MM
+	End   string   `json:"end"`            // HH:MM
+}
+
+// parseClock parses an HH:MM time of day
+func parseClock(value string) (hour, minute int, err error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("time %q is not HH:MM", value)
+	}
+	return parsed.Hour(), parsed.Minute(), nil
+}
+
+// Validate checks the window's times and days
+func (w AudioMuteWindow) Validate() error {
+	if _, _, err := parseClock(w.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if _, _, err := parseClock(w.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if w.Start == w.End {
+		return fmt.Errorf("start and end are both %s", w.Start)
+	}
+	for _, day := range w.Days {
+		if _, known := weekdaysByName[strings.ToLower(day)]; !known {
+			return fmt.Errorf("unknown day %q (expected sun, mon, tue, wed, thu, fri or sat)", day)
+		}
+	}
+	return nil
+}
+
+// startsOn reports whether the window begins on the given weekday
+func (w AudioMuteWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdaysByName[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrence returns the window as it falls on the given local date. Times are built
+// with time.Date, so they follow the location's DST changes.
+func (w AudioMuteWindow) occurrence(year int, month time.Month, day int, location *time.Location) (start, end time.Time) {
+	startHour, startMinute, _ := parseClock(w.Start)
+	endHour, endMinute, _ := parseClock(w.End)
+	start = time.Date(year, month, day, startHour, startMinute, 0, 0, location)
+	end = time.Date(year, month, day, endHour, endMinute, 0, 0, location)
+	if !end.After(start) {
+		end = time.Date(year, month, day+1, endHour, endMinute, 0, 0, location)
+	}
+	return start, end
+}
+
+// mutedAt reports whether the mute schedule drops audio at t, and the next time after t
+// that a window starts or ends (zero without a schedule). Overlapping windows can make
+// next a boundary where the muted state doesn't actually change.
+func (a *AudioOptions) mutedAt(t time.Time, location *time.Location) (muted bool, next time.Time) {
+	if a == nil || len(a.MuteSchedule) == 0 {
+		return false, time.Time{}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	local := t.In(location)
+	for _, window := range a.MuteSchedule {
+		// Start a day back, for a window that began yesterday and runs past midnight
+		for offset := -1; offset < muteScheduleLookahead; offset++ {
+			date := time.Date(local.Year(), local.Month(), local.Day()+offset, 12, 0, 0, 0, location)
+			if !window.startsOn(date.Weekday()) {
+				continue
+			}
+			start, end := window.occurrence(date.Year(), date.Month(), date.Day(), location)
+			if !t.Before(start) && t.Before(end) {
+				muted = true
+			}
+			for _, boundary := range []time.Time{start, end} {
+				if boundary.After(t) && (next.IsZero() || boundary.Before(next)) {
+					next = boundary
+				}
+			}
+		}
+	}
+	return muted, next
+}
+
+// runAudioSchedule restarts the process at the schedule boundaries where its audio
+// should switch between muted and unmuted. The restarted process evaluates the schedule
+// again and starts its own watcher.
+func runAudioSchedule(ctx context.Context, process *ReencodingProcess) {
+	audio := process.Options.Audio
+	if audio == nil || len(audio.MuteSchedule) == 0 {
+		return
+	}
+	location := cameraLocation(process.CameraID)
+
+	for {
+		_, next := audio.mutedAt(time.Now(), location)
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		muted, _ := audio.mutedAt(time.Now(), location)
+		if muted == process.AudioMuted {
+			continue // Another window still covers this boundary
+		}
+
+		processMutex.RLock()
+		active := activeProcesses[process.CameraID]
+		processMutex.RUnlock()
+		if active != process || ctx.Err() != nil {
+			return
+		}
+
+		eventType, state := streamEventAudioUnmuted, "unmuted"
+		if muted {
+			eventType, state = streamEventAudioMuted, "muted"
+		}
+		log.Printf("Audio schedule: camera %s audio is now %s, restarting encoder", process.CameraID, state)
+		streamEvents.Publish(StreamEvent{
+			Type:     eventType,
+			CameraID: process.CameraID,
+			Reason:   "audio mute schedule",
+		})
+
+		// startReencodingProcess replaces this process; its monitor sees the replacement and exits quietly
+		if err := startReencodingProcess(process.Store, process.CameraID, process.SourceURL, process.Options); err != nil {
+			log.Printf("Audio schedule: failed to restart camera %s with audio %s: %v", process.CameraID, state, err)
+		}
+		return
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// FaceDetectionPolicy holds face detection settings at one level (camera or group).
+// Nil fields are inherited from the next level: camera -> group -> global.
+type FaceDetectionPolicy struct {
+	Enabled    *bool             `json:"enabled,omitempty"`
+	IntervalMs *int              `json:"intervalMs,omitempty"`
+	Threshold  *float64          `json:"threshold,omitempty"`
+	ROI        *RegionOfInterest `json:"roi,omitempty"`
+}
+
+// policyUpdate is how SaveCameraFaceDetection treats the policy's nil fields
+type policyUpdate int
+
+const (
+	policyMerge   policyUpdate = iota // Nil interval, threshold and ROI keep their stored values
+	policyReplace                     // Nil fields clear the stored overrides, so they're inherited again
+)
+
+// RegionOfInterest is a rectangle in normalized frame coordinates (0-1).
+// Faces whose center falls outside it are ignored.
+type RegionOfInterest struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Validate checks the policy values are usable
+func (p FaceDetectionPolicy) Validate() error {
+	if p.IntervalMs != nil && *p.IntervalMs < 100 {
+		return fmt.Errorf("intervalMs must be at least 100")
+	}
+	if p.Threshold != nil && (*p.Threshold <= 0 || *p.Threshold > 1) {
+		return fmt.Errorf("threshold must be in (0, 1]")
+	}
+	if roi := p.ROI; roi != nil {
+		if roi.X < 0 || roi.Y < 0 || roi.Width <= 0 || roi.Height <= 0 ||
+			roi.X+roi.Width > 1 || roi.Y+roi.Height > 1 {
+			return fmt.Errorf("roi must lie within the normalized frame (0-1)")
+		}
+	}
+	return nil
+}
+
+// Contains reports whether the center of rect lies inside the ROI for a cols x rows frame
+func (r *RegionOfInterest) Contains(rect image.Rectangle, cols, rows int) bool {
+	if r == nil {
+		return true
+	}
+	centerX := float64(rect.Min.X+rect.Max.X) / 2 / float64(cols)
+	centerY := float64(rect.Min.Y+rect.Max.Y) / 2 / float64(rows)
+	return centerX >= r.X && centerX <= r.X+r.Width && centerY >= r.Y && centerY <= r.Y+r.Height
+}
+
+// CameraGroup is a named set of cameras sharing a face detection policy
+type CameraGroup struct {
+	ID            string              `json:"id"`
+	Name          string              `json:"name"`
+	FaceDetection FaceDetectionPolicy `json:"faceDetection"`
+	CameraIDs     []string            `json:"cameraIds"`
+}
+
+// FaceDetectionSettings are the effective settings for one camera after inheritance
+type FaceDetectionSettings struct {
+	Enabled   bool              `json:"enabled"`
+	Interval  time.Duration     `json:"-"`
+	Threshold float64           `json:"threshold"`
+	ROI       *RegionOfInterest `json:"roi,omitempty"`
+	GroupID   string            `json:"groupId,omitempty"`
+	Sources   map[string]string `json:"sources"` // setting -> "camera" | "group" | "global"
+
+	// Alert routing, from the camera's ALERT_TENANT_LABEL / ALERT_SITE_LABEL labels
+	TenantID string `json:"tenantId,omitempty"`
+	SiteID   string `json:"siteId,omitempty"`
+
+	// Timezone is the camera's validated CAMERA_TIMEZONE_LABEL, "UTC" when unset or invalid
+	Timezone string         `json:"timezone"`
+	Location *time.Location `json:"-"`
+
+	// ObjectClasses are the object detector classes of interest from the camera's
+	// OBJECT_CLASSES_LABEL label; nil uses OBJECT_DETECTION_CLASSES
+	ObjectClasses []string `json:"objectClasses,omitempty"`
+}
+
+// resolveFaceDetectionSettings applies camera overrides, then the group policy, then the
+// global detector defaults
+func resolveFaceDetectionSettings(camera FaceDetectionPolicy, group *CameraGroup, detector *FaceDetector) FaceDetectionSettings {
+	settings := FaceDetectionSettings{
+		Sources:  map[string]string{"enabled": "global", "interval": "global", "threshold": "global", "roi": "global"},
+		Timezone: time.UTC.String(),
+		Location: time.UTC,
+	}
+	if detector != nil {
+		tuning := detector.tuning()
+		settings.Interval = tuning.Interval
+		settings.Threshold = tuning.Threshold
+	}
+
+	apply := func(level string, policy FaceDetectionPolicy) {
+		if policy.Enabled != nil {
+			settings.Enabled = *policy.Enabled
+			settings.Sources["enabled"] = level
+		}
+		if policy.IntervalMs != nil {
+			settings.Interval = time.Duration(*policy.IntervalMs) * time.Millisecond
+			settings.Sources["interval"] = level
+		}
+		if policy.Threshold != nil {
+			settings.Threshold = *policy.Threshold
+			settings.Sources["threshold"] = level
+		}
+		if policy.ROI != nil {
+			settings.ROI = policy.ROI
+			settings.Sources["roi"] = level
+		}
+	}
+
+	// Camera overrides are applied last so they win
+	if group != nil {
+		settings.GroupID = group.ID
+		apply("group", group.FaceDetection)
+	}
+	apply("camera", camera)
+	return settings
+}
+
+// MarshalJSON adds the interval in milliseconds
+func (s FaceDetectionSettings) MarshalJSON() ([]byte, error) {
+	type alias FaceDetectionSettings
+	return json.Marshal(struct {
+		alias
+		IntervalMs int64 `json:"intervalMs"`
+	}{alias(s), s.Interval.Milliseconds()})
+}
+
+// getFaceDetectionSettings loads the camera and group policies and resolves them
+func getFaceDetectionSettings(store CameraStore, cameraID string) (FaceDetectionSettings, error) {
+	cameraPolicy, group, err := store.GetFaceDetectionPolicies(cameraID)
+	if err != nil {
+		return resolveFaceDetectionSettings(FaceDetectionPolicy{}, nil, faceDetector), err
+	}
+	settings := resolveFaceDetectionSettings(cameraPolicy, group, faceDetector)
+
+	if labels, err := store.GetCameraLabels(cameraID); err == nil {
+		settings.TenantID = labels[alertLabelKey("ALERT_TENANT_LABEL", "tenant")]
+		settings.SiteID = labels[alertLabelKey("ALERT_SITE_LABEL", "site")]
+		settings.Location = resolveTimezone(cameraID, labels[timezoneLabelKey()])
+		settings.ObjectClasses = parseObjectClasses(labels[alertLabelKey("OBJECT_CLASSES_LABEL", "objectClasses")])
+		settings.Timezone = settings.Location.String()
+	} else {
+		log.Printf("Failed to load labels for camera %s, alerts will have no tenant/site: %v", cameraID, err)
+	}
+	return settings, nil
+}
+
+// alertLabelKey returns the camera label name that carries an alert routing field
+func alertLabelKey(envKey, defaultLabel string) string {
+	if label := os.Getenv(envKey); label != "" {
+		return label
+	}
+	return defaultLabel
+}
+
+// newGroupID generates an ID for groups created by the worker
+func newGroupID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return "grp_" + hex.EncodeToString(b[:])
+}
+
+// cameraPolicyFromColumns builds the camera-level policy. faceDetectionOverride holds an
+// explicit enable or disable; without one, the legacy non-nullable faceDetectionEnabled
+// column only overrides the group when true, as rows written before the override did.
+func cameraPolicyFromColumns(enabled bool, override sql.NullBool, intervalMs sql.NullInt64, threshold sql.NullFloat64, roi []byte) FaceDetectionPolicy {
+	var policy FaceDetectionPolicy
+	if override.Valid {
+		policy.Enabled = &override.Bool
+	} else if enabled {
+		policy.Enabled = &enabled
+	}
+	if intervalMs.Valid {
+		v := int(intervalMs.Int64)
+		policy.IntervalMs = &v
+	}
+	if threshold.Valid {
+		v := threshold.Float64
+		policy.Threshold = &v
+	}
+	policy.ROI = parseROI(roi)
+	return policy
+}
+
+// parseROI decodes a JSON ROI column, tolerating NULL
+func parseROI(raw []byte) *RegionOfInterest {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var roi RegionOfInterest
+	if err := json.Unmarshal(raw, &roi); err != nil {
+		log.Printf("Failed to parse face detection ROI %q: %v", string(raw), err)
+		return nil
+	}
+	return &roi
+}
+
+// marshalROI encodes an ROI for a JSON column; nil stays NULL
+func marshalROI(roi *RegionOfInterest) (interface{}, error) {
+	if roi == nil {
+		return nil, nil
+	}
+	return json.Marshal(roi)
+}
+
+// GetFaceDetectionPolicies returns the camera's own policy and its group, if any
+func (s *SQLCameraStore) GetFaceDetectionPolicies(cameraID string) (FaceDetectionPolicy, *CameraGroup, error) {
+	if s.db == nil {
+		return FaceDetectionPolicy{}, nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `
+		SELECT c."faceDetectionEnabled", c."faceDetectionOverride", c."faceDetectionIntervalMs", c."faceDetectionThreshold", c."faceDetectionRoi",
+		       g.id, g.name, g."faceDetectionEnabled", g."faceDetectionIntervalMs", g."faceDetectionThreshold", g."faceDetectionRoi"
+		FROM cameras c
+		LEFT JOIN camera_groups g ON g.id = c."groupId"
+		WHERE c.id = $1
+	`
+
+	var enabled bool
+	var override sql.NullBool
+	var intervalMs sql.NullInt64
+	var threshold sql.NullFloat64
+	var roi []byte
+	var groupID, groupName sql.NullString
+	var groupEnabled sql.NullBool
+	var groupIntervalMs sql.NullInt64
+	var groupThreshold sql.NullFloat64
+	var groupROI []byte
+
+	err := s.db.QueryRowContext(ctx, query, cameraID).Scan(&enabled, &override, &intervalMs, &threshold, &roi,
+		&groupID, &groupName, &groupEnabled, &groupIntervalMs, &groupThreshold, &groupROI)
+	if err != nil {
+		return FaceDetectionPolicy{}, nil, err
+	}
+
+	cameraPolicy := cameraPolicyFromColumns(enabled, override, intervalMs, threshold, roi)
+	if !groupID.Valid {
+		return cameraPolicy, nil, nil
+	}
+
+	group := &CameraGroup{ID: groupID.String, Name: groupName.String}
+	group.FaceDetection = groupPolicyFromColumns(groupEnabled, groupIntervalMs, groupThreshold, groupROI)
+	return cameraPolicy, group, nil
+}
+
+// groupPolicyFromColumns builds a group policy from nullable columns
+func groupPolicyFromColumns(enabled sql.NullBool, intervalMs sql.NullInt64, threshold sql.NullFloat64, roi []byte) FaceDetectionPolicy {
+	var policy FaceDetectionPolicy
+	if enabled.Valid {
+		v := enabled.Bool
+		policy.Enabled = &v
+	}
+	if intervalMs.Valid {
+		v := int(intervalMs.Int64)
+		policy.IntervalMs = &v
+	}
+	if threshold.Valid {
+		v := threshold.Float64
+		policy.Threshold = &v
+	}
+	policy.ROI = parseROI(roi)
+	return policy
+}
+
+// ListGroups returns every group with its member camera IDs
+func (s *SQLCameraStore) ListGroups() ([]CameraGroup, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `
+		SELECT g.id, g.name, g."faceDetectionEnabled", g."faceDetectionIntervalMs", g."faceDetectionThreshold",
+		       g."faceDetectionRoi", c.id
+		FROM camera_groups g
+		LEFT JOIN cameras c ON c."groupId" = g.id
+		ORDER BY g.name, c.id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []CameraGroup{}
+	for rows.Next() {
+		var group CameraGroup
+		var enabled sql.NullBool
+		var intervalMs sql.NullInt64
+		var threshold sql.NullFloat64
+		var roi []byte
+		var cameraID sql.NullString
+		if err := rows.Scan(&group.ID, &group.Name, &enabled, &intervalMs, &threshold, &roi, &cameraID); err != nil {
+			log.Printf("Failed to scan camera group row: %v", err)
+			continue
+		}
+
+		// Rows are ordered by group, one per member camera
+		if len(groups) == 0 || groups[len(groups)-1].ID != group.ID {
+			group.FaceDetection = groupPolicyFromColumns(enabled, intervalMs, threshold, roi)
+			group.CameraIDs = []string{}
+			groups = append(groups, group)
+		}
+		if cameraID.Valid {
+			last := &groups[len(groups)-1]
+			last.CameraIDs = append(last.CameraIDs, cameraID.String)
+		}
+	}
+
+	return groups, rows.Err()
+}
+
+// SaveGroup creates a group or updates the policy of the group with the same name
+func (s *SQLCameraStore) SaveGroup(group CameraGroup) (CameraGroup, error) {
+	if s.db == nil {
+		return group, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	roi, err := marshalROI(group.FaceDetection.ROI)
+	if err != nil {
+		return group, fmt.Errorf("failed to marshal roi: %w", err)
+	}
+
+	query := `
+		INSERT INTO camera_groups (id, name, "faceDetectionEnabled", "faceDetectionIntervalMs", "faceDetectionThreshold", "faceDetectionRoi")
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO UPDATE
+		SET "faceDetectionEnabled" = EXCLUDED."faceDetectionEnabled",
+		    "faceDetectionIntervalMs" = EXCLUDED."faceDetectionIntervalMs",
+		    "faceDetectionThreshold" = EXCLUDED."faceDetectionThreshold",
+		    "faceDetectionRoi" = EXCLUDED."faceDetectionRoi"
+		RETURNING id
+	`
+
+	policy := group.FaceDetection
+	err = s.db.QueryRowContext(ctx, query, newGroupID(), group.Name, policy.Enabled, policy.IntervalMs, policy.Threshold, roi).Scan(&group.ID)
+	return group, err
+}
+
+// AssignCamerasToGroup sets groupId on the cameras; an empty groupID removes them from their group
+func (s *SQLCameraStore) AssignCamerasToGroup(groupID string, cameraIDs []string) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var value interface{}
+	if groupID != "" {
+		value = groupID
+	}
+
+	for _, cameraID := range cameraIDs {
+		result, err := s.db.ExecContext(ctx, `UPDATE cameras SET "groupId" = $1 WHERE id = $2`, value, cameraID)
+		if err != nil {
+			return fmt.Errorf("failed to assign camera %s: %w", cameraID, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("camera %s not found", cameraID)
+		}
+	}
+	return nil
+}
+
+// GetFaceDetectionPolicies returns the camera's own policy and its group, if any
+func (s *MemoryCameraStore) GetFaceDetectionPolicies(cameraID string) (FaceDetectionPolicy, *CameraGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return FaceDetectionPolicy{}, nil, sql.ErrNoRows
+	}
+
+	policy := camera.FaceDetection
+	if camera.FaceDetectionEnabled && policy.Enabled == nil {
+		enabled := true
+		policy.Enabled = &enabled
+	}
+
+	group, exists := s.groups[camera.GroupID]
+	if !exists {
+		return policy, nil, nil
+	}
+	groupCopy := *group
+	return policy, &groupCopy, nil
+}
+
+// ListGroups returns every group with its member camera IDs, ordered by name
+func (s *MemoryCameraStore) ListGroups() ([]CameraGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]CameraGroup, 0, len(s.groups))
+	for _, group := range s.groups {
+		groupCopy := *group
+		groupCopy.CameraIDs = []string{}
+		for _, camera := range s.cameras {
+			if camera.GroupID == group.ID {
+				groupCopy.CameraIDs = append(groupCopy.CameraIDs, camera.ID)
+			}
+		}
+		sort.Strings(groupCopy.CameraIDs)
+		groups = append(groups, groupCopy)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// SaveGroup creates a group or updates the policy of the group with the same name
+func (s *MemoryCameraStore) SaveGroup(group CameraGroup) (CameraGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.groups {
+		if existing.Name == group.Name {
+			existing.FaceDetection = group.FaceDetection
+			return *existing, nil
+		}
+	}
+
+	group.ID = newGroupID()
+	group.CameraIDs = nil
+	s.groups[group.ID] = &group
+	return group, nil
+}
+
+// AssignCamerasToGroup sets the group of each camera; an empty groupID removes them from their group
+func (s *MemoryCameraStore) AssignCamerasToGroup(groupID string, cameraIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.groups[groupID]; groupID != "" && !exists {
+		return fmt.Errorf("group %s not found", groupID)
+	}
+
+	for _, cameraID := range cameraIDs {
+		camera, exists := s.cameras[cameraID]
+		if !exists {
+			return fmt.Errorf("camera %s not found", cameraID)
+		}
+		camera.GroupID = groupID
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCameraPolicyFromColumnsOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		legacy   bool
+		override sql.NullBool
+		want     *bool
+	}{
+		{"no override, legacy off inherits", false, sql.NullBool{}, nil},
+		{"no override, legacy on enables", true, sql.NullBool{}, boolPtr(true)},
+		{"explicit disable wins over legacy", true, sql.NullBool{Bool: false, Valid: true}, boolPtr(false)},
+		{"explicit enable", false, sql.NullBool{Bool: true, Valid: true}, boolPtr(true)},
+	}
+	for _, tt := range tests {
+		got := cameraPolicyFromColumns(tt.legacy, tt.override, sql.NullInt64{}, sql.NullFloat64{}, nil).Enabled
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: Enabled = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCameraDisableWinsOverGroup(t *testing.T) {
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "cam-1", RTSPURL: "rtsp://10.0.0.1/stream"})
+	group, err := store.SaveGroup(CameraGroup{Name: "lobby", FaceDetection: FaceDetectionPolicy{Enabled: boolPtr(true)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AssignCamerasToGroup(group.ID, []string{"cam-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SaveCameraFaceDetection("cam-1", FaceDetectionPolicy{Enabled: boolPtr(false)}, policyMerge); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := getFaceDetectionSettings(store, "cam-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Enabled || settings.Sources["enabled"] != "camera" {
+		t.Fatalf("after a camera disable: enabled %v from %s, want false from the camera", settings.Enabled, settings.Sources["enabled"])
+	}
+
+	// Moved to a group that disables detection, an explicit enable still wins until the
+	// camera inherits again. The camera isn't streaming, so nothing starts or stops.
+	quiet, err := store.SaveGroup(CameraGroup{Name: "quiet", FaceDetection: FaceDetectionPolicy{Enabled: boolPtr(false)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AssignCamerasToGroup(quiet.ID, []string{"cam-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveCameraFaceDetection("cam-1", FaceDetectionPolicy{Enabled: boolPtr(true)}, policyMerge); err != nil {
+		t.Fatal(err)
+	}
+	if settings, _ := getFaceDetectionSettings(store, "cam-1"); !settings.Enabled {
+		t.Fatal("an explicit camera enable lost to the group")
+	}
+	router := newRouter(store)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/face-detection/toggle",
+		strings.NewReader(`{"cameraId": "cam-1", "inherit": true}`)))
+	if settings, _ := getFaceDetectionSettings(store, "cam-1"); settings.Enabled || settings.Sources["enabled"] != "group" {
+		t.Fatalf("after inherit (%d %s): enabled %v from %s, want false from the group",
+			recorder.Code, recorder.Body, settings.Enabled, settings.Sources["enabled"])
+	}
+}
+
+func boolPtr(v bool) *bool { return &v }
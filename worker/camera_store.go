@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CameraRecord represents a camera row as seen by the worker
+type CameraRecord struct {
+	ID                   string
+	Name                 string
+	RTSPURL              string
+	PathName             string
+	Enabled              bool
+	Status               string
+	Configured           bool
+	FaceDetectionEnabled bool
+	LastProcessedAt      *time.Time
+	Labels               map[string]string
+	StreamOptions        StreamOptions
+	GroupID              string
+	FaceDetection        FaceDetectionPolicy // Per-camera overrides of the group policy
+}
+
+// MatchesLabels reports whether the camera carries every key=value in selector
+func (r CameraRecord) MatchesLabels(selector map[string]string) bool {
+	for key, value := range selector {
+		if r.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLabels decodes the JSON labels column, tolerating NULL
+func parseLabels(raw []byte) map[string]string {
+	labels := map[string]string{}
+	if len(raw) == 0 {
+		return labels
+	}
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		log.Printf("Failed to parse camera labels %q: %v", string(raw), err)
+	}
+	return labels
+}
+
+// CameraStore abstracts camera persistence so the worker can run without Postgres
+type CameraStore interface {
+	// Available reports whether the store can be used for persistence
+	Available() bool
+	GetCameraInfo(cameraID string) (rtspURL, pathName string, configured bool, err error)
+	UpdateCameraPathInfo(cameraID, pathName string, configured bool)
+	UpdateCameraStatus(cameraID, status string) error
+	GetCameraName(cameraID string) string
+	GetCameraLabels(cameraID string) (map[string]string, error)
+	GetFaceDetectionEnabled(cameraID string) (bool, error)
+	SaveCameraFaceDetection(cameraID string, policy FaceDetectionPolicy, update policyUpdate) error
+	ListConfiguredCameras() ([]CameraRecord, error)
+	ListCameras() ([]CameraRecord, error)
+	UpsertCamera(record CameraRecord) error
+	UpdateCameraRTSPURL(cameraID, rtspURL string) error
+	GetStreamOptions(cameraID string) (StreamOptions, error)
+	SaveStreamOptions(cameraID string, options StreamOptions) error
+	GetFaceDetectionPolicies(cameraID string) (camera FaceDetectionPolicy, group *CameraGroup, err error)
+	ListGroups() ([]CameraGroup, error)
+	SaveGroup(group CameraGroup) (CameraGroup, error)
+	AssignCamerasToGroup(groupID string, cameraIDs []string) error
+}
+
+// SQLCameraStore implements CameraStore over the cameras table in Postgres
+type SQLCameraStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration // Per-statement deadline; 0 means none
+}
+
+// NewSQLCameraStore creates a store backed by db; a nil db behaves as "database not available"
+func NewSQLCameraStore(db *sql.DB, queryTimeout time.Duration) *SQLCameraStore {
+	return &SQLCameraStore{db: db, queryTimeout: queryTimeout}
+}
+
+// queryContext returns the context a statement runs under, bounded by the query timeout
+// so a hung database can't block callers indefinitely
+func (s *SQLCameraStore) queryContext() (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.queryTimeout)
+}
+
+// Available reports whether a database connection is configured
+func (s *SQLCameraStore) Available() bool {
+	return s.db != nil
+}
+
+// UpdateCameraPathInfo stores MediaMTX path information in the database
+func (s *SQLCameraStore) UpdateCameraPathInfo(cameraID, pathName string, configured bool) {
+	if s.db == nil {
+		return // Database not available
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var lastProcessedAt interface{}
+	if configured {
+		lastProcessedAt = time.Now()
+	}
+
+	query := `
+		UPDATE cameras
+		SET "mediamtxPath" = $1,
+		    "mediamtxConfigured" = $2,
+		    "lastProcessedAt" = $3,
+		    status = $4
+		WHERE id = $5
+	`
+
+	status := "PROCESSING"
+	if !configured {
+		status = "OFFLINE"
+		lastProcessedAt = nil
+	}
+
+	_, err := s.db.ExecContext(ctx, query, pathName, configured, lastProcessedAt, status, cameraID)
+	if err != nil {
+		log.Printf("Failed to update camera path info: %v", err)
+	} else {
+		log.Printf("Updated database: camera %s, path %s, configured: %t", cameraID, pathName, configured)
+	}
+}
+
+// UpdateCameraStatus sets the camera's status column
+func (s *SQLCameraStore) UpdateCameraStatus(cameraID, status string) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE cameras SET status = $1 WHERE id = $2`, status, cameraID)
+	return err
+}
+
+// GetCameraInfo retrieves camera information from database
+func (s *SQLCameraStore) GetCameraInfo(cameraID string) (rtspURL, pathName string, configured bool, err error) {
+	if s.db == nil {
+		return "", "", false, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `
+		SELECT "rtspUrl", "mediamtxPath", "mediamtxConfigured"
+		FROM cameras
+		WHERE id = $1
+	`
+
+	var dbRtspURL, dbPathName sql.NullString
+	var dbConfigured sql.NullBool
+
+	err = s.db.QueryRowContext(ctx, query, cameraID).Scan(&dbRtspURL, &dbPathName, &dbConfigured)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	return dbRtspURL.String, dbPathName.String, dbConfigured.Bool, nil
+}
+
+// GetCameraName retrieves camera name from database
+func (s *SQLCameraStore) GetCameraName(cameraID string) string {
+	if s.db == nil {
+		return ""
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var name string
+	query := `SELECT name FROM cameras WHERE id = $1`
+	err := s.db.QueryRowContext(ctx, query, cameraID).Scan(&name)
+	if err != nil {
+		log.Printf("Failed to get camera name for %s: %v", cameraID, err)
+		return ""
+	}
+	return name
+}
+
+// GetCameraLabels returns the camera's labels; NULL yields an empty map
+func (s *SQLCameraStore) GetCameraLabels(cameraID string) (map[string]string, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var raw []byte
+	query := `SELECT labels FROM cameras WHERE id = $1`
+	if err := s.db.QueryRowContext(ctx, query, cameraID).Scan(&raw); err != nil {
+		return nil, err
+	}
+	return parseLabels(raw), nil
+}
+
+// GetFaceDetectionEnabled reports whether face detection is enabled for a camera
+func (s *SQLCameraStore) GetFaceDetectionEnabled(cameraID string) (bool, error) {
+	if s.db == nil {
+		return false, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var faceDetectionEnabled bool
+	query := `SELECT "faceDetectionEnabled" FROM cameras WHERE id = $1`
+	err := s.db.QueryRowContext(ctx, query, cameraID).Scan(&faceDetectionEnabled)
+	return faceDetectionEnabled, err
+}
+
+// SaveCameraFaceDetection stores the camera's enable override (nil inherits the group)
+// and its interval, threshold and ROI. Nil ones keep their stored values with
+// policyMerge and are cleared with policyReplace.
+func (s *SQLCameraStore) SaveCameraFaceDetection(cameraID string, policy FaceDetectionPolicy, update policyUpdate) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	roi, err := marshalROI(policy.ROI)
+	if err != nil {
+		return fmt.Errorf("failed to marshal roi: %w", err)
+	}
+
+	enabled := policy.Enabled != nil && *policy.Enabled
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE cameras
+		SET "faceDetectionEnabled" = $1,
+		    "faceDetectionOverride" = $2,
+		    "faceDetectionIntervalMs" = CASE WHEN $7 THEN $3 ELSE COALESCE($3, "faceDetectionIntervalMs") END,
+		    "faceDetectionThreshold" = CASE WHEN $7 THEN $4 ELSE COALESCE($4, "faceDetectionThreshold") END,
+		    "faceDetectionRoi" = CASE WHEN $7 THEN $5 ELSE COALESCE($5, "faceDetectionRoi") END
+		WHERE id = $6
+	`, enabled, policy.Enabled, policy.IntervalMs, policy.Threshold, roi, cameraID, update == policyReplace)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("camera %s not found", cameraID)
+	}
+	return nil
+}
+
+// ListConfiguredCameras returns all cameras with a configured MediaMTX path
+func (s *SQLCameraStore) ListConfiguredCameras() ([]CameraRecord, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// Include both actively processing cameras AND cameras with configured paths
+	query := `
+		SELECT id, "rtspUrl", "mediamtxPath", enabled, status
+		FROM cameras
+		WHERE "mediamtxConfigured" = true
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cameras := []CameraRecord{}
+	for rows.Next() {
+		var camera CameraRecord
+		if err := rows.Scan(&camera.ID, &camera.RTSPURL, &camera.PathName, &camera.Enabled, &camera.Status); err != nil {
+			log.Printf("Failed to scan camera row: %v", err)
+			continue
+		}
+		cameras = append(cameras, camera)
+	}
+
+	return cameras, rows.Err()
+}
+
+// ListCameras returns every camera known to the database
+func (s *SQLCameraStore) ListCameras() ([]CameraRecord, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `
+		SELECT id, name, "rtspUrl", "mediamtxPath", enabled, status,
+		       "mediamtxConfigured", "faceDetectionEnabled", "lastProcessedAt", labels
+		FROM cameras
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cameras := []CameraRecord{}
+	for rows.Next() {
+		var camera CameraRecord
+		var pathName sql.NullString
+		var lastProcessedAt sql.NullTime
+		var labels []byte
+		if err := rows.Scan(&camera.ID, &camera.Name, &camera.RTSPURL, &pathName, &camera.Enabled, &camera.Status,
+			&camera.Configured, &camera.FaceDetectionEnabled, &lastProcessedAt, &labels); err != nil {
+			log.Printf("Failed to scan camera row: %v", err)
+			continue
+		}
+		camera.PathName = pathName.String
+		if lastProcessedAt.Valid {
+			camera.LastProcessedAt = &lastProcessedAt.Time
+		}
+		camera.Labels = parseLabels(labels)
+		cameras = append(cameras, camera)
+	}
+
+	return cameras, rows.Err()
+}
+
+// UpsertCamera creates the camera, or updates the name, RTSP URL, enabled flag and
+// labels of the camera with the same ID. Path, status and detection columns are left
+// to their own setters.
+func (s *SQLCameraStore) UpsertCamera(record CameraRecord) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	labels, err := json.Marshal(record.Labels)
+	if err != nil {
+		return fmt.Errorf("failed to marshal labels: %w", err)
+	}
+	rtspURL, err := credentialCipher.Seal(record.RTSPURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO cameras (id, name, "rtspUrl", enabled, labels)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE
+		SET name = EXCLUDED.name,
+		    "rtspUrl" = EXCLUDED."rtspUrl",
+		    enabled = EXCLUDED.enabled,
+		    labels = EXCLUDED.labels
+	`, record.ID, record.Name, rtspURL, record.Enabled, labels)
+	return err
+}
+
+// UpdateCameraRTSPURL replaces a camera's source URL, sealing its credentials
+func (s *SQLCameraStore) UpdateCameraRTSPURL(cameraID, rtspURL string) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	sealed, err := credentialCipher.Seal(rtspURL)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE cameras SET "rtspUrl" = $1 WHERE id = $2`, sealed, cameraID)
+	return err
+}
+
+// GetStreamOptions returns the persisted stream options; NULL yields the defaults
+func (s *SQLCameraStore) GetStreamOptions(cameraID string) (StreamOptions, error) {
+	if s.db == nil {
+		return StreamOptions{}, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var raw []byte
+	query := `SELECT "streamOptions" FROM cameras WHERE id = $1`
+	if err := s.db.QueryRowContext(ctx, query, cameraID).Scan(&raw); err != nil {
+		return StreamOptions{}, err
+	}
+
+	var options StreamOptions
+	if len(raw) == 0 {
+		return options, nil
+	}
+	if err := json.Unmarshal(raw, &options); err != nil {
+		return StreamOptions{}, fmt.Errorf("failed to parse stream options: %w", err)
+	}
+	return options, nil
+}
+
+// SaveStreamOptions persists stream options for a camera
+func (s *SQLCameraStore) SaveStreamOptions(cameraID string, options StreamOptions) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// Dual-stream URLs carry credentials too
+	var err error
+	if options.ViewingRTSPURL, err = credentialCipher.Seal(options.ViewingRTSPURL); err != nil {
+		return err
+	}
+	if options.DetectionRTSPURL, err = credentialCipher.Seal(options.DetectionRTSPURL); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream options: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE cameras SET "streamOptions" = $1 WHERE id = $2`, raw, cameraID)
+	return err
+}
+
+// MemoryCameraStore is an in-memory CameraStore for tests and database-less runs
+type MemoryCameraStore struct {
+	cameras map[string]*CameraRecord
+	groups  map[string]*CameraGroup
+	mu      sync.RWMutex
+}
+
+// NewMemoryCameraStore creates an empty in-memory camera store
+func NewMemoryCameraStore() *MemoryCameraStore {
+	return &MemoryCameraStore{
+		cameras: make(map[string]*CameraRecord),
+		groups:  make(map[string]*CameraGroup),
+	}
+}
+
+// AddCamera inserts or replaces a camera row; a non-empty PathName marks it configured
+func (s *MemoryCameraStore) AddCamera(record CameraRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record.Status == "" {
+		record.Status = "OFFLINE"
+	}
+	if record.PathName != "" {
+		record.Configured = true
+	}
+	if record.Labels == nil {
+		record.Labels = map[string]string{}
+	}
+	s.cameras[record.ID] = &record
+}
+
+// Available always reports true for the in-memory store
+func (s *MemoryCameraStore) Available() bool {
+	return true
+}
+
+// UpdateCameraPathInfo mirrors the SQL update semantics; unknown cameras are ignored
+func (s *MemoryCameraStore) UpdateCameraPathInfo(cameraID, pathName string, configured bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return // Same as an UPDATE matching no rows
+	}
+
+	camera.PathName = pathName
+	camera.Configured = configured
+	if configured {
+		now := time.Now()
+		camera.LastProcessedAt = &now
+		camera.Status = "PROCESSING"
+	} else {
+		camera.LastProcessedAt = nil
+		camera.Status = "OFFLINE"
+	}
+}
+
+// UpdateCameraStatus sets the stored status; unknown cameras are ignored
+func (s *MemoryCameraStore) UpdateCameraStatus(cameraID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if camera, exists := s.cameras[cameraID]; exists {
+		camera.Status = status
+	}
+	return nil
+}
+
+// GetCameraInfo returns the stored RTSP URL and path info for a camera
+func (s *MemoryCameraStore) GetCameraInfo(cameraID string) (rtspURL, pathName string, configured bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return "", "", false, sql.ErrNoRows
+	}
+	return camera.RTSPURL, camera.PathName, camera.Configured, nil
+}
+
+// GetCameraName returns the stored camera name or "" if unknown
+func (s *MemoryCameraStore) GetCameraName(cameraID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if camera, exists := s.cameras[cameraID]; exists {
+		return camera.Name
+	}
+	return ""
+}
+
+// GetCameraLabels returns a copy of the camera's labels
+func (s *MemoryCameraStore) GetCameraLabels(cameraID string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return nil, fmt.Errorf("camera %s not found", cameraID)
+	}
+	labels := make(map[string]string, len(camera.Labels))
+	for key, value := range camera.Labels {
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// GetFaceDetectionEnabled returns the stored face detection flag
+func (s *MemoryCameraStore) GetFaceDetectionEnabled(cameraID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return false, sql.ErrNoRows
+	}
+	return camera.FaceDetectionEnabled, nil
+}
+
+// SaveCameraFaceDetection mirrors the SQL update: the flag is stored as the override and
+// the legacy column, and nil interval/threshold/ROI are kept or cleared per update
+func (s *MemoryCameraStore) SaveCameraFaceDetection(cameraID string, policy FaceDetectionPolicy, update policyUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return fmt.Errorf("camera %s not found", cameraID)
+	}
+	camera.FaceDetectionEnabled = policy.Enabled != nil && *policy.Enabled
+	camera.FaceDetection.Enabled = nil
+	if policy.Enabled != nil {
+		enabled := *policy.Enabled
+		camera.FaceDetection.Enabled = &enabled
+	}
+	if policy.IntervalMs != nil || update == policyReplace {
+		camera.FaceDetection.IntervalMs = policy.IntervalMs
+	}
+	if policy.Threshold != nil || update == policyReplace {
+		camera.FaceDetection.Threshold = policy.Threshold
+	}
+	if policy.ROI != nil || update == policyReplace {
+		camera.FaceDetection.ROI = policy.ROI
+	}
+	return nil
+}
+
+// ListConfiguredCameras returns cameras with a configured path, ordered by ID
+func (s *MemoryCameraStore) ListConfiguredCameras() ([]CameraRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cameras := []CameraRecord{}
+	for _, camera := range s.cameras {
+		if camera.Configured {
+			cameras = append(cameras, *camera)
+		}
+	}
+	sort.Slice(cameras, func(i, j int) bool { return cameras[i].ID < cameras[j].ID })
+	return cameras, nil
+}
+
+// ListCameras returns every stored camera, ordered by ID
+func (s *MemoryCameraStore) ListCameras() ([]CameraRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cameras := make([]CameraRecord, 0, len(s.cameras))
+	for _, camera := range s.cameras {
+		cameras = append(cameras, *camera)
+	}
+	sort.Slice(cameras, func(i, j int) bool { return cameras[i].ID < cameras[j].ID })
+	return cameras, nil
+}
+
+// UpsertCamera mirrors the SQL upsert: a new camera starts offline and unconfigured
+func (s *MemoryCameraStore) UpsertCamera(record CameraRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labels := make(map[string]string, len(record.Labels))
+	for key, value := range record.Labels {
+		labels[key] = value
+	}
+	camera, exists := s.cameras[record.ID]
+	if !exists {
+		camera = &CameraRecord{ID: record.ID, Status: "OFFLINE"}
+		s.cameras[record.ID] = camera
+	}
+	camera.Name = record.Name
+	camera.RTSPURL = record.RTSPURL
+	camera.Enabled = record.Enabled
+	camera.Labels = labels
+	return nil
+}
+
+// UpdateCameraRTSPURL replaces a camera's source URL
+func (s *MemoryCameraStore) UpdateCameraRTSPURL(cameraID, rtspURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return fmt.Errorf("camera %s not found", cameraID)
+	}
+	camera.RTSPURL = rtspURL
+	return nil
+}
+
+// GetStreamOptions returns the stored stream options for a camera
+func (s *MemoryCameraStore) GetStreamOptions(cameraID string) (StreamOptions, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camera, exists := s.cameras[cameraID]
+	if !exists {
+		return StreamOptions{}, sql.ErrNoRows
+	}
+	return camera.StreamOptions, nil
+}
+
+// SaveStreamOptions stores stream options; unknown cameras are ignored
+func (s *MemoryCameraStore) SaveStreamOptions(cameraID string, options StreamOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if camera, exists := s.cameras[cameraID]; exists {
+		camera.StreamOptions = options
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// What /process does at MAX_CONCURRENT_STREAMS, selected by STREAM_CAPACITY_MODE
+const (
+	capacityModeReject = "reject" // Return 429 right away (default)
+	capacityModeQueue  = "queue"  // Wait in a FIFO queue for a slot, 429 after STREAM_QUEUE_TIMEOUT
+)
+
+// capacityPollInterval rechecks capacity in case a slot freed without a Notify
+const capacityPollInterval = 500 * time.Millisecond
+
+// ErrCapacityQueueFull is returned when STREAM_QUEUE_MAX_DEPTH requests are already waiting
+var ErrCapacityQueueFull = errors.New("capacity queue is full")
+
+// CapacityTimeoutError is returned when no slot freed up within the queue timeout
+type CapacityTimeoutError struct {
+	Waited time.Duration
+}
+
+func (e *CapacityTimeoutError) Error() string {
+	return fmt.Sprintf("no stream slot freed up within %v", e.Waited.Round(time.Millisecond))
+}
+
+// capacityModeFromEnv reads STREAM_CAPACITY_MODE, defaulting to reject
+func capacityModeFromEnv() string {
+	switch mode := os.Getenv("STREAM_CAPACITY_MODE"); mode {
+	case capacityModeQueue:
+		return mode
+	case "", capacityModeReject:
+		return capacityModeReject
+	default:
+		log.Printf("Unknown STREAM_CAPACITY_MODE %q, using %q", mode, capacityModeReject)
+		return capacityModeReject
+	}
+}
+
+// capacityTicket is one waiting request's place in the queue
+type capacityTicket struct {
+	enqueuedAt time.Time
+}
+
+// CapacityQueue admits /process requests in arrival order as stream slots free up.
+// An admitted request holds a reservation until its start attempt finishes, so two
+// waiters can't both claim the same free slot.
+type CapacityQueue struct {
+	waiters  []*capacityTicket // Head is admitted next
+	reserved int
+	changed  chan struct{} // Closed and replaced whenever capacity may have changed
+
+	admitted uint64
+	timedOut uint64
+	rejected uint64 // Turned away because the queue was full
+	mu       sync.Mutex
+}
+
+// NewCapacityQueue creates an empty queue
+func NewCapacityQueue() *CapacityQueue {
+	return &CapacityQueue{changed: make(chan struct{})}
+}
+
+var capacityQueue = NewCapacityQueue()
+
+// Notify wakes the waiters to recheck capacity; called whenever a stream stops
+func (q *CapacityQueue) Notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Acquire waits behind earlier requests until weight more fits within limit alongside
+// the running and reserved streams, then reserves it. activeCount (the running streams'
+// total weight) is called without the queue's lock held, since it takes processMutex.
+// release must be called once the start attempt is over.
+func (q *CapacityQueue) Acquire(ctx context.Context, weight, limit, maxDepth int, timeout time.Duration, activeCount func() int) (release func(), err error) {
+	ticket := &capacityTicket{enqueuedAt: time.Now()}
+
+	q.mu.Lock()
+	if maxDepth > 0 && len(q.waiters) >= maxDepth {
+		q.rejected++
+		q.mu.Unlock()
+		return nil, ErrCapacityQueueFull
+	}
+	q.waiters = append(q.waiters, ticket)
+	q.mu.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(capacityPollInterval)
+	defer poll.Stop()
+
+	for {
+		active := activeCount()
+
+		q.mu.Lock()
+		if q.waiters[0] == ticket && active+q.reserved+weight <= limit {
+			q.waiters = q.waiters[1:]
+			q.reserved += weight
+			q.admitted++
+			q.mu.Unlock()
+
+			q.Notify() // The next waiter may fit as well
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					q.mu.Lock()
+					q.reserved -= weight
+					q.mu.Unlock()
+					q.Notify()
+				})
+			}, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-poll.C:
+		case <-deadline.C:
+			q.leave(ticket, true)
+			return nil, &CapacityTimeoutError{Waited: time.Since(ticket.enqueuedAt)}
+		case <-ctx.Done():
+			q.leave(ticket, false)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// leave removes a ticket that gave up and lets the one behind it move up
+func (q *CapacityQueue) leave(ticket *capacityTicket, timedOut bool) {
+	q.mu.Lock()
+	for i, waiter := range q.waiters {
+		if waiter == ticket {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			break
+		}
+	}
+	if timedOut {
+		q.timedOut++
+	}
+	q.mu.Unlock()
+	q.Notify()
+}
+
+// CapacityQueueStats is the capacity queue entry of /metrics
+type CapacityQueueStats struct {
+	Mode         string `json:"mode"`
+	Depth        int    `json:"depth"`    // Requests waiting now
+	Reserved     int    `json:"reserved"` // Weight of admitted requests still starting their stream
+	OldestWaitMs int64  `json:"oldestWaitMs,omitempty"`
+	Admitted     uint64 `json:"admitted"`
+	TimedOut     uint64 `json:"timedOut"`
+	Rejected     uint64 `json:"rejected"`
+}
+
+// Stats returns the queue's current depth and counters
+func (q *CapacityQueue) Stats() CapacityQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := CapacityQueueStats{
+		Mode:     currentWorkerConfig().CapacityMode,
+		Depth:    len(q.waiters),
+		Reserved: q.reserved,
+		Admitted: q.admitted,
+		TimedOut: q.timedOut,
+		Rejected: q.rejected,
+	}
+	if len(q.waiters) > 0 {
+		stats.OldestWaitMs = time.Since(q.waiters[0].enqueuedAt).Milliseconds()
+	}
+	return stats
+}
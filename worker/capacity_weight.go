@@ -0,0 +1,42 @@
+package main
+
+// maxStreamWeight bounds a camera's declared weight
+const maxStreamWeight = 100
+
+// streamWeight is how much of MAX_CONCURRENT_STREAMS a camera's stream takes. A
+// declared Weight wins, e.g. for a 4K camera that costs several 1080p encodes;
+// otherwise every FFmpeg output or process the stream brings counts one: the
+// re-encode itself, the QA observer tee, and the pre-roll ring in event recording.
+func streamWeight(options StreamOptions) int {
+	if options.Weight > 0 {
+		return options.Weight
+	}
+	weight := 1
+	if options.Observer.Enabled() {
+		weight++
+	}
+	if eventRecorder.enabled() && options.Output.resolvedType() == outputTypeRTSP {
+		weight++
+	}
+	return weight
+}
+
+// usedCapacity returns the total weight of the running streams
+func usedCapacity() int {
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+
+	used := 0
+	for _, process := range activeProcesses {
+		used += streamWeight(process.Options)
+	}
+	return used
+}
+
+// capacityUtilization formats used capacity as a percentage of the limit for /metrics
+func capacityUtilization(used, limit int) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return float64(used) / float64(limit) * 100
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxAffinityCPU bounds the CPU numbers a cpuAffinity list may name
+const maxAffinityCPU = 1023
+
+// cpuAffinityEnabled gates per-camera CPU pinning (CPU_AFFINITY_ENABLED); read at startup
+var cpuAffinityEnabled bool
+
+// loadCPUAffinityEnabled reads CPU_AFFINITY_ENABLED, warning when the platform can't pin
+func loadCPUAffinityEnabled() bool {
+	if os.Getenv("CPU_AFFINITY_ENABLED") != "true" {
+		return false
+	}
+	if !cpuAffinitySupported {
+		log.Printf("CPU_AFFINITY_ENABLED is set but CPU affinity isn't supported on %s, cameras won't be pinned", runtime.GOOS)
+		return false
+	}
+	return true
+}
+
+// parseCPUList parses a taskset-style list like "2-3,6" into sorted, distinct CPU numbers
+func parseCPUList(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		low, high, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU %q in %q", part, list)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(high); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU range %q in %q", part, list)
+			}
+		}
+		if first < 0 || last > maxAffinityCPU {
+			return nil, fmt.Errorf("CPU %q out of range (0-%d)", part, maxAffinityCPU)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// validateCPUAffinity checks a camera's cpuAffinity option. CPUs are only checked
+// against the host when the stream starts, so options persisted on a bigger host still
+// load.
+func validateCPUAffinity(list string) error {
+	if list == "" {
+		return nil
+	}
+	_, err := parseCPUList(list)
+	return err
+}
+
+// resolveCPUAffinity returns the CPUs a camera's FFmpeg should be pinned to, nil when
+// pinning is off, unset, or names no CPU this host has
+func resolveCPUAffinity(cameraID, list string) []int {
+	if list == "" {
+		return nil
+	}
+	if !cpuAffinityEnabled {
+		log.Printf("Ignoring cpuAffinity %q for camera %s: CPU_AFFINITY_ENABLED is not true", list, cameraID)
+		return nil
+	}
+	requested, err := parseCPUList(list)
+	if err != nil {
+		log.Printf("Ignoring cpuAffinity %q for camera %s: %v", list, cameraID, err)
+		return nil
+	}
+
+	// Check against the worker's own mask, not a CPU count: under a cpuset like 4-7 the
+	// usable CPUs don't start at 0
+	available := make(map[int]bool)
+	for _, cpu := range allowedCPUs() {
+		available[cpu] = true
+	}
+	cpus := make([]int, 0, len(requested))
+	for _, cpu := range requested {
+		if available[cpu] {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) < len(requested) {
+		log.Printf("cpuAffinity %q for camera %s names CPUs the worker may not use, using %v", list, cameraID, cpus)
+	}
+	if len(cpus) == 0 {
+		return nil
+	}
+	return cpus
+}
+
+// allCPUs is 0 to NumCPU-1, for when the affinity mask can't be read
+func allCPUs() []int {
+	cpus := make([]int, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus
+}
+
+// unpinnedCPUs returns the CPUs the worker may use that no running camera is pinned to,
+// for the FFmpeg of cameras without a cpuAffinity. It is nil, meaning anywhere, when
+// pinning is off, nothing is pinned, or the pins cover every CPU. The caller holds
+// processMutex.
+func unpinnedCPUs() []int {
+	if !cpuAffinityEnabled {
+		return nil
+	}
+	pinned := make(map[int]bool)
+	for _, process := range activeProcesses {
+		for _, cpu := range process.CPUAffinity {
+			pinned[cpu] = true
+		}
+	}
+	if len(pinned) == 0 {
+		return nil
+	}
+	cpus := []int{}
+	for _, cpu := range allowedCPUs() {
+		if !pinned[cpu] {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil
+	}
+	return cpus
+}
+
+// rebalanceUnpinnedAffinity moves every unpinned FFmpeg onto unpinnedCPUs after the
+// pinned set changed, so pinned CPUs stay reserved for their cameras. The caller holds
+// processMutex.
+func rebalanceUnpinnedAffinity() {
+	if !cpuAffinityEnabled {
+		return
+	}
+	cpus := unpinnedCPUs()
+	if cpus == nil {
+		cpus = allowedCPUs() // Nothing reserved any more
+	}
+	for cameraID, process := range activeProcesses {
+		if len(process.CPUAffinity) > 0 || process.Command == nil || process.Command.Process == nil {
+			continue
+		}
+		if err := setProcessAffinity(process.Command.Process.Pid, cpus); err != nil {
+			log.Printf("Warning: failed to move FFmpeg for camera %s to CPUs %s: %v", cameraID, formatCPUList(cpus), err)
+		}
+	}
+}
+
+// formatCPUList is the inverse of parseCPUList for sorted CPUs, for logs and /streams
+func formatCPUList(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// cpuAffinitySupported reports whether setProcessAffinity can pin processes here
+const cpuAffinitySupported = true
+
+// allowedCPUs returns the CPUs in the worker's own affinity mask, e.g. its cpuset
+func allowedCPUs() []int {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return allCPUs()
+	}
+	cpus := []int{}
+	for cpu := 0; cpu <= maxAffinityCPU; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+// setProcessAffinity pins every thread of the process to cpus. The main thread goes
+// first so threads it spawns from then on inherit the mask; the ones FFmpeg already
+// started are listed from /proc and pinned individually.
+func setProcessAffinity(pid int, cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(pid, &set); err != nil {
+		return fmt.Errorf("sched_setaffinity %d: %w", pid, err)
+	}
+
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil // Main thread pinned; nothing more to find
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil || tid == pid {
+			continue
+		}
+		// A thread that exited in the meantime is fine to miss
+		if err := unix.SchedSetaffinity(tid, &set); err != nil && err != unix.ESRCH {
+			return fmt.Errorf("sched_setaffinity %d (thread of %d): %w", tid, pid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// allowedCPUs is every CPU outside Linux, where the mask can't be read
+func allowedCPUs() []int {
+	return allCPUs()
+}
+
+// cpuAffinitySupported reports whether setProcessAffinity can pin processes here
+const cpuAffinitySupported = false
+
+// setProcessAffinity is a no-op outside Linux; loadCPUAffinityEnabled keeps pinning off
+func setProcessAffinity(pid int, cpus []int) error {
+	return errors.New("CPU affinity is not supported on this platform")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveCPUAffinityUsesAffinityMask(t *testing.T) {
+	saved := cpuAffinityEnabled
+	cpuAffinityEnabled = true
+	defer func() { cpuAffinityEnabled = saved }()
+
+	allowed := allowedCPUs()
+	if len(allowed) == 0 {
+		t.Fatal("allowedCPUs returned no CPUs")
+	}
+	// Every CPU the mask allows is kept, whatever its number, and nothing else
+	got := resolveCPUAffinity("camera", "0-1023")
+	if !slices.Equal(got, allowed) {
+		t.Fatalf("resolveCPUAffinity kept %v, want the affinity mask %v", got, allowed)
+	}
+}
+
+func TestUnpinnedCPUsIsTheComplement(t *testing.T) {
+	saved := cpuAffinityEnabled
+	cpuAffinityEnabled = true
+	defer func() { cpuAffinityEnabled = saved }()
+
+	allowed := allowedCPUs()
+	if len(allowed) < 2 {
+		t.Skip("needs at least two usable CPUs")
+	}
+	processMutex.Lock()
+	savedProcesses := activeProcesses
+	activeProcesses = map[string]*ReencodingProcess{
+		"pinned":   {CPUAffinity: allowed[:1]},
+		"unpinned": {},
+	}
+	free := unpinnedCPUs()
+	activeProcesses = map[string]*ReencodingProcess{"unpinned": {}}
+	none := unpinnedCPUs()
+	activeProcesses = savedProcesses
+	processMutex.Unlock()
+
+	if !slices.Equal(free, allowed[1:]) {
+		t.Fatalf("unpinnedCPUs = %v, want %v", free, allowed[1:])
+	}
+	if none != nil {
+		t.Fatalf("unpinnedCPUs with nothing pinned = %v, want nil", none)
+	}
+}
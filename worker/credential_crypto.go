@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// sealedCredentialPrefix starts the userinfo of a source URL whose credentials are
+// encrypted: "rtsp://enc.<key version>.<wrapped data key>.<ciphertext>@host/path"
+const sealedCredentialPrefix = "enc."
+
+// credentialKeyVersionPattern limits key versions to characters that survive in a URL userinfo
+var credentialKeyVersionPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,16}$`)
+
+// ErrPlaintextCredentials is returned when a source URL with credentials would be
+// stored unencrypted without RTSP_CREDENTIAL_PLAINTEXT=true
+var ErrPlaintextCredentials = errors.New("refusing to store RTSP credentials in plaintext: set RTSP_CREDENTIAL_KEYS, or RTSP_CREDENTIAL_PLAINTEXT=true for development")
+
+// CredentialKeyWrapper encrypts the per-value data keys. The local implementation
+// uses key-encryption keys from the environment; a KMS client can take its place.
+type CredentialKeyWrapper interface {
+	// ActiveVersion is the key version new values are sealed with
+	ActiveVersion() string
+	Wrap(dataKey []byte) (version string, wrapped []byte, err error)
+	Unwrap(version string, wrapped []byte) ([]byte, error)
+}
+
+// localKeyWrapper wraps data keys with AES-256-GCM under versioned keys from RTSP_CREDENTIAL_KEYS
+type localKeyWrapper struct {
+	active string
+	keys   map[string][]byte
+}
+
+func (w *localKeyWrapper) ActiveVersion() string { return w.active }
+
+func (w *localKeyWrapper) Wrap(dataKey []byte) (string, []byte, error) {
+	wrapped, err := sealAESGCM(w.keys[w.active], dataKey, []byte(w.active))
+	return w.active, wrapped, err
+}
+
+func (w *localKeyWrapper) Unwrap(version string, wrapped []byte) ([]byte, error) {
+	key, exists := w.keys[version]
+	if !exists {
+		return nil, fmt.Errorf("unknown credential key version %q", version)
+	}
+	return openAESGCM(key, wrapped, []byte(version))
+}
+
+// CredentialCipher seals and opens the credentials embedded in source URLs. With no
+// wrapper, credentials stay in plaintext, which is only allowed when configured.
+type CredentialCipher struct {
+	wrapper        CredentialKeyWrapper
+	allowPlaintext bool
+}
+
+// loadCredentialCipher reads RTSP_CREDENTIAL_KEYS ("v2:<base64 32-byte key>,v1:<...>",
+// the first one sealing new values, the rest still opening old ones) and
+// RTSP_CREDENTIAL_PLAINTEXT. Malformed keys are fatal rather than falling back to plaintext.
+func loadCredentialCipher() *CredentialCipher {
+	credentials := &CredentialCipher{allowPlaintext: os.Getenv("RTSP_CREDENTIAL_PLAINTEXT") == "true"}
+
+	value := strings.TrimSpace(os.Getenv("RTSP_CREDENTIAL_KEYS"))
+	if value == "" {
+		if credentials.allowPlaintext {
+			log.Printf("Warning: RTSP_CREDENTIAL_PLAINTEXT=true, camera credentials are stored unencrypted")
+		}
+		return credentials
+	}
+
+	wrapper := &localKeyWrapper{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(value, ",") {
+		version, encoded, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || !credentialKeyVersionPattern.MatchString(version) {
+			log.Fatalf("Invalid RTSP_CREDENTIAL_KEYS entry %q: expected <version>:<base64 key>", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			log.Fatalf("Invalid RTSP_CREDENTIAL_KEYS key %q: expected 32 bytes, base64 encoded", version)
+		}
+		if _, exists := wrapper.keys[version]; exists {
+			log.Fatalf("Invalid RTSP_CREDENTIAL_KEYS: version %q is listed twice", version)
+		}
+		if wrapper.active == "" {
+			wrapper.active = version
+		}
+		wrapper.keys[version] = key
+	}
+	credentials.wrapper = wrapper
+	log.Printf("Camera credentials are encrypted at rest with key %s (%d key(s) loaded)", wrapper.active, len(wrapper.keys))
+	return credentials
+}
+
+var credentialCipher = &CredentialCipher{allowPlaintext: true}
+
+// Enabled reports whether credentials are encrypted
+func (c *CredentialCipher) Enabled() bool {
+	return c.wrapper != nil
+}
+
+// isSealedCredential reports whether a URL's userinfo is an encrypted credential
+func isSealedCredential(user *url.Userinfo) bool {
+	if user == nil {
+		return false
+	}
+	_, hasPassword := user.Password()
+	return !hasPassword && strings.HasPrefix(user.Username(), sealedCredentialPrefix)
+}
+
+// Seal encrypts the credentials of a source URL before it is stored. URLs without
+// credentials, and already sealed ones, are returned as they are.
+func (c *CredentialCipher) Seal(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil || isSealedCredential(parsed.User) {
+		return rawURL, nil
+	}
+	if !c.Enabled() {
+		if c.allowPlaintext {
+			return rawURL, nil
+		}
+		return "", ErrPlaintextCredentials
+	}
+
+	// Envelope encryption: a fresh data key per value, itself encrypted by the wrapper
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	version, wrapped, err := c.wrapper.Wrap(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	sealed, err := sealAESGCM(dataKey, []byte(parsed.User.String()), []byte(version))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+
+	parsed.User = url.User(sealedCredentialPrefix + version + "." +
+		base64.RawURLEncoding.EncodeToString(wrapped) + "." + base64.RawURLEncoding.EncodeToString(sealed))
+	return parsed.String(), nil
+}
+
+// Open decrypts a sealed source URL for use. Anything else is returned unchanged. A
+// sealed URL that can't be opened is an error, never passed on as it is.
+func (c *CredentialCipher) Open(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !isSealedCredential(parsed.User) {
+		return rawURL, nil
+	}
+	if !c.Enabled() {
+		return "", fmt.Errorf("source URL has encrypted credentials but RTSP_CREDENTIAL_KEYS is not set")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(parsed.User.Username(), sealedCredentialPrefix), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted credentials")
+	}
+	version := parts[0]
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted credentials: %w", err)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted credentials: %w", err)
+	}
+	dataKey, err := c.wrapper.Unwrap(version, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap credential key: %w", err)
+	}
+	userinfo, err := openAESGCM(dataKey, sealed, []byte(version))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+
+	// Parse the escaped userinfo the same way it was produced
+	holder, err := url.Parse("rtsp://" + string(userinfo) + "@host")
+	if err != nil {
+		return "", fmt.Errorf("malformed decrypted credentials")
+	}
+	parsed.User = holder.User
+	return parsed.String(), nil
+}
+
+// Reseal re-encrypts a stored URL under the active key. changed is false when it
+// already is, or has no credentials.
+func (c *CredentialCipher) Reseal(rawURL string) (resealed string, changed bool, err error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil || !c.Enabled() {
+		return rawURL, false, nil
+	}
+	if isSealedCredential(parsed.User) {
+		version, _, _ := strings.Cut(strings.TrimPrefix(parsed.User.Username(), sealedCredentialPrefix), ".")
+		if version == c.wrapper.ActiveVersion() {
+			return rawURL, false, nil
+		}
+	}
+	opened, err := c.Open(rawURL)
+	if err != nil {
+		return rawURL, false, err
+	}
+	resealed, err = c.Seal(opened)
+	return resealed, err == nil, err
+}
+
+// openSourceURL decrypts a source URL's credentials right before it is dialed
+func openSourceURL(rawURL string) (string, error) {
+	return credentialCipher.Open(rawURL)
+}
+
+// sealAESGCM encrypts plaintext with AES-GCM, prefixing the random nonce
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM reverses sealAESGCM
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// CredentialRotationResult is the response of POST /credentials/rotate
+type CredentialRotationResult struct {
+	ActiveKey string            `json:"activeKey"`
+	Resealed  []string          `json:"resealed"`  // Cameras rewritten under the active key
+	Unchanged int               `json:"unchanged"` // Already sealed with it, or without credentials
+	Failed    map[string]string `json:"failed"`
+}
+
+// rotateStoredCredentials re-encrypts every camera's source URLs (rtspUrl and the
+// dual-stream URLs in its stream options) under the active key. Plaintext credentials
+// left from before encryption was enabled are sealed too. Once nothing fails, retired
+// keys can be dropped from RTSP_CREDENTIAL_KEYS.
+func rotateStoredCredentials(store CameraStore) (CredentialRotationResult, error) {
+	result := CredentialRotationResult{Resealed: []string{}, Failed: map[string]string{}}
+	if !credentialCipher.Enabled() {
+		return result, fmt.Errorf("credential encryption is not enabled (set RTSP_CREDENTIAL_KEYS)")
+	}
+	result.ActiveKey = credentialCipher.wrapper.ActiveVersion()
+
+	cameras, err := store.ListCameras()
+	if err != nil {
+		return result, fmt.Errorf("failed to list cameras: %w", err)
+	}
+	for _, camera := range cameras {
+		changed, err := resealCamera(store, camera)
+		switch {
+		case err != nil:
+			log.Printf("Failed to re-encrypt credentials of camera %s: %v", camera.ID, err)
+			result.Failed[camera.ID] = err.Error()
+		case changed:
+			result.Resealed = append(result.Resealed, camera.ID)
+		default:
+			result.Unchanged++
+		}
+	}
+	return result, nil
+}
+
+// resealCamera re-encrypts one camera's stored URLs
+func resealCamera(store CameraStore, camera CameraRecord) (bool, error) {
+	rtspURL, urlChanged, err := credentialCipher.Reseal(camera.RTSPURL)
+	if err != nil {
+		return false, err
+	}
+	if urlChanged {
+		if err := store.UpdateCameraRTSPURL(camera.ID, rtspURL); err != nil {
+			return false, err
+		}
+	}
+
+	options, err := store.GetStreamOptions(camera.ID)
+	if err != nil {
+		return urlChanged, fmt.Errorf("stream options: %w", err)
+	}
+	viewingURL, viewingChanged, err := credentialCipher.Reseal(options.ViewingRTSPURL)
+	if err != nil {
+		return urlChanged, fmt.Errorf("viewingRtspUrl: %w", err)
+	}
+	detectionURL, detectionChanged, err := credentialCipher.Reseal(options.DetectionRTSPURL)
+	if err != nil {
+		return urlChanged, fmt.Errorf("detectionRtspUrl: %w", err)
+	}
+	if viewingChanged || detectionChanged {
+		options.ViewingRTSPURL, options.DetectionRTSPURL = viewingURL, detectionURL
+		if err := store.SaveStreamOptions(camera.ID, options); err != nil {
+			return urlChanged, fmt.Errorf("stream options: %w", err)
+		}
+	}
+	return urlChanged || viewingChanged || detectionChanged, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DBConfig holds the connection pool limits and the per-statement timeout
+type DBConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration // Recycle connections before a load balancer drops them
+	ConnMaxIdleTime time.Duration
+	QueryTimeout    time.Duration // Deadline for each statement; 0 disables it
+}
+
+// loadDBConfig reads the pool settings from the environment
+func loadDBConfig() DBConfig {
+	return DBConfig{
+		MaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", 10),
+		MaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", 5),
+		ConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime: getEnvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+		QueryTimeout:    getEnvDuration("DB_QUERY_TIMEOUT", 5*time.Second),
+	}
+}
+
+// apply sets the pool limits on db
+func (c DBConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
+
+// getEnvInt parses a non-negative integer from the environment
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid %s %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvFloat parses a non-negative number from the environment
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid %s %q, using %g", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// DBPoolStats is the connection pool view returned by /metrics
+type DBPoolStats struct {
+	Available         bool   `json:"available"`
+	MaxOpenConns      int    `json:"maxOpenConns"`
+	OpenConns         int    `json:"openConns"`
+	InUse             int    `json:"inUse"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"waitCount"`
+	WaitDuration      string `json:"waitDuration"`
+	MaxIdleClosed     int64  `json:"maxIdleClosed"`
+	MaxIdleTimeClosed int64  `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed int64  `json:"maxLifetimeClosed"`
+	QueryTimeout      string `json:"queryTimeout"`
+}
+
+// dbPoolStats reports the pool state of db; a nil db reports unavailable
+func dbPoolStats(db *sql.DB, config DBConfig) DBPoolStats {
+	if db == nil {
+		return DBPoolStats{}
+	}
+	stats := db.Stats()
+	return DBPoolStats{
+		Available:         true,
+		MaxOpenConns:      stats.MaxOpenConnections,
+		OpenConns:         stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDuration:      stats.WaitDuration.String(),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+		QueryTimeout:      config.QueryTimeout.String(),
+	}
+}
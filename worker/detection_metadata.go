@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	detectionCueHistory     = 300 // Recent cues kept per camera for WebVTT
+	detectionSubscriberBuf  = 32  // Per-subscriber buffer; slow subscribers drop cues
+	detectionCueMinDuration = 500 * time.Millisecond
+)
+
+// DetectionBox is a bounding box in pixels plus its normalized (0-1) form, so a player
+// can overlay it at any display size
+type DetectionBox struct {
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	NX     float64 `json:"nx"`
+	NY     float64 `json:"ny"`
+	NW     float64 `json:"nw"`
+	NH     float64 `json:"nh"`
+	// Set for object detections
+	Label      string  `json:"label,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// DetectionCue is one frame's detections, timed against the stream
+type DetectionCue struct {
+	CameraID    string         `json:"cameraId"`
+	Type        string         `json:"type"` // "face" or "object"
+	At          time.Time      `json:"at"`
+	StreamStart time.Time      `json:"streamStart"`
+	OffsetMs    int64          `json:"offsetMs"`   // At relative to StreamStart
+	DurationMs  int64          `json:"durationMs"` // How long the boxes stay valid
+	FrameWidth  int            `json:"frameWidth"`
+	FrameHeight int            `json:"frameHeight"`
+	Boxes       []DetectionBox `json:"boxes"`
+}
+
+// DetectionMetadataHub fans detection cues out to subscribed players and keeps a short
+// per-camera history for WebVTT export
+type DetectionMetadataHub struct {
+	history     map[string][]DetectionCue // Oldest first
+	subscribers map[string]map[chan DetectionCue]struct{}
+	mu          sync.Mutex
+}
+
+// NewDetectionMetadataHub creates an empty hub
+func NewDetectionMetadataHub() *DetectionMetadataHub {
+	return &DetectionMetadataHub{
+		history:     make(map[string][]DetectionCue),
+		subscribers: make(map[string]map[chan DetectionCue]struct{}),
+	}
+}
+
+// newDetectionCue builds a cue for the given faces, timed against the camera's running stream
+func newDetectionCue(cameraID, cueType string, faces []image.Rectangle, cols, rows int, at time.Time, validFor time.Duration) DetectionCue {
+	if validFor < detectionCueMinDuration {
+		validFor = detectionCueMinDuration
+	}
+
+	cue := DetectionCue{
+		CameraID:    cameraID,
+		Type:        cueType,
+		At:          at,
+		DurationMs:  validFor.Milliseconds(),
+		FrameWidth:  cols,
+		FrameHeight: rows,
+		Boxes:       make([]DetectionBox, 0, len(faces)),
+	}
+
+	processMutex.RLock()
+	if process, exists := activeProcesses[cameraID]; exists {
+		cue.StreamStart = process.StartedAt
+		cue.OffsetMs = at.Sub(process.StartedAt).Milliseconds()
+	}
+	processMutex.RUnlock()
+
+	for _, face := range faces {
+		box := DetectionBox{X: face.Min.X, Y: face.Min.Y, Width: face.Dx(), Height: face.Dy()}
+		if cols > 0 && rows > 0 {
+			box.NX = float64(face.Min.X) / float64(cols)
+			box.NY = float64(face.Min.Y) / float64(rows)
+			box.NW = float64(face.Dx()) / float64(cols)
+			box.NH = float64(face.Dy()) / float64(rows)
+		}
+		cue.Boxes = append(cue.Boxes, box)
+	}
+	return cue
+}
+
+// Publish records a cue and delivers it to the camera's subscribers without blocking
+func (h *DetectionMetadataHub) Publish(cue DetectionCue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	history := append(h.history[cue.CameraID], cue)
+	if excess := len(history) - detectionCueHistory; excess > 0 {
+		history = append(history[:0], history[excess:]...)
+	}
+	h.history[cue.CameraID] = history
+
+	for ch := range h.subscribers[cue.CameraID] {
+		select {
+		case ch <- cue:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of live cues for a camera and a function to unsubscribe
+func (h *DetectionMetadataHub) Subscribe(cameraID string) (<-chan DetectionCue, func()) {
+	ch := make(chan DetectionCue, detectionSubscriberBuf)
+
+	h.mu.Lock()
+	if h.subscribers[cameraID] == nil {
+		h.subscribers[cameraID] = make(map[chan DetectionCue]struct{})
+	}
+	h.subscribers[cameraID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers[cameraID], ch)
+		if len(h.subscribers[cameraID]) == 0 {
+			delete(h.subscribers, cameraID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Recent returns a camera's cues newer than since
+func (h *DetectionMetadataHub) Recent(cameraID string, since time.Time) []DetectionCue {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cues := []DetectionCue{}
+	for _, cue := range h.history[cameraID] {
+		if cue.At.After(since) {
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+// Forget drops a camera's history once its stream stops
+func (h *DetectionMetadataHub) Forget(cameraID string) {
+	h.mu.Lock()
+	delete(h.history, cameraID)
+	h.mu.Unlock()
+}
+
+// renderWebVTT renders cues as a WebVTT metadata track whose payloads are the cue JSON.
+// Cue times are offsets from the stream start, so only the latest stream session is
+// rendered, and each cue is clamped to end when the next one starts.
+func renderWebVTT(cues []DetectionCue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+
+	var session time.Time
+	if len(cues) > 0 {
+		session = cues[len(cues)-1].StreamStart
+	}
+
+	for i, cue := range cues {
+		if session.IsZero() || !cue.StreamStart.Equal(session) {
+			continue
+		}
+		start := time.Duration(cue.OffsetMs) * time.Millisecond
+		end := start + time.Duration(cue.DurationMs)*time.Millisecond
+		if i+1 < len(cues) && cues[i+1].StreamStart.Equal(cue.StreamStart) {
+			if next := time.Duration(cues[i+1].OffsetMs) * time.Millisecond; next < end {
+				end = next
+			}
+		}
+		if end <= start {
+			continue
+		}
+
+		payload, err := json.Marshal(cue)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatVTTTimestamp(start), formatVTTTimestamp(end), payload)
+	}
+	return b.String()
+}
+
+// formatVTTTimestamp formats d as HH:MM:SS.mmm
+func formatVTTTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
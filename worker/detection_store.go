@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// DetectionRecord is a face detection alert as kept in the detections table. The
+// thumbnail isn't stored; ID is the alert's eventId, which links the record to the
+// Kafka alert carrying it, and ClipPath points at the exported recording clip if any.
+type DetectionRecord struct {
+	ID         string         `json:"id"`
+	CameraID   string         `json:"cameraId"`
+	CameraName string         `json:"cameraName,omitempty"`
+	TenantID   string         `json:"tenantId,omitempty"`
+	SiteID     string         `json:"siteId,omitempty"`
+	DetectedAt time.Time      `json:"detectedAt"`
+	FaceCount  int            `json:"faceCount"`
+	Confidence float64        `json:"confidence"`
+	Boxes      []DetectionBox `json:"boxes"`
+	ClipPath   string         `json:"clipPath,omitempty"`
+}
+
+// newDetectionRecord builds the stored form of an alert; boxes are the ones published
+// on the camera's metadata track
+func newDetectionRecord(alert FaceDetectionAlert, boxes []DetectionBox) DetectionRecord {
+	return DetectionRecord{
+		ID:         alert.EventID,
+		CameraID:   alert.CameraID,
+		CameraName: alert.CameraName,
+		TenantID:   alert.TenantID,
+		SiteID:     alert.SiteID,
+		DetectedAt: alert.DetectedAt,
+		FaceCount:  alert.FaceCount,
+		Confidence: alert.Confidence,
+		Boxes:      boxes,
+		ClipPath:   alert.ClipPath,
+	}
+}
+
+// DetectionFilter selects detections for GET /detections; zero fields don't filter
+type DetectionFilter struct {
+	CameraID     string
+	From, To     time.Time // DetectedAt range, From inclusive and To exclusive
+	MinFaceCount int
+	Limit        int
+	Offset       int
+}
+
+// DetectionStore writes face detections to the detections table in the background and
+// answers queries over them. It is nil unless DETECTION_STORE_ENABLED is set and the
+// database is available.
+type DetectionStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+	writes       *AlertQueue // Ordered, drop-oldest, so a slow database never blocks detection
+}
+
+var detectionStore *DetectionStore
+
+// NewDetectionStoreFromEnv returns a store when DETECTION_STORE_ENABLED=true and the
+// database is connected, nil otherwise
+func NewDetectionStoreFromEnv(db *sql.DB, queryTimeout time.Duration) *DetectionStore {
+	if os.Getenv("DETECTION_STORE_ENABLED") != "true" {
+		return nil
+	}
+	if db == nil {
+		log.Println("DETECTION_STORE_ENABLED is set but the database is not available, detections won't be stored")
+		return nil
+	}
+	log.Println("Storing face detections in the detections table")
+	return &DetectionStore{db: db, queryTimeout: queryTimeout, writes: NewAlertQueue()}
+}
+
+func (s *DetectionStore) queryContext() (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.queryTimeout)
+}
+
+// Record queues a detection for writing; a nil store drops it
+func (s *DetectionStore) Record(record DetectionRecord) {
+	if s == nil {
+		return
+	}
+	s.writes.Enqueue(record.CameraID, func() error { return s.insert(record) })
+}
+
+func (s *DetectionStore) insert(record DetectionRecord) error {
+	boxes, err := json.Marshal(record.Boxes)
+	if err != nil {
+		return fmt.Errorf("failed to encode detection boxes: %w", err)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `
+		INSERT INTO detections (id, "cameraId", "cameraName", "tenantId", "siteId", "detectedAt",
+		                        "faceCount", confidence, boxes, "clipPath")
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, NULLIF($10, ''))
+		ON CONFLICT (id) DO NOTHING
+	`
+	_, err = s.db.ExecContext(ctx, query, record.ID, record.CameraID, record.CameraName, record.TenantID, record.SiteID,
+		record.DetectedAt, record.FaceCount, record.Confidence, string(boxes), record.ClipPath)
+	if err != nil {
+		return fmt.Errorf("failed to store detection: %w", err)
+	}
+	return nil
+}
+
+// Query returns one page of matching detections, newest first, and the total match count
+func (s *DetectionStore) Query(filter DetectionFilter) ([]DetectionRecord, int, error) {
+	var conditions []string
+	var args []any
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.CameraID != "" {
+		addCondition(`"cameraId" = $%d`, filter.CameraID)
+	}
+	if !filter.From.IsZero() {
+		addCondition(`"detectedAt" >= $%d`, filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition(`"detectedAt" < $%d`, filter.To)
+	}
+	if filter.MinFaceCount > 0 {
+		addCondition(`"faceCount" >= $%d`, filter.MinFaceCount)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM detections "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, "cameraId", "cameraName", "tenantId", "siteId", "detectedAt", "faceCount", confidence, boxes, "clipPath"
+		FROM detections
+		%s
+		ORDER BY "detectedAt" DESC, id
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+	rows, err := s.db.QueryContext(ctx, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	detections := []DetectionRecord{}
+	for rows.Next() {
+		var record DetectionRecord
+		var cameraName, tenantID, siteID, clipPath sql.NullString
+		var boxes []byte
+		if err := rows.Scan(&record.ID, &record.CameraID, &cameraName, &tenantID, &siteID, &record.DetectedAt,
+			&record.FaceCount, &record.Confidence, &boxes, &clipPath); err != nil {
+			log.Printf("Failed to scan detection row: %v", err)
+			continue
+		}
+		record.CameraName, record.TenantID, record.SiteID, record.ClipPath = cameraName.String, tenantID.String, siteID.String, clipPath.String
+		record.Boxes = []DetectionBox{}
+		if len(boxes) > 0 {
+			if err := json.Unmarshal(boxes, &record.Boxes); err != nil {
+				log.Printf("Failed to parse boxes of detection %s: %v", record.ID, err)
+			}
+		}
+		detections = append(detections, record)
+	}
+	return detections, total, rows.Err()
+}
+
+// Stats reports the write queue's counters; zero for a nil store
+func (s *DetectionStore) Stats() AlertQueueStats {
+	if s == nil {
+		return AlertQueueStats{}
+	}
+	return s.writes.Stats()
+}
+
+// Close flushes queued writes
+func (s *DetectionStore) Close() {
+	if s != nil {
+		s.writes.Close()
+	}
+}
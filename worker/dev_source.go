@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// devSourceScheme selects FFmpeg's synthetic test pattern instead of a camera,
+// e.g. "testsrc://?pattern=smptebars&size=640x480&rate=15"
+const devSourceScheme = "testsrc://"
+
+// devTestPatterns are the lavfi sources testsrc:// can generate
+var devTestPatterns = map[string]bool{"testsrc": true, "testsrc2": true, "smptebars": true, "rgbtestsrc": true}
+
+var devSourceSizePattern = regexp.MustCompile(`^[0-9]{2,4}x[0-9]{2,4}$`)
+
+// devModeEnabled reports whether DEV_MODE allows synthetic and file sources
+func devModeEnabled() bool {
+	return os.Getenv("DEV_MODE") == "true"
+}
+
+// isDevSource reports whether the source is a test pattern or a local file rather
+// than a camera
+func isDevSource(sourceURL string) bool {
+	return strings.HasPrefix(sourceURL, devSourceScheme) || strings.HasPrefix(sourceURL, "file://") ||
+		strings.HasPrefix(sourceURL, "/")
+}
+
+// devSourceInput returns the FFmpeg input for a development source: a lavfi test
+// pattern for testsrc://, or a local file looped forever at its native rate (use a
+// clip with faces to exercise face detection)
+func devSourceInput(sourceURL string) (string, ffmpeg.KwArgs, error) {
+	if !devModeEnabled() {
+		return "", nil, fmt.Errorf("test pattern and file sources require DEV_MODE=true")
+	}
+
+	if strings.HasPrefix(sourceURL, devSourceScheme) {
+		query, err := url.ParseQuery(strings.TrimPrefix(strings.TrimPrefix(sourceURL, devSourceScheme), "?"))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid test pattern options: %w", err)
+		}
+
+		pattern, size, rate := "testsrc2", "1280x720", 30
+		if value := query.Get("pattern"); value != "" {
+			if !devTestPatterns[value] {
+				return "", nil, fmt.Errorf("unsupported test pattern %q (expected testsrc, testsrc2, smptebars or rgbtestsrc)", value)
+			}
+			pattern = value
+		}
+		if value := query.Get("size"); value != "" {
+			if !devSourceSizePattern.MatchString(value) {
+				return "", nil, fmt.Errorf("invalid test pattern size %q (expected e.g. 1280x720)", value)
+			}
+			size = value
+		}
+		if value := query.Get("rate"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 60 {
+				return "", nil, fmt.Errorf("invalid test pattern rate %q (expected 1-60)", value)
+			}
+			rate = parsed
+		}
+		return fmt.Sprintf("%s=size=%s:rate=%d", pattern, size, rate), ffmpeg.KwArgs{"f": "lavfi", "re": ""}, nil
+	}
+
+	path := strings.TrimPrefix(sourceURL, "file://")
+	if _, err := os.Stat(path); err != nil {
+		return "", nil, fmt.Errorf("source file not readable: %w", err)
+	}
+	return path, ffmpeg.KwArgs{"re": "", "stream_loop": "-1"}, nil
+}
+
+// detectionSourceURL is where face detection reads frames: the camera's detection stream
+// when one is set, the camera itself, or the re-encoded output for development and
+// adapter sources, which OpenCV can't open
+func detectionSourceURL(sourceURL, targetURL string, options StreamOptions) string {
+	if options.DetectionRTSPURL != "" {
+		return options.DetectionRTSPURL
+	}
+	if isDevSource(sourceURL) || isAdapterSource(sourceURL) {
+		return targetURL
+	}
+	return sourceURL
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// EncodingProfile overrides the encoder settings a camera is re-encoded with, e.g. a
+// higher bitrate and main profile for a 4K camera or a lower one for a 480p camera on
+// a thin link. Unset fields keep the defaults: libx264 baseline at 1.5Mbps with a
+// keyframe every 30 frames.
+type EncodingProfile struct {
+	Codec        string `json:"codec,omitempty"`        // libx264
+	Profile      string `json:"profile,omitempty"`      // baseline | main | high
+	Maxrate      string `json:"maxrate,omitempty"`      // e.g. "4M"
+	Bufsize      string `json:"bufsize,omitempty"`      // e.g. "8M" (default twice maxrate)
+	GOP          int    `json:"gop,omitempty"`          // Frames between keyframes
+	Preset       string `json:"preset,omitempty"`       // x264 preset, ultrafast to medium
+	AudioBitrate string `json:"audioBitrate,omitempty"` // e.g. "128k"; for aac and opus audio without their own bitrate
+}
+
+// Default encoder settings, matching the original hardcoded FFmpeg arguments
+const (
+	defaultVideoCodec   = "libx264"
+	defaultVideoProfile = "baseline"
+	defaultVideoLevel   = "3.1"
+	defaultVideoGOP     = 30
+	defaultVideoPreset  = "ultrafast"
+	maxVideoGOP         = 600
+	minVideoKbps        = 100
+	maxVideoKbps        = 50000
+)
+
+// videoProfiles are the H.264 profiles WebRTC viewers decode. B-frames stay off
+// whatever the profile, since they add a frame of latency per B-frame.
+var videoProfiles = map[string]bool{"baseline": true, "main": true, "high": true}
+
+// videoPresets are the x264 presets fast enough to encode live; slower ones fall behind
+// the source on most cameras
+var videoPresets = map[string]bool{
+	"ultrafast": true, "superfast": true, "veryfast": true, "faster": true, "fast": true, "medium": true,
+}
+
+// setsVideo reports whether the profile changes anything about the video encode
+func (p *EncodingProfile) setsVideo() bool {
+	return p != nil && (p.Codec != "" || p.Profile != "" || p.Maxrate != "" || p.Bufsize != "" || p.GOP != 0 || p.Preset != "")
+}
+
+// Validate checks every setting is supported and in range
+func (p *EncodingProfile) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if codec := strings.ToLower(p.Codec); codec != "" && codec != defaultVideoCodec {
+		return fmt.Errorf("unsupported codec %q (expected libx264)", p.Codec)
+	}
+	if p.Profile != "" && !videoProfiles[strings.ToLower(p.Profile)] {
+		return fmt.Errorf("unsupported profile %q (expected baseline, main or high)", p.Profile)
+	}
+	if p.Preset != "" && !videoPresets[strings.ToLower(p.Preset)] {
+		return fmt.Errorf("unsupported preset %q (expected ultrafast, superfast, veryfast, faster, fast or medium)", p.Preset)
+	}
+	if p.GOP < 0 || p.GOP > maxVideoGOP {
+		return fmt.Errorf("gop %d out of range (1-%d)", p.GOP, maxVideoGOP)
+	}
+	if p.Maxrate != "" {
+		if err := validateBitrate(p.Maxrate, minVideoKbps, maxVideoKbps); err != nil {
+			return fmt.Errorf("maxrate: %w", err)
+		}
+	}
+	if p.Bufsize != "" {
+		if err := validateBitrate(p.Bufsize, minVideoKbps, 2*maxVideoKbps); err != nil {
+			return fmt.Errorf("bufsize: %w", err)
+		}
+	}
+	if p.AudioBitrate != "" {
+		if err := validateBitrate(p.AudioBitrate, 8, 512); err != nil {
+			return fmt.Errorf("audioBitrate: %w", err)
+		}
+	}
+	return nil
+}
+
+// maxrateKbps is the highest bitrate the camera is encoded at: the profile's maxrate,
+// else ADAPTIVE_BITRATE_MAX_KBPS with adaptive bitrate on, else 1.5Mbps
+func (p *EncodingProfile) maxrateKbps() int {
+	if p != nil && p.Maxrate != "" {
+		if kbps, err := parseBitrateKbps(p.Maxrate); err == nil {
+			return kbps
+		}
+	}
+	if adaptiveBitrateConfig.Enabled {
+		return adaptiveBitrateConfig.MaxKbps
+	}
+	return defaultVideoBitrateKbps
+}
+
+// bufsizeKbps scales the profile's bufsize to the bitrate in effect, so adaptive bitrate
+// keeps its ratio to maxrate; without one it is twice the bitrate
+func (p *EncodingProfile) bufsizeKbps(kbps int) int {
+	if p != nil && p.Bufsize != "" {
+		if bufsize, err := parseBitrateKbps(p.Bufsize); err == nil {
+			return bufsize * kbps / p.maxrateKbps()
+		}
+	}
+	return 2 * kbps
+}
+
+// ffmpegArgs returns the encoder output arguments for the camera, with the defaults for
+// unset fields. The level stays at 3.1 only while profile and maxrate are the
+// defaults; otherwise x264 derives it from the resolution and bitrate, since 3.1 is
+// too low for anything above 720p.
+func (p *EncodingProfile) ffmpegArgs(cameraID string) ffmpeg.KwArgs {
+	resolved := EncodingProfile{Codec: defaultVideoCodec, Profile: defaultVideoProfile, GOP: defaultVideoGOP, Preset: defaultVideoPreset}
+	if p != nil {
+		if p.Codec != "" {
+			resolved.Codec = strings.ToLower(p.Codec)
+		}
+		if p.Profile != "" {
+			resolved.Profile = strings.ToLower(p.Profile)
+		}
+		if p.GOP != 0 {
+			resolved.GOP = p.GOP
+		}
+		if p.Preset != "" {
+			resolved.Preset = strings.ToLower(p.Preset)
+		}
+	}
+
+	kbps := videoBitrateKbps(cameraID, p.maxrateKbps())
+	args := ffmpeg.KwArgs{
+		"c:v":        resolved.Codec,                          // H264 codec
+		"profile:v":  resolved.Profile,                        // Baseline profile (no B-frames) by default
+		"preset":     resolved.Preset,                         // Fastest encoding for low latency by default
+		"g":          strconv.Itoa(resolved.GOP),              // Keyframe every 30 frames (1s at 30fps) by default
+		"keyint_min": strconv.Itoa(resolved.GOP),              // Minimum keyframe interval
+		"maxrate":    fmt.Sprintf("%dk", kbps),                // 1.5Mbps unless the profile or adaptive bitrate changed it
+		"bufsize":    fmt.Sprintf("%dk", p.bufsizeKbps(kbps)), // Twice maxrate unless the profile sets it
+	}
+	if resolved.Profile == defaultVideoProfile && (p == nil || p.Maxrate == "") {
+		args["level"] = defaultVideoLevel // H264 level
+	}
+	return args
+}
+
+// profileLevelID is the SDP profile-level-id of the video libx264 sends with the
+// profile. The level is the default 3.1, since x264 may pick another one.
+func (p *EncodingProfile) profileLevelID() string {
+	profile := defaultVideoProfile
+	if p != nil && p.Profile != "" {
+		profile = strings.ToLower(p.Profile)
+	}
+	switch profile {
+	case "main":
+		return "4d401f"
+	case "high":
+		return "64001f"
+	default:
+		return "42e01f" // Constrained baseline
+	}
+}
+
+// applyAudioBitrate sets the profile's audio bitrate on encoded audio whose options
+// don't set their own
+func (p *EncodingProfile) applyAudioBitrate(audioArgs ffmpeg.KwArgs, audio *AudioOptions) {
+	if p == nil || p.AudioBitrate == "" || (audio != nil && audio.Bitrate != "") {
+		return
+	}
+	if _, encoded := audioArgs["b:a"]; encoded {
+		audioArgs["b:a"] = p.AudioBitrate
+	}
+}
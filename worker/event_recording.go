@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Recording modes, selected by RECORDING_MODE
+const (
+	recordingModeContinuous = "continuous" // MediaMTX records every camera; clips are cut from it (default)
+	recordingModeEvent      = "event"      // Only detections are recorded, with pre-roll
+)
+
+const (
+	// eventRingSegment is the pre-roll ring's segment length, and so how often it is swept
+	eventRingSegment      = 2 * time.Second
+	eventRingRestartDelay = 5 * time.Second
+)
+
+// recordingModeFromEnv reads RECORDING_MODE, defaulting to continuous
+func recordingModeFromEnv() string {
+	switch mode := os.Getenv("RECORDING_MODE"); mode {
+	case recordingModeEvent:
+		return mode
+	case "", recordingModeContinuous:
+		return recordingModeContinuous
+	default:
+		log.Printf("Unknown RECORDING_MODE %q, using %q", mode, recordingModeContinuous)
+		return recordingModeContinuous
+	}
+}
+
+// eventRecording is a detection event being recorded
+type eventRecording struct {
+	path  string    // Final recording
+	dir   string    // Segments collected so far
+	start time.Time // First detection
+	last  time.Time // Latest detection
+}
+
+// eventRing is a camera's pre-roll ring: an FFmpeg process stream-copying the camera's
+// output into short segments, of which only the last EventPreRoll are kept
+type eventRing struct {
+	dir   string
+	event *eventRecording // Open event, if any
+}
+
+// EventRecorder records cameras only around detections. Segments from a camera's ring
+// that overlap [first detection - pre-roll, last detection + trailing] are moved into
+// the event, which is joined into one file once the trailing window has passed.
+type EventRecorder struct {
+	config RecordingConfig
+	rings  map[string]*eventRing // By camera
+	mu     sync.Mutex
+}
+
+// NewEventRecorder creates a recorder; it does nothing unless RECORDING_MODE=event
+func NewEventRecorder(config RecordingConfig) *EventRecorder {
+	return &EventRecorder{
+		config: config,
+		rings:  make(map[string]*eventRing),
+	}
+}
+
+var eventRecorder = NewEventRecorder(RecordingConfig{})
+
+func (r *EventRecorder) enabled() bool {
+	return r.config.Enabled && r.config.Mode == recordingModeEvent
+}
+
+// recordingClipPath returns where the footage of a detection will be saved: the event
+// recording in event mode, otherwise a clip cut from the continuous recording
+func recordingClipPath(cameraID string, detectedAt time.Time) string {
+	if eventRecorder.enabled() {
+		return eventRecorder.Trigger(cameraID, detectedAt)
+	}
+	return clipExporter.Schedule(cameraID, detectedAt)
+}
+
+// Trigger starts an event recording for the camera, or extends the open one, and
+// returns the path it will be written to; "" when the camera has no ring running
+func (r *EventRecorder) Trigger(cameraID string, detectedAt time.Time) string {
+	if !r.enabled() {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ring, exists := r.rings[cameraID]
+	if !exists {
+		return ""
+	}
+	if ring.event != nil {
+		if detectedAt.After(ring.event.last) {
+			ring.event.last = detectedAt
+		}
+		return ring.event.path
+	}
+
+	name := formatSegmentName(detectedAt.Local())
+	eventDir := filepath.Join(r.config.Dir, "events", cameraPathName(cameraID))
+	ring.event = &eventRecording{
+		path:  filepath.Join(eventDir, name+".mp4"),
+		dir:   filepath.Join(eventDir, name),
+		start: detectedAt,
+		last:  detectedAt,
+	}
+	streamEvents.Publish(StreamEvent{
+		Type:     streamEventRecordingStarted,
+		CameraID: cameraID,
+		Details: map[string]interface{}{
+			"recordingPath": ring.event.path,
+			"start":         detectedAt,
+		},
+	})
+	return ring.event.path
+}
+
+// Run keeps the camera's pre-roll ring going until ctx ends, finishing any open event
+// recording on the way out. Only RTSP outputs can be read back, so other outputs aren't
+// recorded.
+func (r *EventRecorder) Run(ctx context.Context, process *ReencodingProcess) {
+	if !r.enabled() {
+		return
+	}
+	cameraID := process.CameraID
+	if outputType := process.Output.Type(); outputType != outputTypeRTSP {
+		log.Printf("Event recording needs an rtsp output, not recording camera %s (%s output)", cameraID, outputType)
+		return
+	}
+
+	// Each run gets its own directory, so a restart's new ring can't collide with this one
+	ring := &eventRing{
+		dir: filepath.Join(r.config.Dir, ".preroll", cameraPathName(cameraID), formatSegmentName(process.StartedAt)),
+	}
+	if err := os.MkdirAll(ring.dir, 0o755); err != nil {
+		log.Printf("Failed to create pre-roll directory for camera %s: %v", cameraID, err)
+		return
+	}
+
+	r.mu.Lock()
+	r.rings[cameraID] = ring
+	r.mu.Unlock()
+
+	ringDone := make(chan struct{})
+	go func() {
+		defer close(ringDone)
+		r.runRing(ctx, cameraID, process.TargetURL, ring.dir)
+	}()
+
+	ticker := time.NewTicker(eventRingSegment)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.sweep(cameraID, ring, false)
+		case <-ctx.Done():
+			<-ringDone
+			r.sweep(cameraID, ring, true)
+
+			r.mu.Lock()
+			if r.rings[cameraID] == ring {
+				delete(r.rings, cameraID)
+			}
+			r.mu.Unlock()
+			if err := os.RemoveAll(ring.dir); err != nil {
+				log.Printf("Failed to remove pre-roll directory for camera %s: %v", cameraID, err)
+			}
+			return
+		}
+	}
+}
+
+// runRing runs the segmenting FFmpeg, restarting it until ctx ends. Segments are named
+// like MediaMTX's so listRecordingSegments can read them.
+func (r *EventRecorder) runRing(ctx context.Context, cameraID, sourceURL, dir string) {
+	for {
+		compiled := ffmpeg.Input(sourceURL, ffmpeg.KwArgs{"rtsp_transport": "tcp"}).
+			Output(filepath.Join(dir, "%Y-%m-%d_%H-%M-%S-000000.mp4"), ffmpeg.KwArgs{
+				"c":                "copy",
+				"map":              "0",
+				"f":                "segment",
+				"segment_time":     fmt.Sprintf("%.0f", eventRingSegment.Seconds()),
+				"segment_format":   "mp4",
+				"reset_timestamps": "1",
+				"strftime":         "1",
+			}).
+			GlobalArgs("-loglevel", "error", "-nostats").
+			Compile()
+
+		output, err := exec.CommandContext(ctx, compiled.Args[0], compiled.Args[1:]...).CombinedOutput()
+		if ctx.Err() != nil {
+			return
+		}
+		tail := output
+		if len(tail) > 512 {
+			tail = tail[len(tail)-512:]
+		}
+		log.Printf("Pre-roll recording for camera %s exited (%v: %s), restarting in %v",
+			cameraID, err, strings.TrimSpace(string(tail)), eventRingRestartDelay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventRingRestartDelay):
+		}
+	}
+}
+
+// sweep moves finished ring segments inside the open event's window into the event,
+// drops the ones older than the pre-roll, and closes the event once its trailing window
+// has been recorded, it reaches EventMaxLength, or the ring stops (final)
+func (r *EventRecorder) sweep(cameraID string, ring *eventRing, final bool) {
+	segments, err := listRecordingSegments(filepath.Dir(ring.dir), filepath.Base(ring.dir))
+	if err != nil {
+		log.Printf("Failed to list pre-roll segments for camera %s: %v", cameraID, err)
+		return
+	}
+
+	r.mu.Lock()
+	event := ring.event
+	var from, to time.Time
+	if event != nil {
+		from, to = event.start.Add(-r.config.EventPreRoll), event.last.Add(r.config.EventTrailing)
+	}
+	r.mu.Unlock()
+
+	// The newest segment is still being written (or was cut off when FFmpeg stopped)
+	now := time.Now()
+	for i := 0; i+1 < len(segments); i++ {
+		segment, end := segments[i], segments[i+1].Start
+		switch {
+		case event != nil && end.After(from) && !segment.Start.After(to):
+			if err := collectEventSegment(event, segment); err != nil {
+				log.Printf("Failed to move pre-roll segment for camera %s: %v", cameraID, err)
+			}
+		case end.Before(now.Add(-r.config.EventPreRoll)):
+			if err := os.Remove(segment.Path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove pre-roll segment for camera %s: %v", cameraID, err)
+			}
+		}
+	}
+	if event == nil {
+		return
+	}
+
+	r.mu.Lock()
+	trailingDone := len(segments) > 0 && segments[len(segments)-1].Start.After(event.last.Add(r.config.EventTrailing))
+	done := final || trailingDone || now.Sub(event.start) >= r.config.EventMaxLength
+	if done && ring.event == event {
+		ring.event = nil
+	}
+	r.mu.Unlock()
+
+	if done {
+		go r.finish(cameraID, event)
+	}
+}
+
+// collectEventSegment moves a ring segment into the event's directory
+func collectEventSegment(event *eventRecording, segment recordingSegment) error {
+	if err := os.MkdirAll(event.dir, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(segment.Path, filepath.Join(event.dir, filepath.Base(segment.Path)))
+}
+
+// finish joins an event's segments into its recording and announces the result
+func (r *EventRecorder) finish(cameraID string, event *eventRecording) {
+	streamEvent := StreamEvent{
+		Type:     streamEventRecordingReady,
+		CameraID: cameraID,
+		Details: map[string]interface{}{
+			"recordingPath": event.path,
+			"start":         event.start,
+			"lastDetection": event.last,
+		},
+	}
+
+	segments, err := listRecordingSegments(filepath.Dir(event.dir), filepath.Base(event.dir))
+	if err == nil && len(segments) == 0 {
+		err = fmt.Errorf("no segments were recorded")
+	}
+	if err == nil {
+		err = concatSegments(segments, event.path, time.Time{}, time.Time{})
+	}
+
+	if err != nil {
+		log.Printf("Failed to finish event recording for camera %s: %v", cameraID, err)
+		streamEvent.Type = streamEventRecordingFailed
+		streamEvent.Reason = err.Error()
+	} else {
+		log.Printf("Saved event recording %s for camera %s (%d segments)", event.path, cameraID, len(segments))
+		if err := os.RemoveAll(event.dir); err != nil {
+			log.Printf("Failed to remove event segments for camera %s: %v", cameraID, err)
+		}
+	}
+	streamEvents.Publish(streamEvent)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Haar cascades give no score per face, and gocv lacks DetectMultiScale3, which would
+// return each face's neighbour count. So the cascades run with minNeighbors 0 and their
+// raw candidates are grouped here as OpenCV's groupRectangles does, keeping the count.
+const (
+	faceMinNeighbors = 8   // Candidates a face needs beyond the first; VERY high to minimize false positives (was 6)
+	faceGroupEps     = 0.2 // OpenCV's GROUP_EPS: how far candidates of one face may differ
+)
+
+// haarFaceConfidence maps a face's neighbour count to (0, 1): a face that just made
+// faceMinNeighbors scores about 0.5, one with three times as many 0.75
+func haarFaceConfidence(neighbors int) float64 {
+	return float64(neighbors) / float64(neighbors+faceMinNeighbors)
+}
+
+// similarFaceRects is OpenCV's SimilarRects: every edge within eps of the smaller size
+func similarFaceRects(a, b image.Rectangle, eps float64) bool {
+	delta := eps * float64(min(a.Dx(), b.Dx())+min(a.Dy(), b.Dy())) * 0.5
+	return math.Abs(float64(a.Min.X-b.Min.X)) <= delta && math.Abs(float64(a.Min.Y-b.Min.Y)) <= delta &&
+		math.Abs(float64(a.Max.X-b.Max.X)) <= delta && math.Abs(float64(a.Max.Y-b.Max.Y)) <= delta
+}
+
+// groupFaceCandidates ports OpenCV's groupRectangles: similar candidates are clustered
+// and averaged, clusters of at most minNeighbors candidates dropped, and so are faces
+// inside a better supported one. It returns each face with its cluster's size.
+func groupFaceCandidates(candidates []image.Rectangle, minNeighbors int, eps float64) ([]image.Rectangle, []int) {
+	// Partition into equivalence classes; union-find stands in for cv::partition
+	parents := make([]int, len(candidates))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range candidates {
+		for j := i + 1; j < len(candidates); j++ {
+			if similarFaceRects(candidates[i], candidates[j], eps) {
+				parents[find(j)] = find(i)
+			}
+		}
+	}
+
+	classes := map[int]int{} // Root -> class index, in order of first candidate
+	var sums [][4]int
+	var counts []int
+	for i, candidate := range candidates {
+		class, ok := classes[find(i)]
+		if !ok {
+			class = len(sums)
+			classes[find(i)] = class
+			sums = append(sums, [4]int{})
+			counts = append(counts, 0)
+		}
+		sums[class][0] += candidate.Min.X
+		sums[class][1] += candidate.Min.Y
+		sums[class][2] += candidate.Dx()
+		sums[class][3] += candidate.Dy()
+		counts[class]++
+	}
+	averaged := make([]image.Rectangle, len(sums))
+	for class, sum := range sums {
+		n := float64(counts[class])
+		x, y := int(math.Round(float64(sum[0])/n)), int(math.Round(float64(sum[1])/n))
+		averaged[class] = image.Rect(x, y, x+int(math.Round(float64(sum[2])/n)), y+int(math.Round(float64(sum[3])/n)))
+	}
+
+	var faces []image.Rectangle
+	var neighbors []int
+	for i, face := range averaged {
+		n1 := counts[i]
+		if n1 <= minNeighbors {
+			continue
+		}
+		inside := false
+		for j, other := range averaged {
+			n2 := counts[j]
+			if j == i || n2 <= minNeighbors {
+				continue
+			}
+			dx, dy := int(math.Round(float64(other.Dx())*eps)), int(math.Round(float64(other.Dy())*eps))
+			if face.Min.X >= other.Min.X-dx && face.Min.Y >= other.Min.Y-dy &&
+				face.Max.X <= other.Max.X+dx && face.Max.Y <= other.Max.Y+dy &&
+				(n2 > max(3, n1) || n1 < 3) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			faces = append(faces, face)
+			neighbors = append(neighbors, n1)
+		}
+	}
+	return faces, neighbors
+}
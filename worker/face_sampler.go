@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"gocv.io/x/gocv"
+)
+
+// Face detection frame acquisition modes, selected by FACE_DETECTION_MODE
+const (
+	faceDetectionModeContinuous = "continuous" // Keep a gocv capture open and decode every frame (default)
+	faceDetectionModeSample     = "sample"     // Grab one keyframe per interval with a short FFmpeg run
+)
+
+// sampleFrameTimeout bounds a single keyframe grab, including RTSP setup
+const sampleFrameTimeout = 10 * time.Second
+
+// sampleKeyframe connects to the source, decodes only the first keyframe and returns it as JPEG
+func sampleKeyframe(ctx context.Context, rtspURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sampleFrameTimeout)
+	defer cancel()
+
+	compiled := ffmpeg.Input(rtspURL, ffmpeg.KwArgs{
+		"rtsp_transport":  "tcp",     // Match the re-encoder's transport
+		"skip_frame":      "nokey",   // Skip decoding everything but keyframes
+		"timeout":         "5000000", // 5 second socket timeout (microseconds)
+		"analyzeduration": "1000000", // Keep stream probing short
+	}).
+		Output("pipe:", ffmpeg.KwArgs{
+			"frames:v": "1",
+			"f":        "image2pipe",
+			"c:v":      "mjpeg",
+			"q:v":      "3",
+			"an":       "",
+		}).
+		Compile()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, compiled.Args[0], compiled.Args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		tail := stderr.Bytes()
+		if len(tail) > 512 {
+			tail = tail[len(tail)-512:]
+		}
+		return nil, fmt.Errorf("ffmpeg keyframe grab failed: %w: %s", err, bytes.TrimSpace(tail))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no frame")
+	}
+	return stdout.Bytes(), nil
+}
+
+// runSampledFaceDetection feeds one keyframe per interval to the processor chain without
+// holding a continuously decoding capture open
+func runSampledFaceDetection(ctx context.Context, cameraID, rtspURL string, chain *FrameProcessorChain, interval time.Duration) {
+	consecutiveFailures := 0
+	maxConsecutiveFailures := 10
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Face detection active for camera %s (interval: %v, mode: %s, processors: %s)", cameraID, interval, faceDetectionModeSample, chain)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping face detection for camera %s", cameraID)
+			return
+		case <-ticker.C:
+		}
+
+		jpegData, err := sampleKeyframe(ctx, rtspURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue // Cancelled mid-grab; the select above will exit
+			}
+			consecutiveFailures++
+			log.Printf("Failed to sample frame from camera %s for face detection (failures: %d/%d): %v",
+				cameraID, consecutiveFailures, maxConsecutiveFailures, err)
+			if consecutiveFailures >= maxConsecutiveFailures {
+				log.Printf("Too many consecutive sampling failures for camera %s, giving up", cameraID)
+				return
+			}
+			continue
+		}
+		consecutiveFailures = 0
+
+		img, err := gocv.IMDecode(jpegData, gocv.IMReadColor)
+		if err != nil || img.Empty() {
+			log.Printf("Failed to decode sampled frame from camera %s: %v", cameraID, err)
+			img.Close()
+			continue
+		}
+
+		// Validate frame before processing
+		if img.Cols() >= 100 && img.Rows() >= 100 {
+			chain.Run(cameraID, img)
+		}
+		img.Close()
+	}
+}
@@ -0,0 +1,74 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cpuTime is the CPU time used so far by this process and its waited-for children
+func cpuTime(tb testing.TB) time.Duration {
+	tb.Helper()
+	var total time.Duration
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var usage syscall.Rusage
+		if err := syscall.Getrusage(who, &usage); err != nil {
+			tb.Fatal(err)
+		}
+		total += time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	}
+	return total
+}
+
+// benchmarkFaceDetectionCPU runs one acquisition mode against a live camera with an empty
+// chain, so only the cost of getting frames is measured, and reports CPU time per interval.
+// Point FACE_DETECTION_BENCH_RTSP_URL at a camera and run with -benchtime=1x, e.g.
+//
+//	FACE_DETECTION_BENCH_RTSP_URL=rtsp://10.0.0.5/stream go test -run '^$' -bench FaceDetectionCPU -benchtime=1x
+func benchmarkFaceDetectionCPU(b *testing.B, mode string) {
+	rtspURL := os.Getenv("FACE_DETECTION_BENCH_RTSP_URL")
+	if rtspURL == "" {
+		b.Skip("FACE_DETECTION_BENCH_RTSP_URL not set")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil && mode == faceDetectionModeSample {
+		b.Skip("ffmpeg not installed")
+	}
+	const (
+		interval  = time.Second
+		intervals = 10
+	)
+	saved := timingConfig.FaceStabilizeDelay
+	timingConfig.FaceStabilizeDelay = 0
+	b.Cleanup(func() { timingConfig.FaceStabilizeDelay = saved })
+	retry := RTSPRetryPolicy{MaxAttempts: 1}
+	chain := &FrameProcessorChain{}
+
+	var used time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), intervals*interval)
+		before := cpuTime(b)
+		switch mode {
+		case faceDetectionModeSample:
+			runSampledFaceDetection(ctx, "bench-camera", rtspURL, chain, interval)
+		default:
+			runContinuousFaceDetection(ctx, "bench-camera", rtspURL, chain, interval, retry)
+		}
+		used += cpuTime(b) - before
+		cancel()
+	}
+	b.ReportMetric(float64(used.Milliseconds())/float64(b.N*intervals), "cpu-ms/interval")
+}
+
+func BenchmarkFaceDetectionCPUContinuous(b *testing.B) {
+	benchmarkFaceDetectionCPU(b, faceDetectionModeContinuous)
+}
+
+func BenchmarkFaceDetectionCPUSample(b *testing.B) {
+	benchmarkFaceDetectionCPU(b, faceDetectionModeSample)
+}
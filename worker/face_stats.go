@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	faceStatsRetention       = 24 * time.Hour // Longest window that can be queried
+	faceStatsMaxEventsCamera = 10000          // Hard cap on retained events per camera
+)
+
+// faceEvent is a single face detection event
+type faceEvent struct {
+	At        time.Time
+	FaceCount int
+}
+
+// cameraFaceStats holds the rolling event history for one camera
+type cameraFaceStats struct {
+	events         []faceEvent // Oldest first
+	totalEvents    uint64
+	lastDetectedAt time.Time
+	lastFaceCount  int
+}
+
+// FaceDetectionStats keeps rolling per-camera face detection counts in memory
+type FaceDetectionStats struct {
+	cameras map[string]*cameraFaceStats
+	mu      sync.Mutex
+}
+
+// CameraFaceStatsSummary is the per-camera view returned by /face-detection/stats
+type CameraFaceStatsSummary struct {
+	CameraID       string            `json:"cameraId"`
+	TotalEvents    uint64            `json:"totalEvents"`
+	LastDetectedAt time.Time         `json:"lastDetectedAt"`
+	LastFaceCount  int               `json:"lastFaceCount"`
+	Windows        map[string]uint64 `json:"windows"` // window -> event count
+	FacesInWindows map[string]uint64 `json:"facesInWindows"`
+}
+
+// NewFaceDetectionStats creates an empty stats tracker
+func NewFaceDetectionStats() *FaceDetectionStats {
+	return &FaceDetectionStats{
+		cameras: make(map[string]*cameraFaceStats),
+	}
+}
+
+// Record adds a detection event for a camera
+func (s *FaceDetectionStats) Record(cameraID string, faceCount int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, exists := s.cameras[cameraID]
+	if !exists {
+		stats = &cameraFaceStats{}
+		s.cameras[cameraID] = stats
+	}
+
+	stats.events = append(stats.events, faceEvent{At: at, FaceCount: faceCount})
+	stats.totalEvents++
+	stats.lastDetectedAt = at
+	stats.lastFaceCount = faceCount
+
+	stats.prune(at)
+}
+
+// prune drops events outside the retention window and enforces the per-camera cap
+func (cs *cameraFaceStats) prune(now time.Time) {
+	cutoff := now.Add(-faceStatsRetention)
+	drop := sort.Search(len(cs.events), func(i int) bool {
+		return cs.events[i].At.After(cutoff)
+	})
+	if excess := len(cs.events) - drop - faceStatsMaxEventsCamera; excess > 0 {
+		drop += excess
+	}
+	if drop > 0 {
+		cs.events = append(cs.events[:0], cs.events[drop:]...)
+	}
+}
+
+// Summary returns per-camera counts over the given windows. An empty cameraID
+// returns all cameras.
+func (s *FaceDetectionStats) Summary(cameraID string, windows []time.Duration) []CameraFaceStatsSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	summaries := make([]CameraFaceStatsSummary, 0, len(s.cameras))
+	for id, stats := range s.cameras {
+		if cameraID != "" && id != cameraID {
+			continue
+		}
+
+		stats.prune(now)
+		summary := CameraFaceStatsSummary{
+			CameraID:       id,
+			TotalEvents:    stats.totalEvents,
+			LastDetectedAt: stats.lastDetectedAt,
+			LastFaceCount:  stats.lastFaceCount,
+			Windows:        make(map[string]uint64, len(windows)),
+			FacesInWindows: make(map[string]uint64, len(windows)),
+		}
+
+		for _, window := range windows {
+			cutoff := now.Add(-window)
+			var events, faces uint64
+			// Walk newest-first and stop at the first event outside the window
+			for i := len(stats.events) - 1; i >= 0 && stats.events[i].At.After(cutoff); i-- {
+				events++
+				faces += uint64(stats.events[i].FaceCount)
+			}
+			summary.Windows[window.String()] = events
+			summary.FacesInWindows[window.String()] = faces
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].CameraID < summaries[j].CameraID })
+	return summaries
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
-	"encoding/base64"
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,12 +20,174 @@ import (
 
 // FaceDetector handles face detection using OpenCV/gocv
 type FaceDetector struct {
-	classifier    *gocv.CascadeClassifier
+	backend       string                    // faceBackendHaar or faceBackendDNN
+	classifiers   []*gocv.CascadeClassifier // Haar: run in turn on every frame, e.g. frontal then profile
+	net           gocv.Net                  // DNN: the SSD face model; used under mu
 	enabled       bool
-	interval      time.Duration
-	threshold     float64
+	modelPath     string // Files the backend was loaded from, as a path list
 	kafkaProducer *KafkaProducer
+	alertQueue    *AlertQueue
 	mu            sync.Mutex
+
+	settings   FaceDetectorTuning // Replaced by Reload; read through tuning()
+	settingsMu sync.RWMutex
+}
+
+// FaceDetectorTuning holds the FACE_DETECTION_* settings that can be reloaded live.
+// Cameras pick up new values the next time their detection loop starts.
+type FaceDetectorTuning struct {
+	Interval      time.Duration
+	Threshold     float64
+	MinFaceRatio  float64 // Minimum face size as a fraction of frame height
+	MaxFaceRatio  float64 // Maximum face size as a fraction of frame height
+	Mode          string  // faceDetectionModeContinuous or faceDetectionModeSample
+	MaxImageBytes int     // Alerts with a larger base64 thumbnail are sent without it
+}
+
+// loadFaceDetectorTuning reads the tunable FACE_DETECTION_* settings from the environment
+func loadFaceDetectorTuning() FaceDetectorTuning {
+	intervalMs, _ := strconv.Atoi(os.Getenv("FACE_DETECTION_INTERVAL"))
+	if intervalMs == 0 {
+		intervalMs = 1000 // Default 1 second
+	}
+
+	threshold, _ := strconv.ParseFloat(os.Getenv("FACE_DETECTION_CONFIDENCE_THRESHOLD"), 64)
+	if threshold == 0 {
+		threshold = 0.5
+	}
+
+	minFaceRatio, _ := strconv.ParseFloat(os.Getenv("FACE_DETECTION_MIN_FACE_RATIO"), 64)
+	if minFaceRatio <= 0 || minFaceRatio >= 1 {
+		minFaceRatio = 0.05 // 5% of frame height (~36px at 720p)
+	}
+
+	maxFaceRatio, _ := strconv.ParseFloat(os.Getenv("FACE_DETECTION_MAX_FACE_RATIO"), 64)
+	if maxFaceRatio <= minFaceRatio || maxFaceRatio > 1 {
+		maxFaceRatio = 0.6 // 60% of frame height
+	}
+
+	mode := os.Getenv("FACE_DETECTION_MODE")
+	switch mode {
+	case faceDetectionModeContinuous, faceDetectionModeSample:
+	case "":
+		mode = faceDetectionModeContinuous
+	default:
+		log.Printf("Unknown FACE_DETECTION_MODE %q, using %q", mode, faceDetectionModeContinuous)
+		mode = faceDetectionModeContinuous
+	}
+
+	return FaceDetectorTuning{
+		Interval:     time.Duration(intervalMs) * time.Millisecond,
+		Threshold:    threshold,
+		MinFaceRatio: minFaceRatio,
+		MaxFaceRatio: maxFaceRatio,
+		Mode:         mode,
+		// Kafka's default message.max.bytes is ~1MB; leave headroom for the rest of the alert
+		MaxImageBytes: getEnvInt("FACE_DETECTION_MAX_IMAGE_BYTES", 768*1024),
+	}
+}
+
+// Face detection backends, chosen with FACE_DETECTION_BACKEND
+const (
+	faceBackendHaar = "haar" // OpenCV Haar cascades; fast, but no confidence scores
+	faceBackendDNN  = "dnn"  // OpenCV's SSD ResNet-10 Caffe face model
+)
+
+// defaultFaceCascade is the cascade loaded from a FACE_DETECTION_MODEL_PATH directory
+const defaultFaceCascade = "haarcascade_frontalface_default.xml"
+
+// The DNN backend's files in a FACE_DETECTION_MODEL_PATH directory, as OpenCV's
+// face_detector sample downloads them
+const (
+	defaultFaceDNNModel  = "res10_300x300_ssd_iter_140000.caffemodel"
+	defaultFaceDNNConfig = "deploy.prototxt"
+)
+
+// faceDNNInputSize is the square input the SSD face model was trained on
+const faceDNNInputSize = 300
+
+// faceDuplicateIoU is the overlap above which detections from different cascades are
+// taken to be the same face
+const faceDuplicateIoU = 0.5
+
+// faceCascadePaths resolves FACE_DETECTION_MODEL_PATH, a path list (":"-separated on
+// Linux) of cascade files, or of directories holding the frontal cascade
+func faceCascadePaths(modelPath string) []string {
+	var paths []string
+	for _, entry := range filepath.SplitList(modelPath) {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case strings.EqualFold(filepath.Ext(entry), ".xml"):
+			paths = append(paths, entry)
+		default:
+			paths = append(paths, filepath.Join(entry, defaultFaceCascade))
+		}
+	}
+	return paths
+}
+
+// faceDNNPaths resolves FACE_DETECTION_MODEL_PATH for the DNN backend: .caffemodel and
+// .prototxt entries name the files, and a directory supplies whichever is missing
+func faceDNNPaths(modelPath string) (model, config string) {
+	var dir string
+	for _, entry := range filepath.SplitList(modelPath) {
+		entry = strings.TrimSpace(entry)
+		switch ext := strings.ToLower(filepath.Ext(entry)); {
+		case entry == "":
+		case ext == ".caffemodel":
+			model = entry
+		case ext == ".prototxt":
+			config = entry
+		case dir == "":
+			dir = entry
+		}
+	}
+	if dir == "" {
+		dir = "/app/models"
+	}
+	if model == "" {
+		model = filepath.Join(dir, defaultFaceDNNModel)
+	}
+	if config == "" {
+		config = filepath.Join(dir, defaultFaceDNNConfig)
+	}
+	return model, config
+}
+
+// loadFaceCascades loads every cascade in modelPath, closing those already loaded when
+// one fails
+func loadFaceCascades(modelPath string) ([]*gocv.CascadeClassifier, []string, error) {
+	cascadePaths := faceCascadePaths(modelPath)
+	if len(cascadePaths) == 0 {
+		return nil, nil, fmt.Errorf("FACE_DETECTION_MODEL_PATH %q lists no cascades", modelPath)
+	}
+	classifiers := make([]*gocv.CascadeClassifier, 0, len(cascadePaths))
+	for _, cascadePath := range cascadePaths {
+		classifier := gocv.NewCascadeClassifier()
+		if !classifier.Load(cascadePath) {
+			classifier.Close()
+			for _, loaded := range classifiers {
+				loaded.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to load cascade classifier from %s", cascadePath)
+		}
+		classifiers = append(classifiers, &classifier)
+	}
+	return classifiers, cascadePaths, nil
+}
+
+// loadFaceNet loads the DNN backend's Caffe model and prototxt
+func loadFaceNet(modelPath string) (gocv.Net, []string, error) {
+	model, config := faceDNNPaths(modelPath)
+	net := gocv.ReadNetFromCaffe(config, model)
+	if net.Empty() {
+		net.Close()
+		return gocv.Net{}, nil, fmt.Errorf("failed to load face detection model from %s and %s", model, config)
+	}
+	net.SetPreferableBackend(gocv.NetBackendDefault)
+	net.SetPreferableTarget(gocv.NetTargetCPU)
+	return net, []string{model, config}, nil
 }
 
 // NewFaceDetector creates a new face detector
@@ -30,52 +195,115 @@ func NewFaceDetector(kafkaProducer *KafkaProducer) (*FaceDetector, error) {
 	enabled := os.Getenv("FACE_DETECTION_ENABLED") == "true"
 	if !enabled {
 		log.Println("Face detection is disabled")
-		return &FaceDetector{enabled: false}, nil
+		return &FaceDetector{enabled: false, settings: loadFaceDetectorTuning()}, nil
 	}
 
-	// Load face detection cascade classifier
+	// Load the face detection model
 	modelPath := os.Getenv("FACE_DETECTION_MODEL_PATH")
 	if modelPath == "" {
 		modelPath = "/app/models"
 	}
 
-	cascadePath := modelPath + "/haarcascade_frontalface_default.xml"
-	classifier := gocv.NewCascadeClassifier()
-
-	if !classifier.Load(cascadePath) {
-		return nil, fmt.Errorf("failed to load cascade classifier from %s", cascadePath)
+	detector := &FaceDetector{enabled: true, kafkaProducer: kafkaProducer}
+	var modelFiles []string
+	var err error
+	switch backend := strings.ToLower(os.Getenv("FACE_DETECTION_BACKEND")); backend {
+	case faceBackendDNN:
+		detector.backend = faceBackendDNN
+		detector.net, modelFiles, err = loadFaceNet(modelPath)
+	case faceBackendHaar, "":
+		detector.backend = faceBackendHaar
+		detector.classifiers, modelFiles, err = loadFaceCascades(modelPath)
+	default:
+		return nil, fmt.Errorf("unknown FACE_DETECTION_BACKEND %q (expected haar or dnn)", backend)
+	}
+	if err != nil {
+		return nil, err
 	}
+	detector.modelPath = strings.Join(modelFiles, string(filepath.ListSeparator))
 
-	intervalMs, _ := strconv.Atoi(os.Getenv("FACE_DETECTION_INTERVAL"))
-	if intervalMs == 0 {
-		intervalMs = 1000 // Default 1 second
+	tuning := loadFaceDetectorTuning()
+
+	if kafkaProducer != nil {
+		detector.alertQueue = NewAlertQueue()
 	}
+	detector.settings = tuning
 
-	threshold, _ := strconv.ParseFloat(os.Getenv("FACE_DETECTION_CONFIDENCE_THRESHOLD"), 64)
-	if threshold == 0 {
-		threshold = 0.5
+	log.Printf("Face detector initialized: backend=%s, interval=%dms, threshold=%.2f, faceSize=%.0f%%-%.0f%% of frame height, mode=%s, model=%s",
+		detector.backend, tuning.Interval.Milliseconds(), tuning.Threshold, tuning.MinFaceRatio*100, tuning.MaxFaceRatio*100, tuning.Mode,
+		strings.Join(modelFiles, ", "))
+
+	return detector, nil
+}
+
+// faceDetectorLoadErr is why NewFaceDetector failed at startup, if it did
+var faceDetectorLoadErr error
+
+// FaceDetectionCapabilities says whether detection can run and with which model
+type FaceDetectionCapabilities struct {
+	Available       bool   `json:"available"` // Toggling detection on will start a detection loop
+	FaceDetection   bool   `json:"faceDetection"`
+	ObjectDetection bool   `json:"objectDetection"`   // Runs on the face detection loop's frames
+	Requested       bool   `json:"requested"`         // FACE_DETECTION_ENABLED=true
+	Backend         string `json:"backend,omitempty"` // haar or dnn
+	Model           string `json:"model,omitempty"`   // Model files in use, as a path list
+	Mode            string `json:"mode,omitempty"`    // continuous or sample
+	Reason          string `json:"reason,omitempty"`  // Why face detection is unavailable
+}
+
+// faceDetectionCapabilities reports what the detectors loaded at startup
+func faceDetectionCapabilities() FaceDetectionCapabilities {
+	capabilities := FaceDetectionCapabilities{
+		FaceDetection:   faceDetectionEnabled(),
+		ObjectDetection: objectDetectionEnabled(),
+		Requested:       os.Getenv("FACE_DETECTION_ENABLED") == "true",
+	}
+	capabilities.Available = capabilities.FaceDetection || capabilities.ObjectDetection
+
+	switch {
+	case capabilities.FaceDetection:
+		capabilities.Backend = faceDetector.backend
+		capabilities.Model = faceDetector.modelPath
+		capabilities.Mode = faceDetector.tuning().Mode
+	case faceDetectorLoadErr != nil:
+		capabilities.Reason = fmt.Sprintf("face detection model unavailable: %v", faceDetectorLoadErr)
+	default:
+		capabilities.Reason = "face detection is disabled (FACE_DETECTION_ENABLED is not true)"
 	}
+	return capabilities
+}
 
-	log.Printf("Face detector initialized: interval=%dms, threshold=%.2f", intervalMs, threshold)
+// tuning returns the current tunable settings
+func (fd *FaceDetector) tuning() FaceDetectorTuning {
+	fd.settingsMu.RLock()
+	defer fd.settingsMu.RUnlock()
+	return fd.settings
+}
 
-	return &FaceDetector{
-		classifier:    &classifier,
-		enabled:       true,
-		interval:      time.Duration(intervalMs) * time.Millisecond,
-		threshold:     threshold,
-		kafkaProducer: kafkaProducer,
-	}, nil
+// Reload re-reads the tunable settings from the environment
+func (fd *FaceDetector) Reload() {
+	tuning := loadFaceDetectorTuning()
+	fd.settingsMu.Lock()
+	fd.settings = tuning
+	fd.settingsMu.Unlock()
 }
 
-// DetectFaces detects faces in an image and returns face count
-func (fd *FaceDetector) DetectFaces(img gocv.Mat) (int, []image.Rectangle) {
-	if !fd.enabled || fd.classifier == nil {
-		return 0, nil
+// DetectFaces detects faces in an image and returns face count, and each face's
+// confidence. Only faces scoring at least threshold are kept; Haar faces score by
+// their neighbour count, see haarFaceConfidence.
+func (fd *FaceDetector) DetectFaces(img gocv.Mat, threshold float64) (int, []image.Rectangle, []float64) {
+	if !fd.enabled || (fd.backend != faceBackendDNN && len(fd.classifiers) == 0) {
+		return 0, nil, nil
 	}
 
 	// Validate input frame
 	if img.Empty() || img.Cols() < 50 || img.Rows() < 50 {
-		return 0, nil
+		return 0, nil, nil
+	}
+
+	if fd.backend == faceBackendDNN {
+		faces, confidences := fd.detectFacesDNN(img, threshold)
+		return len(faces), faces, confidences
 	}
 
 	// Convert to grayscale for better face detection
@@ -89,32 +317,51 @@ func (fd *FaceDetector) DetectFaces(img gocv.Mat) (int, []image.Rectangle) {
 	// Apply histogram equalization to improve detection in varying lighting
 	gocv.EqualizeHist(gray, &gray)
 
+	// Face size bounds scale with the frame so detection behaves the same at 320p and 4K
+	minSize, maxSize := fd.faceSizeBounds(img.Rows())
+
 	// VERY STRICT parameters to minimize false positives
-	// Parameters: scaleFactor=1.15, minNeighbors=8, minSize=(60x60)
+	// Parameters: scaleFactor=1.15, minNeighbors=8, minSize/maxSize relative to frame height
 	// - scaleFactor: 1.15 = less sensitive, skips more scales
 	// - minNeighbors: 8 = require 8+ overlapping detections (VERY strict)
-	// - minSize: 60x60 = only detect reasonably sized faces
-	faces := fd.classifier.DetectMultiScaleWithParams(
-		gray,
-		1.15,              // scaleFactor: higher = less sensitive
-		8,                 // minNeighbors: VERY high to minimize false positives (was 6)
-		0,                 // flags
-		image.Pt(60, 60),  // minSize: larger minimum (was 40x40)
-		image.Pt(400, 400), // maxSize: limit max face size to avoid weird detections
-	)
+	// - minSize: only detect reasonably sized faces for this resolution
+	// Every cascade gets the same parameters, so a profile cascade is as strict as the
+	// frontal one. minNeighbors is applied by groupFaceCandidates, which keeps the counts.
+	var faces []image.Rectangle
+	var neighbors []int
+	for _, classifier := range fd.classifiers {
+		candidates := classifier.DetectMultiScaleWithParams(
+			gray,
+			1.15,                       // scaleFactor: higher = less sensitive
+			0,                          // minNeighbors: raw candidates, grouped below
+			0,                          // flags
+			image.Pt(minSize, minSize), // minSize: fraction of frame height
+			image.Pt(maxSize, maxSize), // maxSize: limit max face size to avoid weird detections
+		)
+		grouped, counts := groupFaceCandidates(candidates, faceMinNeighbors, faceGroupEps)
+		faces = append(faces, grouped...)
+		neighbors = append(neighbors, counts...)
+	}
 
 	// Additional multi-stage filtering
 	validFaces := make([]image.Rectangle, 0)
-	for _, face := range faces {
+	confidences := make([]float64, 0)
+	for i, face := range faces {
+		// 0. The camera's threshold: 0.5 keeps every face that made faceMinNeighbors
+		confidence := haarFaceConfidence(neighbors[i])
+		if confidence < threshold {
+			continue
+		}
+
 		// 1. Aspect ratio check: faces should be roughly square
 		aspectRatio := float64(face.Dx()) / float64(face.Dy())
 		if aspectRatio < 0.75 || aspectRatio > 1.25 {
 			continue // Too narrow or too wide
 		}
 
-		// 2. Size check: face should be reasonable size
+		// 2. Size check: face should be reasonable size for this frame
 		faceArea := face.Dx() * face.Dy()
-		if faceArea < 3600 || faceArea > 160000 { // 60x60 to 400x400
+		if faceArea < minSize*minSize || faceArea > maxSize*maxSize {
 			continue
 		}
 
@@ -129,17 +376,113 @@ func (fd *FaceDetector) DetectFaces(img gocv.Mat) (int, []image.Rectangle) {
 		}
 
 		validFaces = append(validFaces, face)
+		confidences = append(confidences, confidence)
+	}
+
+	// 4. A face seen by several cascades (a three-quarter view, say) counts once
+	if len(fd.classifiers) > 1 {
+		validFaces, confidences = dedupeFaces(validFaces, confidences)
 	}
 
 	if len(faces) > 0 || len(validFaces) > 0 {
 		log.Printf("[FaceDetector] Raw detections: %d, Valid faces after filtering: %d", len(faces), len(validFaces))
 	}
 
-	return len(validFaces), validFaces
+	return len(validFaces), validFaces, confidences
+}
+
+// detectFacesDNN runs the SSD face model on the frame. Only the size check of the Haar
+// filtering applies: the model's boxes are taller than wide and it doesn't produce the
+// edge false positives the other checks were tuned against.
+func (fd *FaceDetector) detectFacesDNN(img gocv.Mat, threshold float64) ([]image.Rectangle, []float64) {
+	// The model expects 300x300 BGR with the training set's mean subtracted
+	blob := gocv.BlobFromImage(img, 1.0, image.Pt(faceDNNInputSize, faceDNNInputSize), gocv.NewScalar(104, 177, 123, 0), false, false)
+	defer blob.Close()
+	fd.net.SetInput(blob, "")
+	output := fd.net.Forward("")
+	defer output.Close()
+
+	// SSD output is [1, 1, detections, 7]: image, label, confidence, then the box corners
+	// as fractions of the frame
+	dims := output.Size()
+	if len(dims) != 4 || dims[3] != 7 {
+		log.Printf("[FaceDetector] Unexpected face model output shape %v", dims)
+		return nil, nil
+	}
+	data, err := output.DataPtrFloat32()
+	if err != nil || len(data) < dims[2]*7 {
+		log.Printf("[FaceDetector] Failed to read face model output: %v", err)
+		return nil, nil
+	}
+
+	minSize, maxSize := fd.faceSizeBounds(img.Rows())
+	width, height := float32(img.Cols()), float32(img.Rows())
+	frame := image.Rect(0, 0, img.Cols(), img.Rows())
+	faces := []image.Rectangle{}
+	confidences := []float64{}
+	raw := 0
+	for i := 0; i < dims[2]; i++ {
+		detection := data[i*7 : i*7+7]
+		confidence := float64(detection[2])
+		if confidence < threshold {
+			continue
+		}
+		raw++
+		face := image.Rect(int(detection[3]*width), int(detection[4]*height),
+			int(detection[5]*width), int(detection[6]*height)).Intersect(frame)
+		if face.Empty() || face.Dy() < minSize || face.Dy() > maxSize {
+			continue
+		}
+		faces = append(faces, face)
+		confidences = append(confidences, confidence)
+	}
+
+	if raw > 0 {
+		log.Printf("[FaceDetector] Raw detections: %d, Valid faces after filtering: %d", raw, len(faces))
+	}
+	return faces, confidences
+}
+
+// dedupeFaces drops faces overlapping a more confident one by more than
+// faceDuplicateIoU; on a tie the first cascade listed wins
+func dedupeFaces(faces []image.Rectangle, confidences []float64) ([]image.Rectangle, []float64) {
+	if len(faces) < 2 {
+		return faces, confidences
+	}
+	scores := make([]float32, len(faces))
+	for i, confidence := range confidences {
+		scores[i] = float32(confidence)
+	}
+	kept := gocv.NMSBoxes(faces, scores, 0, faceDuplicateIoU)
+	sort.Ints(kept) // Keep detection order
+	unique := make([]image.Rectangle, 0, len(kept))
+	uniqueConfidences := make([]float64, 0, len(kept))
+	for _, index := range kept {
+		unique = append(unique, faces[index])
+		uniqueConfidences = append(uniqueConfidences, confidences[index])
+	}
+	return unique, uniqueConfidences
+}
+
+// faceSizeBounds converts the configured face size ratios into pixel sizes for a frame
+func (fd *FaceDetector) faceSizeBounds(frameHeight int) (minSize, maxSize int) {
+	tuning := fd.tuning()
+	minSize = int(float64(frameHeight) * tuning.MinFaceRatio)
+	maxSize = int(float64(frameHeight) * tuning.MaxFaceRatio)
+
+	// The cascade's training window is 24x24; anything smaller can't be detected
+	if minSize < 24 {
+		minSize = 24
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	return minSize, maxSize
 }
 
-// ProcessFrameForFaceDetection processes a frame and sends alert if faces detected
-func (fd *FaceDetector) ProcessFrameForFaceDetection(cameraID, cameraName string, frame gocv.Mat) {
+// ProcessFrameForFaceDetection processes a frame and sends alert if faces detected.
+// settings carries the camera's effective (camera -> group -> global) policy.
+func (fd *FaceDetector) ProcessFrameForFaceDetection(cameraID, cameraName string, frame gocv.Mat, settings FaceDetectionSettings) {
 	if !fd.enabled {
 		return
 	}
@@ -147,17 +490,34 @@ func (fd *FaceDetector) ProcessFrameForFaceDetection(cameraID, cameraName string
 	fd.mu.Lock()
 	defer fd.mu.Unlock()
 
-	faceCount, faces := fd.DetectFaces(frame)
+	_, detected, detectedConfidences := fd.DetectFaces(frame, settings.Threshold)
+
+	// Drop faces outside the region of interest
+	faces := make([]image.Rectangle, 0, len(detected))
+	faceConfidences := make([]float64, 0, len(detected))
+	confidence := 0.0
+	for i, face := range detected {
+		if settings.ROI.Contains(face, frame.Cols(), frame.Rows()) {
+			faces = append(faces, face)
+			faceConfidences = append(faceConfidences, detectedConfidences[i])
+			confidence = max(confidence, detectedConfidences[i])
+		}
+	}
+	faceCount := len(faces)
 
 	if faceCount == 0 {
 		return
 	}
 
 	log.Printf("Detected %d face(s) in camera %s", faceCount, cameraID)
+	detectedAt := time.Now().UTC()
+	faceDetectionStats.Record(cameraID, faceCount, detectedAt)
+	cue := newDetectionCue(cameraID, "face", faces, frame.Cols(), frame.Rows(), detectedAt, settings.Interval)
+	detectionMetadata.Publish(cue)
 
-	// Draw rectangles around detected faces
-	annotatedFrame := frame.Clone()
-	defer annotatedFrame.Close()
+	// Draw rectangles around detected faces on a pooled copy of the frame
+	annotatedFrame := annotationMats.CopyOf(frame)
+	defer annotationMats.Put(annotatedFrame)
 
 	for _, face := range faces {
 		gocv.Rectangle(&annotatedFrame, face, color.RGBA{0, 255, 0, 0}, 2)
@@ -171,62 +531,80 @@ func (fd *FaceDetector) ProcessFrameForFaceDetection(cameraID, cameraName string
 	}
 	defer buf.Close()
 
-	// Convert to base64
-	imageData := base64.StdEncoding.EncodeToString(buf.GetBytes())
-
 	// Create alert metadata with bounding boxes
 	metadata := make(map[string]interface{})
-	boundingBoxes := make([]map[string]int, 0, len(faces))
-	for _, face := range faces {
-		boundingBoxes = append(boundingBoxes, map[string]int{
-			"x":      face.Min.X,
-			"y":      face.Min.Y,
-			"width":  face.Dx(),
-			"height": face.Dy(),
+	boundingBoxes := make([]map[string]interface{}, 0, len(faces))
+	for i, face := range faces {
+		boundingBoxes = append(boundingBoxes, map[string]interface{}{
+			"x":          face.Min.X,
+			"y":          face.Min.Y,
+			"width":      face.Dx(),
+			"height":     face.Dy(),
+			"confidence": faceConfidences[i],
 		})
 	}
 	metadata["faces"] = boundingBoxes
 
+	// Convert to base64, unless it would be over the size limit anyway
+	maxImageBytes := fd.tuning().MaxImageBytes
+	imageData, imageSize := encodeThumbnail(buf.GetBytes(), maxImageBytes)
+	if imageData == "" && imageSize > 0 {
+		log.Printf("Thumbnail for camera %s is %d bytes (limit %d), sending alert without it", cameraID, imageSize, maxImageBytes)
+		metadata["imageOmitted"] = fmt.Sprintf("thumbnail of %d bytes exceeds the %d byte limit", imageSize, maxImageBytes)
+	}
+
 	// Publish alert to Kafka
 	alert := FaceDetectionAlert{
+		EventID:    newEventID(),
+		TenantID:   settings.TenantID,
+		SiteID:     settings.SiteID,
 		CameraID:   cameraID,
 		CameraName: cameraName,
 		FaceCount:  faceCount,
-		Confidence: fd.threshold, // Using threshold as proxy for confidence
+		Confidence: confidence, // Highest face confidence in the frame
 		ImageData:  imageData,
-		DetectedAt: time.Now(),
+		DetectedAt: detectedAt,
+		LocalTime:  localTimestamp(detectedAt, settings.Location),
+		Timezone:   settings.Timezone,
 		Metadata:   metadata,
+		ClipPath:   recordingClipPath(cameraID, detectedAt),
 	}
 
-	if fd.kafkaProducer != nil {
-		if err := fd.kafkaProducer.PublishAlert(alert); err != nil {
-			log.Printf("Failed to publish face detection alert: %v", err)
-		}
-	} else {
+	detectionStore.Record(newDetectionRecord(alert, cue.Boxes))
+
+	alertSummaries.Record(alert)
+
+	// Queued rather than published inline so a slow broker can't stall detection under fd.mu
+	switch {
+	case alertSummaries.SummariesOnly():
+		// Only the window's summary goes to Kafka
+	case fd.alertQueue != nil:
+		fd.alertQueue.Enqueue(cameraID, func() error { return fd.kafkaProducer.PublishAlert(alert) })
+	default:
 		log.Printf("Kafka producer not available, skipping alert publication for camera %s (faces detected: %d)", cameraID, faceCount)
 	}
 }
 
-// EncodeJPEG encodes an image to JPEG bytes
+// EncodeJPEG encodes an image to JPEG bytes, encoding through a pooled buffer so only
+// the returned copy is allocated
 func EncodeJPEG(img image.Image) ([]byte, error) {
-	var buf []byte
-	writer := &jpegWriter{buf: &buf}
-	err := jpeg.Encode(writer, img, &jpeg.Options{Quality: 85})
-	return buf, err
-}
-
-type jpegWriter struct {
-	buf *[]byte
-}
-
-func (w *jpegWriter) Write(p []byte) (n int, err error) {
-	*w.buf = append(*w.buf, p...)
-	return len(p), nil
+	buf := getByteBuffer()
+	defer putByteBuffer(buf)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
 }
 
 // Close cleans up the face detector
 func (fd *FaceDetector) Close() {
-	if fd.classifier != nil {
-		fd.classifier.Close()
+	if fd.alertQueue != nil {
+		fd.alertQueue.Close()
+	}
+	for _, classifier := range fd.classifiers {
+		classifier.Close()
+	}
+	if fd.backend == faceBackendDNN {
+		fd.net.Close()
 	}
 }
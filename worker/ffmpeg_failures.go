@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// FFmpeg failure reasons used as the restart-reason label
+const (
+	ffmpegFailureAuth     = "auth"
+	ffmpegFailureTimeout  = "timeout"
+	ffmpegFailureNetwork  = "network"
+	ffmpegFailureNotFound = "not_found"
+	ffmpegFailureCodec    = "codec"
+	ffmpegFailureOutput   = "output" // Publishing to MediaMTX (or the HLS/SRT target) failed
+	ffmpegFailureKilled   = "killed" // Killed by a signal, e.g. the stall watchdog
+	ffmpegFailureUnknown  = "unknown"
+)
+
+// ffmpegFailurePatterns map stderr substrings (matched lowercase) to a reason; the
+// first match wins, so the specific patterns come before the generic ones
+var ffmpegFailurePatterns = []struct {
+	substring string
+	reason    string
+}{
+	{"401 unauthorized", ffmpegFailureAuth},
+	{"403 forbidden", ffmpegFailureAuth},
+	{"authorization failed", ffmpegFailureAuth},
+	{"404 not found", ffmpegFailureNotFound},
+	{"no such file or directory", ffmpegFailureNotFound},
+	{"timed out", ffmpegFailureTimeout},
+	{"timeout", ffmpegFailureTimeout},
+	{"connection refused", ffmpegFailureNetwork},
+	{"no route to host", ffmpegFailureNetwork},
+	{"network is unreachable", ffmpegFailureNetwork},
+	{"connection reset", ffmpegFailureNetwork},
+	{"broken pipe", ffmpegFailureNetwork},
+	{"name or service not known", ffmpegFailureNetwork},
+	{"temporary failure in name resolution", ffmpegFailureNetwork},
+	{"invalid data found when processing input", ffmpegFailureCodec},
+	{"could not find codec parameters", ffmpegFailureCodec},
+	{"decoder (codec", ffmpegFailureCodec},
+	{"unknown decoder", ffmpegFailureCodec},
+	{"error while decoding", ffmpegFailureCodec},
+	{"non-existing pps", ffmpegFailureCodec},
+}
+
+// stderrTailSize is how much of FFmpeg's stderr is kept for classifying its exit
+const stderrTailSize = 4096
+
+// stderrTail keeps the last few KB written to it; FFmpeg's stderr is teed into it
+type stderrTail struct {
+	buf []byte
+	mu  sync.Mutex
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTailSize {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-stderrTailSize:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered stderr lines, oldest first. Progress updates end in
+// a carriage return, so those count as line breaks too.
+func (t *stderrTail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.FieldsFunc(string(t.buf), func(r rune) bool { return r == '\n' || r == '\r' })
+}
+
+// classifyFFmpegFailure picks a reason from FFmpeg's exit error and the last stderr
+// lines, checking the newest line first since FFmpeg logs the fatal error last.
+// Lines mentioning the output target are reported as output failures.
+func classifyFFmpegFailure(err error, stderrLines []string, targetURL string) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return ffmpegFailureKilled
+		}
+	}
+
+	for i := len(stderrLines) - 1; i >= 0; i-- {
+		line := strings.ToLower(stderrLines[i])
+		for _, pattern := range ffmpegFailurePatterns {
+			if !strings.Contains(line, pattern.substring) {
+				continue
+			}
+			if targetURL != "" && strings.Contains(line, strings.ToLower(targetURL)) {
+				return ffmpegFailureOutput
+			}
+			return pattern.reason
+		}
+	}
+	return ffmpegFailureUnknown
+}
+
+// ffmpegRestarts counts FFmpeg failures per camera and reason. It outlives the
+// camera's StreamMetrics, which are recreated on every start.
+var (
+	ffmpegRestarts      = make(map[string]map[string]uint64)
+	ffmpegRestartsMutex sync.RWMutex
+)
+
+// recordFFmpegFailure counts one failure of the camera's FFmpeg process
+func recordFFmpegFailure(cameraID, reason string) {
+	ffmpegRestartsMutex.Lock()
+	defer ffmpegRestartsMutex.Unlock()
+
+	reasons, exists := ffmpegRestarts[cameraID]
+	if !exists {
+		reasons = make(map[string]uint64)
+		ffmpegRestarts[cameraID] = reasons
+	}
+	reasons[reason]++
+}
+
+// ffmpegRestartReasons returns a copy of the camera's failure counts by reason
+func ffmpegRestartReasons(cameraID string) map[string]uint64 {
+	ffmpegRestartsMutex.RLock()
+	defer ffmpegRestartsMutex.RUnlock()
+
+	reasons := make(map[string]uint64, len(ffmpegRestarts[cameraID]))
+	for reason, count := range ffmpegRestarts[cameraID] {
+		reasons[reason] = count
+	}
+	return reasons
+}
+
+// ffmpegRestartTotals sums the failure counts by reason across cameras
+func ffmpegRestartTotals() map[string]uint64 {
+	ffmpegRestartsMutex.RLock()
+	defer ffmpegRestartsMutex.RUnlock()
+
+	totals := make(map[string]uint64)
+	for _, reasons := range ffmpegRestarts {
+		for reason, count := range reasons {
+			totals[reason] += count
+		}
+	}
+	return totals
+}
+
+// forgetFFmpegRestarts drops counts for cameras with neither a process nor a circuit
+// breaker; caller holds processMutex and circuitBreakersMutex
+func forgetFFmpegRestarts() int {
+	ffmpegRestartsMutex.Lock()
+	defer ffmpegRestartsMutex.Unlock()
+
+	removed := 0
+	for cameraID := range ffmpegRestarts {
+		_, running := activeProcesses[cameraID]
+		_, tracked := circuitBreakers[cameraID]
+		if !running && !tracked {
+			delete(ffmpegRestarts, cameraID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// prometheusFFmpegRestarts renders ffmpeg_restarts_total in the Prometheus text format
+func prometheusFFmpegRestarts() string {
+	ffmpegRestartsMutex.RLock()
+	defer ffmpegRestartsMutex.RUnlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP ffmpeg_restarts_total FFmpeg process failures by camera and classified reason.\n")
+	b.WriteString("# TYPE ffmpeg_restarts_total counter\n")
+
+	cameraIDs := make([]string, 0, len(ffmpegRestarts))
+	for cameraID := range ffmpegRestarts {
+		cameraIDs = append(cameraIDs, cameraID)
+	}
+	sort.Strings(cameraIDs)
+	for _, cameraID := range cameraIDs {
+		reasons := make([]string, 0, len(ffmpegRestarts[cameraID]))
+		for reason := range ffmpegRestarts[cameraID] {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(&b, "ffmpeg_restarts_total{camera_id=\"%s\",reason=\"%s\"} %d\n",
+				prometheusLabelValue(cameraID), prometheusLabelValue(reason), ffmpegRestarts[cameraID][reason])
+		}
+	}
+	return b.String()
+}
+
+// prometheusLabelValue escapes a label value for the text exposition format
+func prometheusLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ffmpegLogHistory       = 500  // Lines kept per camera for GET /logs and new tail subscribers
+	ffmpegLogSubscriberBuf = 256  // Per-subscriber buffer; a slow reader drops lines instead of blocking FFmpeg
+	ffmpegLogMaxLineBytes  = 2048 // Longer lines are cut so a runaway line can't grow the buffer
+)
+
+// FFmpegLogLine is one line of a camera's FFmpeg stderr
+type FFmpegLogLine struct {
+	At   time.Time `json:"at"`
+	Text string    `json:"text"`
+	// Progress lines (frame=, fps=, bitrate=) end in a carriage return; they are
+	// streamed live but not kept in the history
+	Progress bool `json:"progress,omitempty"`
+}
+
+// ffmpegLogSubscriber is one live tail of a camera's log
+type ffmpegLogSubscriber struct {
+	lines   chan FFmpegLogLine
+	dropped int // Lines lost since the subscriber last caught up; guarded by the hub
+}
+
+// FFmpegLogHub keeps each camera's recent FFmpeg stderr and fans new lines out to
+// tail subscribers until the camera's process stops
+type FFmpegLogHub struct {
+	history     map[string][]FFmpegLogLine // Oldest first
+	running     map[string]bool
+	subscribers map[string]map[*ffmpegLogSubscriber]struct{}
+	mu          sync.Mutex
+}
+
+// NewFFmpegLogHub creates an empty hub
+func NewFFmpegLogHub() *FFmpegLogHub {
+	return &FFmpegLogHub{
+		history:     make(map[string][]FFmpegLogLine),
+		running:     make(map[string]bool),
+		subscribers: make(map[string]map[*ffmpegLogSubscriber]struct{}),
+	}
+}
+
+var ffmpegLogs = NewFFmpegLogHub()
+
+// Start marks the camera's process as running so tails started now stay open
+func (h *FFmpegLogHub) Start(cameraID string) {
+	h.mu.Lock()
+	h.running[cameraID] = true
+	h.mu.Unlock()
+}
+
+// Stop ends every tail of the camera; its history is kept for GET /logs until the
+// state janitor drops it
+func (h *FFmpegLogHub) Stop(cameraID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.running, cameraID)
+	for sub := range h.subscribers[cameraID] {
+		close(sub.lines)
+	}
+	delete(h.subscribers, cameraID)
+}
+
+// Publish records a line and delivers it to the camera's subscribers without blocking
+func (h *FFmpegLogHub) Publish(cameraID string, line FFmpegLogLine) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !line.Progress {
+		history := append(h.history[cameraID], line)
+		if excess := len(history) - ffmpegLogHistory; excess > 0 {
+			history = append(history[:0], history[excess:]...)
+		}
+		h.history[cameraID] = history
+	}
+
+	for sub := range h.subscribers[cameraID] {
+		select {
+		case sub.lines <- line:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Recent returns a copy of the camera's buffered lines
+func (h *FFmpegLogHub) Recent(cameraID string) []FFmpegLogLine {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]FFmpegLogLine{}, h.history[cameraID]...)
+}
+
+// Subscribe returns the buffered lines and, if the camera's process is running, a
+// subscriber for the lines that follow them (nil otherwise)
+func (h *FFmpegLogHub) Subscribe(cameraID string) ([]FFmpegLogLine, *ffmpegLogSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	history := append([]FFmpegLogLine{}, h.history[cameraID]...)
+	if !h.running[cameraID] {
+		return history, nil
+	}
+
+	sub := &ffmpegLogSubscriber{lines: make(chan FFmpegLogLine, ffmpegLogSubscriberBuf)}
+	if h.subscribers[cameraID] == nil {
+		h.subscribers[cameraID] = make(map[*ffmpegLogSubscriber]struct{})
+	}
+	h.subscribers[cameraID][sub] = struct{}{}
+	return history, sub
+}
+
+// Unsubscribe removes a tail; it is a no-op once Stop has closed it
+func (h *FFmpegLogHub) Unsubscribe(cameraID string, sub *ffmpegLogSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.subscribers[cameraID][sub]; !exists {
+		return
+	}
+	delete(h.subscribers[cameraID], sub)
+	close(sub.lines)
+	if len(h.subscribers[cameraID]) == 0 {
+		delete(h.subscribers, cameraID)
+	}
+}
+
+// TakeDropped returns and resets how many lines the subscriber has missed
+func (h *FFmpegLogHub) TakeDropped(sub *ffmpegLogSubscriber) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dropped := sub.dropped
+	sub.dropped = 0
+	return dropped
+}
+
+// forgetFFmpegLogs drops the history of cameras that no longer run; caller holds processMutex
+func (h *FFmpegLogHub) forgetFFmpegLogs() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	removed := 0
+	for cameraID := range h.history {
+		if _, running := activeProcesses[cameraID]; !running && !h.running[cameraID] {
+			delete(h.history, cameraID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// logURLUserinfo matches the userinfo of a URL inside a log line, up to its last '@'
+// before the host as url.Parse reads it
+var logURLUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)([^\s/?#'"]*)@`)
+
+// redactLogURLs masks the passwords of the URLs in a line, as redactedURL does. FFmpeg
+// logs its input with the credentials openSourceURL decrypted, and /logs serves the
+// lines to anyone.
+func redactLogURLs(line string) string {
+	return logURLUserinfo.ReplaceAllStringFunc(line, func(match string) string {
+		parts := logURLUserinfo.FindStringSubmatch(match)
+		username, _, hasPassword := strings.Cut(parts[2], ":")
+		if !hasPassword {
+			return match
+		}
+		return parts[1] + username + ":xxxxx@"
+	})
+}
+
+// ffmpegLogWriter splits one process's stderr into lines for the hub. exec copies
+// stderr from a single goroutine, so it needs no lock of its own.
+type ffmpegLogWriter struct {
+	cameraID string
+	hub      *FFmpegLogHub
+	partial  []byte
+}
+
+func (w *ffmpegLogWriter) Write(p []byte) (int, error) {
+	data := p
+	for len(data) > 0 {
+		end := bytes.IndexAny(data, "\r\n")
+		if end < 0 {
+			room := ffmpegLogMaxLineBytes - len(w.partial)
+			w.partial = append(w.partial, data[:min(len(data), max(room, 0))]...)
+			break
+		}
+
+		w.partial = append(w.partial, data[:min(end, max(ffmpegLogMaxLineBytes-len(w.partial), 0))]...)
+		if len(w.partial) > 0 {
+			w.hub.Publish(w.cameraID, FFmpegLogLine{At: time.Now(), Text: redactLogURLs(string(w.partial)), Progress: data[end] == '\r'})
+			w.partial = w.partial[:0]
+		}
+		data = data[end+1:]
+	}
+	return len(p), nil
+}
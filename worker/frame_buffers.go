@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// annotationMatPoolSize bounds the idle Mats kept for drawing alert thumbnails;
+	// detections beyond that many at once allocate and close their own
+	annotationMatPoolSize = 4
+	// maxPooledBufferBytes keeps one oversized thumbnail from pinning its buffer forever
+	maxPooledBufferBytes = 1 << 20
+)
+
+// MatPool reuses the Mats alert thumbnails are drawn on. Mats hold C memory the Go GC
+// can't see or free, so this is a bounded free list rather than a sync.Pool, which
+// drops idle items without closing them: every Mat is either handed out, idle in the
+// list, or closed.
+type MatPool struct {
+	free chan gocv.Mat
+
+	created uint64
+	reused  uint64
+	closed  uint64
+	out     int // Handed out and not yet returned
+	mu      sync.Mutex
+}
+
+// NewMatPool creates a pool keeping at most size idle Mats
+func NewMatPool(size int) *MatPool {
+	return &MatPool{free: make(chan gocv.Mat, size)}
+}
+
+var annotationMats = NewMatPool(annotationMatPoolSize)
+
+// CopyOf returns a pooled Mat holding a copy of src; CopyTo reuses the Mat's memory
+// when the frame size and type haven't changed. The Mat must go back through Put.
+func (p *MatPool) CopyOf(src gocv.Mat) gocv.Mat {
+	var mat gocv.Mat
+	reused := false
+	select {
+	case mat = <-p.free:
+		reused = true
+	default:
+		mat = gocv.NewMat()
+	}
+
+	p.mu.Lock()
+	if reused {
+		p.reused++
+	} else {
+		p.created++
+	}
+	p.out++
+	p.mu.Unlock()
+
+	src.CopyTo(&mat)
+	return mat
+}
+
+// Put returns a Mat to the pool, closing it when the pool is full
+func (p *MatPool) Put(mat gocv.Mat) {
+	kept := false
+	select {
+	case p.free <- mat:
+		kept = true
+	default:
+		mat.Close()
+	}
+
+	p.mu.Lock()
+	p.out--
+	if !kept {
+		p.closed++
+	}
+	p.mu.Unlock()
+}
+
+// Drain closes the idle Mats
+func (p *MatPool) Drain() {
+	for {
+		select {
+		case mat := <-p.free:
+			mat.Close()
+			p.mu.Lock()
+			p.closed++
+			p.mu.Unlock()
+		default:
+			return
+		}
+	}
+}
+
+// MatPoolStats is the Mat accounting of /metrics. Live (created - closed) must equal
+// Idle + InUse; a Live that keeps growing past the pool size means a leaked Mat.
+type MatPoolStats struct {
+	Created uint64 `json:"created"`
+	Reused  uint64 `json:"reused"`
+	Closed  uint64 `json:"closed"`
+	Live    uint64 `json:"live"`
+	Idle    int    `json:"idle"`
+	InUse   int    `json:"inUse"`
+}
+
+// Stats returns the pool's counters
+func (p *MatPool) Stats() MatPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return MatPoolStats{
+		Created: p.created,
+		Reused:  p.reused,
+		Closed:  p.closed,
+		Live:    p.created - p.closed,
+		Idle:    len(p.free),
+		InUse:   p.out,
+	}
+}
+
+// byteBuffers reuses the scratch buffers thumbnails are encoded through
+var byteBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+func getByteBuffer() *bytes.Buffer {
+	buf := byteBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putByteBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferBytes {
+		byteBuffers.Put(buf)
+	}
+}
+
+// encodeThumbnail base64-encodes a JPEG for an alert through a pooled scratch buffer.
+// It returns the encoded size, and skips encoding (returning "") when that exceeds
+// maxBytes (0 = no limit).
+func encodeThumbnail(jpegBytes []byte, maxBytes int) (string, int) {
+	encodedLen := base64.StdEncoding.EncodedLen(len(jpegBytes))
+	if maxBytes > 0 && encodedLen > maxBytes {
+		return "", encodedLen
+	}
+
+	buf := getByteBuffer()
+	defer putByteBuffer(buf)
+	buf.Grow(encodedLen)
+	scratch := buf.AvailableBuffer()[:encodedLen]
+	base64.StdEncoding.Encode(scratch, jpegBytes)
+	return string(scratch), encodedLen
+}
+
+// frameBufferStats is the frameBuffers entry of /metrics
+func frameBufferStats() map[string]interface{} {
+	return map[string]interface{}{
+		"annotationMats": annotationMats.Stats(),
+	}
+}
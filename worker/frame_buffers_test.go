@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"sync"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// checkMatAccounting fails unless every Mat the pool created is idle, in use or closed
+func checkMatAccounting(t *testing.T, stats MatPoolStats, inUse int) {
+	t.Helper()
+	if stats.InUse != inUse {
+		t.Fatalf("InUse = %d, want %d (%+v)", stats.InUse, inUse, stats)
+	}
+	if stats.Live != uint64(stats.Idle+stats.InUse) {
+		t.Fatalf("Live %d != Idle %d + InUse %d: a Mat leaked (%+v)", stats.Live, stats.Idle, stats.InUse, stats)
+	}
+}
+
+func TestMatPoolAccounting(t *testing.T) {
+	const (
+		poolSize   = 4
+		detectors  = 8 // Concurrent detections, more than the pool keeps idle
+		perRound   = 3 // Thumbnails each detection holds at once
+		rounds     = 50
+		totalInUse = detectors * perRound
+	)
+	pool := NewMatPool(poolSize)
+	frame := gocv.NewMatWithSize(48, 64, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	for round := 0; round < rounds; round++ {
+		held := make([][]gocv.Mat, detectors)
+		var wg sync.WaitGroup
+		for i := range held {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < perRound; j++ {
+					held[i] = append(held[i], pool.CopyOf(frame))
+				}
+			}(i)
+		}
+		wg.Wait()
+		checkMatAccounting(t, pool.Stats(), totalInUse)
+
+		for i := range held {
+			wg.Add(1)
+			go func(mats []gocv.Mat) {
+				defer wg.Done()
+				for _, mat := range mats {
+					pool.Put(mat)
+				}
+			}(held[i])
+		}
+		wg.Wait()
+		stats := pool.Stats()
+		checkMatAccounting(t, stats, 0)
+		if stats.Idle > poolSize {
+			t.Fatalf("round %d: %d idle Mats, more than the pool size %d", round, stats.Idle, poolSize)
+		}
+	}
+
+	stats := pool.Stats()
+	if want := uint64(rounds * totalInUse); stats.Created+stats.Reused != want {
+		t.Fatalf("Created %d + Reused %d != %d Mats handed out", stats.Created, stats.Reused, want)
+	}
+	if stats.Reused == 0 {
+		t.Fatal("no Mat was reused across rounds")
+	}
+
+	pool.Drain()
+	stats = pool.Stats()
+	checkMatAccounting(t, stats, 0)
+	if stats.Live != 0 || stats.Created != stats.Closed {
+		t.Fatalf("after Drain: %+v, want every created Mat closed", stats)
+	}
+}
+
+func TestEncodeThumbnail(t *testing.T) {
+	jpeg := bytes.Repeat([]byte{0xff, 0xd8, 0x42}, 100)
+	encoded, size := encodeThumbnail(jpeg, 0)
+	if want := base64.StdEncoding.EncodeToString(jpeg); encoded != want || size != len(want) {
+		t.Fatalf("encodeThumbnail = %q (%d), want %q", encoded, size, want)
+	}
+	if encoded, size := encodeThumbnail(jpeg, 10); encoded != "" || size != 400 {
+		t.Fatalf("over the limit: encodeThumbnail = %q (%d), want \"\" (400)", encoded, size)
+	}
+}
+
+// The annotation path copies every alerting frame before drawing on it; pooled, the copy
+// reuses a Mat's memory instead of allocating a new one per alert
+func BenchmarkAnnotationMatPooled(b *testing.B) {
+	pool := NewMatPool(annotationMatPoolSize)
+	defer pool.Drain()
+	frame := gocv.NewMatWithSize(720, 1280, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		annotated := pool.CopyOf(frame)
+		pool.Put(annotated)
+	}
+}
+
+func BenchmarkAnnotationMatUnpooled(b *testing.B) {
+	frame := gocv.NewMatWithSize(720, 1280, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		annotated := frame.Clone()
+		annotated.Close()
+	}
+}
+
+func BenchmarkEncodeThumbnailPooled(b *testing.B) {
+	jpeg := make([]byte, 64<<10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeThumbnail(jpeg, 0)
+	}
+}
+
+func BenchmarkEncodeThumbnailUnpooled(b *testing.B) {
+	jpeg := make([]byte, 64<<10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = base64.StdEncoding.EncodeToString(jpeg)
+	}
+}
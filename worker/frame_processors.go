@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// FrameProcessor is one stage of a camera's detection chain. Every stage sees the same
+// frame from the camera's single detection capture, in chain order, so gates such as
+// the quality check and motion detection can keep the detectors after them from
+// running on frames not worth analysing.
+type FrameProcessor interface {
+	// Name is the processor's FRAME_PROCESSORS entry, e.g. "motion"
+	Name() string
+	// Process handles one frame, which must not be kept past the call. Returning
+	// ErrSkipFrame ends the chain for this frame without counting as a failure.
+	Process(cameraID string, img gocv.Mat) error
+}
+
+// ErrSkipFrame is returned by a gating processor to drop the frame
+var ErrSkipFrame = errors.New("frame skipped")
+
+// errFrameProcessorUnavailable is returned by a factory whose detector didn't load
+var errFrameProcessorUnavailable = errors.New("frame processor not available")
+
+// frameProcessorCamera is the camera a chain is built for
+type frameProcessorCamera struct {
+	ID       string
+	Name     string
+	Settings FaceDetectionSettings
+}
+
+// frameProcessorFactories build a processor by FRAME_PROCESSORS name; new detection
+// stages (e.g. face recognition) register here
+var frameProcessorFactories = map[string]func(camera frameProcessorCamera) (FrameProcessor, error){
+	"quality": newQualityFrameProcessor,
+	"motion":  newMotionFrameProcessor,
+	"face":    newFaceFrameProcessor,
+	"object":  newObjectFrameProcessor,
+	"freeze":  newFreezeFrameProcessor,
+}
+
+// defaultFrameProcessors is the chain used when FRAME_PROCESSORS is unset, matching the
+// detectors the detection loop always ran
+var defaultFrameProcessors = []string{"face", "object"}
+
+// FrameProcessorConfig holds the default chain and the gates' thresholds
+type FrameProcessorConfig struct {
+	Chain           []string
+	MinBrightness   float64 // quality: frames with a mean gray level below this (0-255) are too dark
+	MaxBrightness   float64 // quality: ... or above this, washed out
+	MinSharpness    float64 // quality: frames whose Laplacian variance is below this are too blurry; 0 disables
+	MotionThreshold float64 // motion: fraction of pixels that must change between frames
+}
+
+// loadFrameProcessorConfig reads FRAME_PROCESSORS, FRAME_QUALITY_MIN_BRIGHTNESS,
+// FRAME_QUALITY_MAX_BRIGHTNESS, FRAME_QUALITY_MIN_SHARPNESS and FRAME_MOTION_THRESHOLD
+func loadFrameProcessorConfig() FrameProcessorConfig {
+	config := FrameProcessorConfig{
+		Chain:           defaultFrameProcessors,
+		MinBrightness:   getEnvFloat("FRAME_QUALITY_MIN_BRIGHTNESS", 20),
+		MaxBrightness:   getEnvFloat("FRAME_QUALITY_MAX_BRIGHTNESS", 235),
+		MinSharpness:    getEnvFloat("FRAME_QUALITY_MIN_SHARPNESS", 50),
+		MotionThreshold: getEnvFloat("FRAME_MOTION_THRESHOLD", 0.01),
+	}
+	if value := os.Getenv("FRAME_PROCESSORS"); value != "" {
+		chain, err := parseFrameProcessors(strings.Split(value, ","))
+		if err != nil {
+			log.Printf("Invalid FRAME_PROCESSORS %q, using %s: %v", value, strings.Join(defaultFrameProcessors, ","), err)
+		} else {
+			config.Chain = chain
+		}
+	}
+	if config.MotionThreshold < 0 || config.MotionThreshold > 1 {
+		log.Printf("FRAME_MOTION_THRESHOLD must be between 0 and 1, using 0.01")
+		config.MotionThreshold = 0.01
+	}
+	return config
+}
+
+var frameProcessorConfig = FrameProcessorConfig{
+	Chain:           defaultFrameProcessors,
+	MinBrightness:   20,
+	MaxBrightness:   235,
+	MinSharpness:    50,
+	MotionThreshold: 0.01,
+}
+
+// parseFrameProcessors normalizes a chain and checks every name is registered and
+// listed once
+func parseFrameProcessors(names []string) ([]string, error) {
+	chain := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, exists := frameProcessorFactories[name]; !exists {
+			return nil, fmt.Errorf("unknown frame processor %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("frame processor %q is listed twice", name)
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no frame processors listed")
+	}
+	return chain, nil
+}
+
+// frameProcessorNames returns the camera's chain: its frameProcessors option, or
+// FRAME_PROCESSORS
+func frameProcessorNames(options StreamOptions) []string {
+	if len(options.FrameProcessors) > 0 {
+		if chain, err := parseFrameProcessors(options.FrameProcessors); err == nil {
+			return chain
+		}
+	}
+	return frameProcessorConfig.Chain
+}
+
+// FrameProcessorChain runs a camera's processors over each frame. It belongs to the
+// camera's detection loop and is not safe for concurrent use.
+type FrameProcessorChain struct {
+	processors []FrameProcessor
+}
+
+// newFrameProcessorChain builds the named processors for the camera, leaving out the
+// ones whose detector isn't loaded
+func newFrameProcessorChain(camera frameProcessorCamera, names []string) *FrameProcessorChain {
+	chain := &FrameProcessorChain{}
+	for _, name := range names {
+		factory, exists := frameProcessorFactories[name]
+		if !exists {
+			log.Printf("Unknown frame processor %q for camera %s, leaving it out", name, camera.ID)
+			continue
+		}
+		processor, err := factory(camera)
+		if err != nil {
+			if !errors.Is(err, errFrameProcessorUnavailable) {
+				log.Printf("Failed to create frame processor %s for camera %s: %v", name, camera.ID, err)
+			}
+			continue
+		}
+		chain.processors = append(chain.processors, processor)
+	}
+	return chain
+}
+
+// Len returns the number of processors in the chain
+func (c *FrameProcessorChain) Len() int {
+	return len(c.processors)
+}
+
+// String lists the chain in order, e.g. "quality -> motion -> face"
+func (c *FrameProcessorChain) String() string {
+	names := make([]string, len(c.processors))
+	for i, processor := range c.processors {
+		names[i] = processor.Name()
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Run passes the frame through the processors until one skips it or fails
+func (c *FrameProcessorChain) Run(cameraID string, img gocv.Mat) {
+	for _, processor := range c.processors {
+		if err := processor.Process(cameraID, img); err != nil {
+			if !errors.Is(err, ErrSkipFrame) {
+				log.Printf("Frame processor %s failed for camera %s: %v", processor.Name(), cameraID, err)
+			}
+			return
+		}
+	}
+}
+
+// Close releases the Mats processors keep between frames
+func (c *FrameProcessorChain) Close() {
+	for _, processor := range c.processors {
+		if closer, ok := processor.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// qualityFrameProcessor drops frames too dark, too bright or too blurry to detect in,
+// e.g. at night without IR or while the camera refocuses
+type qualityFrameProcessor struct {
+	minBrightness float64
+	maxBrightness float64
+	minSharpness  float64
+
+	gray, laplacian, mean, stdDev gocv.Mat
+}
+
+func newQualityFrameProcessor(camera frameProcessorCamera) (FrameProcessor, error) {
+	return &qualityFrameProcessor{
+		minBrightness: frameProcessorConfig.MinBrightness,
+		maxBrightness: frameProcessorConfig.MaxBrightness,
+		minSharpness:  frameProcessorConfig.MinSharpness,
+		gray:          gocv.NewMat(),
+		laplacian:     gocv.NewMat(),
+		mean:          gocv.NewMat(),
+		stdDev:        gocv.NewMat(),
+	}, nil
+}
+
+func (p *qualityFrameProcessor) Name() string { return "quality" }
+
+func (p *qualityFrameProcessor) Process(cameraID string, img gocv.Mat) error {
+	if err := gocv.CvtColor(img, &p.gray, gocv.ColorBGRToGray); err != nil {
+		return err
+	}
+	if brightness := p.gray.Mean().Val1; brightness < p.minBrightness || brightness > p.maxBrightness {
+		return ErrSkipFrame
+	}
+	if p.minSharpness <= 0 {
+		return nil
+	}
+
+	// Variance of the Laplacian: edges are what make a frame sharp
+	if err := gocv.Laplacian(p.gray, &p.laplacian, gocv.MatTypeCV64F, 1, 1, 0, gocv.BorderDefault); err != nil {
+		return err
+	}
+	if err := gocv.MeanStdDev(p.laplacian, &p.mean, &p.stdDev); err != nil {
+		return err
+	}
+	if stdDev := p.stdDev.GetDoubleAt(0, 0); stdDev*stdDev < p.minSharpness {
+		return ErrSkipFrame
+	}
+	return nil
+}
+
+func (p *qualityFrameProcessor) Close() {
+	p.gray.Close()
+	p.laplacian.Close()
+	p.mean.Close()
+	p.stdDev.Close()
+}
+
+// motionFrameWidth is the width frames are scaled to before being compared
+const motionFrameWidth = 320
+
+// motionFrameProcessor passes a frame on only when enough of it changed since the
+// previous one, so a static scene isn't run through the detectors every interval
+type motionFrameProcessor struct {
+	threshold float64
+
+	small, gray, previous, diff gocv.Mat
+}
+
+func newMotionFrameProcessor(camera frameProcessorCamera) (FrameProcessor, error) {
+	return &motionFrameProcessor{
+		threshold: frameProcessorConfig.MotionThreshold,
+		small:     gocv.NewMat(),
+		gray:      gocv.NewMat(),
+		previous:  gocv.NewMat(),
+		diff:      gocv.NewMat(),
+	}, nil
+}
+
+func (p *motionFrameProcessor) Name() string { return "motion" }
+
+func (p *motionFrameProcessor) Process(cameraID string, img gocv.Mat) error {
+	size := image.Pt(motionFrameWidth, motionFrameWidth*img.Rows()/img.Cols())
+	if err := gocv.Resize(img, &p.small, size, 0, 0, gocv.InterpolationArea); err != nil {
+		return err
+	}
+	if err := gocv.CvtColor(p.small, &p.gray, gocv.ColorBGRToGray); err != nil {
+		return err
+	}
+	// Blurring keeps sensor noise and compression artefacts from counting as motion
+	if err := gocv.GaussianBlur(p.gray, &p.gray, image.Pt(21, 21), 0, 0, gocv.BorderDefault); err != nil {
+		return err
+	}
+
+	// The first frame, or the first after the source changed resolution, has nothing
+	// to compare with and is passed on
+	if p.previous.Empty() || p.previous.Rows() != p.gray.Rows() || p.previous.Cols() != p.gray.Cols() {
+		return p.gray.CopyTo(&p.previous)
+	}
+
+	if err := gocv.AbsDiff(p.previous, p.gray, &p.diff); err != nil {
+		return err
+	}
+	gocv.Threshold(p.diff, &p.diff, 25, 255, gocv.ThresholdBinary)
+	changed := float64(gocv.CountNonZero(p.diff)) / float64(p.diff.Total())
+	if err := p.gray.CopyTo(&p.previous); err != nil {
+		return err
+	}
+	if changed < p.threshold {
+		return ErrSkipFrame
+	}
+	return nil
+}
+
+func (p *motionFrameProcessor) Close() {
+	p.small.Close()
+	p.gray.Close()
+	p.previous.Close()
+	p.diff.Close()
+}
+
+// faceFrameProcessor runs the face detector with the camera's resolved settings
+type faceFrameProcessor struct {
+	camera frameProcessorCamera
+}
+
+func newFaceFrameProcessor(camera frameProcessorCamera) (FrameProcessor, error) {
+	if !faceDetectionEnabled() {
+		return nil, errFrameProcessorUnavailable
+	}
+	return &faceFrameProcessor{camera: camera}, nil
+}
+
+func (p *faceFrameProcessor) Name() string { return "face" }
+
+func (p *faceFrameProcessor) Process(cameraID string, img gocv.Mat) error {
+	faceDetector.ProcessFrameForFaceDetection(cameraID, p.camera.Name, img, p.camera.Settings)
+	return nil
+}
+
+// objectFrameProcessor runs the object detector with the camera's resolved settings
+type objectFrameProcessor struct {
+	camera frameProcessorCamera
+}
+
+func newObjectFrameProcessor(camera frameProcessorCamera) (FrameProcessor, error) {
+	if !objectDetectionEnabled() {
+		return nil, errFrameProcessorUnavailable
+	}
+	return &objectFrameProcessor{camera: camera}, nil
+}
+
+func (p *objectFrameProcessor) Name() string { return "object" }
+
+func (p *objectFrameProcessor) Process(cameraID string, img gocv.Mat) error {
+	objectDetector.ProcessFrame(cameraID, p.camera.Name, img, p.camera.Settings)
+	return nil
+}
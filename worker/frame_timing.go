@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Frame timestamp sources, selected by RTSP_FRAME_TIMESTAMP_SOURCE
+const (
+	frameTimestampWallClock = "wallclock" // When the packet reached the worker (default)
+	frameTimestampRTP       = "rtp"       // The source's RTP timestamp, mapped onto the wall clock
+)
+
+// h264ClockRate is the RTP clock of H.264 video
+const h264ClockRate = 90000
+
+// frameTimestampSourceFromEnv reads RTSP_FRAME_TIMESTAMP_SOURCE, defaulting to wallclock
+func frameTimestampSourceFromEnv() string {
+	switch source := os.Getenv("RTSP_FRAME_TIMESTAMP_SOURCE"); source {
+	case frameTimestampRTP:
+		return source
+	case "", frameTimestampWallClock:
+		return frameTimestampWallClock
+	default:
+		log.Printf("Unknown RTSP_FRAME_TIMESTAMP_SOURCE %q, using %q", source, frameTimestampWallClock)
+		return frameTimestampWallClock
+	}
+}
+
+// rtpTimeline unwraps a stream's 32-bit RTP timestamps into a position since its first
+// packet. It is reset whenever the manager reconnects, since the new session's
+// timestamps start from a new random offset.
+type rtpTimeline struct {
+	started      bool
+	last         uint32
+	extended     int64     // last, unwrapped, relative to the first packet's timestamp
+	firstArrival time.Time // Arrival of the first packet; anchors the timeline on the wall clock
+}
+
+// position returns ts as a duration since the first packet. Timestamps move by less
+// than half the 32-bit range between packets, so the signed difference from the
+// previous one unwraps them, and also handles packets reordered backwards.
+func (t *rtpTimeline) position(ts uint32, arrival time.Time, clockRate int) time.Duration {
+	if !t.started {
+		t.started = true
+		t.last, t.extended = ts, 0
+		t.firstArrival = arrival
+		return 0
+	}
+	t.extended += int64(int32(ts - t.last))
+	t.last = ts
+	return time.Duration(t.extended) * time.Second / time.Duration(clockRate)
+}
+
+// Time returns the frame's timestamp under the given source. Frames without an RTP
+// timestamp (cached parameter sets the worker replays) fall back to the wall clock.
+func (f *Frame) Time(source string) time.Time {
+	if source == frameTimestampRTP && f.HasRTPTimestamp {
+		return f.RTPStart.Add(f.RTPPosition)
+	}
+	return f.ArrivalTime
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// FreezeDetectionConfig controls the frozen picture check. A camera can keep sending
+// packets, so the byte counters and the watchdog stay happy, while its picture stopped
+// changing; this compares decoded frames instead.
+type FreezeDetectionConfig struct {
+	Enabled   bool          // Check every stream, not just chains listing "freeze"
+	Interval  time.Duration // Time between compared frames
+	Threshold float64       // Fraction of pixels that must differ for two frames not to count as identical
+	Duration  time.Duration // Identical frames for this long make the stream frozen
+	Restart   bool          // Restart FFmpeg when a stream freezes
+}
+
+// loadFreezeDetectionConfig reads FREEZE_DETECTION_ENABLED, FREEZE_CHECK_INTERVAL,
+// FREEZE_DIFF_THRESHOLD, FREEZE_DURATION and FREEZE_RESTART
+func loadFreezeDetectionConfig() FreezeDetectionConfig {
+	config := FreezeDetectionConfig{
+		Enabled:   os.Getenv("FREEZE_DETECTION_ENABLED") == "true",
+		Interval:  getEnvDuration("FREEZE_CHECK_INTERVAL", 5*time.Second),
+		Threshold: getEnvFloat("FREEZE_DIFF_THRESHOLD", 0.0005),
+		Duration:  getEnvDuration("FREEZE_DURATION", 30*time.Second),
+		Restart:   os.Getenv("FREEZE_RESTART") == "true",
+	}
+	if config.Interval < time.Second {
+		config.Interval = 5 * time.Second
+	}
+	if config.Threshold < 0 || config.Threshold > 1 {
+		log.Printf("FREEZE_DIFF_THRESHOLD must be between 0 and 1, using 0.0005")
+		config.Threshold = 0.0005
+	}
+	if config.Duration < config.Interval {
+		log.Printf("FREEZE_DURATION is shorter than FREEZE_CHECK_INTERVAL, using %v", 2*config.Interval)
+		config.Duration = 2 * config.Interval
+	}
+	return config
+}
+
+var freezeDetectionConfig = FreezeDetectionConfig{
+	Interval:  5 * time.Second,
+	Threshold: 0.0005,
+	Duration:  30 * time.Second,
+}
+
+// Frames are compared at freezeFrameWidth, twice motion detection's, so a camera's
+// on-screen clock alone is enough change to tell a live static scene from a frozen one.
+// A pixel's gray level must change by freezePixelDelta to count as different; anything
+// smaller is re-encoding noise on an unchanged picture.
+const (
+	freezeFrameWidth = 640
+	freezePixelDelta = 5
+)
+
+// FrozenStream is a stream whose picture is currently frozen
+type FrozenStream struct {
+	CameraID  string    `json:"cameraId"`
+	Since     time.Time `json:"since"` // First of the identical frames
+	Restarted bool      `json:"restarted"`
+}
+
+// FreezeStats is the freezeDetection entry of /metrics
+type FreezeStats struct {
+	Enabled  bool           `json:"enabled"`
+	Frozen   []FrozenStream `json:"frozen"`
+	Alerts   uint64         `json:"alerts"`
+	Restarts uint64         `json:"restarts"`
+}
+
+// FreezeTracker records which streams are frozen, for /metrics and /health/summary
+type FreezeTracker struct {
+	frozen   map[string]FrozenStream
+	alerts   uint64
+	restarts uint64
+	mu       sync.Mutex
+}
+
+var freezeTracker = &FreezeTracker{frozen: make(map[string]FrozenStream)}
+
+// Frozen reports the camera's freeze and restarts its FFmpeg when FREEZE_RESTART is set
+func (t *FreezeTracker) Frozen(cameraID string, since time.Time, changed float64) {
+	frozenFor := time.Since(since).Round(time.Second)
+	restarted := freezeDetectionConfig.Restart && restartFrozenStream(cameraID)
+
+	t.mu.Lock()
+	t.frozen[cameraID] = FrozenStream{CameraID: cameraID, Since: since, Restarted: restarted}
+	t.alerts++
+	if restarted {
+		t.restarts++
+	}
+	t.mu.Unlock()
+
+	log.Printf("Freeze detection: picture of camera %s hasn't changed for %v (%.4f of pixels differ)", cameraID, frozenFor, changed)
+	streamEvents.Publish(StreamEvent{
+		Type:     streamEventFrozen,
+		CameraID: cameraID,
+		Reason:   fmt.Sprintf("picture unchanged for %v while the stream kept running", frozenFor),
+		Details: map[string]interface{}{
+			"frozenSince":     since.UTC(),
+			"changedFraction": changed,
+			"restarted":       restarted,
+		},
+	})
+}
+
+// Thawed clears the camera's freeze once its picture changes again
+func (t *FreezeTracker) Thawed(cameraID string, frozenFor time.Duration) {
+	t.mu.Lock()
+	_, wasFrozen := t.frozen[cameraID]
+	delete(t.frozen, cameraID)
+	t.mu.Unlock()
+	if !wasFrozen {
+		return
+	}
+
+	log.Printf("Freeze detection: picture of camera %s is changing again after %v", cameraID, frozenFor.Round(time.Second))
+	streamEvents.Publish(StreamEvent{
+		Type:     streamEventUnfrozen,
+		CameraID: cameraID,
+		Reason:   fmt.Sprintf("picture changing again after %v", frozenFor.Round(time.Second)),
+	})
+}
+
+// Forget drops the camera once nothing checks it any more
+func (t *FreezeTracker) Forget(cameraID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.frozen, cameraID)
+}
+
+// Stats returns the frozen streams, sorted by camera ID, and the counters
+func (t *FreezeTracker) Stats() FreezeStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := FreezeStats{
+		Enabled:  freezeDetectionConfig.Enabled,
+		Frozen:   make([]FrozenStream, 0, len(t.frozen)),
+		Alerts:   t.alerts,
+		Restarts: t.restarts,
+	}
+	for _, frozen := range t.frozen {
+		stats.Frozen = append(stats.Frozen, frozen)
+	}
+	sort.Slice(stats.Frozen, func(i, j int) bool { return stats.Frozen[i].CameraID < stats.Frozen[j].CameraID })
+	return stats
+}
+
+// restartFrozenStream kills the camera's FFmpeg so the monitor goroutine restarts it,
+// as the stream watchdog does
+func restartFrozenStream(cameraID string) bool {
+	processMutex.RLock()
+	process, exists := activeProcesses[cameraID]
+	processMutex.RUnlock()
+	if !exists || process.Command == nil || process.Command.Process == nil {
+		return false
+	}
+	if err := process.Command.Process.Kill(); err != nil {
+		log.Printf("Freeze detection: failed to kill FFmpeg for camera %s: %v", cameraID, err)
+		return false
+	}
+	log.Printf("Freeze detection: restarting FFmpeg for camera %s", cameraID)
+	return true
+}
+
+// freezeFrameProcessor compares a frame every FREEZE_CHECK_INTERVAL with the last one
+// it compared and reports the stream frozen once they stay near-identical for
+// FREEZE_DURATION. It never drops frames, so it can go anywhere in a chain; first is
+// best, since a gate before it hides the frames that show the freeze.
+type freezeFrameProcessor struct {
+	cameraID string
+	config   FreezeDetectionConfig
+
+	lastCompared time.Time
+	previousAt   time.Time // When previous was captured
+	frozenSince  time.Time // Zero while the picture changes
+	alerted      bool
+
+	small, gray, previous, diff gocv.Mat
+}
+
+func newFreezeFrameProcessor(camera frameProcessorCamera) (FrameProcessor, error) {
+	return &freezeFrameProcessor{
+		cameraID: camera.ID,
+		config:   freezeDetectionConfig,
+		small:    gocv.NewMat(),
+		gray:     gocv.NewMat(),
+		previous: gocv.NewMat(),
+		diff:     gocv.NewMat(),
+	}, nil
+}
+
+func (p *freezeFrameProcessor) Name() string { return "freeze" }
+
+func (p *freezeFrameProcessor) Process(cameraID string, img gocv.Mat) error {
+	// A tenth of slack, so a loop ticking at the interval itself doesn't skip every
+	// other frame on timer jitter
+	now := time.Now()
+	if now.Sub(p.lastCompared) < p.config.Interval*9/10 {
+		return nil
+	}
+	p.lastCompared = now
+
+	size := image.Pt(freezeFrameWidth, freezeFrameWidth*img.Rows()/img.Cols())
+	if err := gocv.Resize(img, &p.small, size, 0, 0, gocv.InterpolationArea); err != nil {
+		return err
+	}
+	if err := gocv.CvtColor(p.small, &p.gray, gocv.ColorBGRToGray); err != nil {
+		return err
+	}
+	if p.previous.Empty() || p.previous.Rows() != p.gray.Rows() || p.previous.Cols() != p.gray.Cols() {
+		p.reset(cameraID)
+		p.lastCompared, p.previousAt = now, now
+		return p.gray.CopyTo(&p.previous)
+	}
+
+	// Unlike motion detection nothing is blurred: a frozen picture decodes the same
+	// every time, and the sliver of change a live one shows is what counts
+	if err := gocv.AbsDiff(p.previous, p.gray, &p.diff); err != nil {
+		return err
+	}
+	gocv.Threshold(p.diff, &p.diff, freezePixelDelta, 255, gocv.ThresholdBinary)
+	changed := float64(gocv.CountNonZero(p.diff)) / float64(p.diff.Total())
+	previousAt := p.previousAt
+	if err := p.gray.CopyTo(&p.previous); err != nil {
+		return err
+	}
+	p.previousAt = now
+
+	if changed > p.config.Threshold {
+		if p.alerted {
+			freezeTracker.Thawed(cameraID, now.Sub(p.frozenSince))
+		}
+		p.frozenSince, p.alerted = time.Time{}, false
+		return nil
+	}
+	if p.frozenSince.IsZero() {
+		p.frozenSince = previousAt
+	}
+	if !p.alerted && now.Sub(p.frozenSince) >= p.config.Duration {
+		p.alerted = true
+		freezeTracker.Frozen(cameraID, p.frozenSince, changed)
+	}
+	return nil
+}
+
+// reset forgets the comparison state, e.g. after the resolution changed
+func (p *freezeFrameProcessor) reset(cameraID string) {
+	if p.alerted {
+		freezeTracker.Forget(cameraID)
+	}
+	p.previous.Close()
+	p.previous = gocv.NewMat()
+	p.lastCompared, p.frozenSince, p.alerted = time.Time{}, time.Time{}, false
+}
+
+func (p *freezeFrameProcessor) Close() {
+	if p.alerted {
+		freezeTracker.Forget(p.cameraID)
+	}
+	p.small.Close()
+	p.gray.Close()
+	p.previous.Close()
+	p.diff.Close()
+}
+
+// withFreezeProcessor puts "freeze" first in a chain when FREEZE_DETECTION_ENABLED is
+// set and the chain doesn't list it already
+func withFreezeProcessor(names []string) []string {
+	if !freezeDetectionConfig.Enabled {
+		return names
+	}
+	for _, name := range names {
+		if name == "freeze" {
+			return names
+		}
+	}
+	return append([]string{"freeze"}, names...)
+}
+
+// standaloneFreeze is a stream's own freeze check. It is paused while a detection chain
+// runs, whose freeze processor takes over, so the camera isn't decoded twice.
+type standaloneFreeze struct {
+	rtspURL string
+	stream  context.Context    // Ends with the stream
+	cancel  context.CancelFunc // Stops the running check; nil while paused
+}
+
+// standaloneFreezes holds the streams' checks by camera ID. standaloneFreezesMu is taken
+// before faceDetectionMutex.
+var (
+	standaloneFreezes   = make(map[string]*standaloneFreeze)
+	standaloneFreezesMu sync.Mutex
+)
+
+// startFreezeDetection checks a stream for freezes on its own capture of the detection
+// source, reusing the detection loop, whenever no detection chain runs for it. It ends
+// with ctx.
+func startFreezeDetection(cameraID, rtspURL string, ctx context.Context) {
+	if !freezeDetectionConfig.Enabled {
+		return
+	}
+	rtspURL, err := openSourceURL(rtspURL)
+	if err != nil {
+		log.Printf("Freeze detection can't read camera %s: %v", cameraID, err)
+		return
+	}
+
+	freeze := &standaloneFreeze{rtspURL: rtspURL, stream: ctx}
+	standaloneFreezesMu.Lock()
+	if previous, exists := standaloneFreezes[cameraID]; exists && previous.cancel != nil {
+		previous.cancel()
+	}
+	standaloneFreezes[cameraID] = freeze
+	if !faceDetectionRunning(cameraID) {
+		freeze.run(cameraID)
+	}
+	standaloneFreezesMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		standaloneFreezesMu.Lock()
+		if standaloneFreezes[cameraID] == freeze {
+			delete(standaloneFreezes, cameraID)
+		}
+		standaloneFreezesMu.Unlock()
+	}()
+}
+
+// run starts the check; the caller holds standaloneFreezesMu
+func (f *standaloneFreeze) run(cameraID string) {
+	if f.cancel != nil || f.stream.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(f.stream)
+	f.cancel = cancel
+
+	processor, _ := newFreezeFrameProcessor(frameProcessorCamera{ID: cameraID})
+	chain := &FrameProcessorChain{processors: []FrameProcessor{processor}}
+	log.Printf("Starting freeze detection for camera %s every %v", cameraID, freezeDetectionConfig.Interval)
+	go func() {
+		defer freezeTracker.Forget(cameraID)
+		runProcessors(ctx, cameraID, f.rtspURL, chain, freezeDetectionConfig.Interval)
+	}()
+}
+
+// pauseFreezeDetection stops the camera's own freeze check when a detection chain
+// starts. Cancelling it closes its capture and frees its source connection, which the
+// chain may be queued for.
+func pauseFreezeDetection(cameraID string) {
+	standaloneFreezesMu.Lock()
+	defer standaloneFreezesMu.Unlock()
+	if freeze, exists := standaloneFreezes[cameraID]; exists && freeze.cancel != nil {
+		log.Printf("Freeze detection for camera %s handed over to its detection chain", cameraID)
+		freeze.cancel()
+		freeze.cancel = nil
+	}
+}
+
+// resumeFreezeDetection restarts the camera's own freeze check once its detection chain
+// stops, unless the stream itself is stopping
+func resumeFreezeDetection(cameraID string) {
+	standaloneFreezesMu.Lock()
+	defer standaloneFreezesMu.Unlock()
+	if freeze, exists := standaloneFreezes[cameraID]; exists && !faceDetectionRunning(cameraID) {
+		freeze.run(cameraID)
+	}
+}
+
+// faceDetectionRunning reports whether a detection chain is registered for the camera
+func faceDetectionRunning(cameraID string) bool {
+	faceDetectionMutex.RLock()
+	defer faceDetectionMutex.RUnlock()
+	_, running := faceDetectionActive[cameraID]
+	return running
+}
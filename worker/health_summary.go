@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health summary statuses, best to worst
+const (
+	healthHealthy   = "healthy"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// healthRank orders the statuses so the overall status is the worst subsystem's
+var healthRank = map[string]int{healthHealthy: 0, healthDegraded: 1, healthUnhealthy: 2}
+
+// SubsystemHealth is one subsystem's entry in GET /health/summary
+type SubsystemHealth struct {
+	Status string `json:"status"`           // healthy | degraded | unhealthy
+	Detail string `json:"detail,omitempty"` // Why it isn't healthy, or what it's doing
+	Data   any    `json:"data,omitempty"`   // The underlying check's own report
+}
+
+// StreamHealthSummary is the streams entry's data
+type StreamHealthSummary struct {
+	Active         int      `json:"active"`
+	MaxStreams     int      `json:"maxStreams"`
+	UsedCapacity   int      `json:"usedCapacity"`
+	Stalled        int      `json:"stalled"` // No frame for STREAM_FRAME_STALL_THRESHOLD
+	StalledCameras []string `json:"stalledCameras"`
+	Frozen         int      `json:"frozen"` // Picture unchanged for FREEZE_DURATION
+	FrozenCameras  []string `json:"frozenCameras"`
+}
+
+// BreakerHealthSummary is the circuitBreakers entry's data
+type BreakerHealthSummary struct {
+	Tracked     int      `json:"tracked"`
+	Open        int      `json:"open"`
+	HalfOpen    int      `json:"halfOpen"`
+	OpenCameras []string `json:"openCameras"`
+}
+
+// HealthSummary is GET /health/summary: every subsystem's health and the worst of them
+type HealthSummary struct {
+	Status     string                     `json:"status"`
+	CheckedAt  time.Time                  `json:"checkedAt"`
+	Subsystems map[string]SubsystemHealth `json:"subsystems"`
+}
+
+// healthSummary runs the individual health checks, the slow ones (database ping,
+// MediaMTX request) in parallel. MediaMTX being unreachable makes the worker
+// unhealthy, since no stream can publish; anything else only degrades it.
+func healthSummary(ctx context.Context) HealthSummary {
+	summary := HealthSummary{
+		Status:     healthHealthy,
+		CheckedAt:  time.Now().UTC(),
+		Subsystems: make(map[string]SubsystemHealth),
+	}
+
+	var database, mediamtx SubsystemHealth
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		database = databaseHealth(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		mediamtx = mediamtxHealth()
+	}()
+
+	summary.Subsystems["kafka"] = kafkaHealth()
+	summary.Subsystems["faceDetection"] = faceDetectionHealth()
+	summary.Subsystems["streams"] = streamsHealth()
+	summary.Subsystems["circuitBreakers"] = circuitBreakersHealth()
+
+	wg.Wait()
+	summary.Subsystems["database"] = database
+	summary.Subsystems["mediamtx"] = mediamtx
+
+	for _, subsystem := range summary.Subsystems {
+		if healthRank[subsystem.Status] > healthRank[summary.Status] {
+			summary.Status = subsystem.Status
+		}
+	}
+	return summary
+}
+
+// databaseHealth pings the database, as /health/deps does
+func databaseHealth(ctx context.Context) SubsystemHealth {
+	if db == nil {
+		return SubsystemHealth{Status: healthHealthy, Detail: "not configured"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return SubsystemHealth{Status: healthDegraded, Detail: fmt.Sprintf("ping failed: %v", err)}
+	}
+	return SubsystemHealth{Status: healthHealthy}
+}
+
+// mediamtxHealth asks the default MediaMTX API for its paths, and reports the outage
+// monitor's view alongside
+func mediamtxHealth() SubsystemHealth {
+	outage := mediamtxOutage.Stats()
+	if !isMediaMTXHealthy() {
+		return SubsystemHealth{Status: healthUnhealthy, Detail: "MediaMTX API is not responding", Data: outage}
+	}
+	if outage.Deferred > 0 {
+		return SubsystemHealth{Status: healthDegraded, Detail: fmt.Sprintf("%d camera(s) waiting to be re-published", outage.Deferred), Data: outage}
+	}
+	return SubsystemHealth{Status: healthHealthy, Data: outage}
+}
+
+// kafkaHealth reports the producer's health check; streams keep running without it,
+// but alerts and events aren't published
+func kafkaHealth() SubsystemHealth {
+	snapshot := kafkaMetrics.Snapshot()
+	if !snapshot.Healthy {
+		detail := "Kafka producer is unhealthy"
+		if snapshot.LastError != "" {
+			detail += ": " + snapshot.LastError
+		}
+		return SubsystemHealth{Status: healthDegraded, Detail: detail, Data: snapshot}
+	}
+	return SubsystemHealth{Status: healthHealthy, Data: snapshot}
+}
+
+// faceDetectionHealth is degraded only when detection was requested but couldn't load;
+// detection that is switched off is healthy
+func faceDetectionHealth() SubsystemHealth {
+	capabilities := faceDetectionCapabilities()
+	health := SubsystemHealth{Status: healthHealthy, Data: capabilities}
+	switch {
+	case capabilities.FaceDetection:
+	case capabilities.Requested:
+		health.Status, health.Detail = healthDegraded, capabilities.Reason
+	default:
+		health.Detail = capabilities.Reason
+	}
+	return health
+}
+
+// streamsHealth counts running and stalled streams against the capacity
+func streamsHealth() SubsystemHealth {
+	snapshots := snapshotActiveStreams()
+	data := StreamHealthSummary{
+		Active:         len(snapshots),
+		MaxStreams:     currentWorkerConfig().MaxConcurrentStreams,
+		UsedCapacity:   usedCapacity(),
+		StalledCameras: []string{},
+		FrozenCameras:  []string{},
+	}
+	for _, snapshot := range snapshots {
+		if streamStalled(snapshot.LastFrameTime, snapshot.StartTime) {
+			data.StalledCameras = append(data.StalledCameras, snapshot.CameraID)
+		}
+	}
+	sort.Strings(data.StalledCameras)
+	data.Stalled = len(data.StalledCameras)
+	for _, frozen := range freezeTracker.Stats().Frozen {
+		data.FrozenCameras = append(data.FrozenCameras, frozen.CameraID)
+	}
+	data.Frozen = len(data.FrozenCameras)
+
+	health := SubsystemHealth{Status: healthHealthy, Data: data}
+	switch {
+	case data.Stalled > 0:
+		health.Status, health.Detail = healthDegraded, fmt.Sprintf("%d stream(s) stalled", data.Stalled)
+	case data.Frozen > 0:
+		health.Status, health.Detail = healthDegraded, fmt.Sprintf("%d stream(s) frozen", data.Frozen)
+	case data.UsedCapacity >= data.MaxStreams:
+		health.Status, health.Detail = healthDegraded, "at maximum capacity"
+	}
+	return health
+}
+
+// circuitBreakersHealth counts open breakers; half-open ones are already probing
+func circuitBreakersHealth() SubsystemHealth {
+	circuitBreakersMutex.RLock()
+	breakers := make(map[string]*CircuitBreaker, len(circuitBreakers))
+	for cameraID, cb := range circuitBreakers {
+		breakers[cameraID] = cb
+	}
+	circuitBreakersMutex.RUnlock()
+
+	data := BreakerHealthSummary{Tracked: len(breakers), OpenCameras: []string{}}
+	for cameraID, cb := range breakers {
+		switch cb.Snapshot().State {
+		case "open":
+			data.OpenCameras = append(data.OpenCameras, cameraID)
+		case "half-open":
+			data.HalfOpen++
+		}
+	}
+	sort.Strings(data.OpenCameras)
+	data.Open = len(data.OpenCameras)
+
+	if data.Open > 0 {
+		return SubsystemHealth{Status: healthDegraded, Detail: fmt.Sprintf("%d circuit breaker(s) open", data.Open), Data: data}
+	}
+	return SubsystemHealth{Status: healthHealthy, Data: data}
+}
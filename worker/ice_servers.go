@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ICEConfig holds the STUN/TURN servers used by the worker's own peer connections
+// (WHEP). TURN servers take either a static username and credential, or a shared
+// secret from which time-limited credentials are derived (the TURN REST API scheme
+// coturn implements with use-auth-secret), re-issued before they expire.
+type ICEConfig struct {
+	STUNURLs []string
+	TURNURLs []string
+
+	username   string // Static TURN credentials
+	credential string
+	secret     string        // Shared secret for time-limited credentials; wins over the static ones
+	ttl        time.Duration // Lifetime of a time-limited credential
+
+	issuedUsername   string
+	issuedCredential string
+	expiresAt        time.Time
+	rotations        uint64
+	mu               sync.Mutex
+}
+
+// loadICEConfig reads WEBRTC_ICE_SERVERS (comma-separated stun:/turn: URLs),
+// WEBRTC_TURN_USERNAME/WEBRTC_TURN_CREDENTIAL or WEBRTC_TURN_SECRET and
+// WEBRTC_TURN_CREDENTIAL_TTL
+func loadICEConfig() *ICEConfig {
+	config := &ICEConfig{
+		username:   os.Getenv("WEBRTC_TURN_USERNAME"),
+		credential: os.Getenv("WEBRTC_TURN_CREDENTIAL"),
+		secret:     os.Getenv("WEBRTC_TURN_SECRET"),
+		ttl:        getEnvDuration("WEBRTC_TURN_CREDENTIAL_TTL", 24*time.Hour),
+	}
+	if config.ttl < time.Minute {
+		config.ttl = time.Minute
+	}
+	for _, server := range strings.Split(os.Getenv("WEBRTC_ICE_SERVERS"), ",") {
+		server = strings.TrimSpace(server)
+		switch {
+		case server == "":
+		case strings.HasPrefix(server, "stun:"), strings.HasPrefix(server, "stuns:"):
+			config.STUNURLs = append(config.STUNURLs, server)
+		case strings.HasPrefix(server, "turn:"), strings.HasPrefix(server, "turns:"):
+			config.TURNURLs = append(config.TURNURLs, server)
+		default:
+			log.Printf("Ignoring ICE server %q: expected a stun:, stuns:, turn: or turns: URL", server)
+		}
+	}
+	if len(config.TURNURLs) > 0 && config.secret == "" && (config.username == "" || config.credential == "") {
+		log.Printf("TURN servers configured without WEBRTC_TURN_USERNAME/WEBRTC_TURN_CREDENTIAL or WEBRTC_TURN_SECRET, ignoring them")
+		config.TURNURLs = nil
+	}
+	return config
+}
+
+var iceConfig = &ICEConfig{}
+
+// ephemeral reports whether TURN credentials are derived from the shared secret
+func (c *ICEConfig) ephemeral() bool {
+	return c.secret != ""
+}
+
+// turnCredentials returns the TURN username and credential to use now. Time-limited
+// ones are re-issued once less than half their lifetime remains, so a connection
+// negotiated with them still has a usable credential for a while.
+func (c *ICEConfig) turnCredentials(now time.Time) (username, credential string, expiresAt time.Time) {
+	if !c.ephemeral() {
+		return c.username, c.credential, time.Time{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.issuedUsername == "" || c.expiresAt.Sub(now) < c.ttl/2 {
+		c.expiresAt = now.Add(c.ttl)
+		// Username is "<expiry unix time>[:<user>]", the credential its HMAC-SHA1 under the secret
+		c.issuedUsername = fmt.Sprintf("%d", c.expiresAt.Unix())
+		if c.username != "" {
+			c.issuedUsername += ":" + c.username
+		}
+		mac := hmac.New(sha1.New, []byte(c.secret))
+		mac.Write([]byte(c.issuedUsername))
+		c.issuedCredential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if c.rotations > 0 {
+			log.Printf("Rotated TURN credentials, now valid until %s", c.expiresAt.Format(time.RFC3339))
+		}
+		c.rotations++
+	}
+	return c.issuedUsername, c.issuedCredential, c.expiresAt
+}
+
+// Servers returns the ICE servers for a new peer connection
+func (c *ICEConfig) Servers() []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	if len(c.STUNURLs) > 0 {
+		servers = append(servers, webrtc.ICEServer{URLs: c.STUNURLs})
+	}
+	if len(c.TURNURLs) > 0 {
+		username, credential, _ := c.turnCredentials(time.Now())
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           c.TURNURLs,
+			Username:       username,
+			Credential:     credential,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	return servers
+}
+
+// ICEConfigView is the webrtc.ice entry of GET /config. Time-limited credentials are
+// included, since clients need them to reach the TURN server too; a static
+// credential is not.
+type ICEConfigView struct {
+	ICEServers []webrtc.ICEServer `json:"iceServers"`
+	// CredentialMode is "none", "static" or "ephemeral"
+	CredentialMode string     `json:"credentialMode"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	Rotations      uint64     `json:"rotations,omitempty"`
+}
+
+// View returns the effective ICE configuration
+func (c *ICEConfig) View() ICEConfigView {
+	view := ICEConfigView{ICEServers: []webrtc.ICEServer{}, CredentialMode: "none"}
+	if len(c.STUNURLs) > 0 {
+		view.ICEServers = append(view.ICEServers, webrtc.ICEServer{URLs: c.STUNURLs})
+	}
+	if len(c.TURNURLs) == 0 {
+		return view
+	}
+
+	username, credential, expiresAt := c.turnCredentials(time.Now())
+	turn := webrtc.ICEServer{URLs: c.TURNURLs, Username: username}
+	if c.ephemeral() {
+		view.CredentialMode = "ephemeral"
+		turn.Credential = credential
+		view.ExpiresAt = &expiresAt
+		c.mu.Lock()
+		view.Rotations = c.rotations
+		c.mu.Unlock()
+	} else {
+		view.CredentialMode = "static"
+	}
+	view.ICEServers = append(view.ICEServers, turn)
+	return view
+}
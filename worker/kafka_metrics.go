@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaLatencyBuckets are the upper bounds, in seconds, of the publish latency histogram
+var kafkaLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// KafkaMetrics counts alert publishes and tracks broker connectivity. It is global
+// rather than per producer so a failed startup connection is still reported.
+type KafkaMetrics struct {
+	mu             sync.Mutex
+	published      uint64
+	publishErrors  uint64
+	retries        uint64   // Writer retries, collected on each health check
+	latencyBuckets []uint64 // Non-cumulative counts per kafkaLatencyBuckets bound, plus +Inf
+	latencySum     float64
+	latencyCount   uint64
+	healthy        bool
+	lastCheck      time.Time
+	lastError      string
+}
+
+var kafkaMetrics = &KafkaMetrics{latencyBuckets: make([]uint64, len(kafkaLatencyBuckets)+1)}
+
+// ObservePublish records one PublishAlert call; latency is zero when the alert
+// failed before reaching the writer
+func (m *KafkaMetrics) ObservePublish(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.publishErrors++
+	} else {
+		m.published++
+	}
+	if latency <= 0 {
+		return
+	}
+
+	seconds := latency.Seconds()
+	bucket := len(kafkaLatencyBuckets)
+	for i, bound := range kafkaLatencyBuckets {
+		if seconds <= bound {
+			bucket = i
+			break
+		}
+	}
+	m.latencyBuckets[bucket]++
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// SetHealth records the outcome of a connectivity check
+func (m *KafkaMetrics) SetHealth(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.healthy = err == nil
+	m.lastCheck = time.Now()
+	m.lastError = ""
+	if err != nil {
+		m.lastError = err.Error()
+	}
+}
+
+func (m *KafkaMetrics) addRetries(retries int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries += uint64(retries)
+}
+
+// KafkaHealth is the Kafka entry of /health/deps and /metrics
+type KafkaHealth struct {
+	Healthy          bool       `json:"healthy"`
+	LastCheck        *time.Time `json:"lastCheck,omitempty"`
+	LastError        string     `json:"lastError,omitempty"`
+	Published        uint64     `json:"alertsPublished"`
+	PublishErrors    uint64     `json:"alertPublishErrors"`
+	Retries          uint64     `json:"retries"`
+	AvgLatencyMillis float64    `json:"avgLatencyMs"`
+}
+
+// Snapshot returns the current counters and health
+func (m *KafkaMetrics) Snapshot() KafkaHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	health := KafkaHealth{
+		Healthy:       m.healthy,
+		LastError:     m.lastError,
+		Published:     m.published,
+		PublishErrors: m.publishErrors,
+		Retries:       m.retries,
+	}
+	if !m.lastCheck.IsZero() {
+		lastCheck := m.lastCheck
+		health.LastCheck = &lastCheck
+	}
+	if m.latencyCount > 0 {
+		health.AvgLatencyMillis = 1000 * m.latencySum / float64(m.latencyCount)
+	}
+	return health
+}
+
+// Prometheus renders the counters, histogram and kafka_healthy gauge in the text format
+func (m *KafkaMetrics) Prometheus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP alerts_published_total Face detection alerts written to Kafka.\n")
+	b.WriteString("# TYPE alerts_published_total counter\n")
+	fmt.Fprintf(&b, "alerts_published_total %d\n", m.published)
+	b.WriteString("# HELP alert_publish_errors_total Face detection alerts that failed to serialize or write to Kafka.\n")
+	b.WriteString("# TYPE alert_publish_errors_total counter\n")
+	fmt.Fprintf(&b, "alert_publish_errors_total %d\n", m.publishErrors)
+	b.WriteString("# HELP kafka_writer_retries_total Retried Kafka write attempts.\n")
+	b.WriteString("# TYPE kafka_writer_retries_total counter\n")
+	fmt.Fprintf(&b, "kafka_writer_retries_total %d\n", m.retries)
+
+	b.WriteString("# HELP alert_publish_latency_seconds Time taken to write an alert to Kafka.\n")
+	b.WriteString("# TYPE alert_publish_latency_seconds histogram\n")
+	var cumulative uint64
+	for i, bound := range kafkaLatencyBuckets {
+		cumulative += m.latencyBuckets[i]
+		fmt.Fprintf(&b, "alert_publish_latency_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(&b, "alert_publish_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(&b, "alert_publish_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(&b, "alert_publish_latency_seconds_count %d\n", m.latencyCount)
+
+	healthy := 0
+	if m.healthy {
+		healthy = 1
+	}
+	b.WriteString("# HELP kafka_healthy Whether the last Kafka connectivity check succeeded.\n")
+	b.WriteString("# TYPE kafka_healthy gauge\n")
+	fmt.Fprintf(&b, "kafka_healthy %d\n", healthy)
+	return b.String()
+}
+
+// checkKafkaConnection dials the topic's partition 0 leader, as NewKafkaProducer does
+func checkKafkaConnection(brokers, topic string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := kafka.DialLeader(ctx, "tcp", brokers, topic, 0)
+	if err != nil {
+		return fmt.Errorf("failed to connect to kafka: %w", err)
+	}
+	return conn.Close()
+}
+
+// runHealthChecks re-checks broker connectivity every KAFKA_HEALTH_CHECK_INTERVAL
+// until the producer is closed, so a lost broker shows up before alerts are dropped
+func (kp *KafkaProducer) runHealthChecks(brokers string) {
+	interval := getEnvDuration("KAFKA_HEALTH_CHECK_INTERVAL", 30*time.Second)
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	wasHealthy := true
+	for {
+		select {
+		case <-kp.stopHealth:
+			return
+		case <-ticker.C:
+		}
+
+		err := checkKafkaConnection(brokers, kp.topic, 5*time.Second)
+		kafkaMetrics.SetHealth(err)
+		kafkaMetrics.addRetries(kp.writer.Stats().Retries)
+		if err != nil && wasHealthy {
+			log.Printf("Kafka health check failed: %v", err)
+		} else if err == nil && !wasHealthy {
+			log.Printf("Kafka connection restored (brokers: %s)", brokers)
+		}
+		wasHealthy = err == nil
+	}
+}
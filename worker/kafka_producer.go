@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
 	"fmt"
 	"log"
 	"os"
@@ -14,19 +14,27 @@ import (
 
 // KafkaProducer wraps kafka-go writer
 type KafkaProducer struct {
-	writer *kafka.Writer
-	topic  string
+	writer     *kafka.Writer
+	topic      string
+	serializer AlertSerializer
+	stopHealth chan struct{} // Closed by Close to stop the periodic connectivity check
 }
 
 // FaceDetectionAlert represents a face detection event
 type FaceDetectionAlert struct {
+	EventID    string                 `json:"eventId"` // unique per alert; consumers dedupe retried deliveries on it
+	TenantID   string                 `json:"tenantId,omitempty"`
+	SiteID     string                 `json:"siteId,omitempty"`
 	CameraID   string                 `json:"cameraId"`
 	CameraName string                 `json:"cameraName"`
 	FaceCount  int                    `json:"faceCount"`
 	Confidence float64                `json:"confidence"`
-	ImageData  string                 `json:"imageData"` // base64 encoded thumbnail
-	DetectedAt time.Time              `json:"detectedAt"`
-	Metadata   map[string]interface{} `json:"metadata"` // bounding boxes, etc.
+	ImageData  string                 `json:"imageData"`          // base64 encoded thumbnail
+	DetectedAt time.Time              `json:"detectedAt"`         // UTC
+	LocalTime  string                 `json:"localTime"`          // detectedAt in the camera's timezone, RFC 3339 with offset
+	Timezone   string                 `json:"timezone"`           // IANA name, "UTC" when the camera has none
+	Metadata   map[string]interface{} `json:"metadata"`           // bounding boxes, etc.
+	ClipPath   string                 `json:"clipPath,omitempty"` // set when a recording clip is being exported
 }
 
 // NewKafkaProducer creates a new Kafka producer
@@ -41,6 +49,11 @@ func NewKafkaProducer(topic string) (*KafkaProducer, error) {
 		brokers = "localhost:9092"
 	}
 
+	serializer, err := NewAlertSerializer(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure alert serialization: %w", err)
+	}
+
 	writer := &kafka.Writer{
 		Addr:         kafka.TCP(brokers),
 		Topic:        topic,
@@ -53,47 +66,80 @@ func NewKafkaProducer(topic string) (*KafkaProducer, error) {
 	}
 
 	// Test connection
-	conn, err := kafka.DialLeader(context.Background(), "tcp", brokers, topic, 0)
+	err = checkKafkaConnection(brokers, topic, 10*time.Second)
+	kafkaMetrics.SetHealth(err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to kafka: %w", err)
+		return nil, err
 	}
-	conn.Close()
 
 	log.Printf("Kafka producer initialized for topic '%s' with brokers: %s", topic, brokers)
 
-	return &KafkaProducer{
-		writer: writer,
-		topic:  topic,
-	}, nil
+	producer := &KafkaProducer{
+		writer:     writer,
+		topic:      topic,
+		serializer: serializer,
+		stopHealth: make(chan struct{}),
+	}
+	go producer.runHealthChecks(brokers)
+	return producer, nil
 }
 
 // PublishAlert sends a face detection alert to Kafka
 func (kp *KafkaProducer) PublishAlert(alert FaceDetectionAlert) error {
-	alertJSON, err := json.Marshal(alert)
+	if alert.EventID == "" {
+		alert.EventID = newEventID()
+	}
+
+	alertValue, err := kp.serializer.Serialize(alert)
 	if err != nil {
-		return fmt.Errorf("failed to marshal alert: %w", err)
+		kafkaMetrics.ObservePublish(0, err)
+		return fmt.Errorf("failed to serialize alert: %w", err)
 	}
 
 	message := kafka.Message{
 		Key:   []byte(alert.CameraID), // Use cameraId as key for partitioning
-		Value: alertJSON,
+		Value: alertValue,
 		Time:  alert.DetectedAt,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte(kp.serializer.ContentType())},
+			{Key: "event-id", Value: []byte(alert.EventID)},
+		},
+	}
+	if alert.TenantID != "" {
+		message.Headers = append(message.Headers, kafka.Header{Key: "tenant-id", Value: []byte(alert.TenantID)})
+	}
+	if alert.SiteID != "" {
+		message.Headers = append(message.Headers, kafka.Header{Key: "site-id", Value: []byte(alert.SiteID)})
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	start := time.Now()
 	err = kp.writer.WriteMessages(ctx, message)
+	kafkaMetrics.ObservePublish(time.Since(start), err)
 	if err != nil {
 		return fmt.Errorf("failed to write message to kafka: %w", err)
 	}
 
-	log.Printf("Published face detection alert to Kafka: camera=%s, faces=%d", alert.CameraID, alert.FaceCount)
+	log.Printf("Published face detection alert to Kafka: camera=%s, faces=%d, event=%s", alert.CameraID, alert.FaceCount, alert.EventID)
 	return nil
 }
 
+// newEventID returns a random (version 4) UUID
+func newEventID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 // Close closes the Kafka producer
 func (kp *KafkaProducer) Close() error {
+	if kp.stopHealth != nil {
+		close(kp.stopHealth)
+	}
 	if kp.writer != nil {
 		return kp.writer.Close()
 	}
@@ -5,12 +5,19 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,8 +31,8 @@ import (
 
 // WebRTCOfferRequest represents the incoming WebRTC offer request
 type WebRTCOfferRequest struct {
-	CameraID string `json:"cameraId" binding:"required"`
-	RTSPURL  string `json:"rtspUrl" binding:"required"`
+	CameraID string `json:"cameraId" binding:"required,cameraid"`
+	RTSPURL  string `json:"rtspUrl" binding:"required,rtspurl"`
 }
 
 // WebRTCOfferResponse represents the response with the answer
@@ -34,6 +41,8 @@ type WebRTCOfferResponse struct {
 	SessionID string `json:"sessionId"`
 	Status    string `json:"status"`
 	Error     string `json:"error,omitempty"`
+	// WebRTCURL is where the camera plays on the MediaMTX instance it publishes to
+	WebRTCURL string `json:"webrtcUrl,omitempty"`
 }
 
 // ReencodingProcess represents an active re-encoding process
@@ -44,6 +53,25 @@ type ReencodingProcess struct {
 	Context   context.Context
 	Cancel    context.CancelFunc
 	Command   *exec.Cmd
+	Options   StreamOptions // Reused on auto-restart
+	Output    OutputTarget
+	Store     CameraStore // Where the camera's state is recorded; restarts reuse it
+	StartedAt time.Time
+	// AudioMuted is set when the audio mute schedule dropped audio at start
+	AudioMuted bool
+	// VideoMode is whether FFmpeg transcodes or copies the video, and why
+	VideoMode VideoModeDecision
+	// CPUAffinity is the CPUs FFmpeg was pinned to, nil when it wasn't
+	CPUAffinity []int
+	// StopReason is the camera status to record once a deliberate stop completes
+	StopReason string
+	// ReleaseSource frees the source connection slot; safe to call more than once
+	ReleaseSource func()
+	// Exited is closed once FFmpeg has exited, and TornDown once the process monitor has
+	// cleaned up after it, including the MediaMTX path of a deliberate stop. The monitor
+	// is the command's only waiter; a second Wait would return at once.
+	Exited   <-chan struct{}
+	TornDown <-chan struct{}
 }
 
 // WorkerConfig holds configuration for the worker service
@@ -51,36 +79,79 @@ type WorkerConfig struct {
 	MaxConcurrentStreams int
 	MaxMemoryMB          int
 	MaxCPUPercent        int
+	// Defaults for circuit breakers created after the config is (re)loaded
+	CircuitBreakerMaxFailures  int
+	CircuitBreakerResetTimeout time.Duration
+	// New cameras' failures don't count towards MaxFailures for this long; see breakerWarmup
+	CircuitBreakerWarmup time.Duration
+	// What /process does at MaxConcurrentStreams; see capacity_queue.go
+	CapacityMode          string
+	CapacityQueueTimeout  time.Duration
+	CapacityQueueMaxDepth int // 0 = unbounded
+}
+
+// loadWorkerConfig reads MAX_CONCURRENT_STREAMS, CIRCUIT_BREAKER_* and the capacity
+// queue settings from the environment
+func loadWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		MaxConcurrentStreams:       getEnvInt("MAX_CONCURRENT_STREAMS", 20),
+		MaxMemoryMB:                4096,
+		MaxCPUPercent:              80,
+		CircuitBreakerMaxFailures:  getEnvInt("CIRCUIT_BREAKER_MAX_FAILURES", 10),
+		CircuitBreakerResetTimeout: getEnvDuration("CIRCUIT_BREAKER_RESET_TIMEOUT", time.Minute),
+		CircuitBreakerWarmup:       getEnvDuration("CIRCUIT_BREAKER_WARMUP", 2*time.Minute),
+		CapacityMode:               capacityModeFromEnv(),
+		CapacityQueueTimeout:       getEnvDuration("STREAM_QUEUE_TIMEOUT", 30*time.Second),
+		CapacityQueueMaxDepth:      getEnvInt("STREAM_QUEUE_MAX_DEPTH", 50),
+	}
+}
+
+// currentWorkerConfig returns the worker config; /reload may replace it at any time
+func currentWorkerConfig() WorkerConfig {
+	workerConfigMutex.RLock()
+	defer workerConfigMutex.RUnlock()
+	return workerConfig
 }
 
 // StreamMetrics tracks metrics for a single stream
 type StreamMetrics struct {
 	CameraID        string
 	StartTime       time.Time
-	BytesProcessed  uint64
+	BytesProcessed  uint64 // Output bytes FFmpeg has written this run (progress total_size)
 	FramesProcessed uint64
-	LastFrameTime   time.Time
+	LastFrameTime   time.Time // When FFmpeg last reported a new frame; zero before the first
 	ErrorCount      int
+	// RestartReasons counts FFmpeg failures by reason over all of the camera's runs
+	RestartReasons map[string]uint64
+	// Latency is estimated from FFmpeg's progress reports; nil until the first frame
+	Latency *FFmpegLatencyEstimate
 }
 
 // CircuitBreaker implements circuit breaker pattern for stream failures
 type CircuitBreaker struct {
 	CameraID        string
 	FailureCount    int
+	WarmupFailures  int // Failures during the warm-up window, not counted in FailureCount
 	LastFailureTime time.Time
 	State           string // "closed", "open", "half-open"
 	MaxFailures     int
 	ResetTimeout    time.Duration
+	probeInFlight   bool      // A half-open probe attempt is outstanding
+	probeStartedAt  time.Time // When the outstanding probe was granted
+	lastUsed        time.Time // Last stream start for the camera; idle closed breakers are pruned
+	warmupUntil     time.Time // Zero once the camera has started successfully
 	mu              sync.RWMutex
 }
 
 // NewCircuitBreaker creates a new circuit breaker
 func NewCircuitBreaker(cameraID string) *CircuitBreaker {
+	config := currentWorkerConfig()
 	return &CircuitBreaker{
 		CameraID:     cameraID,
 		State:        "closed",
-		MaxFailures:  10,              // Increased from 3 to 10 for better tolerance
-		ResetTimeout: 1 * time.Minute, // Reduced from 5min to 1min for faster recovery
+		MaxFailures:  config.CircuitBreakerMaxFailures,  // Default 10 for better tolerance
+		ResetTimeout: config.CircuitBreakerResetTimeout, // Default 1min for faster recovery
+		lastUsed:     time.Now(),
 	}
 }
 
@@ -89,9 +160,27 @@ func (cb *CircuitBreaker) RecordFailure() {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	cb.FailureCount++
 	cb.LastFailureTime = time.Now()
 
+	// A camera still booting (e.g. a PoE camera after a power cycle) fails its first
+	// attempts; don't let those open the breaker before it's ever come up
+	if cb.State == "closed" && cb.LastFailureTime.Before(cb.warmupUntil) {
+		cb.WarmupFailures++
+		log.Printf("Circuit breaker ignoring failure %d of camera %s during its warm-up (until %s)",
+			cb.WarmupFailures, cb.CameraID, cb.warmupUntil.Format(time.RFC3339))
+		return
+	}
+
+	cb.FailureCount++
+
+	// A failed half-open probe reopens the breaker immediately
+	if cb.State == "half-open" {
+		cb.State = "open"
+		cb.probeInFlight = false
+		log.Printf("Circuit breaker probe failed for camera %s, reopening", cb.CameraID)
+		return
+	}
+
 	if cb.FailureCount >= cb.MaxFailures {
 		cb.State = "open"
 		log.Printf("Circuit breaker opened for camera %s after %d failures", cb.CameraID, cb.FailureCount)
@@ -105,9 +194,27 @@ func (cb *CircuitBreaker) RecordSuccess() {
 
 	cb.FailureCount = 0
 	cb.State = "closed"
+	cb.probeInFlight = false
+	cb.warmupUntil = time.Time{} // The camera is up; later failures count
+	cb.WarmupFailures = 0
 }
 
-// CanAttempt checks if an attempt can be made
+// breakerWarmup resolves the camera's warm-up window: the per-camera option wins, 0
+// falls back to CIRCUIT_BREAKER_WARMUP, and a negative value disables it
+func breakerWarmup(options StreamOptions) time.Duration {
+	switch {
+	case options.BreakerWarmupSeconds < 0:
+		return 0
+	case options.BreakerWarmupSeconds > 0:
+		return time.Duration(options.BreakerWarmupSeconds) * time.Second
+	default:
+		return currentWorkerConfig().CircuitBreakerWarmup
+	}
+}
+
+// CanAttempt checks if an attempt can be made. While half-open exactly one
+// probe is allowed through; it must be resolved with RecordSuccess or
+// RecordFailure before another attempt is granted.
 func (cb *CircuitBreaker) CanAttempt() bool {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
@@ -116,9 +223,22 @@ func (cb *CircuitBreaker) CanAttempt() bool {
 		return true
 	}
 
+	if cb.State == "half-open" {
+		// Don't let a probe that never reported back wedge the breaker forever
+		if cb.probeInFlight && time.Since(cb.probeStartedAt) <= cb.ResetTimeout {
+			return false
+		}
+		cb.probeInFlight = true
+		cb.probeStartedAt = time.Now()
+		log.Printf("Circuit breaker granting new probe for camera %s", cb.CameraID)
+		return true
+	}
+
 	// Check if reset timeout has elapsed
 	if time.Since(cb.LastFailureTime) > cb.ResetTimeout {
 		cb.State = "half-open"
+		cb.probeInFlight = true
+		cb.probeStartedAt = time.Now()
 		log.Printf("Circuit breaker half-open for camera %s, allowing retry", cb.CameraID)
 		return true
 	}
@@ -126,24 +246,129 @@ func (cb *CircuitBreaker) CanAttempt() bool {
 	return false
 }
 
-// Global map to track active re-encoding processes
+// ReleaseProbe hands back a half-open probe that never reached the camera, e.g. one
+// refused locally, so the next attempt is granted a probe right away
+func (cb *CircuitBreaker) ReleaseProbe() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probeInFlight = false
+}
+
+// CircuitBreakerState is a point-in-time view of a breaker
+type CircuitBreakerState struct {
+	State           string     `json:"state"`
+	FailureCount    int        `json:"failureCount"`
+	LastFailureTime *time.Time `json:"lastFailureTime,omitempty"`
+	ProbeInFlight   bool       `json:"probeInFlight"`
+	WarmupUntil     *time.Time `json:"warmupUntil,omitempty"` // While in the future, failures aren't counted
+	WarmupFailures  int        `json:"warmupFailures,omitempty"`
+}
+
+// Snapshot returns the breaker's current state
+func (cb *CircuitBreaker) Snapshot() CircuitBreakerState {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	state := CircuitBreakerState{
+		State:          cb.State,
+		FailureCount:   cb.FailureCount,
+		ProbeInFlight:  cb.probeInFlight,
+		WarmupFailures: cb.WarmupFailures,
+	}
+	if !cb.LastFailureTime.IsZero() {
+		lastFailure := cb.LastFailureTime
+		state.LastFailureTime = &lastFailure
+	}
+	if time.Now().Before(cb.warmupUntil) {
+		warmupUntil := cb.warmupUntil
+		state.WarmupUntil = &warmupUntil
+	}
+	return state
+}
+
+// WouldAllow reports whether CanAttempt could currently succeed, without
+// transitioning state or claiming the half-open probe
+func (cb *CircuitBreaker) WouldAllow() bool {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	switch cb.State {
+	case "closed":
+		return true
+	case "half-open":
+		return !cb.probeInFlight || time.Since(cb.probeStartedAt) > cb.ResetTimeout
+	default:
+		return time.Since(cb.LastFailureTime) > cb.ResetTimeout
+	}
+}
+
+// CircuitBreakerEntry is one camera's breaker in GET /circuit-breakers
+type CircuitBreakerEntry struct {
+	CameraID string `json:"cameraId"`
+	CircuitBreakerState
+}
+
+// circuitBreakerStates returns a snapshot of every breaker, sorted by camera ID, or only
+// those in state when it is set
+func circuitBreakerStates(state string) []CircuitBreakerEntry {
+	circuitBreakersMutex.RLock()
+	breakers := make([]*CircuitBreaker, 0, len(circuitBreakers))
+	for _, cb := range circuitBreakers {
+		breakers = append(breakers, cb)
+	}
+	circuitBreakersMutex.RUnlock()
+
+	entries := make([]CircuitBreakerEntry, 0, len(breakers))
+	for _, cb := range breakers {
+		snapshot := cb.Snapshot()
+		if state == "" || snapshot.State == state {
+			entries = append(entries, CircuitBreakerEntry{CameraID: cb.CameraID, CircuitBreakerState: snapshot})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CameraID < entries[j].CameraID })
+	return entries
+}
+
+// Global map to track active re-encoding processes.
+//
+// Lock order: processMutex, then streamMetricsMutex, then faceDetectionMutex, then
+// circuitBreakersMutex. A goroutine holding one of these may only take those after it,
+// so a stop racing a start or a face detection toggle can't deadlock. The leaf locks
+// (hubs, limiters, per-breaker mutexes) never take any of them.
 var (
 	activeProcesses = make(map[string]*ReencodingProcess)
 	processMutex    = sync.RWMutex{}
 	db              *sql.DB
+	dbConfig        = loadDBConfig()
+	cameraStore     = CameraStore(NewSQLCameraStore(nil, 0))
 	workerConfig    = WorkerConfig{
-		MaxConcurrentStreams: 20, // Default to 20 concurrent streams
-		MaxMemoryMB:          4096,
-		MaxCPUPercent:        80,
+		MaxConcurrentStreams:       20, // Default to 20 concurrent streams
+		MaxMemoryMB:                4096,
+		MaxCPUPercent:              80,
+		CircuitBreakerMaxFailures:  10,
+		CircuitBreakerResetTimeout: time.Minute,
+		CircuitBreakerWarmup:       2 * time.Minute,
+		CapacityMode:               capacityModeReject,
 	}
+	workerConfigMutex    = sync.RWMutex{}
 	streamMetrics        = make(map[string]*StreamMetrics)
 	streamMetricsMutex   = sync.RWMutex{}
 	circuitBreakers      = make(map[string]*CircuitBreaker)
 	circuitBreakersMutex = sync.RWMutex{}
 	kafkaProducer        *KafkaProducer
 	faceDetector         *FaceDetector
+	objectDetector       *ObjectDetector
 	faceDetectionActive  = make(map[string]context.CancelFunc) // Track active face detection goroutines
 	faceDetectionMutex   = sync.RWMutex{}
+	faceDetectionStats   = NewFaceDetectionStats()
+	detectionMetadata    = NewDetectionMetadataHub()
+	restartLimiter       = NewRestartLimiter(defaultRestartRate, defaultRestartBurst) // Paces auto-restarts fleet-wide
+	sourceConnections    = NewSourceConnectionLimiter(0)                              // Caps connections opened to each camera
+	timingConfig         = loadTimingConfig()
+	streamEvents         = NewStreamEventBus()
+	recordingConfig      = RecordingConfig{}
+	clipExporter         = NewClipExporter(recordingConfig)
+	mediamtxAuth         = MediaMTXAuthProvider(&basicAuthProvider{username: "admin", password: "admin"})
 )
 
 // RetryConfig holds configuration for retry operations
@@ -151,6 +376,24 @@ type RetryConfig struct {
 	MaxAttempts int
 	BaseDelay   time.Duration
 	MaxDelay    time.Duration
+	Jitter      float64 // Spreads each sleep by up to ±this fraction (0-1); 0 sleeps the exact delay
+}
+
+// jitterDelay spreads delay randomly by up to ±fraction, so callers retrying after the
+// same outage don't stay in lockstep
+func jitterDelay(delay time.Duration, fraction float64) time.Duration {
+	fraction = min(max(fraction, 0), 1)
+	if fraction == 0 {
+		return delay
+	}
+	return delay + time.Duration(float64(delay)*fraction*(2*rand.Float64()-1))
+}
+
+// restartBackoff is base doubled per failure, capped at maxDelay. The shift is clamped
+// first: warm-up failures keep counting, and an unclamped 1<<failures overflows into
+// zero or negative delays.
+func restartBackoff(failures int, base, maxDelay time.Duration) time.Duration {
+	return min(time.Duration(1)<<uint(min(max(failures, 0), 30))*base, maxDelay)
 }
 
 // RetryOperation performs an operation with exponential backoff retry
@@ -175,9 +418,11 @@ func RetryOperation(operation func() error, config RetryConfig, operationName st
 			break
 		}
 
-		// Sleep with exponential backoff
-		log.Printf("Retrying '%s' in %v...", operationName, delay)
-		time.Sleep(delay)
+		// Sleep with exponential backoff; the jitter only moves this sleep, not the
+		// delays after it
+		sleep := jitterDelay(delay, config.Jitter)
+		log.Printf("Retrying '%s' in %v...", operationName, sleep)
+		time.Sleep(sleep)
 
 		// Double the delay for next attempt, up to max(Exponential Backoff)
 		delay *= 2
@@ -204,15 +449,20 @@ func initDatabase() {
 		log.Printf("Failed to connect to database: %v", err)
 		return
 	}
+	dbConfig = loadDBConfig()
+	dbConfig.apply(db)
 
 	// Test the connection
-	if err := db.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
 		log.Printf("Failed to ping database: %v", err)
 		db = nil
 		return
 	}
 
-	log.Println("Database connection established successfully")
+	log.Printf("Database connection established successfully (max open conns: %d, query timeout: %v)",
+		dbConfig.MaxOpenConns, dbConfig.QueryTimeout)
 }
 
 // waitForMediaMTXReady waits for MediaMTX API to become available with retry logic
@@ -235,7 +485,7 @@ func waitForMediaMTXReady(maxWaitTime time.Duration) error {
 			return fmt.Errorf("timeout waiting for MediaMTX API after %v", maxWaitTime)
 		case <-ticker.C:
 			client := &http.Client{Timeout: 3 * time.Second}
-			resp, err := client.Get(mediamtxAPIURL + "/v3/paths/list")
+			resp, err := mediamtxGet(client, mediamtxAPIURL+"/v3/paths/list")
 			if err != nil {
 				log.Printf("MediaMTX API not ready yet: %v", err)
 				continue
@@ -259,7 +509,7 @@ func isMediaMTXHealthy() bool {
 	}
 
 	client := &http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(mediamtxAPIURL + "/v3/paths/list")
+	resp, err := mediamtxGet(client, mediamtxAPIURL+"/v3/paths/list")
 	if err != nil {
 		return false
 	}
@@ -268,66 +518,9 @@ func isMediaMTXHealthy() bool {
 	return resp.StatusCode == http.StatusOK
 }
 
-// updateCameraPathInfo stores MediaMTX path information in the database
-func updateCameraPathInfo(cameraID, pathName string, configured bool) {
-	if db == nil {
-		return // Database not available
-	}
-
-	var lastProcessedAt interface{}
-	if configured {
-		lastProcessedAt = time.Now()
-	}
-
-	query := `
-		UPDATE cameras
-		SET "mediamtxPath" = $1,
-		    "mediamtxConfigured" = $2,
-		    "lastProcessedAt" = $3,
-		    status = $4
-		WHERE id = $5
-	`
-
-	status := "PROCESSING"
-	if !configured {
-		status = "OFFLINE"
-		lastProcessedAt = nil
-	}
-
-	_, err := db.Exec(query, pathName, configured, lastProcessedAt, status, cameraID)
-	if err != nil {
-		log.Printf("Failed to update camera path info: %v", err)
-	} else {
-		log.Printf("Updated database: camera %s, path %s, configured: %t", cameraID, pathName, configured)
-	}
-}
-
-// getCameraInfo retrieves camera information from database
-func getCameraInfo(cameraID string) (rtspURL, pathName string, configured bool, err error) {
-	if db == nil {
-		return "", "", false, fmt.Errorf("database not available")
-	}
-
-	query := `
-		SELECT "rtspUrl", "mediamtxPath", "mediamtxConfigured"
-		FROM cameras
-		WHERE id = $1
-	`
-
-	var dbRtspURL, dbPathName sql.NullString
-	var dbConfigured sql.NullBool
-
-	err = db.QueryRow(query, cameraID).Scan(&dbRtspURL, &dbPathName, &dbConfigured)
-	if err != nil {
-		return "", "", false, err
-	}
-
-	return dbRtspURL.String, dbPathName.String, dbConfigured.Bool, nil
-}
-
 // restoreActivePaths restores MediaMTX paths for cameras that were processing before restart
-func restoreActivePaths() {
-	if db == nil {
+func restoreActivePaths(store CameraStore) {
+	if !store.Available() {
 		log.Println("Database not available, skipping path restoration")
 		return
 	}
@@ -338,42 +531,16 @@ func restoreActivePaths() {
 		log.Printf("MediaMTX not ready after waiting: %v", err)
 		log.Println("Will retry path restoration later...")
 		// Schedule retry after 30 seconds
-		time.AfterFunc(30*time.Second, restoreActivePaths)
+		time.AfterFunc(30*time.Second, func() { restoreActivePaths(store) })
 		return
 	}
 
 	// Query cameras that need restoration
-	// Include both actively processing cameras AND cameras with configured paths
-	query := `
-		SELECT id, "rtspUrl", "mediamtxPath", enabled, status
-		FROM cameras
-		WHERE "mediamtxConfigured" = true
-	`
-
-	rows, err := db.Query(query)
+	camerasToRestore, err := store.ListConfiguredCameras()
 	if err != nil {
 		log.Printf("Failed to query cameras for restoration: %v", err)
 		return
 	}
-	defer rows.Close()
-
-	type CameraToRestore struct {
-		ID       string
-		RTSPURL  string
-		PathName string
-		Enabled  bool
-		Status   string
-	}
-
-	camerasToRestore := []CameraToRestore{}
-	for rows.Next() {
-		var camera CameraToRestore
-		if err := rows.Scan(&camera.ID, &camera.RTSPURL, &camera.PathName, &camera.Enabled, &camera.Status); err != nil {
-			log.Printf("Failed to scan camera row: %v", err)
-			continue
-		}
-		camerasToRestore = append(camerasToRestore, camera)
-	}
 
 	if len(camerasToRestore) == 0 {
 		log.Println("No camera paths to restore")
@@ -393,21 +560,23 @@ func restoreActivePaths() {
 		if camera.Enabled && camera.Status == "PROCESSING" {
 			log.Printf("Restoring active stream for camera %s", camera.ID)
 
-			// Use retry logic for restoration
+			// Use retry logic for restoration, jittered since every restored camera
+			// retries at once when MediaMTX is slow to come back
 			retryConfig := RetryConfig{
 				MaxAttempts: 3,
 				BaseDelay:   2 * time.Second,
 				MaxDelay:    10 * time.Second,
+				Jitter:      0.2,
 			}
 
 			err := RetryOperation(func() error {
-				return startReencodingProcess(camera.ID, camera.RTSPURL)
+				return startReencodingProcess(store, camera.ID, camera.RTSPURL, loadStreamOptions(store, camera.ID))
 			}, retryConfig, fmt.Sprintf("restore camera %s", camera.ID))
 
 			if err != nil {
 				log.Printf("Failed to restore camera %s after retries: %v", camera.ID, err)
 				// Update status to ERROR
-				updateCameraPathInfo(camera.ID, camera.PathName, false)
+				store.UpdateCameraPathInfo(camera.ID, camera.PathName, false)
 				continue
 			}
 
@@ -442,6 +611,43 @@ func main() {
 	// Initialize database connection
 	log.Println("Initializing database connection...")
 	initDatabase()
+	cameraStore = NewSQLCameraStore(db, dbConfig.QueryTimeout)
+	detectionStore = NewDetectionStoreFromEnv(db, dbConfig.QueryTimeout)
+
+	workerConfig = loadWorkerConfig()
+	restartLimiter = NewRestartLimiterFromEnv()
+	sourceConnections = NewSourceConnectionLimiterFromEnv()
+	timingConfig = loadTimingConfig()
+	credentialCipher = loadCredentialCipher()
+	startConfirmConfig = loadStartConfirmConfig()
+	pathPrefix = pathPrefixFromEnv()
+	frameDistribution = loadFrameDistributionConfig()
+	streamManagerRetry = loadRTSPRetryPolicy()
+	mediamtxAuth = newMediaMTXAuthFromEnv()
+	mediamtxMaxResponseBytes = mediamtxResponseLimitFromEnv()
+	go runStateJanitor()
+	adaptiveBitrateConfig = loadAdaptiveBitrateConfig()
+	recordingConfig = loadRecordingConfig()
+	clipExporter = NewClipExporter(recordingConfig)
+	eventRecorder = NewEventRecorder(recordingConfig)
+	webrtcSignaling = loadWebRTCSignalingConfig()
+	iceConfig = loadICEConfig()
+	warnInvalidConfiguredURLs()
+	frameProcessorConfig = loadFrameProcessorConfig()
+	videoCopyConfig = loadVideoCopyConfig()
+	cpuAffinityEnabled = loadCPUAffinityEnabled()
+	freezeDetectionConfig = loadFreezeDetectionConfig()
+	snapshotCache = NewSnapshotCache(getEnvDuration("SNAPSHOT_CACHE_TTL", defaultSnapshotCacheTTL))
+	mediamtxOutage = NewMediaMTXOutageMonitor(loadMediaMTXOutageConfig())
+	go mediamtxOutage.Run()
+	if recordingConfig.continuous() {
+		log.Printf("Recording enabled: segments in %s, detection clips in %s (-%v/+%v)",
+			recordingConfig.Dir, recordingConfig.ClipDir, recordingConfig.ClipBefore, recordingConfig.ClipAfter)
+	} else if recordingConfig.Enabled {
+		log.Printf("Event recording enabled: detections recorded to %s (pre-roll %v, trailing %v, max %v)",
+			recordingConfig.Dir, recordingConfig.EventPreRoll, recordingConfig.EventTrailing, recordingConfig.EventMaxLength)
+	}
+	log.Printf("MediaMTX API auth: %s", mediamtxAuth.Name())
 
 	// Initialize Kafka producer
 	log.Println("Initializing Kafka producer...")
@@ -452,21 +658,85 @@ func main() {
 		log.Println("Face detection alerts will not be sent to Kafka")
 	} else {
 		log.Println("Kafka producer initialized successfully")
+		streamEvents.AttachKafka(kafkaProducer)
 	}
+	alertSummaries = NewAlertSummarizer(loadAlertSummaryConfig())
+	alertSummaries.AttachKafka(kafkaProducer)
+	go alertSummaries.Run()
 
 	// Initialize face detector
 	log.Println("Initializing face detector...")
 	faceDetector, err = NewFaceDetector(kafkaProducer)
 	if err != nil {
+		faceDetectorLoadErr = err
 		log.Printf("Warning: Failed to initialize face detector: %v", err)
 		log.Println("Face detection will be disabled")
 	} else if faceDetector.enabled {
 		log.Println("Face detector initialized successfully")
 	}
 
+	// Initialize object detector
+	objectDetector, err = NewObjectDetector(kafkaProducer)
+	if err != nil {
+		log.Printf("Warning: Failed to initialize object detector: %v", err)
+		log.Println("Object detection will be disabled")
+	}
+
 	// Create Gin router
-	r := gin.Default()
+	if err := registerRequestValidators(); err != nil {
+		log.Fatalf("Failed to register request validators: %v", err)
+	}
+	r := newRouter(cameraStore)
+
+	// Restore active camera paths after MediaMTX is ready
+	log.Println("Scheduling path restoration after MediaMTX initialization...")
+	go func() {
+		// Wait for the MediaMTX API to answer instead of a fixed delay
+		if !waitForMediaMTXAPI() {
+			log.Printf("MediaMTX API not reachable after %v, restoring paths anyway", timingConfig.RestoreStartupDelay)
+		}
+		restoreActivePaths(cameraStore)
+	}()
+
+	// Setup cleanup on shutdown
+	defer func() {
+		log.Println("Shutting down worker service...")
+
+		// Close face detector first so queued alerts are flushed while Kafka is still open
+		if faceDetector != nil {
+			log.Println("Closing face detector...")
+			faceDetector.Close()
+		}
+		if objectDetector != nil {
+			log.Println("Closing object detector...")
+			objectDetector.Close()
+		}
+		alertSummaries.Close()
+		annotationMats.Drain()
+		detectionStore.Close()
+		closeWebRTCSessions()
+
+		// Close Kafka producer
+		if kafkaProducer != nil {
+			log.Println("Closing Kafka producer...")
+			if err := kafkaProducer.Close(); err != nil {
+				log.Printf("Error closing Kafka producer: %v", err)
+			}
+		}
+
+		streamEvents.Close()
+
+		log.Println("Worker service shutdown complete")
+	}()
+
+	// Start server
+	fmt.Printf("Worker service starting on port %s\n", port)
+	log.Fatal(r.Run(":" + port))
+}
 
+// newRouter builds the worker's HTTP API; the handlers read and write cameras through store
+func newRouter(store CameraStore) *gin.Engine {
+	r := gin.Default()
 	r.Use(cors.Default()) // All origins allowed by default
 
 	// Health check endpoint
@@ -477,61 +747,275 @@ func main() {
 		})
 	})
 
+	// Readiness for the load balancer: not ready while in maintenance mode
+	r.GET("/health/readyz", func(c *gin.Context) {
+		state := currentMaintenance()
+		if state.Enabled {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status":      "maintenance",
+				"maintenance": state,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":      "ready",
+			"maintenance": state,
+		})
+	})
+
+	// StreamInfo is one stream in GET /streams and GET /streams/:cameraId
+	type StreamInfo struct {
+		CameraID        string     `json:"cameraId"`
+		PathName        string     `json:"pathName"`
+		WebRTCURL       string     `json:"webrtcUrl"`
+		RTSPSourceURL   string     `json:"rtspSourceUrl"`
+		ObserverURL     string     `json:"observerUrl"`                 // Read-only RTSP pull of the re-encoded output
+		ObserverOutput  string     `json:"observerOutputUrl,omitempty"` // Secondary QA tee target, if configured
+		Status          string     `json:"status"`
+		VideoMode       string     `json:"videoMode"`                 // transcode | copy
+		VideoModeReason string     `json:"videoModeReason,omitempty"` // Why the auto video mode picked it
+		CPUAffinity     string     `json:"cpuAffinity,omitempty"`     // CPUs FFmpeg is pinned to
+		StartTime       *time.Time `json:"startTime,omitempty"`
+		Uptime          string     `json:"uptime,omitempty"`
+		FramesProcessed uint64     `json:"framesProcessed,omitempty"`
+		BytesProcessed  uint64     `json:"bytesProcessed,omitempty"`
+		// LastFrameTime is when FFmpeg last reported a new frame, absent before the first
+		LastFrameTime         *time.Time `json:"lastFrameTime,omitempty"`
+		SecondsSinceLastFrame *float64   `json:"secondsSinceLastFrame,omitempty"`
+	}
+
+	newStreamInfo := func(process streamSnapshot) StreamInfo {
+		cameraID := process.CameraID
+		pathName := cameraPathName(cameraID)
+		webrtcURL := fmt.Sprintf("%s/%s", process.Options.MediaMTX.webrtcBaseURL(), pathName)
+
+		info := StreamInfo{
+			CameraID:      cameraID,
+			PathName:      pathName,
+			WebRTCURL:     webrtcURL,
+			RTSPSourceURL: process.SourceURL,
+			ObserverURL:   getObserverURL(process.Options.MediaMTX, cameraID),
+			Status:        "ACTIVE",
+
+			VideoMode:       process.VideoMode.Mode,
+			VideoModeReason: process.VideoMode.Reason,
+			CPUAffinity:     formatCPUList(process.CPUAffinity),
+		}
+		if process.Options.Observer.Enabled() {
+			info.ObserverOutput = process.Options.Observer.URL
+		}
+
+		// Only report uptime when the start time is actually known
+		if !process.StartTime.IsZero() {
+			startTime := process.StartTime
+			info.StartTime = &startTime
+			info.Uptime = time.Since(startTime).Round(time.Second).String()
+		}
+		info.FramesProcessed = process.FramesProcessed
+		info.BytesProcessed = process.BytesProcessed
+		if !process.LastFrameTime.IsZero() {
+			lastFrame := process.LastFrameTime
+			since := time.Since(lastFrame).Seconds()
+			info.LastFrameTime, info.SecondsSinceLastFrame = &lastFrame, &since
+		}
+		if streamStalled(process.LastFrameTime, process.StartTime) {
+			info.Status = "STALLED"
+		}
+		return info
+	}
+
 	// GET /streams - List all active streams with MediaMTX links
 	r.GET("/streams", func(c *gin.Context) {
-		processMutex.RLock()
-		streamMetricsMutex.RLock()
-		defer processMutex.RUnlock()
-		defer streamMetricsMutex.RUnlock()
+		snapshots := snapshotActiveStreams()
+		streams := make([]StreamInfo, 0, len(snapshots))
+		copying := 0
+		for _, process := range snapshots {
+			if process.VideoMode.Mode == videoModeCopy {
+				copying++
+			}
+			streams = append(streams, newStreamInfo(process))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"streams":       streams,
+			"total":         len(streams),
+			"videoCopies":   copying, // Streams skipping libx264
+			"maxConcurrent": currentWorkerConfig().MaxConcurrentStreams,
+			"usedCapacity":  usedCapacity(),
+		})
+	})
+
+	// GET /streams/:cameraId - One active stream, for dashboards polling a single tile
+	r.GET("/streams/:cameraId", func(c *gin.Context) {
+		cameraID := c.Param("cameraId")
+		if !cameraIDPattern.MatchString(cameraID) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid camera ID",
+			})
+			return
+		}
+
+		process, exists := snapshotActiveStream(cameraID)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("No active stream for camera %s", cameraID),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, newStreamInfo(process))
+	})
+
+	// DELETE /streams - Stop every active stream, a few at a time, e.g. for a maintenance window
+	r.DELETE("/streams", func(c *gin.Context) {
+		cameraIDs, _ := selectActiveCameras(store, nil)
+		log.Printf("Draining %d streams, %d at a time", len(cameraIDs), streamDrainWorkers)
+		c.JSON(http.StatusOK, drainStreams(cameraIDs, streamDrainWorkers))
+	})
+
+	// GET /cameras - All registered cameras joined with their live worker state
+	r.GET("/cameras", func(c *gin.Context) {
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Database not available",
+			})
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "limit must be between 1 and 1000",
+			})
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "offset must be a non-negative integer",
+			})
+			return
+		}
+
+		// ?label=site=hq&label=floor=2 requires every listed label to match
+		labelSelector := map[string]string{}
+		for _, label := range c.QueryArray("label") {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok || key == "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Invalid label filter %q (expected key=value)", label),
+				})
+				return
+			}
+			labelSelector[key] = value
+		}
+
+		records, err := store.ListCameras()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to list cameras: %v", err),
+			})
+			return
+		}
 
-		mediamtxWebRTCURL := os.Getenv("MEDIAMTX_WEBRTC_URL")
-		if mediamtxWebRTCURL == "" {
-			mediamtxWebRTCURL = "http://localhost:8891"
+		type CircuitBreakerInfo struct {
+			State        string `json:"state"`
+			FailureCount int    `json:"failureCount"`
 		}
 
-		type StreamInfo struct {
-			CameraID        string    `json:"cameraId"`
-			PathName        string    `json:"pathName"`
-			WebRTCURL       string    `json:"webrtcUrl"`
-			RTSPSourceURL   string    `json:"rtspSourceUrl"`
-			Status          string    `json:"status"`
-			StartTime       time.Time `json:"startTime"`
-			Uptime          string    `json:"uptime"`
-			FramesProcessed uint64    `json:"framesProcessed,omitempty"`
+		type CameraInfo struct {
+			ID                   string              `json:"id"`
+			Name                 string              `json:"name"`
+			Enabled              bool                `json:"enabled"`
+			Status               string              `json:"status"`
+			MediaMTXPath         string              `json:"mediamtxPath,omitempty"`
+			MediaMTXConfigured   bool                `json:"mediamtxConfigured"`
+			LastProcessedAt      *time.Time          `json:"lastProcessedAt,omitempty"`
+			FaceDetectionEnabled bool                `json:"faceDetectionEnabled"`
+			FaceDetectionActive  bool                `json:"faceDetectionActive"`
+			Labels               map[string]string   `json:"labels"`
+			Active               bool                `json:"active"`
+			StartTime            *time.Time          `json:"startTime,omitempty"`
+			Uptime               string              `json:"uptime,omitempty"`
+			FramesProcessed      uint64              `json:"framesProcessed,omitempty"`
+			ErrorCount           int                 `json:"errorCount,omitempty"`
+			CircuitBreaker       *CircuitBreakerInfo `json:"circuitBreaker,omitempty"`
 		}
 
-		streams := make([]StreamInfo, 0, len(activeProcesses))
-		for cameraID, process := range activeProcesses {
-			pathName := fmt.Sprintf("camera_%s", cameraID)
-			webrtcURL := fmt.Sprintf("%s/%s", mediamtxWebRTCURL, pathName)
+		filtered := make([]CameraRecord, 0, len(records))
+		for _, record := range records {
+			if record.MatchesLabels(labelSelector) {
+				filtered = append(filtered, record)
+			}
+		}
 
-			info := StreamInfo{
-				CameraID:      cameraID,
-				PathName:      pathName,
-				WebRTCURL:     webrtcURL,
-				RTSPSourceURL: process.SourceURL,
-				Status:        "ACTIVE",
+		total := len(filtered)
+		if offset > total {
+			offset = total
+		}
+		end := offset + limit
+		if end > total {
+			end = total
+		}
+		page := filtered[offset:end]
+
+		cameras := make([]CameraInfo, 0, len(page))
+		for _, record := range page {
+			info := CameraInfo{
+				ID:                   record.ID,
+				Name:                 record.Name,
+				Enabled:              record.Enabled,
+				Status:               record.Status,
+				MediaMTXPath:         record.PathName,
+				MediaMTXConfigured:   record.Configured,
+				LastProcessedAt:      record.LastProcessedAt,
+				FaceDetectionEnabled: record.FaceDetectionEnabled,
+				Labels:               record.Labels,
 			}
 
-			// Add metrics if available
-			if metrics, exists := streamMetrics[cameraID]; exists {
-				info.StartTime = metrics.StartTime
+			processMutex.RLock()
+			_, info.Active = activeProcesses[record.ID]
+			processMutex.RUnlock()
+
+			streamMetricsMutex.RLock()
+			if metrics, exists := streamMetrics[record.ID]; exists {
+				startTime := metrics.StartTime
+				info.StartTime = &startTime
 				info.Uptime = time.Since(metrics.StartTime).Round(time.Second).String()
 				info.FramesProcessed = metrics.FramesProcessed
+				info.ErrorCount = metrics.ErrorCount
 			}
+			streamMetricsMutex.RUnlock()
 
-			streams = append(streams, info)
+			faceDetectionMutex.RLock()
+			_, info.FaceDetectionActive = faceDetectionActive[record.ID]
+			faceDetectionMutex.RUnlock()
+
+			circuitBreakersMutex.RLock()
+			cb, exists := circuitBreakers[record.ID]
+			circuitBreakersMutex.RUnlock()
+			if exists {
+				cb.mu.RLock()
+				info.CircuitBreaker = &CircuitBreakerInfo{
+					State:        cb.State,
+					FailureCount: cb.FailureCount,
+				}
+				cb.mu.RUnlock()
+			}
+
+			cameras = append(cameras, info)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"streams":       streams,
-			"total":         len(streams),
-			"maxConcurrent": workerConfig.MaxConcurrentStreams,
+			"cameras": cameras,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
 		})
 	})
 
 	// GET /metrics - Resource usage metrics
 	r.GET("/metrics", func(c *gin.Context) {
+		used := usedCapacity()
 		processMutex.RLock()
 		streamMetricsMutex.RLock()
 		activeCount := len(activeProcesses)
@@ -541,7 +1025,11 @@ func main() {
 			CameraID        string `json:"cameraId"`
 			Uptime          string `json:"uptime"`
 			FramesProcessed uint64 `json:"framesProcessed"`
+			BytesProcessed  uint64 `json:"bytesProcessed"`
 			ErrorCount      int    `json:"errorCount"`
+
+			RestartReasons map[string]uint64      `json:"restartReasons"`
+			Latency        *FFmpegLatencyEstimate `json:"latency,omitempty"`
 		}
 
 		metricsData := make([]MetricsSummary, 0, len(streamMetrics))
@@ -550,16 +1038,77 @@ func main() {
 				CameraID:        cameraID,
 				Uptime:          time.Since(metrics.StartTime).Round(time.Second).String(),
 				FramesProcessed: metrics.FramesProcessed,
+				BytesProcessed:  metrics.BytesProcessed,
 				ErrorCount:      metrics.ErrorCount,
+				RestartReasons:  metrics.RestartReasons,
+				Latency:         metrics.Latency,
 			})
 		}
 		streamMetricsMutex.RUnlock()
 
 		c.JSON(http.StatusOK, gin.H{
-			"activeStreams": activeCount,
-			"maxStreams":    workerConfig.MaxConcurrentStreams,
-			"utilization":   fmt.Sprintf("%.1f%%", float64(activeCount)/float64(workerConfig.MaxConcurrentStreams)*100),
-			"streams":       metricsData,
+			"activeStreams":    activeCount,
+			"maxStreams":       currentWorkerConfig().MaxConcurrentStreams,
+			"usedCapacity":     used,
+			"utilization":      fmt.Sprintf("%.1f%%", capacityUtilization(used, currentWorkerConfig().MaxConcurrentStreams)),
+			"streams":          metricsData,
+			"restartLimiter":   restartLimiter.Stats(),
+			"database":         dbPoolStats(db, dbConfig),
+			"webrtcStreamers":  webRTCStreamerStats(),
+			"alertQueue":       faceDetectorAlertQueueStats(),
+			"objectAlertQueue": objectDetectorAlertQueueStats(),
+			"alertSummary":     alertSummaries.Stats(),
+			"detectionStore":   detectionStore.Stats(),
+			"capacityQueue":    capacityQueue.Stats(),
+			"trackedState":     trackedStateSizes(),
+			"adaptiveBitrate":  adaptiveBitrateStats(),
+			"ffmpegRestarts":   ffmpegRestartTotals(),
+			"kafka":            kafkaMetrics.Snapshot(),
+			"frameBuffers":     frameBufferStats(),
+			"webrtcSessions":   webrtcSessionStats(),
+			"mediamtxOutage":   mediamtxOutage.Stats(),
+			"freezeDetection":  freezeTracker.Stats(),
+		})
+	})
+
+	// GET /metrics/prometheus - Stream, circuit breaker, FFmpeg restart and Kafka publish
+	// metrics in the Prometheus text format
+	r.GET("/metrics/prometheus", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8",
+			[]byte(prometheusStreamMetrics()+prometheusCircuitBreakers()+prometheusFFmpegRestarts()+kafkaMetrics.Prometheus()))
+	})
+
+	// GET /health/deps - Database and Kafka reachability; 503 when a configured dependency is down
+	r.GET("/health/deps", func(c *gin.Context) {
+		healthy := true
+
+		database := gin.H{"configured": db != nil, "healthy": false}
+		if db != nil {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+			err := db.PingContext(ctx)
+			cancel()
+			database["healthy"] = err == nil
+			if err != nil {
+				database["error"] = err.Error()
+				healthy = false
+			}
+		}
+
+		// Kafka is always configured (KAFKA_BROKERS defaults to localhost:9092), so a
+		// producer that failed to start counts as down
+		kafkaHealth := kafkaMetrics.Snapshot()
+		if !kafkaHealth.Healthy {
+			healthy = false
+		}
+
+		status, code := "healthy", http.StatusOK
+		if !healthy {
+			status, code = "degraded", http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{
+			"status":   status,
+			"database": database,
+			"kafka":    kafkaHealth,
 		})
 	})
 
@@ -573,7 +1122,8 @@ func main() {
 		issues := []string{}
 
 		// Check if we're at capacity
-		if activeCount >= workerConfig.MaxConcurrentStreams {
+		used := usedCapacity()
+		if used >= currentWorkerConfig().MaxConcurrentStreams {
 			healthy = false
 			issues = append(issues, "at maximum capacity")
 		}
@@ -581,9 +1131,13 @@ func main() {
 		// Check for stale streams (no activity in 5 minutes)
 		streamMetricsMutex.RLock()
 		for cameraID, metrics := range streamMetrics {
-			if time.Since(metrics.LastFrameTime) > 5*time.Minute {
+			lastActivity := metrics.LastFrameTime
+			if lastActivity.IsZero() {
+				lastActivity = metrics.StartTime // No frame yet
+			}
+			if time.Since(lastActivity) > 5*time.Minute {
 				healthy = false
-				issues = append(issues, fmt.Sprintf("camera %s: no activity for %v", cameraID, time.Since(metrics.LastFrameTime)))
+				issues = append(issues, fmt.Sprintf("camera %s: no activity for %v", cameraID, time.Since(lastActivity)))
 			}
 		}
 		streamMetricsMutex.RUnlock()
@@ -598,54 +1152,294 @@ func main() {
 		c.JSON(statusCode, gin.H{
 			"status":        status,
 			"activeStreams": activeCount,
-			"maxStreams":    workerConfig.MaxConcurrentStreams,
+			"maxStreams":    currentWorkerConfig().MaxConcurrentStreams,
+			"usedCapacity":  used,
 			"issues":        issues,
 		})
 	})
 
-	// MediaMTX path status endpoint for debugging
-	r.GET("/mediamtx/paths", func(c *gin.Context) {
-		mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-		if mediamtxAPIURL == "" {
-			mediamtxAPIURL = "http://localhost:9997"
+	// GET /health/summary - Rollup of every subsystem for uptime monitors; 503 only when unhealthy
+	r.GET("/health/summary", func(c *gin.Context) {
+		summary := healthSummary(c.Request.Context())
+		code := http.StatusOK
+		if summary.Status == healthUnhealthy {
+			code = http.StatusServiceUnavailable
 		}
+		c.JSON(code, summary)
+	})
 
-		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Get(mediamtxAPIURL + "/v3/paths/list")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to get MediaMTX paths: %v", err),
-			})
-			return
+	// GET /circuit-breakers?state=open - Every camera's breaker, optionally only those in one state
+	r.GET("/circuit-breakers", func(c *gin.Context) {
+		state := c.Query("state")
+		if state != "" {
+			if _, known := circuitBreakerStateValues[state]; !known {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Invalid state %q (must be closed, half-open or open)", state),
+				})
+				return
+			}
 		}
-		defer resp.Body.Close()
 
-		body, _ := io.ReadAll(resp.Body)
-		c.Header("Content-Type", "application/json")
-		c.String(resp.StatusCode, string(body))
+		breakers := circuitBreakerStates(state)
+		c.JSON(http.StatusOK, gin.H{
+			"circuitBreakers": breakers,
+			"total":           len(breakers),
+		})
 	})
 
-	// Individual path status endpoint
-	r.GET("/mediamtx/path/:pathName", func(c *gin.Context) {
-		pathName := c.Param("pathName")
-		mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-		if mediamtxAPIURL == "" {
-			mediamtxAPIURL = "http://localhost:9997"
+	// POST /circuit-breakers/:cameraId/reset?restart=true - Close a camera's breaker after the
+	// fault is fixed; also served on the older /circuit-breaker/:cameraId/reset
+	resetCircuitBreaker := func(c *gin.Context) {
+		cameraID := c.Param("cameraId")
+
+		circuitBreakersMutex.RLock()
+		cb, exists := circuitBreakers[cameraID]
+		circuitBreakersMutex.RUnlock()
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("No circuit breaker for camera %s", cameraID),
+			})
+			return
 		}
 
-		apiURL := fmt.Sprintf("%s/v3/paths/get/%s", mediamtxAPIURL, pathName)
-		client := &http.Client{Timeout: 5 * time.Second}
+		before := cb.Snapshot()
+		cb.RecordSuccess()
+		after := cb.Snapshot()
+		log.Printf("Circuit breaker for camera %s reset by operator (was %s with %d failures)",
+			cameraID, before.State, before.FailureCount)
 
-		req, err := http.NewRequest("GET", apiURL, nil)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
+		response := gin.H{
+			"cameraId": cameraID,
+			"before":   before,
+			"after":    after,
+		}
+
+		if c.Query("restart") == "true" {
+			restarted, restartErr := false, ""
+			if isProcessRunning(cameraID) {
+				restartErr = "camera is already streaming"
+			} else if rtspURL, _, _, err := store.GetCameraInfo(cameraID); err != nil {
+				restartErr = fmt.Sprintf("failed to look up camera: %v", err)
+			} else if err := startReencodingProcess(store, cameraID, rtspURL, loadStreamOptions(store, cameraID)); err != nil {
+				restartErr = err.Error()
+			} else {
+				restarted = true
+			}
+			response["restarted"] = restarted
+			if restartErr != "" {
+				response["restartError"] = restartErr
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+	r.POST("/circuit-breakers/:cameraId/reset", resetCircuitBreaker)
+	r.POST("/circuit-breaker/:cameraId/reset", resetCircuitBreaker)
+
+	// POST /reload - Re-read .env and apply the settings that are safe to change live
+	r.POST("/reload", func(c *gin.Context) {
+		result, err := reloadConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to reload config: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// POST /maintenance - Stop accepting new streams (e.g. before a node drain) while
+	// running streams and /stop keep working
+	r.POST("/maintenance", func(c *gin.Context) {
+		var req struct {
+			Enabled     *bool  `json:"enabled" binding:"required"`
+			Reason      string `json:"reason"`
+			AutoRestart *bool  `json:"autoRestart"` // Keep auto-restarting existing cameras (default true)
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+
+		allowAutoRestart := true
+		if req.AutoRestart != nil {
+			allowAutoRestart = *req.AutoRestart
+		}
+		c.JSON(http.StatusOK, setMaintenance(*req.Enabled, req.Reason, allowAutoRestart))
+	})
+
+	// MediaMTX path status endpoint for debugging
+	r.GET("/mediamtx/paths", func(c *gin.Context) {
+		mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
+		if mediamtxAPIURL == "" {
+			mediamtxAPIURL = "http://localhost:9997"
+		}
+
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := mediamtxGet(client, mediamtxAPIURL+"/v3/paths/list")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to get MediaMTX paths: %v", err),
+			})
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := readMediaMTXBody(resp)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": fmt.Sprintf("Failed to read MediaMTX response: %v", err),
+			})
+			return
+		}
+		c.Header("Content-Type", "application/json")
+		c.String(resp.StatusCode, string(body))
+	})
+
+	// Dry run of a reconciliation pass: what would be deleted, started or stopped to
+	// bring MediaMTX, the running processes and the database back in line. Changes nothing.
+	r.GET("/reconcile/plan", func(c *gin.Context) {
+		c.JSON(http.StatusOK, buildReconcilePlan(store))
+	})
+
+	// GET /mediamtx/orphans - Worker-prefixed MediaMTX paths with no running process and no enabled camera
+	r.GET("/mediamtx/orphans", func(c *gin.Context) {
+		orphans, errs := findOrphanedMediaMTXPaths(store)
+		if len(errs) > 0 {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": fmt.Sprintf("Failed to list orphaned MediaMTX paths: %s", strings.Join(errs, "; ")),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"orphans": orphans,
+			"count":   len(orphans),
+		})
+	})
+
+	// POST /mediamtx/orphans/cleanup - Delete orphaned paths, all of them or the listed ones
+	r.POST("/mediamtx/orphans/cleanup", func(c *gin.Context) {
+		var req struct {
+			Paths []string `json:"paths" binding:"max=1000"` // Optional; defaults to every orphaned path
+		}
+
+		// An empty body cleans up every orphaned path
+		if c.Request.ContentLength != 0 && !bindJSON(c, &req) {
+			return
+		}
+
+		results, errs := cleanupOrphanedMediaMTXPaths(store, req.Paths)
+		if len(errs) > 0 {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": fmt.Sprintf("Failed to list orphaned MediaMTX paths: %s", strings.Join(errs, "; ")),
+			})
+			return
+		}
+
+		summary := map[string]int{}
+		for _, result := range results {
+			summary[result.Action]++
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"results": results,
+			"summary": summary,
+		})
+	})
+
+	// GET /export - Every camera and group, with their settings, as a snapshot POST /import restores
+	r.GET("/export", func(c *gin.Context) {
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Database not available",
+			})
+			return
+		}
+
+		snapshot, err := buildSnapshot(store)
+		if err != nil {
+			log.Printf("Failed to export snapshot: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to export snapshot: %v", err),
+			})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="worker-snapshot-%s.json"`, snapshot.ExportedAt.UTC().Format("20060102T150405Z")))
+		c.JSON(http.StatusOK, snapshot)
+	})
+
+	// POST /import?start=true&running=skip|restart - Register a GET /export snapshot's cameras
+	// and groups, optionally starting the cameras that were streaming
+	r.POST("/import", func(c *gin.Context) {
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Database not available",
+			})
+			return
+		}
+
+		start := c.Query("start") == "true"
+		running := c.DefaultQuery("running", importRunningSkip)
+		if running != importRunningSkip && running != importRunningRestart {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("running must be %s or %s", importRunningSkip, importRunningRestart),
+			})
+			return
+		}
+		if start {
+			if reason, refused := maintenanceRejection(); refused {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error":       reason,
+					"maintenance": true,
+				})
+				return
+			}
+		}
+
+		var snapshot WorkerSnapshot
+		if !bindJSON(c, &snapshot) {
+			return
+		}
+		if err := snapshot.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid snapshot: %v", err),
+			})
+			return
+		}
+
+		log.Printf("Importing snapshot from %s: %d cameras, %d groups (start: %v, running: %s)",
+			snapshot.ExportedAt.Format(time.RFC3339), len(snapshot.Cameras), len(snapshot.Groups), start, running)
+		results := importSnapshot(store, snapshot, start, running)
+		summary := importSummary(results)
+		log.Printf("Snapshot import finished: %v", summary)
+
+		c.JSON(http.StatusOK, gin.H{
+			"cameras": results,
+			"summary": summary,
+		})
+	})
+
+	// Individual path status endpoint
+	r.GET("/mediamtx/path/:pathName", func(c *gin.Context) {
+		pathName := c.Param("pathName")
+		mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
+		if mediamtxAPIURL == "" {
+			mediamtxAPIURL = "http://localhost:9997"
+		}
+
+		apiURL := fmt.Sprintf("%s/v3/paths/get/%s", mediamtxAPIURL, pathName)
+		client := &http.Client{Timeout: 5 * time.Second}
+
+		req, err := http.NewRequest("GET", apiURL, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("Failed to create request: %v", err),
 			})
 			return
 		}
-		// req.SetBasicAuth("admin", "admin")
 
-		resp, err := client.Do(req)
+		resp, err := mediamtxDo(client, req)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("Failed to get path status: %v", err),
@@ -654,22 +1448,99 @@ func main() {
 		}
 		defer resp.Body.Close()
 
-		body, _ := io.ReadAll(resp.Body)
+		body, err := readMediaMTXBody(resp)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": fmt.Sprintf("Failed to read MediaMTX response: %v", err),
+			})
+			return
+		}
 		c.Header("Content-Type", "application/json")
 		c.String(resp.StatusCode, string(body))
 	})
 
+	// POST /credentials/seal - Encrypt a source URL's credentials, e.g. for the backend to
+	// store in the cameras table
+	r.POST("/credentials/seal", func(c *gin.Context) {
+		var req struct {
+			RTSPURL string `json:"rtspUrl" binding:"required,rtspurl"`
+		}
+		if !bindJSON(c, &req) {
+			return
+		}
+		if !credentialCipher.Enabled() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Credential encryption is not enabled (set RTSP_CREDENTIAL_KEYS)",
+			})
+			return
+		}
+
+		sealed, err := credentialCipher.Seal(req.RTSPURL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"rtspUrl":    sealed,
+			"keyVersion": credentialCipher.wrapper.ActiveVersion(),
+		})
+	})
+
+	// POST /credentials/rotate - Re-encrypt every stored source URL under the active key
+	r.POST("/credentials/rotate", func(c *gin.Context) {
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+			return
+		}
+		result, err := rotateStoredCredentials(store)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// POST /test-source - Check an RTSP URL works before registering a camera (no side effects)
+	r.POST("/test-source", func(c *gin.Context) {
+		var req struct {
+			RTSPURL  string `json:"rtspUrl" binding:"required,rtspurl"`
+			Username string `json:"username" binding:"max=256"`
+			Password string `json:"password" binding:"max=256"`
+
+			// Optional sub stream for face detection, probed with the same credentials
+			DetectionRTSPURL string `json:"detectionRtspUrl" binding:"omitempty,rtspurl"`
+		}
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		result := probeRTSPSource(req.RTSPURL, req.Username, req.Password)
+		if parsed, err := url.Parse(req.RTSPURL); err == nil {
+			log.Printf("Tested source %s: reachable=%v authOk=%v video=%s",
+				parsed.Redacted(), result.Reachable, result.AuthOK, result.VideoCodec)
+		}
+		if req.DetectionRTSPURL != "" {
+			detection := probeRTSPSource(req.DetectionRTSPURL, req.Username, req.Password)
+			if parsed, err := url.Parse(req.DetectionRTSPURL); err == nil {
+				log.Printf("Tested detection source %s: reachable=%v authOk=%v video=%s",
+					parsed.Redacted(), detection.Reachable, detection.AuthOK, detection.VideoCodec)
+			}
+			result.Detection = &detection
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
 	// Register camera and configure MediaMTX path (without starting stream)
 	r.POST("/register", func(c *gin.Context) {
 		var req struct {
-			CameraID string `json:"cameraId" binding:"required"`
-			Name     string `json:"name"`
+			CameraID string `json:"cameraId" binding:"required,cameraid"`
+			Name     string `json:"name" binding:"max=128"`
+			// ValidateSnapshot grabs a frame from the camera before marking it configured
+			ValidateSnapshot bool `json:"validateSnapshot"`
+			TimeoutMs        int  `json:"timeoutMs" binding:"min=0,max=60000"`
 		}
 
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid request: %v", err),
-			})
+		if !bindJSON(c, &req) {
 			return
 		}
 
@@ -685,14 +1556,52 @@ func main() {
 		}
 
 		// Generate path name for MediaMTX
-		pathName := fmt.Sprintf("camera_%s", req.CameraID)
+		pathName := cameraPathName(req.CameraID)
 
 		// Pre-configure MediaMTX path (will accept any publisher)
 		// This ensures the path exists before FFmpeg tries to stream
 		log.Printf("Pre-configuring MediaMTX path: %s", pathName)
 
+		// Prove the source actually delivers frames before calling the camera configured
+		if req.ValidateSnapshot {
+			timeout := defaultSnapshotTimeout
+			if req.TimeoutMs > 0 {
+				timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+			}
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			snapshot, err := grabSnapshot(ctx, store, req.CameraID)
+			cancel()
+			if err != nil {
+				log.Printf("Validation snapshot failed for camera %s: %v", req.CameraID, err)
+				store.UpdateCameraPathInfo(req.CameraID, pathName, false)
+				if statusErr := store.UpdateCameraStatus(req.CameraID, cameraStatusError); statusErr != nil {
+					log.Printf("Failed to update status for camera %s: %v", req.CameraID, statusErr)
+				}
+				c.JSON(http.StatusOK, gin.H{
+					"message":            fmt.Sprintf("Camera %s registered, but its validation snapshot failed", req.CameraID),
+					"pathName":           pathName,
+					"mediamtxPath":       pathName,
+					"mediamtxConfigured": false,
+					"status":             cameraStatusError,
+					"warning":            fmt.Sprintf("validation snapshot failed: %v", err),
+				})
+				return
+			}
+
+			store.UpdateCameraPathInfo(req.CameraID, pathName, true)
+			log.Printf("Successfully registered camera %s with path %s (validated from %s)", req.CameraID, pathName, snapshot.Source)
+			c.JSON(http.StatusOK, gin.H{
+				"message":            fmt.Sprintf("Camera %s registered successfully", req.CameraID),
+				"pathName":           pathName,
+				"mediamtxPath":       pathName,
+				"mediamtxConfigured": true,
+				"snapshot":           snapshot,
+			})
+			return
+		}
+
 		// Update database to mark camera as registered
-		updateCameraPathInfo(req.CameraID, pathName, true)
+		store.UpdateCameraPathInfo(req.CameraID, pathName, true)
 
 		log.Printf("Successfully registered camera %s with path %s", req.CameraID, pathName)
 		c.JSON(http.StatusOK, gin.H{
@@ -707,15 +1616,12 @@ func main() {
 	r.POST("/preconfig-paths", func(c *gin.Context) {
 		var req struct {
 			Cameras []struct {
-				CameraID string `json:"cameraId" binding:"required"`
-				Name     string `json:"name"`
-			} `json:"cameras" binding:"required"`
+				CameraID string `json:"cameraId" binding:"required,cameraid"`
+				Name     string `json:"name" binding:"max=128"`
+			} `json:"cameras" binding:"required,dive"`
 		}
 
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid request: %v", err),
-			})
+		if !bindJSON(c, &req) {
 			return
 		}
 
@@ -741,14 +1647,14 @@ func main() {
 		successCount := 0
 
 		for _, camera := range req.Cameras {
-			pathName := fmt.Sprintf("camera_%s", camera.CameraID)
+			pathName := cameraPathName(camera.CameraID)
 			result := PreconfigResult{
 				CameraID: camera.CameraID,
 				PathName: pathName,
 			}
 
 			// Update database to mark camera path as configured
-			updateCameraPathInfo(camera.CameraID, pathName, true)
+			store.UpdateCameraPathInfo(camera.CameraID, pathName, true)
 			result.Success = true
 			successCount++
 			log.Printf("Pre-configured path for camera %s: %s", camera.CameraID, pathName)
@@ -768,48 +1674,206 @@ func main() {
 
 	// Unified camera processing endpoint
 	r.POST("/process", func(c *gin.Context) {
+		if reason, refused := maintenanceRejection(); refused {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":       reason,
+				"maintenance": true,
+			})
+			return
+		}
+
 		var req struct {
-			CameraID string `json:"cameraId" binding:"required"`
-			RTSPURL  string `json:"rtspUrl" binding:"required"`
-			Name     string `json:"name"`
+			CameraID string           `json:"cameraId" binding:"required,cameraid"`
+			RTSPURL  string           `json:"rtspUrl" binding:"required_without=ViewingRTSPURL,omitempty,rtspurl"`
+			Name     string           `json:"name" binding:"max=128"`
+			Audio    *AudioOptions    `json:"audio"`    // Optional; persisted per camera when set
+			Observer *ObserverOptions `json:"observer"` // Optional QA tee; persisted per camera when set
+			Output   *OutputOptions   `json:"output"`   // Optional rtsp/hls/ll-hls/srt target; persisted per camera when set
+			Input    *InputOptions    `json:"input"`    // Optional RTSP read buffer and max delay; persisted per camera when set
+
+			Encoding  *EncodingProfile    `json:"encodingProfile"`                                    // Optional codec/profile/bitrate/GOP/preset; persisted per camera when set
+			Filters   *VideoFilterOptions `json:"filters"`                                            // Optional deinterlace/denoise/crop/scale; persisted per camera when set
+			VideoMode string              `json:"videoMode" binding:"omitempty,oneof=transcode auto"` // Optional; auto copies compatible source video; persisted per camera when set
+
+			MaxSourceConnections int `json:"maxSourceConnections" binding:"min=0,max=100"` // Optional per-camera connection cap
+			WatchdogStallSeconds int `json:"watchdogStallSeconds" binding:"max=86400"`     // Optional; negative disables the stall watchdog
+			BreakerWarmupSeconds int `json:"breakerWarmupSeconds" binding:"max=3600"`      // Optional; negative disables the circuit breaker warm-up
+
+			FrameProcessors []string `json:"frameProcessors" binding:"max=8"` // Optional detection chain, e.g. ["quality","motion","face"]; persisted per camera when set
+
+			Priority *int `json:"priority" binding:"omitempty,min=-1000,max=1000"` // Higher wins; persisted per camera when set, 0 resets it
+			Evict    bool `json:"evict"`                                           // At capacity, evict a lower-priority stream instead of returning 429
+			Weight   int  `json:"weight" binding:"min=0,max=100"`                  // Optional capacity slots the stream takes; persisted per camera when set
+
+			CPUAffinity string `json:"cpuAffinity" binding:"max=256"` // Optional CPUs to pin FFmpeg to, e.g. "2-3"; persisted per camera when set
+
+			// Optional dual-stream cameras: the main stream is re-encoded for viewing (in place
+			// of rtspUrl) and face detection reads the sub stream; persisted per camera when set
+			ViewingRTSPURL   string `json:"viewingRtspUrl" binding:"omitempty,rtspurl"`
+			DetectionRTSPURL string `json:"detectionRtspUrl" binding:"omitempty,rtspurl"`
+
+			// Optional MediaMTX instance for sharded setups; persisted per camera when set
+			MediaMTXAPIURL     string `json:"mediamtxApiUrl"`
+			MediaMTXPublishURL string `json:"mediamtxPublishUrl"`
+			MediaMTXWebRTCURL  string `json:"mediamtxWebrtcUrl"`
 		}
 
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if !bindJSON(c, &req) {
+			return
+		}
+		if hasWHIPSession(req.CameraID) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": fmt.Sprintf("Camera %s is being published over WHIP; end that session first", req.CameraID),
+			})
+			return
+		}
+
+		override := StreamOptions{
+			Audio:                req.Audio,
+			Observer:             req.Observer,
+			Output:               req.Output,
+			Input:                req.Input,
+			Filters:              req.Filters,
+			Encoding:             req.Encoding,
+			VideoMode:            req.VideoMode,
+			MaxSourceConnections: req.MaxSourceConnections,
+			Priority:             req.Priority,
+			CPUAffinity:          req.CPUAffinity,
+			WatchdogStallSeconds: req.WatchdogStallSeconds,
+			BreakerWarmupSeconds: req.BreakerWarmupSeconds,
+			FrameProcessors:      req.FrameProcessors,
+			Weight:               req.Weight,
+			ViewingRTSPURL:       req.ViewingRTSPURL,
+			DetectionRTSPURL:     req.DetectionRTSPURL,
+		}
+		if req.MediaMTXAPIURL != "" || req.MediaMTXPublishURL != "" || req.MediaMTXWebRTCURL != "" {
+			override.MediaMTX = &MediaMTXInstance{APIURL: req.MediaMTXAPIURL, PublishURL: req.MediaMTXPublishURL, WebRTCURL: req.MediaMTXWebRTCURL}
+		}
+		options, err := resolveStreamOptions(store, req.CameraID, override)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid request: %v", err),
+				"error": fmt.Sprintf("Invalid stream options: %v", err),
 			})
 			return
 		}
 
-		// Check if we've reached the concurrent stream limit
-		processMutex.RLock()
-		activeCount := len(activeProcesses)
-		processMutex.RUnlock()
+		// A new detection stream is probed up front, so a wrong sub stream URL fails the
+		// request rather than face detection once the camera is running
+		if req.DetectionRTSPURL != "" {
+			if probe, err := preflightSource(req.CameraID, req.DetectionRTSPURL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":     fmt.Sprintf("Detection stream failed the pre-flight probe: %v", err),
+					"detection": probe,
+				})
+				return
+			}
+		}
 
-		if activeCount >= workerConfig.MaxConcurrentStreams {
-			log.Printf("Cannot start camera %s: reached max concurrent streams (%d/%d)",
-				req.CameraID, activeCount, workerConfig.MaxConcurrentStreams)
+		// Check the stream fits in the concurrent stream limit; each stream takes its weight
+		config := currentWorkerConfig()
+		queueing := config.CapacityMode == capacityModeQueue
+		weight := streamWeight(options)
+		if weight > config.MaxConcurrentStreams {
 			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": fmt.Sprintf("Maximum concurrent streams reached (%d/%d)",
-					activeCount, workerConfig.MaxConcurrentStreams),
+				"error": fmt.Sprintf("Stream weight %d exceeds max concurrent streams (%d)", weight, config.MaxConcurrentStreams),
 			})
 			return
 		}
 
+		// A heavy stream may need more than one lower-priority stream evicted. The whole
+		// set is chosen first, so a request that can't be satisfied stops nothing.
+		evicted := []string{}
+		if used := usedCapacity(); used+weight > config.MaxConcurrentStreams {
+			var victims []evictionVictim
+			enough := false
+			if req.Evict {
+				victims, enough = findEvictionVictims(options.priority(), req.CameraID, used+weight-config.MaxConcurrentStreams)
+			}
+
+			if enough {
+				for _, victim := range victims {
+					if err := evictStream(victim.CameraID, victim.Priority, req.CameraID, options.priority()); err != nil {
+						log.Printf("Eviction of camera %s failed: %v", victim.CameraID, err)
+					}
+					evicted = append(evicted, victim.CameraID)
+				}
+			} else if !queueing {
+				log.Printf("Cannot start camera %s (weight %d): reached max concurrent streams (%d/%d)",
+					req.CameraID, weight, used, config.MaxConcurrentStreams)
+				errorMsg := fmt.Sprintf("Maximum concurrent streams reached (%d/%d, stream weight %d)",
+					used, config.MaxConcurrentStreams, weight)
+				if req.Evict {
+					errorMsg += fmt.Sprintf("; streams with priority below %d don't free enough capacity", options.priority())
+				}
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error":   errorMsg,
+					"evicted": evicted,
+				})
+				return
+			}
+			// Otherwise queue for the capacity
+		}
+
+		// In queue mode, wait behind earlier requests for capacity (unless evictions made it)
+		releaseSlot := func() {}
+		if queueing && len(evicted) == 0 {
+			release, err := capacityQueue.Acquire(c.Request.Context(), weight, config.MaxConcurrentStreams, config.CapacityQueueMaxDepth,
+				config.CapacityQueueTimeout, usedCapacity)
+			if err != nil {
+				log.Printf("Cannot start camera %s: %v (max concurrent streams %d)", req.CameraID, err, config.MaxConcurrentStreams)
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error":  fmt.Sprintf("Maximum concurrent streams reached (%d): %v", config.MaxConcurrentStreams, err),
+					"queued": true,
+				})
+				return
+			}
+			releaseSlot = release
+		}
+
 		log.Printf("Starting processing for camera %s with RTSP URL: %s", req.CameraID, req.RTSPURL)
 
 		// Generate path name for MediaMTX
-		pathName := fmt.Sprintf("camera_%s", req.CameraID)
-
-		// Stop any existing process for this camera first
-		// This will also clean up the MediaMTX path
-		stopReencodingProcess(req.CameraID)
+		pathName := cameraPathName(req.CameraID)
 
-		// Wait a moment for cleanup to complete
-		time.Sleep(500 * time.Millisecond)
+		// Stop any existing process for this camera first and confirm
+		// MediaMTX has dropped its publisher before starting a new one
+		previousMediaMTX := activeMediaMTX(req.CameraID)
+		if stopReencodingProcess(req.CameraID) {
+			waitForStreamStopped(previousMediaMTX, pathName)
+		}
 
-		// Start re-encoding process to remove B-frames
-		err := startReencodingProcess(req.CameraID, req.RTSPURL)
+		// Start re-encoding process to remove B-frames; once it runs (or has failed) it
+		// no longer needs the queue's reservation
+		err = startReencodingProcess(store, req.CameraID, req.RTSPURL, options)
+		releaseSlot()
+		var limitErr *SourceConnectionLimitError
+		if errors.As(err, &limitErr) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": limitErr.Error(),
+			})
+			return
+		}
+		if errors.Is(err, ErrWHIPPublishing) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		var conflictErr *PathConflictError
+		if errors.As(err, &conflictErr) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   conflictErr.Error(),
+				"owner":   conflictErr.Owner,
+				"skipped": conflictErr.Skipped,
+			})
+			return
+		}
+		var unsupportedCodecErr *UnsupportedCodecError
+		if errors.As(err, &unsupportedCodecErr) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"error":  unsupportedCodecErr.Error(),
+				"codecs": unsupportedCodecErr.Codecs,
+			})
+			return
+		}
 		if err != nil {
 			log.Printf("Failed to start re-encoding process: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -818,55 +1882,113 @@ func main() {
 			return
 		}
 
-		// Wait for MediaMTX path to be ready with stream
-		log.Printf("Waiting for MediaMTX path %s to receive stream from FFmpeg...", pathName)
-		streamReadyErr := waitForPathWithStream(pathName, 60*time.Second)
+		// Wait for the output to be ready (MediaMTX path, HLS playlist, or SRT connection)
+		outputType := options.Output.resolvedType()
+		log.Printf("Waiting for %s output of camera %s to receive stream from FFmpeg...", outputType, req.CameraID)
+		streamReadyErr := waitForOutputReady(req.CameraID, 60*time.Second)
 		if streamReadyErr != nil {
-			log.Printf("Error: Stream not ready for path %s: %v", pathName, streamReadyErr)
+			log.Printf("Error: Stream not ready for camera %s (%s output): %v", req.CameraID, outputType, streamReadyErr)
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error":   fmt.Sprintf("Stream not ready: %v", streamReadyErr),
 				"message": "FFmpeg stream did not become ready in time",
 			})
 			return
 		}
-		log.Printf("MediaMTX path %s has active stream and is ready", pathName)
+		log.Printf("%s output for camera %s has active stream and is ready", outputType, req.CameraID)
 
 		log.Printf("Successfully started processing for camera %s", req.CameraID)
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"message":   fmt.Sprintf("Camera %s processing started", req.CameraID),
 			"pathName":  pathName,
 			"status":    "ready",
 			"sessionId": pathName,
-			"webrtcUrl": fmt.Sprintf("%s/%s", os.Getenv("MEDIAMTX_WEBRTC_URL"), pathName),
-		})
+			"webrtcUrl": fmt.Sprintf("%s/%s", options.MediaMTX.webrtcBaseURL(), pathName),
+		}
+		if len(evicted) > 0 {
+			response["evicted"] = evicted
+		}
+		if outputType != outputTypeRTSP {
+			response["output"] = outputType
+			processMutex.RLock()
+			if process, exists := activeProcesses[req.CameraID]; exists {
+				response["outputUrl"] = process.TargetURL
+			}
+			processMutex.RUnlock()
+		}
+		c.JSON(http.StatusOK, response)
 	})
 
 	// POST /process-batch - Start processing multiple cameras
 	r.POST("/process-batch", func(c *gin.Context) {
-		var req struct {
-			Cameras []struct {
-				CameraID string `json:"cameraId" binding:"required"`
-				RTSPURL  string `json:"rtspUrl" binding:"required"`
-				Name     string `json:"name"`
-			} `json:"cameras" binding:"required"`
-		}
-
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if reason, refused := maintenanceRejection(); refused {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":       reason,
+				"maintenance": true,
+			})
+			return
+		}
+
+		type BatchCamera struct {
+			CameraID string        `json:"cameraId" binding:"required,cameraid"`
+			RTSPURL  string        `json:"rtspUrl" binding:"required,rtspurl"`
+			Name     string        `json:"name" binding:"max=128"`
+			Audio    *AudioOptions `json:"audio"`
+
+			Encoding *EncodingProfile `json:"encodingProfile"` // Optional; persisted per camera when set
+		}
+
+		var req struct {
+			Cameras  []BatchCamera   `json:"cameras" binding:"required_without=Selector,dive"`
+			Selector *CameraSelector `json:"selector"`
+		}
+
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		// Expand the selector against the DB once, up front, so every camera
+		// acted on below comes from the same resolved list
+		if req.Selector != nil {
+			selected, err := selectStoredCameras(store, req.Selector)
+			if err != nil {
+				respondSelectorError(c, err)
+				return
+			}
+
+			listed := make(map[string]bool, len(req.Cameras))
+			for _, camera := range req.Cameras {
+				listed[camera.CameraID] = true
+			}
+			for _, camera := range selected {
+				if listed[camera.ID] || camera.RTSPURL == "" {
+					continue
+				}
+				req.Cameras = append(req.Cameras, BatchCamera{
+					CameraID: camera.ID,
+					RTSPURL:  camera.RTSPURL,
+					Name:     camera.Name,
+				})
+			}
+		}
+
+		if len(req.Cameras) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid request: %v", err),
+				"error": "No cameras matched the request",
 			})
 			return
 		}
 
-		// Check if batch would exceed limit
-		processMutex.RLock()
-		activeCount := len(activeProcesses)
-		processMutex.RUnlock()
+		// Check if batch would exceed limit, by the weight of each camera's stored options
+		batchWeight := 0
+		for _, camera := range req.Cameras {
+			batchWeight += streamWeight(loadStreamOptions(store, camera.CameraID))
+		}
+		used := usedCapacity()
 
-		if activeCount+len(req.Cameras) > workerConfig.MaxConcurrentStreams {
+		if used+batchWeight > currentWorkerConfig().MaxConcurrentStreams {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": fmt.Sprintf("Batch would exceed max concurrent streams (%d/%d)",
-					activeCount+len(req.Cameras), workerConfig.MaxConcurrentStreams),
+					used+batchWeight, currentWorkerConfig().MaxConcurrentStreams),
 			})
 			return
 		}
@@ -885,25 +2007,26 @@ func main() {
 		// Start cameras concurrently
 		for _, camera := range req.Cameras {
 			wg.Add(1)
-			go func(cam struct {
-				CameraID string `json:"cameraId" binding:"required"`
-				RTSPURL  string `json:"rtspUrl" binding:"required"`
-				Name     string `json:"name"`
-			}) {
+			go func(cam BatchCamera) {
 				defer wg.Done()
 
-				pathName := fmt.Sprintf("camera_%s", cam.CameraID)
+				pathName := cameraPathName(cam.CameraID)
 				result := BatchResult{
 					CameraID: cam.CameraID,
 					PathName: pathName,
 				}
 
 				// Stop any existing process
-				stopReencodingProcess(cam.CameraID)
-				time.Sleep(500 * time.Millisecond)
+				previousMediaMTX := activeMediaMTX(cam.CameraID)
+				if stopReencodingProcess(cam.CameraID) {
+					waitForStreamStopped(previousMediaMTX, pathName)
+				}
 
 				// Start re-encoding
-				err := startReencodingProcess(cam.CameraID, cam.RTSPURL)
+				options, err := resolveStreamOptions(store, cam.CameraID, StreamOptions{Audio: cam.Audio, Encoding: cam.Encoding})
+				if err == nil {
+					err = startReencodingProcess(store, cam.CameraID, cam.RTSPURL, options)
+				}
 				if err != nil {
 					result.Success = false
 					result.Error = err.Error()
@@ -929,11 +2052,17 @@ func main() {
 			}
 		}
 
+		resolvedCameras := make([]string, 0, len(req.Cameras))
+		for _, camera := range req.Cameras {
+			resolvedCameras = append(resolvedCameras, camera.CameraID)
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"message":    fmt.Sprintf("Batch processing completed: %d/%d successful", successCount, len(req.Cameras)),
 			"total":      len(req.Cameras),
 			"successful": successCount,
 			"failed":     len(req.Cameras) - successCount,
+			"cameras":    resolvedCameras,
 			"results":    results,
 		})
 	})
@@ -955,25 +2084,644 @@ func main() {
 		// Stop the re-encoding process
 		stopReencodingProcess(req.CameraID)
 
-		// // Clean up MediaMTX path
-		pathName := fmt.Sprintf("camera_%s", req.CameraID)
-		// if err := cleanupMediaMTXPath(pathName); err != nil {
-		// 	log.Printf("Warning: Failed to cleanup MediaMTX path %s: %v", pathName, err)
-		// 	// Don't fail the entire request just because cleanup failed
-		// }
+		// The process monitor has removed the MediaMTX path by now, once FFmpeg exited
+		pathName := cameraPathName(req.CameraID)
 
 		log.Printf("Successfully stopped processing for camera %s", req.CameraID)
 		c.JSON(http.StatusOK, gin.H{
-			"message":  fmt.Sprintf("Stopped processing for camera %s", req.CameraID),
-			"pathName": pathName,
+			"message":  fmt.Sprintf("Stopped processing for camera %s", req.CameraID),
+			"pathName": pathName,
+		})
+	})
+
+	// POST /kill/:cameraId - Stop a camera and escalate on any FFmpeg process that ignores it
+	r.POST("/kill/:cameraId", func(c *gin.Context) {
+		cameraID := c.Param("cameraId")
+		if !cameraIDPattern.MatchString(cameraID) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid camera ID",
+			})
+			return
+		}
+
+		stopped := stopReencodingProcess(cameraID)
+		processes := ffmpegProcesses.Processes(cameraID)
+		if !stopped && len(processes) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("No FFmpeg process found for camera %s", cameraID),
+			})
+			return
+		}
+
+		results := make([]ProcessKillResult, 0, len(processes))
+		status := http.StatusOK
+		for _, tracked := range processes {
+			result := killProcessEscalating(tracked)
+			log.Printf("Kill of FFmpeg process %d for camera %s: %s -> %s (method %q, attempts %v)",
+				result.PID, cameraID, result.StateBefore, result.StateAfter, result.Method, result.Attempts)
+			if !result.Terminated() {
+				status = http.StatusInternalServerError
+			}
+			results = append(results, result)
+		}
+
+		c.JSON(status, gin.H{
+			"cameraId":  cameraID,
+			"stopped":   stopped, // Had an active stream that was stopped first
+			"processes": results,
+		})
+	})
+
+	// GET /snapshot/:cameraId - One JPEG frame of the camera, cached for a few seconds
+	r.GET("/snapshot/:cameraId", func(c *gin.Context) {
+		cameraID := c.Param("cameraId")
+		if !cameraIDPattern.MatchString(cameraID) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid camera ID",
+			})
+			return
+		}
+
+		snapshot, cached, err := snapshotCache.Get(c.Request.Context(), store, cameraID)
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errUnknownCamera) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("Camera %s not found", cameraID),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": fmt.Sprintf("Failed to capture snapshot of camera %s: %v", cameraID, err),
+			})
+			return
+		}
+
+		c.Header("Last-Modified", snapshot.CapturedAt.UTC().Format(http.TimeFormat))
+		c.Header("X-Snapshot-Source", snapshot.Source)
+		if cached {
+			c.Header("X-Snapshot-Cache", "hit")
+		} else {
+			c.Header("X-Snapshot-Cache", "miss")
+		}
+		c.Data(http.StatusOK, "image/jpeg", snapshot.Image)
+	})
+
+	// POST /snapshots - Grab one frame from many cameras at once, as JSON or a contact sheet
+	r.POST("/snapshots", func(c *gin.Context) {
+		var req struct {
+			CameraIDs []string        `json:"cameraIds" binding:"required_without=Selector"`
+			Selector  *CameraSelector `json:"selector"`
+			Format    string          `json:"format"` // "json" (default) or "sheet"
+			TimeoutMs int             `json:"timeoutMs"`
+			Columns   int             `json:"columns"`
+			TileWidth int             `json:"tileWidth"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+		if req.Format != "" && req.Format != "json" && req.Format != "sheet" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid format %q (must be json or sheet)", req.Format)})
+			return
+		}
+		if req.TileWidth < 0 || req.TileWidth > 1920 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tileWidth must be between 0 and 1920"})
+			return
+		}
+
+		cameraIDs := make([]string, 0, len(req.CameraIDs))
+		seen := make(map[string]bool)
+		for _, cameraID := range req.CameraIDs {
+			if cameraID != "" && !seen[cameraID] {
+				seen[cameraID] = true
+				cameraIDs = append(cameraIDs, cameraID)
+			}
+		}
+		if req.Selector != nil {
+			selected, err := selectStoredCameras(store, req.Selector)
+			if err != nil {
+				respondSelectorError(c, err)
+				return
+			}
+			for _, camera := range selected {
+				if !seen[camera.ID] {
+					seen[camera.ID] = true
+					cameraIDs = append(cameraIDs, camera.ID)
+				}
+			}
+		}
+		if len(cameraIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No cameras matched the request"})
+			return
+		}
+
+		timeout := defaultSnapshotTimeout
+		if req.TimeoutMs > 0 {
+			timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+		}
+		if timeout > maxSnapshotTimeout {
+			timeout = maxSnapshotTimeout
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		snapshots, failures := grabSnapshots(ctx, store, cameraIDs, getEnvInt("SNAPSHOT_CONCURRENCY", defaultSnapshotWorkers))
+
+		if req.Format == "sheet" {
+			sheet, err := buildContactSheet(cameraIDs, snapshots, req.Columns, req.TileWidth)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			missing := make([]string, 0, len(failures))
+			for _, cameraID := range cameraIDs {
+				if _, failed := failures[cameraID]; failed {
+					missing = append(missing, cameraID)
+				}
+			}
+			c.Header("X-Snapshot-Cameras", strings.Join(cameraIDs, ","))
+			c.Header("X-Snapshot-Missing", strings.Join(missing, ","))
+			c.Data(http.StatusOK, "image/jpeg", sheet)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"snapshots": snapshots,
+			"errors":    failures,
+			"requested": len(cameraIDs),
+			"captured":  len(snapshots),
+			"complete":  len(failures) == 0,
+		})
+	})
+
+	// POST /stop-all - Stop every active camera, or those matching a selector
+	r.POST("/stop-all", func(c *gin.Context) {
+		var req struct {
+			Selector *CameraSelector `json:"selector"`
+		}
+
+		// An empty body stops everything. Decoding tells, where ContentLength is -1
+		// for a chunked body whether or not it's empty.
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+
+		// Resolve against one snapshot of the active set; cameras started after
+		// this point are not touched by this request
+		cameraIDs, err := selectActiveCameras(store, req.Selector)
+		if err != nil {
+			respondSelectorError(c, err)
+			return
+		}
+
+		// Stopped like DELETE /streams, a few at a time
+		log.Printf("Stopping %d cameras, %d at a time", len(cameraIDs), streamDrainWorkers)
+		drained := drainStreams(cameraIDs, streamDrainWorkers)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     fmt.Sprintf("Stopped %d cameras", len(cameraIDs)),
+			"total":       len(cameraIDs),
+			"cameras":     cameraIDs,
+			"stopped":     drained.Stopped,
+			"forceKilled": drained.ForceKilled,
+			"durationMs":  drained.DurationMs,
+		})
+	})
+
+	// POST /face-detection/toggle - Toggle face detection for a camera. The choice (and any
+	// interval/threshold) is saved to the camera so auto-restarts and restoreActivePaths keep it.
+	r.POST("/face-detection/toggle", func(c *gin.Context) {
+		var req struct {
+			CameraID   string   `json:"cameraId" binding:"required,cameraid"`
+			Enabled    bool     `json:"enabled"`
+			Inherit    bool     `json:"inherit"` // Drop the camera's override and follow its group; enabled is ignored
+			IntervalMs *int     `json:"intervalMs" binding:"omitempty,min=100"`
+			Threshold  *float64 `json:"threshold" binding:"omitempty,gt=0,lte=1"`
+		}
+
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		// The camera's override is stored as is, so an explicit disable wins over a group
+		// that enables detection; inherit clears it and applies what the group resolves to
+		override := &req.Enabled
+		if req.Inherit {
+			override = nil
+			cameraPolicy, group, err := store.GetFaceDetectionPolicies(req.CameraID)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": fmt.Sprintf("Failed to read the group policy of camera %s: %v", req.CameraID, err),
+				})
+				return
+			}
+			cameraPolicy.Enabled = nil
+			req.Enabled = resolveFaceDetectionSettings(cameraPolicy, group, faceDetector).Enabled
+		}
+
+		log.Printf("Toggle face detection for camera %s: %v (inherit %v)", req.CameraID, req.Enabled, req.Inherit)
+
+		// persist saves the toggle before detection (re)starts, so startFaceDetection
+		// resolves the new interval/threshold. Without a store the toggle is runtime-only.
+		persist := func() bool {
+			policy := FaceDetectionPolicy{Enabled: override, IntervalMs: req.IntervalMs, Threshold: req.Threshold}
+			if err := store.SaveCameraFaceDetection(req.CameraID, policy, policyMerge); err != nil {
+				log.Printf("Warning: face detection toggle for camera %s not persisted, it won't survive a restart: %v", req.CameraID, err)
+				return false
+			}
+			return true
+		}
+
+		if req.Enabled {
+			// Nothing would run: the model failed to load or detection is switched off
+			if capabilities := faceDetectionCapabilities(); !capabilities.Available {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error":        fmt.Sprintf("Face detection is unavailable on this worker: %s", capabilities.Reason),
+					"capabilities": capabilities,
+				})
+				return
+			}
+
+			// Start face detection if not already running
+			processMutex.RLock()
+			process, exists := activeProcesses[req.CameraID]
+			processMutex.RUnlock()
+
+			if !exists {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Camera is not actively streaming. Start the camera first.",
+				})
+				return
+			}
+
+			// Get RTSP URL from database
+			rtspURL, _, _, err := store.GetCameraInfo(req.CameraID)
+			if err != nil {
+				log.Printf("Failed to get camera info: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("Failed to get camera info: %v", err),
+				})
+				return
+			}
+
+			persisted := persist()
+
+			// Check if face detection is already active
+			faceDetectionMutex.RLock()
+			_, alreadyActive := faceDetectionActive[req.CameraID]
+			faceDetectionMutex.RUnlock()
+
+			if alreadyActive && req.IntervalMs == nil && req.Threshold == nil {
+				c.JSON(http.StatusOK, gin.H{
+					"message":   "Face detection already active for this camera",
+					"cameraId":  req.CameraID,
+					"enabled":   true,
+					"persisted": persisted,
+				})
+				return
+			}
+			// Register under processMutex so a concurrent stop either runs first (and the
+			// process is gone) or waits and cancels this detection with the process.
+			// An active detection is replaced so a new interval/threshold applies.
+			processMutex.RLock()
+			if activeProcesses[req.CameraID] != process {
+				processMutex.RUnlock()
+				c.JSON(http.StatusConflict, gin.H{
+					"error":     "Camera stopped or restarted while enabling face detection, try again",
+					"persisted": persisted,
+				})
+				return
+			}
+			faceDetectionCtx := registerFaceDetection(req.CameraID, process.Context)
+			processMutex.RUnlock()
+
+			startFaceDetection(store, req.CameraID, detectionSourceURL(rtspURL, process.TargetURL, process.Options), process.Options, faceDetectionCtx)
+
+			log.Printf("Face detection started for camera %s", req.CameraID)
+			c.JSON(http.StatusOK, gin.H{
+				"message":   "Face detection enabled successfully",
+				"cameraId":  req.CameraID,
+				"enabled":   true,
+				"persisted": persisted,
+			})
+		} else {
+			// Stop face detection
+			persisted := persist()
+			stopFaceDetection(req.CameraID)
+			resumeFreezeDetection(req.CameraID) // The stream's own freeze check takes over again
+
+			log.Printf("Face detection stopped for camera %s", req.CameraID)
+			c.JSON(http.StatusOK, gin.H{
+				"message":   "Face detection disabled successfully",
+				"cameraId":  req.CameraID,
+				"enabled":   false,
+				"persisted": persisted,
+			})
+		}
+	})
+
+	// GET /face-detection/capabilities - Whether detection is available and which model is loaded
+	r.GET("/face-detection/capabilities", func(c *gin.Context) {
+		c.JSON(http.StatusOK, faceDetectionCapabilities())
+	})
+
+	// GET /face-detection/stats - Rolling face detection event counts per camera
+	r.GET("/face-detection/stats", func(c *gin.Context) {
+		windows := []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour}
+		if windowsParam := c.Query("windows"); windowsParam != "" {
+			windows = windows[:0]
+			for _, part := range strings.Split(windowsParam, ",") {
+				window, err := time.ParseDuration(strings.TrimSpace(part))
+				if err != nil || window <= 0 || window > faceStatsRetention {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error": fmt.Sprintf("Invalid window %q (must be a duration up to %v)", part, faceStatsRetention),
+					})
+					return
+				}
+				windows = append(windows, window)
+			}
+		}
+
+		cameras := faceDetectionStats.Summary(c.Query("cameraId"), windows)
+		c.JSON(http.StatusOK, gin.H{
+			"cameras": cameras,
+			"total":   len(cameras),
+		})
+	})
+
+	// GET /detections - Stored face detections, newest first (DETECTION_STORE_ENABLED)
+	r.GET("/detections", func(c *gin.Context) {
+		if detectionStore == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Detection storage is not enabled (set DETECTION_STORE_ENABLED=true with a database)",
+			})
+			return
+		}
+
+		filter := DetectionFilter{CameraID: c.Query("cameraId")}
+		var err error
+		if filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "100")); err != nil || filter.Limit < 1 || filter.Limit > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "limit must be between 1 and 1000",
+			})
+			return
+		}
+		if filter.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil || filter.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "offset must be a non-negative integer",
+			})
+			return
+		}
+		if value := c.Query("minFaceCount"); value != "" {
+			if filter.MinFaceCount, err = strconv.Atoi(value); err != nil || filter.MinFaceCount < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "minFaceCount must be a non-negative integer",
+				})
+				return
+			}
+		}
+		for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("%s must be an RFC 3339 timestamp", param),
+				})
+				return
+			}
+		}
+
+		detections, total, err := detectionStore.Query(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to query detections: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"detections": detections,
+			"total":      total,
+			"limit":      filter.Limit,
+			"offset":     filter.Offset,
+		})
+	})
+
+	// GET /metadata/:cameraId/events - Live detection cues as Server-Sent Events, for player overlays
+	r.GET("/metadata/:cameraId/events", func(c *gin.Context) {
+		cameraID := c.Param("cameraId")
+		cues, unsubscribe := detectionMetadata.Subscribe(cameraID)
+		defer unsubscribe()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case cue := <-cues:
+				c.SSEvent("detection", cue)
+				return true
+			case <-keepAlive.C:
+				c.SSEvent("ping", gin.H{"at": time.Now()})
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
+
+	// GET /logs/:cameraId - The camera's buffered FFmpeg stderr lines
+	r.GET("/logs/:cameraId", func(c *gin.Context) {
+		lines := ffmpegLogs.Recent(c.Param("cameraId"))
+		c.JSON(http.StatusOK, gin.H{
+			"cameraId": c.Param("cameraId"),
+			"lines":    lines,
+			"total":    len(lines),
+		})
+	})
+
+	// GET /logs/:cameraId/stream - Buffered FFmpeg stderr lines, then new ones as Server-Sent
+	// Events until the client disconnects or the process stops
+	r.GET("/logs/:cameraId/stream", func(c *gin.Context) {
+		cameraID := c.Param("cameraId")
+		history, sub := ffmpegLogs.Subscribe(cameraID)
+		if sub == nil && len(history) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("No FFmpeg logs for camera %s", cameraID)})
+			return
+		}
+		if sub != nil {
+			defer ffmpegLogs.Unsubscribe(cameraID, sub)
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		for _, line := range history {
+			c.SSEvent("log", line)
+		}
+		if sub == nil {
+			c.SSEvent("end", gin.H{"reason": "process not running"})
+			return
+		}
+
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case line, open := <-sub.lines:
+				if !open {
+					c.SSEvent("end", gin.H{"reason": "process stopped"})
+					return false
+				}
+				if dropped := ffmpegLogs.TakeDropped(sub); dropped > 0 {
+					c.SSEvent("dropped", gin.H{"lines": dropped})
+				}
+				c.SSEvent("log", line)
+				return true
+			case <-keepAlive.C:
+				c.SSEvent("ping", gin.H{"at": time.Now()})
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
+
+	// GET /metadata/:cameraId/track.vtt?since= - Recent detection cues as a WebVTT metadata track
+	r.GET("/metadata/:cameraId/track.vtt", func(c *gin.Context) {
+		var since time.Time
+		if sinceParam := c.Query("since"); sinceParam != "" {
+			parsed, err := time.Parse(time.RFC3339, sinceParam)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid since %q (must be RFC3339)", sinceParam)})
+				return
+			}
+			since = parsed
+		}
+
+		cues := detectionMetadata.Recent(c.Param("cameraId"), since)
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "text/vtt; charset=utf-8", []byte(renderWebVTT(cues)))
+	})
+
+	// GET /face-detection/settings?cameraId= - Effective face detection settings and where each came from
+	r.GET("/face-detection/settings", func(c *gin.Context) {
+		cameraID := c.Query("cameraId")
+		if cameraID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cameraId is required"})
+			return
+		}
+
+		settings, err := getFaceDetectionSettings(store, cameraID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to load face detection settings: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"cameraId": cameraID,
+			"settings": settings,
+		})
+	})
+
+	// GET /events?cameraId=&since= - Recent stream lifecycle events (e.g. evictions)
+	r.GET("/events", func(c *gin.Context) {
+		var since time.Time
+		if sinceParam := c.Query("since"); sinceParam != "" {
+			parsed, err := time.Parse(time.RFC3339, sinceParam)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Invalid since %q (expected RFC3339)", sinceParam),
+				})
+				return
+			}
+			since = parsed
+		}
+
+		events := streamEvents.Recent(c.Query("cameraId"), since)
+		c.JSON(http.StatusOK, gin.H{
+			"events": events,
+			"total":  len(events),
+		})
+	})
+
+	// GET /groups - List camera groups and their members
+	r.GET("/groups", func(c *gin.Context) {
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+			return
+		}
+
+		groups, err := store.ListGroups()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to list groups: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"groups": groups,
+			"total":  len(groups),
+		})
+	})
+
+	// POST /groups - Create a group, or update the face detection policy of an existing one by name
+	r.POST("/groups", func(c *gin.Context) {
+		var req struct {
+			Name          string              `json:"name" binding:"required"`
+			FaceDetection FaceDetectionPolicy `json:"faceDetection"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+		if err := req.FaceDetection.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid face detection policy: %v", err),
+			})
+			return
+		}
+
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+			return
+		}
+
+		group, err := store.SaveGroup(CameraGroup{Name: req.Name, FaceDetection: req.FaceDetection})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to save group: %v", err),
+			})
+			return
+		}
+
+		log.Printf("Saved camera group %s (%s)", group.Name, group.ID)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Group saved; settings apply the next time face detection starts",
+			"group":   group,
 		})
 	})
 
-	// POST /face-detection/toggle - Toggle face detection for a camera
-	r.POST("/face-detection/toggle", func(c *gin.Context) {
+	// POST /groups/:id/cameras - Assign cameras to a group
+	// DELETE /groups/:id/cameras - Remove cameras from a group
+	assignGroupCameras := func(c *gin.Context) {
 		var req struct {
-			CameraID string `json:"cameraId" binding:"required"`
-			Enabled  bool   `json:"enabled"`
+			CameraIDs []string `json:"cameraIds" binding:"required,min=1"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -983,83 +2731,48 @@ func main() {
 			return
 		}
 
-		log.Printf("Toggle face detection for camera %s: %v", req.CameraID, req.Enabled)
-
-		if req.Enabled {
-			// Start face detection if not already running
-			processMutex.RLock()
-			process, exists := activeProcesses[req.CameraID]
-			processMutex.RUnlock()
-
-			if !exists {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "Camera is not actively streaming. Start the camera first.",
-				})
-				return
-			}
-
-			// Get RTSP URL from database
-			rtspURL, _, _, err := getCameraInfo(req.CameraID)
-			if err != nil {
-				log.Printf("Failed to get camera info: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": fmt.Sprintf("Failed to get camera info: %v", err),
-				})
-				return
-			}
-
-			// Check if face detection is already active
-			faceDetectionMutex.RLock()
-			_, alreadyActive := faceDetectionActive[req.CameraID]
-			faceDetectionMutex.RUnlock()
-
-			if alreadyActive {
-				c.JSON(http.StatusOK, gin.H{
-					"message":  "Face detection already active for this camera",
-					"cameraId": req.CameraID,
-					"enabled":  true,
-				})
-				return
-			}
-
-			// Start face detection
-			faceDetectionCtx, faceDetectionCancel := context.WithCancel(process.Context)
-			faceDetectionMutex.Lock()
-			faceDetectionActive[req.CameraID] = faceDetectionCancel
-			faceDetectionMutex.Unlock()
-
-			startFaceDetection(req.CameraID, rtspURL, faceDetectionCtx)
+		if !store.Available() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+			return
+		}
 
-			log.Printf("Face detection started for camera %s", req.CameraID)
-			c.JSON(http.StatusOK, gin.H{
-				"message":  "Face detection enabled successfully",
-				"cameraId": req.CameraID,
-				"enabled":  true,
-			})
-		} else {
-			// Stop face detection
-			stopFaceDetection(req.CameraID)
+		groupID := c.Param("id")
+		assignTo := groupID
+		if c.Request.Method == http.MethodDelete {
+			assignTo = ""
+		}
 
-			log.Printf("Face detection stopped for camera %s", req.CameraID)
-			c.JSON(http.StatusOK, gin.H{
-				"message":  "Face detection disabled successfully",
-				"cameraId": req.CameraID,
-				"enabled":  false,
+		if err := store.AssignCamerasToGroup(assignTo, req.CameraIDs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Failed to update group membership: %v", err),
 			})
+			return
 		}
-	})
+
+		c.JSON(http.StatusOK, gin.H{
+			"groupId":   groupID,
+			"cameraIds": req.CameraIDs,
+			"assigned":  assignTo != "",
+		})
+	}
+	r.POST("/groups/:id/cameras", assignGroupCameras)
+	r.DELETE("/groups/:id/cameras", assignGroupCameras)
 
 	// WebRTC offer endpoint - now redirects to unified processing
 	r.POST("/webrtc/offer", func(c *gin.Context) {
-		var req WebRTCOfferRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, WebRTCOfferResponse{
+		if reason, refused := maintenanceRejection(); refused {
+			c.JSON(http.StatusServiceUnavailable, WebRTCOfferResponse{
 				Status: "error",
-				Error:  fmt.Sprintf("Invalid request: %v", err),
+				Error:  reason,
 			})
 			return
 		}
 
+		var req WebRTCOfferRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
 		log.Printf("Received WebRTC offer for camera %s, redirecting to unified processing", req.CameraID)
 
 		// // Forward to unified processing endpoint
@@ -1074,17 +2787,18 @@ func main() {
 		// }
 
 		// Call unified processing internally
-		pathName := fmt.Sprintf("camera_%s", req.CameraID)
-
-		// Stop any existing process for this camera first
-		// This will also clean up the MediaMTX path
-		stopReencodingProcess(req.CameraID)
+		pathName := cameraPathName(req.CameraID)
 
-		// Wait a moment for cleanup to complete
-		time.Sleep(500 * time.Millisecond)
+		// Stop any existing process for this camera first and confirm
+		// MediaMTX has dropped its publisher before starting a new one
+		previousMediaMTX := activeMediaMTX(req.CameraID)
+		if stopReencodingProcess(req.CameraID) {
+			waitForStreamStopped(previousMediaMTX, pathName)
+		}
 
 		// Start re-encoding process
-		err := startReencodingProcess(req.CameraID, req.RTSPURL)
+		options := loadStreamOptions(store, req.CameraID)
+		err := startReencodingProcess(store, req.CameraID, req.RTSPURL, options)
 		if err != nil {
 			log.Printf("Failed to start re-encoding process: %v", err)
 			c.JSON(http.StatusInternalServerError, WebRTCOfferResponse{
@@ -1104,49 +2818,151 @@ func main() {
 			Answer:    "",
 			SessionID: pathName,
 			Status:    "mediamtx_configured",
+			WebRTCURL: fmt.Sprintf("%s/%s", options.MediaMTX.webrtcBaseURL(), pathName),
 		})
 	})
 
-	// Restore active camera paths after MediaMTX is ready
-	log.Println("Scheduling path restoration after MediaMTX initialization...")
-	go func() {
-		// Wait longer for MediaMTX to be fully ready (increased from 5s to 10s)
-		time.Sleep(10 * time.Second)
-		restoreActivePaths()
-	}()
+	// POST /whep/:cameraId - WHEP playback: answer an SDP offer with the camera's stream
+	r.POST("/whep/:cameraId", func(c *gin.Context) {
+		if reason, refused := maintenanceRejection(); refused {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
+			return
+		}
+		cameraID := c.Param("cameraId")
+		if !cameraIDPattern.MatchString(cameraID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid camera ID"})
+			return
+		}
+		offer, err := readSDPOffer(c.Request)
+		if errors.Is(err, errNotSDP) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	// Setup cleanup on shutdown
-	defer func() {
-		log.Println("Shutting down worker service...")
+		process, sourceURL, err := whepSourceURL(cameraID)
+		if process == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 
-		// Close Kafka producer
-		if kafkaProducer != nil {
-			log.Println("Closing Kafka producer...")
-			if err := kafkaProducer.Close(); err != nil {
-				log.Printf("Error closing Kafka producer: %v", err)
-			}
+		session, answer, err := startWHEPSession(process, sourceURL, offer)
+		var unsupportedCodecErr *UnsupportedCodecError
+		if errors.Is(err, ErrWHEPSessionLimit) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		} else if errors.As(err, &unsupportedCodecErr) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "codecs": unsupportedCodecErr.Codecs})
+			return
+		} else if err != nil {
+			log.Printf("WHEP offer for camera %s failed: %v", cameraID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		// Close face detector
-		if faceDetector != nil {
-			log.Println("Closing face detector...")
-			faceDetector.Close()
+		c.Header("Location", fmt.Sprintf("/whep/%s/%s", cameraID, session.ID))
+		c.Data(http.StatusCreated, sdpContentType, []byte(answer))
+	})
+
+	// DELETE /whep/:cameraId/:sessionId - End a WHEP session
+	r.DELETE("/whep/:cameraId/:sessionId", func(c *gin.Context) {
+		session, exists := lookupWHEPSession(c.Param("cameraId"), c.Param("sessionId"))
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "WHEP session not found"})
+			return
 		}
+		session.Close("closed by client")
+		c.Status(http.StatusOK)
+	})
 
-		log.Println("Worker service shutdown complete")
-	}()
+	// POST /whip/:cameraId - WHIP ingest: publish an encoder's WebRTC stream into the camera's path
+	r.POST("/whip/:cameraId", func(c *gin.Context) {
+		if reason, refused := maintenanceRejection(); refused {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
+			return
+		}
+		cameraID := c.Param("cameraId")
+		if !cameraIDPattern.MatchString(cameraID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid camera ID"})
+			return
+		}
+		offer, err := readSDPOffer(c.Request)
+		if errors.Is(err, errNotSDP) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	// Start server
-	fmt.Printf("Worker service starting on port %s\n", port)
-	log.Fatal(r.Run(":" + port))
-}
+		// The camera's re-encoder already publishes to the path
+		processMutex.RLock()
+		_, streaming := activeProcesses[cameraID]
+		processMutex.RUnlock()
+		if streaming {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": fmt.Sprintf("Camera %s is being re-encoded into its path; stop it before publishing over WHIP", cameraID),
+			})
+			return
+		}
 
-// cleanupMediaMTXPath removes a path from MediaMTX
-func cleanupMediaMTXPath(pathName string) error {
-	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-	if mediamtxAPIURL == "" {
-		mediamtxAPIURL = "http://localhost:9997"
+		session, answer, err := startWHIPSession(loadStreamOptions(store, cameraID).MediaMTX, cameraID, offer)
+		var upstreamErr *WHIPUpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.StatusCode >= 400 && upstreamErr.StatusCode < 500 {
+			c.JSON(upstreamErr.StatusCode, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			log.Printf("WHIP offer for camera %s failed: %v", cameraID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Location", fmt.Sprintf("/whip/%s/%s", cameraID, session.ID))
+		c.Data(http.StatusCreated, sdpContentType, []byte(answer))
+	})
+
+	// DELETE /whip/:cameraId/:sessionId - End a WHIP session
+	r.DELETE("/whip/:cameraId/:sessionId", func(c *gin.Context) {
+		session, exists := lookupWHIPSession(c.Param("cameraId"), c.Param("sessionId"))
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "WHIP session not found"})
+			return
+		}
+		if err := session.Close(); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	// Trickle ICE and ICE restarts aren't supported: offers and answers carry every candidate
+	trickleUnsupported := func(c *gin.Context) {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Trickle ICE is not supported"})
 	}
+	r.PATCH("/whep/:cameraId/:sessionId", trickleUnsupported)
+	r.PATCH("/whip/:cameraId/:sessionId", trickleUnsupported)
+
+	// GET /config - Effective configuration clients need, currently the ICE servers
+	// of the worker's WebRTC sessions
+	r.GET("/config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"webrtc": gin.H{
+				"ice": iceConfig.View(),
+			},
+		})
+	})
+
+	return r
+}
+
+// cleanupMediaMTXPath removes a path from MediaMTX and records that in the store
+func cleanupMediaMTXPath(store CameraStore, instance *MediaMTXInstance, pathName string) error {
+	mediamtxAPIURL := instance.apiBaseURL()
 
 	// Delete the path
 	deleteURL := mediamtxAPIURL + "/v3/config/paths/delete/" + pathName
@@ -1154,17 +2970,16 @@ func cleanupMediaMTXPath(pathName string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create delete request: %w", err)
 	}
-	deleteReq.SetBasicAuth("admin", "admin")
 
 	client := &http.Client{Timeout: 10 * time.Second}
-	deleteResp, err := client.Do(deleteReq)
+	deleteResp, err := mediamtxDo(client, deleteReq)
 	if err != nil {
 		return fmt.Errorf("failed to delete path: %w", err)
 	}
 	defer deleteResp.Body.Close()
 
 	if deleteResp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(deleteResp.Body)
+		body := mediamtxErrorBody(deleteResp)
 		// Don't treat "path not found" as an error
 		if deleteResp.StatusCode == http.StatusNotFound {
 			log.Printf("MediaMTX path %s was already deleted or didn't exist", pathName)
@@ -1176,18 +2991,19 @@ func cleanupMediaMTXPath(pathName string) error {
 	log.Printf("Successfully cleaned up MediaMTX path: %s", pathName)
 
 	// Update database to reflect path cleanup
-	cameraID := getCorrespondingCameraID(pathName)
-	updateCameraPathInfo(cameraID, pathName, false)
+	cameraID, ok := getCorrespondingCameraID(pathName)
+	if !ok {
+		log.Printf("Warning: MediaMTX path %s isn't a worker camera path, not updating the database", pathName)
+		return nil
+	}
+	store.UpdateCameraPathInfo(cameraID, pathName, false)
 
 	return nil
 }
 
 // forceCleanupMediaMTXPath forcefully removes a path from MediaMTX with multiple attempts
-func forceCleanupMediaMTXPath(pathName string) error {
-	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-	if mediamtxAPIURL == "" {
-		mediamtxAPIURL = "http://localhost:9997"
-	}
+func forceCleanupMediaMTXPath(instance *MediaMTXInstance, pathName string) error {
+	mediamtxAPIURL := instance.apiBaseURL()
 
 	client := &http.Client{Timeout: 5 * time.Second}
 
@@ -1201,9 +3017,8 @@ func forceCleanupMediaMTXPath(pathName string) error {
 		if err != nil {
 			return fmt.Errorf("failed to create delete request: %w", err)
 		}
-		deleteReq.SetBasicAuth("admin", "admin")
 
-		deleteResp, err := client.Do(deleteReq)
+		deleteResp, err := mediamtxDo(client, deleteReq)
 		if err != nil {
 			log.Printf("Delete attempt %d failed: %v", attempt, err)
 			if attempt < 3 {
@@ -1219,8 +3034,8 @@ func forceCleanupMediaMTXPath(pathName string) error {
 			return nil
 		}
 
-		body, _ := io.ReadAll(deleteResp.Body)
-		log.Printf("Delete attempt %d failed with status %d: %s", attempt, deleteResp.StatusCode, string(body))
+		body := mediamtxErrorBody(deleteResp)
+		log.Printf("Delete attempt %d failed with status %d: %s", attempt, deleteResp.StatusCode, body)
 
 		if attempt < 3 {
 			time.Sleep(time.Duration(attempt) * time.Second)
@@ -1230,22 +3045,147 @@ func forceCleanupMediaMTXPath(pathName string) error {
 	return fmt.Errorf("failed to force cleanup path %s after 3 attempts", pathName)
 }
 
+// Path conflict policies for configureMediaMTXPath, selected by MEDIAMTX_PATH_CONFLICT_POLICY
+const (
+	pathConflictOverwrite = "overwrite" // Delete the existing path and configure ours (default)
+	pathConflictSkip      = "skip"      // Leave the existing path alone and report it skipped
+	pathConflictError     = "error"     // Refuse to touch the path and return an error
+
+	unknownPathOwner = "unknown" // A path whose config couldn't be read
+)
+
+// mediamtxPublisherSource is the source of a path fed by whoever publishes to it, which
+// is how the worker's FFmpeg feeds a camera's path
+const mediamtxPublisherSource = "publisher"
+
+// PathConflictError reports a MediaMTX path configured with another source, which the
+// conflict policy leaves to its owner
+type PathConflictError struct {
+	PathName string
+	Owner    string // The path's configured source
+	Skipped  bool   // Left alone by the skip policy rather than refused by the error policy
+}
+
+func (e *PathConflictError) Error() string {
+	if e.Skipped {
+		return fmt.Sprintf("MediaMTX path %s belongs to another source (%s), skipped", e.PathName, e.Owner)
+	}
+	return fmt.Sprintf("MediaMTX path %s is already configured with a different source (%s)", e.PathName, e.Owner)
+}
+
+// getPathConflictPolicy returns the configured policy for paths owned by another source
+func getPathConflictPolicy() string {
+	switch policy := os.Getenv("MEDIAMTX_PATH_CONFLICT_POLICY"); policy {
+	case pathConflictSkip, pathConflictError:
+		return policy
+	case "", pathConflictOverwrite:
+		return pathConflictOverwrite
+	default:
+		log.Printf("Unknown MEDIAMTX_PATH_CONFLICT_POLICY %q, using %q", policy, pathConflictOverwrite)
+		return pathConflictOverwrite
+	}
+}
+
+// getMediaMTXPathSource returns the configured source of an existing MediaMTX path
+func getMediaMTXPathSource(instance *MediaMTXInstance, pathName string) (source string, exists bool, err error) {
+	mediamtxAPIURL := instance.apiBaseURL()
+
+	req, err := http.NewRequest("GET", mediamtxAPIURL+"/v3/config/paths/get/"+pathName, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := mediamtxDo(client, req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get path config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body := mediamtxErrorBody(resp)
+		return "", false, fmt.Errorf("failed to get path config %s: status %d, body: %s", pathName, resp.StatusCode, body)
+	}
+
+	var pathConfig struct {
+		Source string `json:"source"`
+	}
+	if err := decodeMediaMTXJSON(resp, &pathConfig); err != nil {
+		return "", true, fmt.Errorf("failed to parse path config %s: %w", pathName, err)
+	}
+	return pathConfig.Source, true, nil
+}
+
+// checkPathConflict applies the conflict policy to an existing path whose source isn't
+// want. A *PathConflictError means the caller must leave the path alone.
+func checkPathConflict(instance *MediaMTXInstance, pathName, want string) error {
+	existingSource, exists, err := getMediaMTXPathSource(instance, pathName)
+	if err != nil {
+		// Can't tell who owns the path; fall back to the overwrite behavior
+		log.Printf("Warning: Could not read existing config for path %s: %v", pathName, err)
+		return nil
+	}
+	if !exists || existingSource == want {
+		return nil
+	}
+	return applyPathConflictPolicy(pathName, existingSource)
+}
+
+// applyPathConflictPolicy decides what to do with a path owned by a different source:
+// nil to overwrite it, or a *PathConflictError to leave it to its owner
+func applyPathConflictPolicy(pathName, existingSource string) error {
+	switch getPathConflictPolicy() {
+	case pathConflictSkip:
+		log.Printf("MediaMTX path %s already points at a different source (%s), leaving it in place", pathName, existingSource)
+		return &PathConflictError{PathName: pathName, Owner: existingSource, Skipped: true}
+	case pathConflictError:
+		return &PathConflictError{PathName: pathName, Owner: existingSource}
+	default:
+		log.Printf("MediaMTX path %s points at a different source (%s), overwriting", pathName, existingSource)
+		return nil
+	}
+}
+
 // configureMediaMTXPath configures a path in MediaMTX via API and waits for it to be ready
-func configureMediaMTXPath(pathName, rtspURL string) error {
-	// Get MediaMTX API URL from environment or default
-	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-	if mediamtxAPIURL == "" {
-		mediamtxAPIURL = "http://localhost:9997"
+func configureMediaMTXPath(store CameraStore, instance *MediaMTXInstance, pathName, rtspURL string) error {
+	mediamtxAPIURL := instance.apiBaseURL()
+
+	// Look the path up first so the common case (no existing path) skips the cleanup round-trip
+	existingSource, exists, err := getMediaMTXPathSource(instance, pathName)
+	if err != nil {
+		// Can't tell whether the path exists; assume another source might own it, so
+		// only the overwrite policy goes on to delete it
+		log.Printf("Warning: Could not read existing config for path %s: %v", pathName, err)
+		if err := applyPathConflictPolicy(pathName, unknownPathOwner); err != nil {
+			return err
+		}
+		exists, existingSource = true, ""
 	}
 
-	// Ensure the path is clean before creating
-	log.Printf("Ensuring MediaMTX path %s is clean before configuration", pathName)
-	if err := cleanupMediaMTXPath(pathName); err != nil {
-		log.Printf("Warning: Failed to cleanup existing path %s: %v", pathName, err)
+	if exists && existingSource == rtspURL {
+		log.Printf("MediaMTX path %s is already configured with this source, skipping re-create", pathName)
+		return awaitMediaMTXPathReady(store, instance, pathName)
 	}
 
-	// Wait a moment for cleanup to complete
-	time.Sleep(500 * time.Millisecond)
+	if exists {
+		// Don't clobber a path another process configured unless policy allows it
+		if existingSource != "" {
+			if err := applyPathConflictPolicy(pathName, existingSource); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Removing existing MediaMTX path %s before configuration", pathName)
+		if err := cleanupMediaMTXPath(store, instance, pathName); err != nil {
+			log.Printf("Warning: Failed to cleanup existing path %s: %v", pathName, err)
+		}
+
+		// Confirm the delete took effect before re-adding
+		waitForPathRemoved(instance, pathName)
+	}
 
 	// MediaMTX API endpoint
 	apiURL := mediamtxAPIURL + "/v3/config/paths/add/" + pathName
@@ -1260,6 +3200,9 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 		"runOnDemand":    "",    // No demand command
 		"runOnReady":     "",    // No ready command
 	}
+	for key, value := range recordingConfig.mediamtxPathSettings() {
+		pathConfig[key] = value
+	}
 
 	// Convert to JSON
 	jsonData, err := json.Marshal(pathConfig)
@@ -1267,7 +3210,7 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 		return fmt.Errorf("failed to marshal path config: %w", err)
 	}
 
-	// Create HTTP request with basic auth and timeout
+	// Create HTTP request with timeout
 	client := &http.Client{Timeout: 10 * time.Second}
 	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -1275,13 +3218,14 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth("admin", "admin") // Default MediaMTX credentials
 
-	// Configure retry for MediaMTX API calls
+	// Configure retry for MediaMTX API calls, jittered so cameras configured together
+	// don't retry in lockstep
 	retryConfig := RetryConfig{
 		MaxAttempts: 3,
 		BaseDelay:   2 * time.Second,
 		MaxDelay:    10 * time.Second,
+		Jitter:      0.2,
 	}
 
 	var resp *http.Response
@@ -1292,10 +3236,9 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 			return fmt.Errorf("failed to create request: %w", reqErr)
 		}
 		retryReq.Header.Set("Content-Type", "application/json")
-		retryReq.SetBasicAuth("admin", "admin")
 
 		var httpErr error
-		resp, httpErr = client.Do(retryReq)
+		resp, httpErr = mediamtxDo(client, retryReq)
 		if httpErr != nil {
 			return fmt.Errorf("HTTP request failed: %w", httpErr)
 		}
@@ -1303,9 +3246,9 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 		// Don't defer close here since we need to use resp outside this function
 		// Check if the request was successful
 		if resp.StatusCode >= 500 {
-			body, _ := io.ReadAll(resp.Body)
+			body := mediamtxErrorBody(resp)
 			resp.Body.Close()
-			return fmt.Errorf("MediaMTX server error (status %d): %s", resp.StatusCode, string(body))
+			return fmt.Errorf("MediaMTX server error (status %d): %s", resp.StatusCode, body)
 		}
 
 		return nil
@@ -1317,7 +3260,7 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 	defer resp.Body.Close()
 
 	// Check response
-	body, err := io.ReadAll(resp.Body)
+	body, err := readMediaMTXBody(resp)
 	if err != nil {
 		return fmt.Errorf("failed to read API response body: %w", err)
 	}
@@ -1327,14 +3270,19 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 		log.Printf("MediaMTX API error - Status: %d, Response: %s, URL: %s",
 			resp.StatusCode, string(body), apiURL)
 
-		// Handle case where path already exists (shouldn't happen after cleanup)
+		// Handle case where path already exists (shouldn't happen after the lookup above)
 		if resp.StatusCode == http.StatusBadRequest && bytes.Contains(body, []byte("path already exists")) {
+			// Someone may have created it between our lookup and add
+			if err := checkPathConflict(instance, pathName, rtspURL); err != nil {
+				return err
+			}
+
 			log.Printf("MediaMTX path %s still exists after cleanup, forcing removal...", pathName)
 			// Force cleanup and try again
-			if err := forceCleanupMediaMTXPath(pathName); err != nil {
+			if err := forceCleanupMediaMTXPath(instance, pathName); err != nil {
 				return fmt.Errorf("failed to force cleanup path %s: %w", pathName, err)
 			}
-			time.Sleep(1 * time.Second)
+			waitForPathRemoved(instance, pathName)
 
 			// Retry the request - create new request to reset body
 			retryReq, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
@@ -1342,19 +3290,18 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 				return fmt.Errorf("failed to create retry request: %w", err)
 			}
 			retryReq.Header.Set("Content-Type", "application/json")
-			retryReq.SetBasicAuth("admin", "admin")
 
-			resp2, err := client.Do(retryReq)
+			resp2, err := mediamtxDo(client, retryReq)
 			if err != nil {
 				return fmt.Errorf("failed to retry API request: %w", err)
 			}
 			defer resp2.Body.Close()
 
 			if resp2.StatusCode != http.StatusOK {
-				body2, _ := io.ReadAll(resp2.Body)
+				body2 := mediamtxErrorBody(resp2)
 				log.Printf("MediaMTX API retry failed - Status: %d, Response: %s",
-					resp2.StatusCode, string(body2))
-				return fmt.Errorf("MediaMTX API retry failed with status %d: %s", resp2.StatusCode, string(body2))
+					resp2.StatusCode, body2)
+				return fmt.Errorf("MediaMTX API retry failed with status %d: %s", resp2.StatusCode, body2)
 			}
 			log.Printf("Successfully configured MediaMTX path %s after retry", pathName)
 		} else {
@@ -1374,43 +3321,51 @@ func configureMediaMTXPath(pathName, rtspURL string) error {
 			default:
 				errorMsg = fmt.Sprintf("MediaMTX API returned status %d: %s", resp.StatusCode, string(body))
 			}
-			return fmt.Errorf(errorMsg)
+			return errors.New(errorMsg)
 		}
 	}
 
 	log.Printf("Successfully configured MediaMTX path: %s", pathName)
 
+	return awaitMediaMTXPathReady(store, instance, pathName)
+}
+
+// awaitMediaMTXPathReady waits for a configured path's source and records it in the store
+func awaitMediaMTXPathReady(store CameraStore, instance *MediaMTXInstance, pathName string) error {
 	// Wait for the RTSP source to be ready with better error handling
 	log.Printf("Waiting for MediaMTX path %s to be ready...", pathName)
-	err = waitForPathReady(pathName)
+	err := waitForPathReady(instance, pathName)
 	if err != nil {
 		// If path isn't ready, clean up and return error
 		log.Printf("Path %s failed to become ready: %v", pathName, err)
-		cleanupMediaMTXPath(pathName)
-		stopReencodingProcess(getCorrespondingCameraID(pathName))
+		cleanupMediaMTXPath(store, instance, pathName)
+		if cameraID, ok := getCorrespondingCameraID(pathName); ok {
+			stopReencodingProcess(cameraID)
+		}
 		return fmt.Errorf("path not ready after waiting: %w", err)
 	}
 
 	log.Printf("MediaMTX path %s is ready for streaming", pathName)
 
 	// Store path information in database
-	cameraID := getCorrespondingCameraID(pathName)
-	updateCameraPathInfo(cameraID, pathName, true)
+	cameraID, ok := getCorrespondingCameraID(pathName)
+	if !ok {
+		log.Printf("Warning: MediaMTX path %s isn't a worker camera path, not updating the database", pathName)
+		return nil
+	}
+	store.UpdateCameraPathInfo(cameraID, pathName, true)
 
 	return nil
 }
 
 // waitForPathWithStream waits for a MediaMTX path to have an active stream with readers
-func waitForPathWithStream(pathName string, timeout time.Duration) error {
+func waitForPathWithStream(instance *MediaMTXInstance, pathName string, timeout time.Duration) error {
 	checkInterval := 1 * time.Second
 	timeoutChan := time.After(timeout)
 	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
-	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-	if mediamtxAPIURL == "" {
-		mediamtxAPIURL = "http://localhost:9997"
-	}
+	mediamtxAPIURL := instance.apiBaseURL()
 
 	log.Printf("Waiting for path %s to have active stream (timeout: %v)", pathName, timeout)
 
@@ -1426,9 +3381,8 @@ func waitForPathWithStream(pathName string, timeout time.Duration) error {
 			if err != nil {
 				continue
 			}
-			// No auth needed - MediaMTX configured for anonymous access
 
-			resp, err := client.Do(req)
+			resp, err := mediamtxDo(client, req)
 			if err != nil {
 				log.Printf("Error checking path %s: %v (retrying...)", pathName, err)
 				continue
@@ -1436,7 +3390,7 @@ func waitForPathWithStream(pathName string, timeout time.Duration) error {
 
 			if resp.StatusCode == http.StatusOK {
 				var pathInfo map[string]any
-				err := json.NewDecoder(resp.Body).Decode(&pathInfo)
+				err := decodeMediaMTXJSON(resp, &pathInfo)
 				resp.Body.Close()
 
 				if err != nil {
@@ -1466,7 +3420,7 @@ func waitForPathWithStream(pathName string, timeout time.Duration) error {
 }
 
 // waitForPathReady waits for a MediaMTX path to have an active RTSP source
-func waitForPathReady(pathName string) error {
+func waitForPathReady(instance *MediaMTXInstance, pathName string) error {
 	maxWaitTime := 45 * time.Second  // Increased timeout
 	checkInterval := 2 * time.Second // Increased interval
 	timeout := time.After(maxWaitTime)
@@ -1480,25 +3434,18 @@ func waitForPathReady(pathName string) error {
 		case <-timeout:
 			return fmt.Errorf("timeout waiting for path %s to be ready after %v", pathName, maxWaitTime)
 		case <-ticker.C:
-			// Get MediaMTX API URL from environment or default
-			mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
-			if mediamtxAPIURL == "" {
-				mediamtxAPIURL = "http://localhost:9997"
-			}
-
 			// Check if path has active source
-			apiURL := fmt.Sprintf("%s/v3/paths/get/%s", mediamtxAPIURL, pathName)
+			apiURL := fmt.Sprintf("%s/v3/paths/get/%s", instance.apiBaseURL(), pathName)
 
-			// Create GET request with basic auth and timeout
+			// Create GET request with timeout
 			client := &http.Client{Timeout: 5 * time.Second}
 			req, err := http.NewRequest("GET", apiURL, nil)
 			if err != nil {
 				log.Printf("Error creating request for path %s: %v", pathName, err)
 				continue
 			}
-			req.SetBasicAuth("admin", "admin") // Default MediaMTX credentials
 
-			resp, err := client.Do(req)
+			resp, err := mediamtxDo(client, req)
 			if err != nil {
 				log.Printf("Error checking path %s status: %v", pathName, err)
 				continue
@@ -1506,7 +3453,7 @@ func waitForPathReady(pathName string) error {
 
 			if resp.StatusCode == http.StatusOK {
 				var pathInfo map[string]any
-				err := json.NewDecoder(resp.Body).Decode(&pathInfo)
+				err := decodeMediaMTXJSON(resp, &pathInfo)
 				resp.Body.Close()
 
 				if err != nil {
@@ -1541,155 +3488,369 @@ func waitForPathReady(pathName string) error {
 }
 
 // startReencodingProcess starts an FFmpeg process to re-encode a stream and remove B-frames
-func startReencodingProcess(cameraID, sourceURL string) error {
-	// Check circuit breaker
-	circuitBreakersMutex.Lock()
-	cb, exists := circuitBreakers[cameraID]
-	if !exists {
-		cb = NewCircuitBreaker(cameraID)
-		circuitBreakers[cameraID] = cb
+func startReencodingProcess(store CameraStore, cameraID, sourceURL string, options StreamOptions) error {
+	// An encoder publishing over WHIP owns the camera's path
+	if hasWHIPSession(cameraID) {
+		return fmt.Errorf("%w: end the WHIP session for camera %s first", ErrWHIPPublishing, cameraID)
+	}
+
+	// In a shared MediaMTX the camera's path may be configured by someone else; FFmpeg
+	// can't publish into it, and the stop would delete it
+	if options.Output.resolvedType() == outputTypeRTSP && getPathConflictPolicy() != pathConflictOverwrite {
+		if err := checkPathConflict(options.MediaMTX, cameraPathName(cameraID), mediamtxPublisherSource); err != nil {
+			return err
+		}
 	}
-	circuitBreakersMutex.Unlock()
 
+	// Check circuit breaker
+	cb := claimCircuitBreaker(cameraID, options)
 	if !cb.CanAttempt() {
 		return fmt.Errorf("circuit breaker is open for camera %s, retry later", cameraID)
 	}
+	// Every return before FFmpeg starts settles the probe CanAttempt may have granted:
+	// source and config errors fail it, local refusals hand it back
+	started, sourceFailed := false, false
+	defer func() {
+		switch {
+		case started:
+		case sourceFailed:
+			cb.RecordFailure()
+		default:
+			cb.ReleaseProbe()
+		}
+	}()
+
+	// A dual-stream camera's viewing stream replaces its rtspUrl
+	if options.ViewingRTSPURL != "" {
+		sourceURL = options.ViewingRTSPURL
+	}
+
+	// The auto video mode's DESCRIBE runs before processMutex is taken, counted against
+	// the camera's source connections
+	sourceConnections.SetLimit(cameraID, options.MaxSourceConnections)
+	videoMode := decideVideoMode(cameraID, sourceURL, options)
+	if videoMode.unsupported != nil {
+		sourceFailed = true
+		return videoMode.unsupported
+	}
+	if videoMode.Reason != "" {
+		log.Printf("Video mode for camera %s: %s (%s)", cameraID, videoMode.Mode, videoMode.Reason)
+	}
 
+	// Held until FFmpeg is running, but not while confirming the stream is live
 	processMutex.Lock()
-	defer processMutex.Unlock()
+	locked := true
+	defer func() {
+		if locked {
+			processMutex.Unlock()
+		}
+	}()
+
+	// Check if process already exists and stop it
+	if process, exists := activeProcesses[cameraID]; exists {
+		log.Printf("Stopping existing re-encoding process for camera %s", cameraID)
+		if process.Cancel != nil {
+			process.Cancel()
+		}
+		// Force kill if needed
+		if process.Command != nil && process.Command.Process != nil {
+			process.Command.Process.Kill()
+		}
+		if process.ReleaseSource != nil {
+			process.ReleaseSource()
+		}
+		delete(activeProcesses, cameraID)
+	}
+
+	// Claim a connection to the source camera before FFmpeg opens one
+	releaseSource, err := sourceConnections.TryAcquire(cameraID, sourceConnReencode)
+	if err != nil {
+		return err
+	}
+
+	// Resolve where the re-encoded stream is published
+	output, err := newOutputTarget(cameraID, options.Output, options.MediaMTX)
+	if err != nil {
+		releaseSource()
+		sourceFailed = true
+		return err
+	}
+	targetURL := output.URL()
+
+	// Stored credentials are decrypted only for the URL handed to FFmpeg
+	dialURL, err := openSourceURL(sourceURL)
+	if err != nil {
+		releaseSource()
+		sourceFailed = true
+		return fmt.Errorf("failed to read source credentials for camera %s: %w", cameraID, err)
+	}
 
-	// Check if process already exists and stop it
-	if process, exists := activeProcesses[cameraID]; exists {
-		log.Printf("Stopping existing re-encoding process for camera %s", cameraID)
-		if process.Cancel != nil {
-			process.Cancel()
+	// Cameras are read over RTSP, or through a source adapter piping into FFmpeg's stdin;
+	// DEV_MODE also allows test patterns and local files
+	inputURL, inputArgs := dialURL, ffmpeg.KwArgs{
+		"rtsp_transport": "tcp",      // Use TCP for input to reduce packet loss
+		"timeout":        "60000000", // 30 second I/O timeout (microseconds) - increased tolerance
+	}
+	// buffer_size and max_delay default to 4MB and 5s unless the camera's input options say otherwise
+	for key, value := range options.Input.ffmpegArgs() {
+		inputArgs[key] = value
+	}
+	var adapter SourceAdapter
+	if isAdapterSource(sourceURL) {
+		if adapter, err = newSourceAdapter(dialURL); err != nil {
+			releaseSource()
+			sourceFailed = true
+			return err
 		}
-		// Force kill if needed
-		if process.Command != nil && process.Command.Process != nil {
-			process.Command.Process.Kill()
+		inputURL, inputArgs = "pipe:0", adapter.InputArgs()
+		log.Printf("Reading camera %s through the %s source adapter", cameraID, adapter.Type())
+	} else if isDevSource(sourceURL) {
+		if inputURL, inputArgs, err = devSourceInput(sourceURL); err != nil {
+			releaseSource()
+			sourceFailed = true
+			return err
 		}
-		delete(activeProcesses, cameraID)
+		log.Printf("Using development source %s for camera %s", sourceURL, cameraID)
 	}
 
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Generate target URL for re-encoded stream
-	targetURL := getReencodedStreamURL(cameraID)
-
 	// Create FFmpeg command optimized for WebRTC streaming with minimal packet loss
-	cmd := ffmpeg.Input(sourceURL, ffmpeg.KwArgs{
-		"rtsp_transport": "tcp",      // Use TCP for input to reduce packet loss
-		"buffer_size":    "4000000",  // 4MB buffer (increased for unstable streams)
-		"timeout":        "60000000", // 30 second I/O timeout (microseconds) - increased tolerance
-		"max_delay":      "5000000",  // 5 second max demux delay
-	}).
-		Output(targetURL, ffmpeg.KwArgs{
-			"c:v":               "libx264",     // H264 codec
-			"profile:v":         "baseline",    // Baseline profile (no B-frames)
-			"level":             "3.1",         // H264 level
-			"preset":            "ultrafast",   // Fastest encoding for low latency
-			"tune":              "zerolatency", // Low latency tuning
-			"g":                 "30",          // Keyframe every 30 frames (1s at 30fps)
-			"keyint_min":        "30",          // Minimum keyframe interval
-			"bf":                "0",           // No B-frames
-			"refs":              "1",           // Single reference frame
-			"maxrate":           "1500k",       // Maximum bitrate 1.5Mbps
-			"bufsize":           "3000k",       // Buffer size 3Mbps
-			"pix_fmt":           "yuv420p",     // Compatible pixel format
-			"c:a":               "aac",         // Audio codec
-			"b:a":               "64k",         // Audio bitrate
-			"ar":                "44100",       // Audio sample rate
-			"f":                 "rtsp",        // Output format
-			"rtsp_transport":    "tcp",         // Use TCP transport
-			"timeout":           "60000000",    // 30s Output I/O timeout (increased)
-			"muxdelay":          "0.1",         // Reduce mux delay
-			"avoid_negative_ts": "make_zero",   // Fix timestamp issues
-			"fflags":            "+genpts",     // Generate presentation timestamps
-			"err_detect":        "ignore_err",  // Ignore decoding errors to keep stream alive
-		}).
+	outputArgs := ffmpeg.KwArgs{
+		"tune":              "zerolatency", // Low latency tuning
+		"bf":                "0",           // No B-frames
+		"refs":              "1",           // Single reference frame
+		"pix_fmt":           "yuv420p",     // Compatible pixel format
+		"muxdelay":          "0.1",         // Reduce mux delay
+		"avoid_negative_ts": "make_zero",   // Fix timestamp issues
+		"fflags":            "+genpts",     // Generate presentation timestamps
+		"err_detect":        "ignore_err",  // Ignore decoding errors to keep stream alive
+	}
+	// Codec, profile, bitrate, keyframe interval and preset come from the camera's
+	// encoding profile, defaulting to libx264 baseline at 1.5Mbps
+	for key, value := range options.Encoding.ffmpegArgs(cameraID) {
+		outputArgs[key] = value
+	}
+	for key, value := range output.MuxerArgs() {
+		outputArgs[key] = value
+	}
+	// Filters run on the decoded frames ahead of libx264. yadif holds one frame back to
+	// look at the next; the others work frame by frame, so zerolatency encoding is kept
+	if filter := options.Filters.ffmpegFilter(); filter != "" {
+		outputArgs["vf"] = filter
+		log.Printf("Filtering camera %s video with %s", cameraID, filter)
+	}
+	// Repeat SPS/PPS in-band with every keyframe rather than only in the container's
+	// extradata, so viewers joining late or after a parameter change can decode. fMP4
+	// segments need the length-prefixed form, so LL-HLS skips the Annex B conversion.
+	outputArgs["x264-params"] = "repeat-headers=1"
+	if output.Type() != outputTypeLLHLS {
+		outputArgs["bsf:v"] = "h264_mp4toannexb"
+	}
+	if videoMode.Mode == videoModeCopy {
+		applyVideoCopyArgs(outputArgs, output.Type())
+	}
+	// A pinned camera's encoder gets one thread per CPU it may run on
+	cpus := resolveCPUAffinity(cameraID, options.CPUAffinity)
+	if len(cpus) > 0 {
+		outputArgs["threads"] = strconv.Itoa(len(cpus))
+	}
+	audioArgs := options.Audio.ffmpegArgs()
+	options.Encoding.applyAudioBitrate(audioArgs, options.Audio)
+	audioMuted, _ := options.Audio.mutedAt(time.Now(), cameraLocation(cameraID))
+	if audioMuted {
+		audioArgs = ffmpeg.KwArgs{"an": ""} // Inside a mute window; runAudioSchedule restores it
+		log.Printf("Audio for camera %s is muted by its schedule", cameraID)
+	}
+	for key, value := range audioArgs {
+		outputArgs[key] = value
+	}
+
+	// Optionally tee the exact same encode to a QA observer endpoint
+	outputURL := targetURL
+	if options.Observer.Enabled() {
+		outputURL = options.Observer.teeTarget(targetURL)
+		outputArgs["f"] = "tee"
+		outputArgs["map"] = []string{"0:v:0", "0:a:0?"} // tee requires explicit stream mapping
+		delete(outputArgs, "rtsp_transport")            // Set per slave in the tee spec
+		log.Printf("Teeing re-encoded output for camera %s to observer %s", cameraID, options.Observer.URL)
+	}
+
+	cmd := ffmpeg.Input(inputURL, inputArgs).
+		Output(outputURL, outputArgs).
 		OverWriteOutput()
 
+	// FFmpeg reports progress on an extra pipe, for frame counts and the latency estimate
+	progressReader, progressWriter, progressErr := os.Pipe()
+	if progressErr != nil {
+		log.Printf("Warning: no progress pipe for camera %s, latency won't be estimated: %v", cameraID, progressErr)
+	} else {
+		cmd = cmd.GlobalArgs(ffmpegProgressArgs...)
+	}
+	closeProgress := func() {
+		if progressErr == nil {
+			progressReader.Close()
+			progressWriter.Close()
+		}
+	}
+
 	// Start the FFmpeg process
+	// FFmpeg logs go to our stderr and the camera's log tail; the last few KB are also
+	// kept to classify why it exited
+	stderr := &stderrTail{}
+	logWriter := &ffmpegLogWriter{cameraID: cameraID, hub: ffmpegLogs}
 	execCmd := cmd.Compile()
-	execCmd.Stderr = os.Stderr // Redirect FFmpeg logs
+	execCmd.Stderr = io.MultiWriter(os.Stderr, stderr, logWriter)
 
 	// Set the context for cancellation
 	if ctx != nil {
 		execCmd = exec.CommandContext(ctx, execCmd.Args[0], execCmd.Args[1:]...)
-		execCmd.Stderr = os.Stderr
+		execCmd.Stderr = io.MultiWriter(os.Stderr, stderr, logWriter)
+	}
+	startInProcessGroup(execCmd)
+	if progressErr == nil {
+		execCmd.ExtraFiles = []*os.File{progressWriter} // pipe:3
+	}
+
+	var adapterInput io.WriteCloser
+	if adapter != nil {
+		if adapterInput, err = execCmd.StdinPipe(); err != nil {
+			cancel()
+			releaseSource()
+			closeProgress()
+			return fmt.Errorf("failed to open FFmpeg stdin for the source adapter: %w", err)
+		}
 	}
 
-	err := execCmd.Start()
+	err = execCmd.Start()
 	if err != nil {
 		cancel()
-		cb.RecordFailure()
+		releaseSource()
+		closeProgress()
+		sourceFailed = true
 		return fmt.Errorf("failed to start FFmpeg process: %w", err)
 	}
+	started = true // The process monitor and the start confirmation settle the probe from here
+	tracked := ffmpegProcesses.Track(cameraID, execCmd.Process.Pid)
+	if len(cpus) > 0 {
+		if err := setProcessAffinity(execCmd.Process.Pid, cpus); err != nil {
+			log.Printf("Warning: failed to pin FFmpeg for camera %s to CPUs %s: %v", cameraID, formatCPUList(cpus), err)
+			cpus = nil
+		} else {
+			log.Printf("Pinned FFmpeg for camera %s to CPUs %s", cameraID, formatCPUList(cpus))
+		}
+	}
+	// Unpinned cameras stay off the CPUs other cameras are pinned to
+	if len(cpus) == 0 {
+		if free := unpinnedCPUs(); free != nil {
+			if err := setProcessAffinity(execCmd.Process.Pid, free); err != nil {
+				log.Printf("Warning: failed to keep FFmpeg for camera %s off pinned CPUs: %v", cameraID, err)
+			}
+		}
+	}
+	if progressErr == nil {
+		progressWriter.Close() // FFmpeg holds the write end now
+	}
+	if adapter != nil {
+		go runSourceAdapter(ctx, cameraID, adapter, adapterInput)
+	}
 
 	// Store the process
-	activeProcesses[cameraID] = &ReencodingProcess{
+	exited := make(chan struct{})
+	tornDown := make(chan struct{})
+	process := &ReencodingProcess{
 		CameraID:  cameraID,
 		SourceURL: sourceURL,
 		TargetURL: targetURL,
 		Context:   ctx,
 		Cancel:    cancel,
 		Command:   execCmd,
+		Options:   options,
+		Output:    output,
+		Store:     store,
+		StartedAt: time.Now(),
+
+		AudioMuted:    audioMuted,
+		VideoMode:     videoMode,
+		CPUAffinity:   cpus,
+		ReleaseSource: releaseSource,
+		Exited:        exited,
+		TornDown:      tornDown,
+	}
+	activeProcesses[cameraID] = process
+	if len(cpus) > 0 {
+		rebalanceUnpinnedAffinity() // Reserve the newly pinned CPUs
 	}
+	ffmpegLogs.Start(cameraID)
+	go runStreamWatchdog(ctx, process)
+	go runAdaptiveBitrate(ctx, process)
+	go runAudioSchedule(ctx, process)
+	go eventRecorder.Run(ctx, process)
 
 	// Initialize metrics for this stream
-	streamMetricsMutex.Lock()
-	streamMetrics[cameraID] = &StreamMetrics{
-		CameraID:      cameraID,
-		StartTime:     time.Now(),
-		LastFrameTime: time.Now(),
+	metrics := &StreamMetrics{
+		CameraID:       cameraID,
+		StartTime:      time.Now(),
+		RestartReasons: ffmpegRestartReasons(cameraID),
 	}
+	streamMetricsMutex.Lock()
+	streamMetrics[cameraID] = metrics
 	streamMetricsMutex.Unlock()
-
-	// Check if face detection is enabled for this camera in the database
-	if db != nil {
-		var faceDetectionEnabled bool
-		query := `SELECT "faceDetectionEnabled" FROM cameras WHERE id = $1`
-		err := db.QueryRow(query, cameraID).Scan(&faceDetectionEnabled)
-
-		if err == nil && faceDetectionEnabled {
-			log.Printf("Face detection is enabled for camera %s, starting detection...", cameraID)
-
-			// Start face detection for this camera
-			faceDetectionMutex.Lock()
-			// Stop any existing face detection
-			if existingCancel, exists := faceDetectionActive[cameraID]; exists {
-				existingCancel()
-			}
-			// Create new context for face detection
-			faceDetectionCtx, faceDetectionCancel := context.WithCancel(context.Background())
-			faceDetectionActive[cameraID] = faceDetectionCancel
-			faceDetectionMutex.Unlock()
-
-			// Start face detection goroutine
-			startFaceDetection(cameraID, sourceURL, faceDetectionCtx)
-		} else {
-			log.Printf("Face detection is disabled for camera %s (default: false)", cameraID)
-		}
+	progressMetrics := metrics // Where confirmStreamStarted reads FFmpeg's frame count
+	if progressErr == nil {
+		go runFFmpegProgress(metrics, process.StartedAt, progressReader, newOutputByteCounter(process))
+	} else {
+		progressMetrics = nil
+		go runOutputActivity(ctx, process, metrics) // LastFrameTime from the output instead
 	}
 
+	resumeFaceDetection(store, process)
+	// A detection chain checks for freezes itself; the stream's own check runs whenever
+	// no chain does, starting paused when one already is
+	startFreezeDetection(cameraID, detectionSourceURL(sourceURL, targetURL, options), ctx)
+
 	// Monitor the process in a goroutine with enhanced error handling
 	go func() {
+		defer close(tornDown)
 		err := execCmd.Wait()
+		close(exited)
+		ffmpegProcesses.Exited(tracked)
+		releaseSource()
+
+		// The camera's per-process state is torn down under processMutex, so a restart
+		// can't register new face detection or metrics in between that this then removes
 		processMutex.Lock()
-		delete(activeProcesses, cameraID)
+		replaced := releaseExitedProcess(process)
+		stopReason := process.StopReason
 		processMutex.Unlock()
 
-		// Stop face detection
-		stopFaceDetection(cameraID)
+		// A newer process owns the camera's face detection, metrics and path now
+		if replaced {
+			log.Printf("FFmpeg process for camera %s exited after being replaced", cameraID)
+			return
+		}
 
-		// Clean up metrics
-		streamMetricsMutex.Lock()
-		delete(streamMetrics, cameraID)
-		streamMetricsMutex.Unlock()
+		// A cancelled context means the stream was stopped deliberately (stop, eviction),
+		// so record the final state instead of auto-restarting
+		if ctx.Err() != nil {
+			log.Printf("FFmpeg process for camera %s stopped on request", cameraID)
+			finishStoppedProcess(store, process, stopReason)
+			return
+		}
 
 		if err != nil {
-			log.Printf("FFmpeg process for camera %s ended with error: %v", cameraID, err)
+			reason := classifyFFmpegFailure(err, stderr.Lines(), targetURL)
+			recordFFmpegFailure(cameraID, reason)
+			log.Printf("FFmpeg process for camera %s ended with error: %v (reason: %s)", cameraID, err, reason)
+
+			// Losing MediaMTX isn't the camera's fault: leave the breaker and the path alone
+			// and let the outage monitor re-publish it once MediaMTX is back
+			if mediamtxOutage.ExplainsFailure(options.MediaMTX, reason) {
+				log.Printf("Camera %s lost MediaMTX during an outage, deferring its restart to the coordinated re-publish", cameraID)
+				mediamtxOutage.Defer(store, cameraID, sourceURL, options)
+				return
+			}
 
 			// Record failure in circuit breaker
 			circuitBreakersMutex.RLock()
@@ -1699,33 +3860,37 @@ func startReencodingProcess(cameraID, sourceURL string) error {
 			if cbExists {
 				cb.RecordFailure()
 
-				// Auto-restart with exponential backoff if circuit breaker allows
-				if cb.CanAttempt() {
-					// Calculate backoff delay based on failure count (with jitter)
-					failureCount := cb.FailureCount
-					baseDelay := 2 * time.Second
-					maxDelay := 30 * time.Second
-
-					// Exponential backoff: 2^n seconds (capped at 30s)
-					backoffDelay := time.Duration(1<<uint(failureCount)) * baseDelay
-					if backoffDelay > maxDelay {
-						backoffDelay = maxDelay
-					}
+				// Auto-restart with exponential backoff if circuit breaker allows.
+				// startReencodingProcess claims the attempt itself, so only peek here.
+				if !autoRestartAllowed() {
+					log.Printf("Maintenance mode: skipping auto-restart for camera %s", cameraID)
+				} else if cb.WouldAllow() {
+					// Calculate backoff delay based on failure count (with jitter); warm-up
+					// failures back off too, they just don't open the breaker
+					state := cb.Snapshot()
+					failureCount := state.FailureCount + state.WarmupFailures
+
+					// Exponential backoff: 2^n * 2 seconds (capped at 30s)
+					backoffDelay := restartBackoff(failureCount, 2*time.Second, 30*time.Second)
 
 					// Add jitter (±20%) to prevent thundering herd
-					jitter := time.Duration(float64(backoffDelay) * 0.2 * (2*float64(time.Now().UnixNano()%100)/100.0 - 1))
-					backoffDelay += jitter
+					backoffDelay = jitterDelay(backoffDelay, 0.2)
 
 					log.Printf("Auto-restarting FFmpeg for camera %s after failure (attempt %d, waiting %v)", cameraID, failureCount, backoffDelay)
 					time.Sleep(backoffDelay)
 
+					// Wait for a fleet-wide restart token so simultaneous failures recover gradually
+					if waited := restartLimiter.Wait(); waited > 0 {
+						log.Printf("Auto-restart for camera %s delayed %v by restart rate limiter", cameraID, waited.Round(time.Millisecond))
+					}
+
 					// Get camera info from database
-					_, pathName, configured, dbErr := getCameraInfo(cameraID)
+					_, pathName, configured, dbErr := store.GetCameraInfo(cameraID)
 					if dbErr == nil && configured {
 						// Try to restart
-						if restartErr := startReencodingProcess(cameraID, sourceURL); restartErr != nil {
+						if restartErr := startReencodingProcess(store, cameraID, sourceURL, options); restartErr != nil {
 							log.Printf("Failed to auto-restart camera %s: %v", cameraID, restartErr)
-							updateCameraPathInfo(cameraID, pathName, false)
+							store.UpdateCameraPathInfo(cameraID, pathName, false)
 						} else {
 							log.Printf("Successfully auto-restarted camera %s", cameraID)
 						}
@@ -1737,12 +3902,12 @@ func startReencodingProcess(cameraID, sourceURL string) error {
 			}
 
 			// Clean up MediaMTX path on process failure
-			pathName := fmt.Sprintf("camera_%s", cameraID)
-			if cleanupErr := cleanupMediaMTXPath(pathName); cleanupErr != nil {
+			pathName := cameraPathName(cameraID)
+			if cleanupErr := cleanupMediaMTXPath(store, options.MediaMTX, pathName); cleanupErr != nil {
 				log.Printf("Failed to cleanup MediaMTX path after FFmpeg failure: %v", cleanupErr)
 			}
 			// Update database status
-			updateCameraPathInfo(cameraID, pathName, false)
+			store.UpdateCameraPathInfo(cameraID, pathName, false)
 		} else {
 			log.Printf("FFmpeg process for camera %s ended normally", cameraID)
 
@@ -1757,266 +3922,560 @@ func startReencodingProcess(cameraID, sourceURL string) error {
 
 	log.Printf("Started re-encoding process for camera %s: %s -> %s", cameraID, sourceURL, targetURL)
 
-	// Wait for the process to start up and begin streaming
-	// Check multiple times with shorter intervals for faster feedback
-	log.Printf("Waiting for FFmpeg process to establish connection...")
-	maxChecks := 10
-	checkInterval := 500 * time.Millisecond
+	// Only report success once the stream is live. The process monitor needs
+	// processMutex if FFmpeg exits meanwhile, so it's released first.
+	processMutex.Unlock()
+	locked = false
+	log.Printf("Waiting for camera %s to publish (%s confirmation)...", cameraID, startConfirmConfig.Mode)
+	if err := confirmStreamStarted(process, progressMetrics, exited); err != nil {
+		select {
+		case <-exited:
+			// The process monitor already recorded the failure
+		default:
+			// Running but publishing nothing: stop it, and count it against the camera
+			processMutex.RLock()
+			current := activeProcesses[cameraID]
+			processMutex.RUnlock()
+			if current == process && process.Context.Err() == nil {
+				stopReencodingProcess(cameraID)
+				cb.RecordFailure()
+			}
+		}
+		return err
+	}
+	log.Printf("FFmpeg process for camera %s is publishing", cameraID)
 
-	for i := range maxChecks {
-		time.Sleep(checkInterval)
+	// Record success in circuit breaker
+	circuitBreakersMutex.RLock()
+	if cb, exists := circuitBreakers[cameraID]; exists {
+		cb.RecordSuccess()
+	}
+	circuitBreakersMutex.RUnlock()
 
-		// Check if process is still running
-		if execCmd.ProcessState != nil && execCmd.ProcessState.Exited() {
-			exitErr := execCmd.ProcessState.String()
-			return fmt.Errorf("FFmpeg process exited immediately (%s), check RTSP source: %s", exitErr, sourceURL)
-		}
+	// Update database to mark camera as processing
+	pathName := cameraPathName(cameraID)
+	store.UpdateCameraPathInfo(cameraID, pathName, true)
+	return nil
+}
 
-		// After a few checks, consider it successful
-		if i >= 5 {
-			log.Printf("FFmpeg process for camera %s is running and stable", cameraID)
+// resumeFaceDetection starts a new process's face detection if the camera's stored
+// settings (camera -> group -> global) enable it, so a toggle survives FFmpeg restarts.
+// The caller holds processMutex, so a stop can't slip in before it's registered.
+func resumeFaceDetection(store CameraStore, process *ReencodingProcess) {
+	if !store.Available() {
+		return
+	}
+	cameraID := process.CameraID
+	faceDetectionSettings, err := getFaceDetectionSettings(store, cameraID)
+	if err != nil || !faceDetectionSettings.Enabled {
+		log.Printf("Face detection is disabled for camera %s (default: false)", cameraID)
+		return
+	}
+	log.Printf("Face detection is enabled for camera %s, starting detection...", cameraID)
 
-			// Record success in circuit breaker
-			circuitBreakersMutex.RLock()
-			if cb, exists := circuitBreakers[cameraID]; exists {
-				cb.RecordSuccess()
-			}
-			circuitBreakersMutex.RUnlock()
+	// Start face detection for this camera; it ends with the process
+	faceDetectionCtx := registerFaceDetection(cameraID, process.Context)
+	startFaceDetection(store, cameraID, detectionSourceURL(process.SourceURL, process.TargetURL, process.Options), process.Options, faceDetectionCtx)
+}
 
-			// Update database to mark camera as processing
-			pathName := fmt.Sprintf("camera_%s", cameraID)
-			updateCameraPathInfo(cameraID, pathName, true)
-			return nil
-		}
+// releaseExitedProcess drops the per-camera state of a process whose FFmpeg exited and
+// reports whether a restart already replaced it, in which case the state is the new
+// process's and is kept. The caller holds processMutex.
+func releaseExitedProcess(process *ReencodingProcess) (replaced bool) {
+	cameraID := process.CameraID
+	current, exists := activeProcesses[cameraID]
+	if exists && current != process {
+		return true
 	}
 
-	return nil
+	delete(activeProcesses, cameraID)
+	capacityQueue.Notify()
+	if len(process.CPUAffinity) > 0 {
+		rebalanceUnpinnedAffinity() // Give its CPUs back to the unpinned cameras
+	}
+
+	streamMetricsMutex.Lock()
+	delete(streamMetrics, cameraID)
+	streamMetricsMutex.Unlock()
+
+	stopFaceDetection(cameraID)
+	detectionMetadata.Forget(cameraID)
+	ffmpegLogs.Stop(cameraID)
+	return false
 }
 
 // stopReencodingProcess stops the re-encoding process for a camera
-func stopReencodingProcess(cameraID string) {
+func stopReencodingProcess(cameraID string) bool {
+	stopped, _ := stopReencodingProcessGracefully(cameraID)
+	return stopped
+}
+
+// processTeardownTimeout bounds how long a stop waits for the process monitor after FFmpeg
+// exited; it covers the MediaMTX path delete's 10 second request timeout
+const processTeardownTimeout = 12 * time.Second
+
+// stopReencodingProcessGracefully stops the camera's process and reports whether FFmpeg
+// had to be killed after its 3 second grace period. It returns once FFmpeg has exited
+// and the MediaMTX path is removed. processMutex is released while FFmpeg shuts down, so
+// stops of different cameras run in parallel.
+func stopReencodingProcessGracefully(cameraID string) (stopped, forceKilled bool) {
 	processMutex.Lock()
-	defer processMutex.Unlock()
 
 	// Stop face detection first
 	stopFaceDetection(cameraID)
 
-	if process, exists := activeProcesses[cameraID]; exists {
-		log.Printf("Stopping re-encoding process for camera %s", cameraID)
+	process, exists := activeProcesses[cameraID]
+	if !exists {
+		processMutex.Unlock()
+		log.Printf("No active re-encoding process found for camera %s", cameraID)
+		return false, false
+	}
+	log.Printf("Stopping re-encoding process for camera %s", cameraID)
 
-		// Cancel the context
-		if process.Cancel != nil {
-			process.Cancel()
+	// Cancel the context; the process monitor sees it and won't auto-restart
+	if process.Cancel != nil {
+		process.Cancel()
+	}
+	processMutex.Unlock()
+
+	// Try graceful shutdown first, then force kill
+	if process.Command != nil && process.Command.Process != nil && process.Exited != nil {
+		// Give it 3 seconds to shut down gracefully
+		select {
+		case <-process.Exited:
+			log.Printf("FFmpeg process for camera %s shut down gracefully", cameraID)
+		case <-time.After(3 * time.Second):
+			log.Printf("Force killing FFmpeg process for camera %s", cameraID)
+			forceKilled = true
+			if err := process.Command.Process.Kill(); err != nil {
+				log.Printf("Failed to kill FFmpeg process for camera %s: %v", cameraID, err)
+			}
 		}
+	}
 
-		// Try graceful shutdown first, then force kill
-		if process.Command != nil && process.Command.Process != nil {
-			// Give it 3 seconds to shut down gracefully
-			done := make(chan bool, 1)
-			go func() {
-				process.Command.Wait()
-				done <- true
-			}()
+	// Wait for the process monitor to remove the MediaMTX path, now that nothing
+	// publishes to it, so a start right after this finds it gone
+	if process.TornDown != nil {
+		select {
+		case <-process.TornDown:
+		case <-time.After(processTeardownTimeout):
+			log.Printf("Warning: Process monitor of camera %s hasn't finished cleaning up after %v, continuing anyway", cameraID, processTeardownTimeout)
+		}
+	}
 
-			select {
-			case <-done:
-				log.Printf("FFmpeg process for camera %s shut down gracefully", cameraID)
-			case <-time.After(3 * time.Second):
-				log.Printf("Force killing FFmpeg process for camera %s", cameraID)
-				if err := process.Command.Process.Kill(); err != nil {
-					log.Printf("Failed to kill FFmpeg process for camera %s: %v", cameraID, err)
-				}
-			}
+	processMutex.Lock()
+	defer processMutex.Unlock()
+	if process.ReleaseSource != nil {
+		process.ReleaseSource()
+	}
+	// A start for the camera while FFmpeg was shutting down replaced the process; the
+	// new one owns the camera's entry and output now
+	if current, exists := activeProcesses[cameraID]; exists && current != process {
+		log.Printf("Re-encoding process for camera %s stopped; a new one replaced it meanwhile", cameraID)
+		return true, forceKilled
+	}
+	delete(activeProcesses, cameraID)
+	capacityQueue.Notify()
+	snapshotCache.Forget(cameraID) // A stopped stream's frame would be served as current
+
+	// Remove whatever the output target left behind (e.g. HLS segments)
+	if process.Output != nil {
+		if err := process.Output.Cleanup(); err != nil {
+			log.Printf("Warning: Failed to clean up %s output for camera %s: %v", process.Output.Type(), cameraID, err)
 		}
+	}
 
-		delete(activeProcesses, cameraID)
+	log.Printf("Re-encoding process for camera %s stopped and cleaned up", cameraID)
+	return true, forceKilled
+}
 
-		// Clean up MediaMTX path after stopping FFmpeg
-		// pathName := fmt.Sprintf("camera_%s", cameraID)
-		// if err := cleanupMediaMTXPath(pathName); err != nil {
-		// 	log.Printf("Warning: Failed to cleanup MediaMTX path %s: %v", pathName, err)
-		// }
+// claimCircuitBreaker returns the camera's circuit breaker, creating it on the first
+// start, and marks it used so the state janitor keeps it
+func claimCircuitBreaker(cameraID string, options StreamOptions) *CircuitBreaker {
+	circuitBreakersMutex.Lock()
+	defer circuitBreakersMutex.Unlock()
 
-		log.Printf("Re-encoding process for camera %s stopped and cleaned up", cameraID)
-	} else {
-		log.Printf("No active re-encoding process found for camera %s", cameraID)
+	cb, exists := circuitBreakers[cameraID]
+	if !exists {
+		cb = NewCircuitBreaker(cameraID)
+		cb.warmupUntil = time.Now().Add(breakerWarmup(options)) // Not shared yet, so no cb.mu
+		circuitBreakers[cameraID] = cb
+	}
+	cb.touch() // Under circuitBreakersMutex so the janitor can't prune it mid-start
+	return cb
+}
+
+// finishStoppedProcess records the final state of a process stopped on request. The
+// process monitor calls it once FFmpeg has exited, so nothing publishes to the MediaMTX
+// path it deletes; a path MediaMTX no longer has counts as deleted.
+func finishStoppedProcess(store CameraStore, process *ReencodingProcess, stopReason string) {
+	pathName := cameraPathName(process.CameraID)
+	if err := cleanupMediaMTXPath(store, process.Options.MediaMTX, pathName); err != nil {
+		log.Printf("Failed to cleanup MediaMTX path after stop: %v", err)
+	}
+	store.UpdateCameraPathInfo(process.CameraID, pathName, false)
+	if stopReason != "" && store.Available() {
+		if err := store.UpdateCameraStatus(process.CameraID, stopReason); err != nil {
+			log.Printf("Failed to update status for camera %s: %v", process.CameraID, err)
+		}
 	}
 }
 
 // getReencodedStreamURL generates the URL for publishing the re-encoded stream
-func getReencodedStreamURL(cameraID string) string {
-	// Generate RTSP URL for publishing re-encoded stream to MediaMTX
-	// This URL must match the MediaMTX path name for proper routing
-	mediamtxURL := "rtsp://localhost:8554"
-	// if mediamtxURL == "" {
-	// 	mediamtxURL = "rtsp://localhost:8554"
-	// }
+func getReencodedStreamURL(instance *MediaMTXInstance, cameraID string) string {
+	// Must match the MediaMTX path name for proper routing
+	return fmt.Sprintf("%s/%s", instance.publishBaseURL(), cameraPathName(cameraID))
+}
+
+// defaultPathPrefix is prepended to camera IDs to form MediaMTX path names
+const defaultPathPrefix = "camera_"
+
+// pathPrefixPattern keeps the prefix within the characters MediaMTX allows in path names
+var pathPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// pathPrefix is the MediaMTX path prefix in use (MEDIAMTX_PATH_PREFIX)
+var pathPrefix = defaultPathPrefix
+
+// pathPrefixFromEnv reads MEDIAMTX_PATH_PREFIX, defaulting to "camera_"
+func pathPrefixFromEnv() string {
+	prefix := os.Getenv("MEDIAMTX_PATH_PREFIX")
+	if prefix == "" {
+		return defaultPathPrefix
+	}
+	if !pathPrefixPattern.MatchString(prefix) {
+		log.Printf("Invalid MEDIAMTX_PATH_PREFIX %q, using %q", prefix, defaultPathPrefix)
+		return defaultPathPrefix
+	}
+	return prefix
+}
+
+// cameraPathName returns the MediaMTX path name for a camera; getCorrespondingCameraID
+// is its inverse
+func cameraPathName(cameraID string) string {
+	return pathPrefix + cameraID
+}
+
+// streamSnapshot is a point-in-time copy of one active stream and its metrics
+type streamSnapshot struct {
+	CameraID        string
+	SourceURL       string
+	Options         StreamOptions
+	VideoMode       VideoModeDecision
+	CPUAffinity     []int
+	StartTime       time.Time // Zero if unknown
+	FramesProcessed uint64
+	BytesProcessed  uint64
+	LastFrameTime   time.Time // Zero before the first frame
+}
 
-	// FIXED: Use consistent path naming - camera_{cameraID} (matches MediaMTX path)
-	return fmt.Sprintf("%s/camera_%s", mediamtxURL, cameraID)
+// streamStalled reports whether a running stream has gone STREAM_FRAME_STALL_THRESHOLD
+// without a new frame, counting from its start when no frame has arrived yet
+func streamStalled(lastFrame, start time.Time) bool {
+	threshold := timingConfig.FrameStallThreshold
+	if threshold <= 0 {
+		return false
+	}
+	if lastFrame.IsZero() {
+		lastFrame = start
+	}
+	return !lastFrame.IsZero() && time.Since(lastFrame) > threshold
 }
 
-// getCorrespondingCameraID extracts camera ID from MediaMTX path name
-func getCorrespondingCameraID(pathName string) string {
-	// pathName format: "camera_<cameraID>"
-	if len(pathName) > 7 && pathName[:7] == "camera_" {
-		return pathName[7:]
+// snapshotActiveStreams copies activeProcesses joined with streamMetrics. Both locks are
+// held together, processMutex first as in startReencodingProcess, so a stream can't
+// change between the two reads.
+func snapshotActiveStreams() []streamSnapshot {
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+	streamMetricsMutex.RLock()
+	defer streamMetricsMutex.RUnlock()
+
+	snapshots := make([]streamSnapshot, 0, len(activeProcesses))
+	for cameraID, process := range activeProcesses {
+		snapshots = append(snapshots, newStreamSnapshot(cameraID, process))
 	}
-	return pathName // fallback
+	return snapshots
 }
 
-// getCameraName retrieves camera name from database
-func getCameraName(cameraID string) string {
-	if db == nil {
-		return ""
+// snapshotActiveStream is snapshotActiveStreams for one camera, reading only its entries
+func snapshotActiveStream(cameraID string) (streamSnapshot, bool) {
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+	streamMetricsMutex.RLock()
+	defer streamMetricsMutex.RUnlock()
+
+	process, exists := activeProcesses[cameraID]
+	if !exists {
+		return streamSnapshot{}, false
 	}
+	return newStreamSnapshot(cameraID, process), true
+}
 
-	var name string
-	query := `SELECT name FROM cameras WHERE id = $1`
-	err := db.QueryRow(query, cameraID).Scan(&name)
-	if err != nil {
-		log.Printf("Failed to get camera name for %s: %v", cameraID, err)
-		return ""
+// newStreamSnapshot joins a process with its metrics; caller holds processMutex and
+// streamMetricsMutex
+func newStreamSnapshot(cameraID string, process *ReencodingProcess) streamSnapshot {
+	snapshot := streamSnapshot{
+		CameraID:    cameraID,
+		SourceURL:   process.SourceURL,
+		Options:     process.Options,
+		VideoMode:   process.VideoMode,
+		CPUAffinity: process.CPUAffinity,
+		StartTime:   process.StartedAt,
+	}
+	if metrics, exists := streamMetrics[cameraID]; exists {
+		if !metrics.StartTime.IsZero() {
+			snapshot.StartTime = metrics.StartTime
+		}
+		snapshot.FramesProcessed = metrics.FramesProcessed
+		snapshot.BytesProcessed = metrics.BytesProcessed
+		snapshot.LastFrameTime = metrics.LastFrameTime
+	}
+	return snapshot
+}
+
+// getCorrespondingCameraID extracts camera ID from MediaMTX path name. Only the
+// leading prefix is stripped, so an ID that itself starts with it round-trips. ok is
+// false for paths the worker doesn't own: no prefix, or not a valid camera ID after it.
+func getCorrespondingCameraID(pathName string) (cameraID string, ok bool) {
+	cameraID, found := strings.CutPrefix(pathName, pathPrefix)
+	if !found || !cameraIDPattern.MatchString(cameraID) {
+		return "", false
 	}
-	return name
+	return cameraID, true
 }
 
-// startFaceDetection starts face detection for a camera stream
-func startFaceDetection(cameraID, rtspURL string, ctx context.Context) {
-	if faceDetector == nil || !faceDetector.enabled {
+// startFaceDetection starts a camera's frame processor chain (FRAME_PROCESSORS or its
+// frameProcessors option) on its detection source
+func startFaceDetection(store CameraStore, cameraID, rtspURL string, options StreamOptions, ctx context.Context) {
+	// All detectors share one capture through the chain; gates alone have nothing to
+	// feed, but a freeze check does
+	if !faceDetectionEnabled() && !objectDetectionEnabled() && !freezeDetectionConfig.Enabled {
 		return
 	}
 
 	log.Printf("Starting face detection for camera %s", cameraID)
 
+	// The capture dials the source itself, so it needs the decrypted credentials
+	rtspURL, err := openSourceURL(rtspURL)
+	if err != nil {
+		log.Printf("Face detection can't read camera %s: %v", cameraID, err)
+		return
+	}
+
 	// Get camera name from database
-	cameraName := getCameraName(cameraID)
+	cameraName := store.GetCameraName(cameraID)
 	if cameraName == "" {
 		cameraName = fmt.Sprintf("Camera_%s", cameraID)
 	}
 
-	go func() {
-		// Retry logic for opening video capture with better error handling
-		var capture *gocv.VideoCapture
-		var err error
-		maxRetries := 3
-		retryDelay := 2 * time.Second
-		consecutiveFailures := 0
-		maxConsecutiveFailures := 10
-
-		// Open capture with retry
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			select {
-			case <-ctx.Done():
-				log.Printf("Face detection cancelled for camera %s before video capture opened", cameraID)
-				return
-			default:
-			}
+	// Resolve interval/threshold/ROI from the camera and its group
+	settings, err := getFaceDetectionSettings(store, cameraID)
+	if err != nil && store.Available() {
+		log.Printf("Failed to load face detection settings for camera %s, using defaults: %v", cameraID, err)
+	}
+	if settings.Interval <= 0 {
+		settings.Interval = time.Second // No face detector to supply the global default
+	}
 
-			// Use VideoCaptureFile with specific codec hints for better stability
-			capture, err = gocv.OpenVideoCapture(rtspURL)
-			if err == nil && capture != nil && capture.IsOpened() {
-				// Set buffer size to reduce latency and packet loss
-				capture.Set(gocv.VideoCaptureFPS, 15) // Limit FPS to reduce bandwidth
-				capture.Set(gocv.VideoCaptureBufferSize, 3) // Small buffer for real-time
+	chain := newFrameProcessorChain(frameProcessorCamera{ID: cameraID, Name: cameraName, Settings: settings}, withFreezeProcessor(frameProcessorNames(options)))
+	if chain.Len() == 0 {
+		log.Printf("No frame processors available for camera %s, not starting face detection", cameraID)
+		return
+	}
 
-				log.Printf("Successfully opened video capture for face detection on camera %s (attempt %d)", cameraID, attempt)
-				break
-			}
+	go runProcessors(ctx, cameraID, rtspURL, chain, settings.Interval)
+}
 
-			if err != nil {
-				log.Printf("Failed to open video capture for face detection on camera %s (attempt %d/%d): %v", cameraID, attempt, maxRetries, err)
-			}
+// runProcessors holds a source connection for the camera's detection stream and passes
+// a frame through the chain every interval, in the configured acquisition mode
+func runProcessors(ctx context.Context, cameraID, rtspURL string, chain *FrameProcessorChain, interval time.Duration) {
+	defer chain.Close()
 
-			if attempt < maxRetries {
-				log.Printf("Retrying face detection video capture in %v...", retryDelay)
-				time.Sleep(retryDelay)
-			} else {
-				log.Printf("All attempts failed to open video capture for face detection on camera %s", cameraID)
-				return
-			}
+	// Queue behind other connections if the camera is at its connection limit
+	releaseSource, err := sourceConnections.TryAcquire(cameraID, sourceConnFaceDetection)
+	if err != nil {
+		log.Printf("Face detection for camera %s waiting for a free source connection: %v", cameraID, err)
+		if releaseSource, err = sourceConnections.Acquire(ctx, cameraID, sourceConnFaceDetection, 0); err != nil {
+			log.Printf("Face detection cancelled for camera %s while waiting for a source connection", cameraID)
+			return
 		}
-		defer func() {
-			if capture != nil {
-				capture.Close()
-			}
-		}()
+	}
+	defer releaseSource()
+
+	// Sampling mode grabs single keyframes instead of decoding the stream continuously
+	if faceDetectionEnabled() && faceDetector.tuning().Mode == faceDetectionModeSample {
+		runSampledFaceDetection(ctx, cameraID, rtspURL, chain, interval)
+		return
+	}
+
+	runContinuousFaceDetection(ctx, cameraID, rtspURL, chain, interval, detectionRetryPolicy(cameraID))
+}
 
-		// Wait for stream to stabilize and discard initial frames
-		log.Printf("Waiting for stream to stabilize for camera %s...", cameraID)
-		time.Sleep(3 * time.Second)
+// runContinuousFaceDetection keeps a gocv capture of the source open and feeds the
+// latest frame to the processor chain once per interval
+func runContinuousFaceDetection(ctx context.Context, cameraID, rtspURL string, chain *FrameProcessorChain, interval time.Duration, retry RTSPRetryPolicy) {
+	consecutiveFailures := 0
+	maxConsecutiveFailures := 10
 
-		// Discard first few frames to avoid corrupted data
-		tempImg := gocv.NewMat()
-		for i := 0; i < 10; i++ {
-			capture.Read(&tempImg)
+	capture, err := openVideoCaptureWithRetry(ctx, cameraID, rtspURL, "face detection", retry)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Face detection cancelled for camera %s before video capture opened", cameraID)
+		}
+		return
+	}
+	defer func() {
+		if capture != nil {
+			capture.Close()
 		}
-		tempImg.Close()
+	}()
 
-		img := gocv.NewMat()
-		defer img.Close()
+	// Wait for stream to stabilize and discard initial frames
+	log.Printf("Waiting %v for stream to stabilize for camera %s...", timingConfig.FaceStabilizeDelay, cameraID)
+	if !sleepContext(ctx, timingConfig.FaceStabilizeDelay) {
+		log.Printf("Face detection cancelled for camera %s while stabilizing", cameraID)
+		return
+	}
 
-		ticker := time.NewTicker(faceDetector.interval)
-		defer ticker.Stop()
+	// Discard first few frames to avoid corrupted data
+	tempImg := gocv.NewMat()
+	for i := 0; i < 10; i++ {
+		capture.Read(&tempImg)
+	}
+	tempImg.Close()
 
-		log.Printf("Face detection active for camera %s (interval: %v)", cameraID, faceDetector.interval)
+	img := gocv.NewMat()
+	defer img.Close()
 
-		for {
-			select {
-			case <-ctx.Done():
-				log.Printf("Stopping face detection for camera %s", cameraID)
-				return
-			case <-ticker.C:
-				// Read frame from video capture
-				if ok := capture.Read(&img); !ok || img.Empty() {
-					consecutiveFailures++
-					log.Printf("Failed to read frame from camera %s for face detection (failures: %d/%d)",
-						cameraID, consecutiveFailures, maxConsecutiveFailures)
-
-					// If too many failures, try to reconnect
-					if consecutiveFailures >= maxConsecutiveFailures {
-						log.Printf("Too many consecutive failures, attempting to reconnect camera %s", cameraID)
-						capture.Close()
-
-						time.Sleep(2 * time.Second) // Wait before reconnecting
-
-						capture, err = gocv.OpenVideoCapture(rtspURL)
-						if err != nil || capture == nil || !capture.IsOpened() {
-							log.Printf("Failed to reconnect video capture for camera %s: %v", cameraID, err)
-							return // Give up
-						}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-						// Reset settings
-						capture.Set(gocv.VideoCaptureFPS, 15)
-						capture.Set(gocv.VideoCaptureBufferSize, 3)
+	log.Printf("Face detection active for camera %s (interval: %v, processors: %s)", cameraID, interval, chain)
 
-						// Discard initial frames after reconnect
-						for i := 0; i < 5; i++ {
-							capture.Read(&img)
-						}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping face detection for camera %s", cameraID)
+			return
+		case <-ticker.C:
+			// Read frame from video capture
+			if ok := capture.Read(&img); !ok || img.Empty() {
+				consecutiveFailures++
+				log.Printf("Failed to read frame from camera %s for face detection (failures: %d/%d)",
+					cameraID, consecutiveFailures, maxConsecutiveFailures)
+
+				// If too many failures, try to reconnect
+				if consecutiveFailures >= maxConsecutiveFailures {
+					log.Printf("Too many consecutive failures, attempting to reconnect camera %s", cameraID)
+					capture.Close()
+					capture = nil
+
+					if !sleepContext(ctx, retry.InitialDelay) { // Wait before reconnecting
+						return
+					}
 
-						consecutiveFailures = 0
-						log.Printf("Successfully reconnected camera %s", cameraID)
+					capture, err = openVideoCaptureWithRetry(ctx, cameraID, rtspURL, "face detection", retry)
+					if err != nil {
+						log.Printf("Failed to reconnect video capture for camera %s: %v", cameraID, err)
+						return // Give up
 					}
-					continue
-				}
 
-				// Reset failure counter on successful read
-				consecutiveFailures = 0
+					// Discard initial frames after reconnect
+					for i := 0; i < 5; i++ {
+						capture.Read(&img)
+					}
 
-				// Validate frame before processing
-				if img.Cols() < 100 || img.Rows() < 100 {
-					continue // Frame too small, skip
+					consecutiveFailures = 0
+					log.Printf("Successfully reconnected camera %s", cameraID)
 				}
+				continue
+			}
+
+			// Reset failure counter on successful read
+			consecutiveFailures = 0
 
-				// Process frame for face detection
-				faceDetector.ProcessFrameForFaceDetection(cameraID, cameraName, img)
+			// Validate frame before processing
+			if img.Cols() < 100 || img.Rows() < 100 {
+				continue // Frame too small, skip
 			}
+
+			chain.Run(cameraID, img)
 		}
-	}()
+	}
+}
+
+// detectionRetryPolicy is how face detection reconnects to the camera: its sourceRetry
+// option if set, otherwise 3 attempts 2s apart
+func detectionRetryPolicy(cameraID string) RTSPRetryPolicy {
+	processMutex.RLock()
+	process, exists := activeProcesses[cameraID]
+	processMutex.RUnlock()
+	if exists && process.Options.SourceRetry != nil {
+		return process.Options.SourceRetry.policy()
+	}
+	return RTSPRetryPolicy{MaxAttempts: 3, InitialDelay: 2 * time.Second, MaxDelay: 2 * time.Second}
+}
+
+// openVideoCaptureWithRetry opens a gocv capture of rtspURL with a small buffer for
+// real-time reads, retrying under the policy. purpose names the reader in the logs.
+func openVideoCaptureWithRetry(ctx context.Context, cameraID, rtspURL, purpose string, retry RTSPRetryPolicy) (*gocv.VideoCapture, error) {
+	var lastErr error
+	retryDelay := retry.InitialDelay
+	for attempt := 1; retry.MaxAttempts == 0 || attempt <= retry.MaxAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		capture, err := gocv.OpenVideoCapture(rtspURL)
+		if err == nil && capture != nil && capture.IsOpened() {
+			// Set buffer size to reduce latency and packet loss
+			capture.Set(gocv.VideoCaptureFPS, 15)       // Limit FPS to reduce bandwidth
+			capture.Set(gocv.VideoCaptureBufferSize, 3) // Small buffer for real-time
+
+			log.Printf("Successfully opened video capture for %s on camera %s (attempt %d)", purpose, cameraID, attempt)
+			return capture, nil
+		}
+		if capture != nil {
+			capture.Close()
+		}
+		if err == nil {
+			err = fmt.Errorf("video capture did not open")
+		}
+		lastErr = err
+		log.Printf("Failed to open video capture for %s on camera %s (attempt %d): %v", purpose, cameraID, attempt, err)
+
+		if retry.MaxAttempts == 0 || attempt < retry.MaxAttempts {
+			log.Printf("Retrying %s video capture in %v...", purpose, retryDelay)
+			if !sleepContext(ctx, retryDelay) {
+				return nil, ctx.Err()
+			}
+			retryDelay = min(retryDelay*2, retry.MaxDelay)
+		}
+	}
+	log.Printf("All attempts failed to open video capture for %s on camera %s", purpose, cameraID)
+	return nil, lastErr
+}
+
+// registerFaceDetection records a new detection for the camera, cancelling any previous
+// one, and returns its context. The caller holds processMutex (either mode) and has
+// checked the camera's process is running, so a stop can't slip in before the detection
+// is registered and leave it behind.
+func registerFaceDetection(cameraID string, parent context.Context) context.Context {
+	faceDetectionMutex.Lock()
+	if cancel, exists := faceDetectionActive[cameraID]; exists {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	faceDetectionActive[cameraID] = cancel
+	faceDetectionMutex.Unlock()
+
+	// The chain's own freeze processor replaces the stream's separate check
+	pauseFreezeDetection(cameraID)
+	return ctx
 }
 
-// stopFaceDetection stops face detection for a camera
+// stopFaceDetection stops face detection for a camera. Callers that also change
+// activeProcesses hold processMutex, which is taken before faceDetectionMutex.
 func stopFaceDetection(cameraID string) {
 	faceDetectionMutex.Lock()
 	defer faceDetectionMutex.Unlock()
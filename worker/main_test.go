@@ -0,0 +1,702 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	if err := registerRequestValidators(); err != nil {
+		panic(err)
+	}
+}
+
+func TestListCamerasFromStore(t *testing.T) {
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "cam-1", Name: "Lobby", RTSPURL: "rtsp://10.0.0.1/stream", Labels: map[string]string{"site": "hq"}})
+	store.AddCamera(CameraRecord{ID: "cam-2", Name: "Dock", RTSPURL: "rtsp://10.0.0.2/stream", Labels: map[string]string{"site": "depot"}})
+	router := newRouter(store)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/cameras?label=site=hq", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /cameras = %d: %s", recorder.Code, recorder.Body)
+	}
+	var body struct {
+		Cameras []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"cameras"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Cameras) != 1 || body.Cameras[0].ID != "cam-1" || body.Cameras[0].Name != "Lobby" {
+		t.Fatalf("GET /cameras?label=site=hq returned %+v, want only cam-1", body.Cameras)
+	}
+}
+
+func TestListCamerasWithoutDatabase(t *testing.T) {
+	router := newRouter(NewSQLCameraStore(nil, 0))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/cameras", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /cameras without a database = %d, want 503", recorder.Code)
+	}
+}
+
+func TestSnapshotTargetReadsStore(t *testing.T) {
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "cam-1", RTSPURL: "rtsp://10.0.0.1/stream"})
+
+	url, source, err := snapshotTarget(store, "cam-1")
+	if err != nil || url != "rtsp://10.0.0.1/stream" || source != "camera" {
+		t.Fatalf("snapshotTarget(cam-1) = %q, %q, %v; want the stored URL read directly", url, source, err)
+	}
+	if _, _, err := snapshotTarget(store, "cam-unknown"); err == nil {
+		t.Fatal("snapshotTarget accepted a camera the store doesn't know")
+	}
+}
+
+func TestCheckPathConflictPolicies(t *testing.T) {
+	sources := map[string]string{"cam_shared": "rtsp://other-host/feed", "cam_ours": mediamtxPublisherSource}
+	mediamtx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[len("/v3/config/paths/get/"):]
+		source, ok := sources[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": name, "source": source})
+	}))
+	defer mediamtx.Close()
+	instance := &MediaMTXInstance{APIURL: mediamtx.URL}
+
+	tests := []struct {
+		policy, path string
+		wantErr      bool
+		wantSkipped  bool
+	}{
+		{pathConflictOverwrite, "cam_shared", false, false},
+		{pathConflictSkip, "cam_shared", true, true},
+		{pathConflictError, "cam_shared", true, false},
+		{pathConflictError, "cam_ours", false, false},
+		{pathConflictError, "cam_missing", false, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("MEDIAMTX_PATH_CONFLICT_POLICY", tt.policy)
+		err := checkPathConflict(instance, tt.path, mediamtxPublisherSource)
+		var conflictErr *PathConflictError
+		if got := errors.As(err, &conflictErr); got != tt.wantErr {
+			t.Errorf("%s policy on %s: err = %v, want a conflict: %v", tt.policy, tt.path, err, tt.wantErr)
+			continue
+		}
+		if conflictErr != nil && (conflictErr.Skipped != tt.wantSkipped || conflictErr.Owner != sources[tt.path]) {
+			t.Errorf("%s policy on %s: got %+v", tt.policy, tt.path, conflictErr)
+		}
+	}
+}
+
+func TestStreamWebRTCURLFollowsInstance(t *testing.T) {
+	t.Setenv("MEDIAMTX_WEBRTC_URL", "http://mediamtx-1:8891")
+	processMutex.Lock()
+	activeProcesses["cam-sharded"] = &ReencodingProcess{
+		CameraID: "cam-sharded",
+		Options:  StreamOptions{MediaMTX: &MediaMTXInstance{WebRTCURL: "http://mediamtx-2:8891/"}},
+	}
+	activeProcesses["cam-default"] = &ReencodingProcess{CameraID: "cam-default"}
+	processMutex.Unlock()
+	t.Cleanup(func() {
+		processMutex.Lock()
+		delete(activeProcesses, "cam-sharded")
+		delete(activeProcesses, "cam-default")
+		processMutex.Unlock()
+	})
+	router := newRouter(NewMemoryCameraStore())
+
+	for cameraID, want := range map[string]string{
+		"cam-sharded": "http://mediamtx-2:8891/" + cameraPathName("cam-sharded"),
+		"cam-default": "http://mediamtx-1:8891/" + cameraPathName("cam-default"),
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/streams/"+cameraID, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET /streams/%s = %d: %s", cameraID, recorder.Code, recorder.Body)
+		}
+		var info struct {
+			WebRTCURL string `json:"webrtcUrl"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.WebRTCURL != want {
+			t.Errorf("%s webrtcUrl = %q, want %q", cameraID, info.WebRTCURL, want)
+		}
+	}
+}
+
+// fakeMediaMTX serves the path config and runtime endpoints over a set of configured
+// paths (name -> source), each ready once configured, answering 404 for a path it
+// doesn't have. Requests are logged as "METHOD /path".
+type fakeMediaMTX struct {
+	mu       sync.Mutex
+	paths    map[string]string
+	requests []string
+	deletes  []string
+	onDelete func()
+	failGets bool // Path config lookups answer 500
+}
+
+func (f *fakeMediaMTX) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	source, exists := f.paths[name]
+	switch {
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v3/config/paths/delete/"):
+		f.deletes = append(f.deletes, name)
+		if f.onDelete != nil {
+			f.onDelete()
+		}
+		if !exists {
+			http.Error(w, `{"error":"path configuration not found"}`, http.StatusNotFound)
+			return
+		}
+		delete(f.paths, name)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v3/config/paths/add/"):
+		if exists {
+			http.Error(w, `{"error":"path already exists"}`, http.StatusBadRequest)
+			return
+		}
+		var config struct {
+			Source string `json:"source"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.paths[name] = config.Source
+	case strings.HasPrefix(r.URL.Path, "/v3/config/paths/get/"):
+		if f.failGets {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": name, "source": source})
+	case strings.HasPrefix(r.URL.Path, "/v3/paths/get/"):
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"name": name, "ready": true, "source": map[string]string{"type": "rtspSource"}})
+	case r.URL.Path == "/v3/paths/list" || r.URL.Path == "/v3/config/paths/list":
+		type item struct {
+			Name string `json:"name"`
+		}
+		list := struct {
+			Items []item `json:"items"`
+		}{Items: []item{}}
+		for name := range f.paths {
+			list.Items = append(list.Items, item{Name: name})
+		}
+		json.NewEncoder(w).Encode(list)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestStopDeletesMediaMTXPathAfterExit(t *testing.T) {
+	const cameraID = "cam-stop"
+	pathName := cameraPathName(cameraID)
+	mediamtx := &fakeMediaMTX{paths: map[string]string{pathName: mediamtxPublisherSource, "other_path": "rtsp://other-host/feed"}}
+	server := httptest.NewServer(mediamtx)
+	defer server.Close()
+	t.Setenv("MEDIAMTX_API_URL", server.URL)
+
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: cameraID, RTSPURL: "rtsp://10.0.0.1/stream"})
+	store.UpdateCameraPathInfo(cameraID, pathName, true)
+
+	// A stand-in for FFmpeg that runs until the stop cancels its context, monitored the
+	// way startReencodingProcess does: the path goes once the command has exited
+	ctx, cancel := context.WithCancel(context.Background())
+	command := exec.CommandContext(ctx, "sleep", "60")
+	if err := command.Start(); err != nil {
+		t.Skipf("can't run a stand-in process: %v", err)
+	}
+	exited, tornDown := make(chan struct{}), make(chan struct{})
+	exitedAtDelete := false
+	mediamtx.onDelete = func() {
+		select {
+		case <-exited:
+			exitedAtDelete = true
+		default:
+		}
+	}
+	process := &ReencodingProcess{
+		CameraID: cameraID,
+		Context:  ctx,
+		Cancel:   cancel,
+		Command:  command,
+		Store:    store,
+		Exited:   exited,
+		TornDown: tornDown,
+	}
+	go func() {
+		defer close(tornDown)
+		command.Wait()
+		close(exited)
+		finishStoppedProcess(store, process, "")
+	}()
+	processMutex.Lock()
+	activeProcesses[cameraID] = process
+	processMutex.Unlock()
+
+	if !stopReencodingProcess(cameraID) {
+		t.Fatal("stopReencodingProcess reported no process")
+	}
+
+	mediamtx.mu.Lock()
+	deletes := append([]string(nil), mediamtx.deletes...)
+	mediamtx.mu.Unlock()
+	if len(deletes) != 1 || deletes[0] != pathName {
+		t.Fatalf("MediaMTX got deletes %v, want one for %s", deletes, pathName)
+	}
+	if !exitedAtDelete {
+		t.Fatal("the path was deleted before the process had exited")
+	}
+	states, err := listMediaMTXPathStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, listed := states[pathName]; listed {
+		t.Fatalf("%s is still in the MediaMTX paths list: %v", pathName, states)
+	}
+	if _, listed := states["other_path"]; !listed {
+		t.Fatalf("stop removed another path: %v", states)
+	}
+	if _, _, configured, _ := store.GetCameraInfo(cameraID); configured {
+		t.Fatal("camera still recorded as configured after the stop")
+	}
+
+	// The path is gone now, so MediaMTX answers 404, which counts as deleted
+	if err := cleanupMediaMTXPath(store, nil, pathName); err != nil {
+		t.Fatalf("cleanup of an already deleted path = %v, want nil", err)
+	}
+}
+
+// countRequests counts the logged requests starting with prefix
+func (f *fakeMediaMTX) countRequests(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestConfigureMediaMTXPathChecksExistingPath(t *testing.T) {
+	const (
+		pathName = "camera_cam-cfg"
+		want     = "rtsp://10.0.0.1/stream"
+	)
+	const foreign = "rtsp://10.0.0.9/old"
+	tests := []struct {
+		name        string
+		existing    map[string]string
+		failGets    bool
+		policy      string // MEDIAMTX_PATH_CONFLICT_POLICY, overwrite when empty
+		wantDeletes int
+		wantAdds    int
+		wantSource  string
+		wantSkipped *bool // The PathConflictError expected, if any
+	}{
+		{name: "path doesn't exist", existing: map[string]string{}, wantAdds: 1, wantSource: want},
+		{name: "path exists with this source", existing: map[string]string{pathName: want}, wantSource: want},
+		{name: "path exists with a different source", existing: map[string]string{pathName: foreign}, wantDeletes: 1, wantAdds: 1, wantSource: want},
+		{name: "lookup fails", existing: map[string]string{pathName: foreign}, failGets: true, wantDeletes: 1, wantAdds: 1, wantSource: want},
+		{name: "lookup fails under skip", existing: map[string]string{pathName: foreign}, failGets: true, policy: pathConflictSkip, wantSource: foreign, wantSkipped: boolPtr(true)},
+		{name: "lookup fails under error", existing: map[string]string{pathName: foreign}, failGets: true, policy: pathConflictError, wantSource: foreign, wantSkipped: boolPtr(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.policy != "" {
+				t.Setenv("MEDIAMTX_PATH_CONFLICT_POLICY", tt.policy)
+			} else {
+				t.Parallel() // Each waits out a readiness poll
+			}
+			mediamtx := &fakeMediaMTX{paths: tt.existing, failGets: tt.failGets}
+			server := httptest.NewServer(mediamtx)
+			defer server.Close()
+
+			store := NewMemoryCameraStore()
+			store.AddCamera(CameraRecord{ID: "cam-cfg", RTSPURL: want})
+			err := configureMediaMTXPath(store, &MediaMTXInstance{APIURL: server.URL}, pathName, want)
+			if tt.wantSkipped != nil {
+				var conflictErr *PathConflictError
+				if !errors.As(err, &conflictErr) || conflictErr.Skipped != *tt.wantSkipped || conflictErr.Owner != unknownPathOwner {
+					t.Fatalf("configureMediaMTXPath = %v, want a conflict (skipped %v) with an unknown owner", err, *tt.wantSkipped)
+				}
+			} else if err != nil {
+				t.Fatalf("configureMediaMTXPath = %v", err)
+			}
+
+			if got := mediamtx.countRequests("GET /v3/config/paths/get/" + pathName); got < 1 {
+				t.Errorf("the path wasn't looked up before configuring")
+			}
+			if got := mediamtx.countRequests("DELETE "); got != tt.wantDeletes {
+				t.Errorf("%d deletes, want %d", got, tt.wantDeletes)
+			}
+			if got := mediamtx.countRequests("POST /v3/config/paths/add/"); got != tt.wantAdds {
+				t.Errorf("%d adds, want %d", got, tt.wantAdds)
+			}
+			mediamtx.mu.Lock()
+			source := mediamtx.paths[pathName]
+			mediamtx.mu.Unlock()
+			if source != tt.wantSource {
+				t.Errorf("path source = %q, want %q", source, tt.wantSource)
+			}
+			if _, _, configured, _ := store.GetCameraInfo("cam-cfg"); configured != (tt.wantSkipped == nil) {
+				t.Errorf("camera recorded as configured: %v, want %v", configured, tt.wantSkipped == nil)
+			}
+		})
+	}
+}
+
+func TestConfigureMediaMTXPathSkipsForeignSource(t *testing.T) {
+	t.Setenv("MEDIAMTX_PATH_CONFLICT_POLICY", pathConflictSkip)
+	const pathName = "camera_cam-cfg"
+	mediamtx := &fakeMediaMTX{paths: map[string]string{pathName: "rtsp://other-host/feed"}}
+	server := httptest.NewServer(mediamtx)
+	defer server.Close()
+
+	err := configureMediaMTXPath(NewMemoryCameraStore(), &MediaMTXInstance{APIURL: server.URL}, pathName, "rtsp://10.0.0.1/stream")
+	var conflictErr *PathConflictError
+	if !errors.As(err, &conflictErr) || !conflictErr.Skipped || conflictErr.Owner != "rtsp://other-host/feed" {
+		t.Fatalf("configureMediaMTXPath = %v, want a skipped conflict with the other owner", err)
+	}
+	if got := mediamtx.countRequests("DELETE ") + mediamtx.countRequests("POST "); got != 0 {
+		t.Fatalf("the foreign path was modified (%d requests)", got)
+	}
+}
+
+func TestPathPrefixRoundTrip(t *testing.T) {
+	saved := pathPrefix
+	t.Cleanup(func() { pathPrefix = saved })
+
+	ids := []string{"1", "cam-1", "camera_1", "camera_camera_2", "camera_", "site1-cam.", "A_b-C", strings.Repeat("x", 64)}
+	for _, prefix := range []string{defaultPathPrefix, "site1-cam.", "cam", "camera_camera_"} {
+		pathPrefix = prefix
+		for _, id := range ids {
+			if !cameraIDPattern.MatchString(id) {
+				continue // "site1-cam." isn't an ID, only a prefix
+			}
+			path := cameraPathName(id)
+			got, ok := getCorrespondingCameraID(path)
+			if !ok || got != id {
+				t.Errorf("prefix %q: %q -> %q -> (%q, %v), want %q back", prefix, id, path, got, ok, id)
+				continue
+			}
+			if back := cameraPathName(got); back != path {
+				t.Errorf("prefix %q: %q -> %q -> %q, want the same path", prefix, path, got, back)
+			}
+
+			// The publish URL's last segment is the same path, so it round-trips too
+			publishURL := getReencodedStreamURL(&MediaMTXInstance{PublishURL: "rtsp://mediamtx:8554"}, id)
+			segment := publishURL[strings.LastIndex(publishURL, "/")+1:]
+			if got, ok := getCorrespondingCameraID(segment); !ok || got != id {
+				t.Errorf("prefix %q: publish URL %q maps back to (%q, %v), want %q", prefix, publishURL, got, ok, id)
+			}
+		}
+	}
+}
+
+func TestPathPrefixFromEnv(t *testing.T) {
+	for value, want := range map[string]string{
+		"":            defaultPathPrefix,
+		"site1-cam.":  "site1-cam.",
+		"CAM_":        "CAM_",
+		"has space_":  defaultPathPrefix,
+		"../escape_":  defaultPathPrefix,
+		"cam/nested_": defaultPathPrefix,
+	} {
+		t.Setenv("MEDIAMTX_PATH_PREFIX", value)
+		if got := pathPrefixFromEnv(); got != want {
+			t.Errorf("MEDIAMTX_PATH_PREFIX=%q gives prefix %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestCorrespondingCameraIDRejectsForeignPaths(t *testing.T) {
+	for _, path := range []string{
+		"",
+		defaultPathPrefix,            // Prefix with no ID
+		"live_feed",                  // Created outside the worker
+		"Camera_1",                   // Prefix is case sensitive
+		"xcamera_1",                  // Prefix not at the start
+		defaultPathPrefix + "a b",    // Not a valid camera ID after the prefix
+		defaultPathPrefix + "../etc", // Likewise
+		defaultPathPrefix + "1/sub",  // Nested path
+		defaultPathPrefix + strings.Repeat("x", 65),
+	} {
+		if id, ok := getCorrespondingCameraID(path); ok {
+			t.Errorf("getCorrespondingCameraID(%q) = %q, true; want false for a path the worker doesn't own", path, id)
+		}
+	}
+}
+
+func TestCleanupOfForeignPathLeavesStoreAlone(t *testing.T) {
+	mediamtx := &fakeMediaMTX{paths: map[string]string{"live_feed": "rtsp://other-host/feed"}}
+	server := httptest.NewServer(mediamtx)
+	defer server.Close()
+
+	// A camera whose ID happens to equal the external path's name
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "live_feed", RTSPURL: "rtsp://10.0.0.1/stream"})
+	store.UpdateCameraPathInfo("live_feed", cameraPathName("live_feed"), true)
+
+	if err := cleanupMediaMTXPath(store, &MediaMTXInstance{APIURL: server.URL}, "live_feed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := mediamtx.countRequests("DELETE /v3/config/paths/delete/live_feed"); got != 1 {
+		t.Fatalf("%d deletes of the path, want 1", got)
+	}
+	_, pathName, configured, _ := store.GetCameraInfo("live_feed")
+	if !configured || pathName != cameraPathName("live_feed") {
+		t.Fatalf("cleanup of a foreign path rewrote camera live_feed to (%q, %v)", pathName, configured)
+	}
+}
+
+// Run with -race: a camera starts and stops in a loop while its face detection is
+// toggled on and off, so registration, the stop's teardown and the toggle interleave
+func TestFaceDetectionToggleRacesStartStop(t *testing.T) {
+	const (
+		cameraID = "cam-race"
+		cycles   = 200
+	)
+	savedDetector, savedChain := faceDetector, frameProcessorConfig.Chain
+	faceDetector = &FaceDetector{enabled: true}
+	frameProcessorConfig.Chain = nil // Registration only; no detection loop dials the camera
+	t.Cleanup(func() { faceDetector, frameProcessorConfig.Chain = savedDetector, savedChain })
+
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: cameraID, RTSPURL: "rtsp://10.0.0.1/stream"})
+	router := newRouter(store)
+	toggle := func(enabled bool) int {
+		body := fmt.Sprintf(`{"cameraId":%q,"enabled":%v}`, cameraID, enabled)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/face-detection/toggle", strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	var contextsMu sync.Mutex
+	var processContexts []context.Context
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < cycles; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			contextsMu.Lock()
+			processContexts = append(processContexts, ctx)
+			contextsMu.Unlock()
+			processMutex.Lock()
+			activeProcesses[cameraID] = &ReencodingProcess{CameraID: cameraID, Context: ctx, Cancel: cancel}
+			processMutex.Unlock()
+			stopReencodingProcess(cameraID)
+		}
+	}()
+	for _, enabled := range []bool{true, false} {
+		go func(enabled bool) {
+			defer wg.Done()
+			for i := 0; i < cycles; i++ {
+				switch code := toggle(enabled); code {
+				case http.StatusOK, http.StatusBadRequest, http.StatusConflict:
+				default:
+					t.Errorf("toggle enabled=%v = %d", enabled, code)
+					return
+				}
+			}
+		}(enabled)
+	}
+	wg.Wait()
+
+	// Whatever the interleaving, a stopped camera has no detection left registered
+	if faceDetectionRunning(cameraID) {
+		t.Fatal("face detection still registered for the stopped camera")
+	}
+	for i, ctx := range processContexts {
+		if ctx.Err() == nil {
+			t.Fatalf("process %d wasn't cancelled by its stop", i)
+		}
+	}
+}
+
+func TestRestartBackoffClampsShift(t *testing.T) {
+	base, maxDelay := 2*time.Second, 30*time.Second
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 2 * time.Second},
+		{1, 4 * time.Second},
+		{3, 16 * time.Second},
+		{4, maxDelay},
+		{30, maxDelay},
+		{63, maxDelay}, // 1<<63 alone would be negative
+		{64, maxDelay}, // and 1<<64 zero
+		{1000, maxDelay},
+	}
+	for _, tt := range tests {
+		if got := restartBackoff(tt.failures, base, maxDelay); got != tt.want {
+			t.Errorf("restartBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestProcessRejectsUnreachableDetectionStream(t *testing.T) {
+	router := newRouter(NewMemoryCameraStore())
+	body := `{"cameraId": "cam-dual", "rtspUrl": "rtsp://10.0.0.1/main", "detectionRtspUrl": "rtsp://127.0.0.1:1/sub"}`
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("POST /process = %d: %s, want 400", recorder.Code, recorder.Body)
+	}
+	var resp struct {
+		Error     string            `json:"error"`
+		Detection SourceProbeResult `json:"detection"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Error, "pre-flight") || resp.Detection.Reachable || resp.Detection.Error == "" {
+		t.Fatalf("response %+v, want the failed detection probe", resp)
+	}
+
+	processMutex.RLock()
+	_, started := activeProcesses["cam-dual"]
+	processMutex.RUnlock()
+	if started {
+		t.Fatal("camera started despite the unreachable detection stream")
+	}
+}
+
+func TestStartReencodingProcessSettlesHalfOpenProbe(t *testing.T) {
+	const cameraID = "cam-probe"
+	t.Setenv("DEV_MODE", "false")
+	t.Cleanup(func() {
+		sourceConnections.SetLimit(cameraID, 0)
+		circuitBreakersMutex.Lock()
+		delete(circuitBreakers, cameraID)
+		circuitBreakersMutex.Unlock()
+	})
+	halfOpen := func() *CircuitBreaker {
+		cb := claimCircuitBreaker(cameraID, StreamOptions{})
+		cb.mu.Lock()
+		cb.State, cb.LastFailureTime, cb.probeInFlight = "open", time.Now().Add(-2*cb.ResetTimeout), false
+		cb.mu.Unlock()
+		return cb
+	}
+	store := NewMemoryCameraStore()
+
+	// Refused locally: the connection limit is taken, so the probe is handed back
+	cb := halfOpen()
+	release, err := sourceConnections.TryAcquire(cameraID, sourceConnReencode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = startReencodingProcess(store, cameraID, "rtsp://10.0.0.1/stream", StreamOptions{MaxSourceConnections: 1})
+	release()
+	var limitErr *SourceConnectionLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("startReencodingProcess = %v, want the connection limit", err)
+	}
+	if state := cb.Snapshot(); state.State != "half-open" || state.ProbeInFlight || !cb.WouldAllow() {
+		t.Fatalf("after a local refusal: %+v, want a half-open breaker granting the next probe", state)
+	}
+
+	// A source the worker can't read fails the probe
+	cb = halfOpen()
+	if err := startReencodingProcess(store, cameraID, "testsrc://", StreamOptions{}); err == nil {
+		t.Fatal("a test pattern started without DEV_MODE")
+	}
+	if state := cb.Snapshot(); state.State != "open" || state.ProbeInFlight {
+		t.Fatalf("after a source error: %+v, want the breaker reopened", state)
+	}
+}
+
+// A toggle is stored with the camera, so the process a restart starts after FFmpeg
+// exited picks detection and its settings up again
+func TestFaceDetectionToggleSurvivesRestart(t *testing.T) {
+	const cameraID = "cam-resume"
+	savedDetector, savedChain := faceDetector, frameProcessorConfig.Chain
+	faceDetector = &FaceDetector{enabled: true}
+	frameProcessorConfig.Chain = nil // Registration only; no detection loop dials the camera
+	t.Cleanup(func() { faceDetector, frameProcessorConfig.Chain = savedDetector, savedChain })
+
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: cameraID, RTSPURL: "rtsp://10.0.0.1/stream"})
+	start := func() *ReencodingProcess {
+		ctx, cancel := context.WithCancel(context.Background())
+		process := &ReencodingProcess{CameraID: cameraID, SourceURL: "rtsp://10.0.0.1/stream", Context: ctx, Cancel: cancel, Store: store}
+		processMutex.Lock()
+		activeProcesses[cameraID] = process
+		resumeFaceDetection(store, process)
+		processMutex.Unlock()
+		return process
+	}
+	t.Cleanup(func() { stopReencodingProcess(cameraID) })
+
+	first := start()
+	if faceDetectionRunning(cameraID) {
+		t.Fatal("face detection started before it was toggled on")
+	}
+	body := fmt.Sprintf(`{"cameraId":%q,"enabled":true,"intervalMs":500,"threshold":0.7}`, cameraID)
+	recorder := httptest.NewRecorder()
+	newRouter(store).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/face-detection/toggle", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK || !faceDetectionRunning(cameraID) {
+		t.Fatalf("toggle = %d: %s, running %v; want detection on", recorder.Code, recorder.Body, faceDetectionRunning(cameraID))
+	}
+
+	// FFmpeg exits: the monitor tears the camera's state down, detection included
+	processMutex.Lock()
+	replaced := releaseExitedProcess(first)
+	processMutex.Unlock()
+	first.Cancel()
+	if replaced || faceDetectionRunning(cameraID) {
+		t.Fatalf("after the exit: replaced %v, detection running %v; want both false", replaced, faceDetectionRunning(cameraID))
+	}
+
+	start()
+	if !faceDetectionRunning(cameraID) {
+		t.Fatal("face detection didn't resume with the restarted process")
+	}
+	settings, err := getFaceDetectionSettings(store, cameraID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.Enabled || settings.Interval != 500*time.Millisecond || settings.Threshold != 0.7 {
+		t.Fatalf("settings after the restart: enabled %v, interval %v, threshold %v; want true, 500ms, 0.7",
+			settings.Enabled, settings.Interval, settings.Threshold)
+	}
+}
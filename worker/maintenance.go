@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MaintenanceState is the worker-wide maintenance flag set via POST /maintenance.
+// While enabled the worker refuses new streams but leaves running ones alone.
+type MaintenanceState struct {
+	Enabled          bool       `json:"enabled"`
+	Reason           string     `json:"reason,omitempty"`
+	AllowAutoRestart bool       `json:"allowAutoRestart"` // Whether existing cameras still auto-restart after a failure
+	Since            *time.Time `json:"since,omitempty"`
+}
+
+var (
+	maintenance      = MaintenanceState{AllowAutoRestart: true}
+	maintenanceMutex sync.RWMutex
+)
+
+// currentMaintenance returns a snapshot of the maintenance state
+func currentMaintenance() MaintenanceState {
+	maintenanceMutex.RLock()
+	defer maintenanceMutex.RUnlock()
+	return maintenance
+}
+
+// setMaintenance turns maintenance mode on or off; turning it off restores the defaults
+func setMaintenance(enabled bool, reason string, allowAutoRestart bool) MaintenanceState {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	if !enabled {
+		if maintenance.Enabled {
+			log.Printf("Maintenance mode disabled, accepting new streams")
+		}
+		maintenance = MaintenanceState{AllowAutoRestart: true}
+		return maintenance
+	}
+
+	since := time.Now()
+	if maintenance.Enabled && maintenance.Since != nil {
+		since = *maintenance.Since // Changing the reason doesn't restart the clock
+	}
+	maintenance = MaintenanceState{
+		Enabled:          true,
+		Reason:           reason,
+		AllowAutoRestart: allowAutoRestart,
+		Since:            &since,
+	}
+	log.Printf("Maintenance mode enabled (reason: %q, auto-restart: %v), refusing new streams", reason, allowAutoRestart)
+	return maintenance
+}
+
+// maintenanceRejection returns the error for a new-stream request refused during
+// maintenance, and whether the request should be refused at all
+func maintenanceRejection() (string, bool) {
+	state := currentMaintenance()
+	if !state.Enabled {
+		return "", false
+	}
+	if state.Reason == "" {
+		return "Worker is in maintenance mode and not accepting new streams", true
+	}
+	return fmt.Sprintf("Worker is in maintenance mode and not accepting new streams: %s", state.Reason), true
+}
+
+// autoRestartAllowed reports whether a failed stream may be restarted under the
+// current maintenance state
+func autoRestartAllowed() bool {
+	state := currentMaintenance()
+	return !state.Enabled || state.AllowAutoRestart
+}
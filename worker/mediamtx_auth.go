@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin renews a fetched token this long before it expires
+const tokenRefreshMargin = 30 * time.Second
+
+// MediaMTXAuthProvider attaches credentials to MediaMTX API requests
+type MediaMTXAuthProvider interface {
+	// Authorize adds credentials to req, fetching a token first if needed
+	Authorize(req *http.Request) error
+	// Invalidate drops cached credentials after MediaMTX rejects them with 401
+	Invalidate()
+	// Name describes the provider for logs
+	Name() string
+}
+
+// basicAuthProvider sends static basic-auth credentials (MediaMTX internal auth)
+type basicAuthProvider struct {
+	username string
+	password string
+}
+
+func (p *basicAuthProvider) Authorize(req *http.Request) error {
+	req.SetBasicAuth(p.username, p.password)
+	return nil
+}
+
+func (p *basicAuthProvider) Invalidate() {}
+
+func (p *basicAuthProvider) Name() string { return "basic" }
+
+// staticTokenProvider sends a fixed bearer token, e.g. a long-lived JWT
+type staticTokenProvider struct {
+	token string
+}
+
+func (p *staticTokenProvider) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	return nil
+}
+
+func (p *staticTokenProvider) Invalidate() {}
+
+func (p *staticTokenProvider) Name() string { return "static-token" }
+
+// clientCredentialsProvider fetches JWTs from the identity provider MediaMTX trusts
+// (authMethod: jwt) using the OAuth2 client-credentials grant, caching each token
+// until shortly before it expires
+type clientCredentialsProvider struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	client       *http.Client
+
+	token     string
+	expiresAt time.Time
+	mu        sync.Mutex
+}
+
+func (p *clientCredentialsProvider) Authorize(req *http.Request) error {
+	token, err := p.currentToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (p *clientCredentialsProvider) Invalidate() {
+	p.mu.Lock()
+	p.token = ""
+	p.mu.Unlock()
+}
+
+func (p *clientCredentialsProvider) Name() string { return "client-credentials" }
+
+// currentToken returns the cached token, refreshing it when missing or near expiry
+func (p *clientCredentialsProvider) currentToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" && time.Now().Before(p.expiresAt) {
+		return p.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if p.scope != "" {
+		form.Set("scope", p.scope)
+	}
+	req, err := http.NewRequest("POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.clientID, p.clientSecret)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch MediaMTX token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access_token")
+	}
+
+	lifetime := time.Duration(tokenResp.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	if lifetime > 2*tokenRefreshMargin {
+		lifetime -= tokenRefreshMargin
+	}
+	p.token = tokenResp.AccessToken
+	p.expiresAt = time.Now().Add(lifetime)
+	log.Printf("Fetched MediaMTX API token (valid for %v)", lifetime.Round(time.Second))
+	return p.token, nil
+}
+
+// newMediaMTXAuthFromEnv picks the provider: MEDIAMTX_API_TOKEN for a static token,
+// MEDIAMTX_TOKEN_URL (+ MEDIAMTX_CLIENT_ID/SECRET/SCOPE) for refreshed tokens,
+// otherwise basic auth with MEDIAMTX_API_USER/MEDIAMTX_API_PASS, each defaulting to admin
+func newMediaMTXAuthFromEnv() MediaMTXAuthProvider {
+	if token := os.Getenv("MEDIAMTX_API_TOKEN"); token != "" {
+		return &staticTokenProvider{token: token}
+	}
+
+	if tokenURL := os.Getenv("MEDIAMTX_TOKEN_URL"); tokenURL != "" {
+		return &clientCredentialsProvider{
+			tokenURL:     tokenURL,
+			clientID:     os.Getenv("MEDIAMTX_CLIENT_ID"),
+			clientSecret: os.Getenv("MEDIAMTX_CLIENT_SECRET"),
+			scope:        os.Getenv("MEDIAMTX_TOKEN_SCOPE"),
+			client:       &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	// Default MediaMTX credentials, so a secured API only needs whichever one differs
+	username, password := "admin", "admin"
+	if value := os.Getenv("MEDIAMTX_API_USER"); value != "" {
+		username = value
+	}
+	if value := os.Getenv("MEDIAMTX_API_PASS"); value != "" {
+		password = value
+	}
+	return &basicAuthProvider{username: username, password: password}
+}
+
+// mediamtxDo sends an authorized MediaMTX API request. On 401 it drops the cached
+// credentials and retries once, so an expired token is refreshed transparently.
+func mediamtxDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	if err := mediamtxAuth.Authorize(req); err != nil {
+		return nil, fmt.Errorf("MediaMTX API authorization failed: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The body can only be replayed if the request was built from a rewindable reader
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, mediamtxErrorBodyLimit))
+	resp.Body.Close()
+
+	log.Printf("MediaMTX API rejected %s credentials for %s, refreshing and retrying", mediamtxAuth.Name(), req.URL.Path)
+	mediamtxAuth.Invalidate()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewind request body: %w", err)
+		}
+		retry.Body = body
+	}
+	if err := mediamtxAuth.Authorize(retry); err != nil {
+		return nil, fmt.Errorf("MediaMTX API authorization failed: %w", err)
+	}
+	return client.Do(retry)
+}
+
+// mediamtxGet sends an authorized GET to the MediaMTX API
+func mediamtxGet(client *http.Client, apiURL string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return mediamtxDo(client, req)
+}
+
+// mediamtxErrorBodyLimit is how much of a failed response is kept for logs and errors
+const mediamtxErrorBodyLimit = 4096
+
+// mediamtxMaxResponseBytes caps how much of a MediaMTX API response body is read
+// (MEDIAMTX_MAX_RESPONSE_BYTES), so a misbehaving API can't exhaust worker memory
+var mediamtxMaxResponseBytes int64 = 8 << 20
+
+// mediamtxResponseLimitFromEnv reads MEDIAMTX_MAX_RESPONSE_BYTES, defaulting to 8 MiB
+func mediamtxResponseLimitFromEnv() int64 {
+	limit := getEnvInt("MEDIAMTX_MAX_RESPONSE_BYTES", 8<<20)
+	if limit <= 0 {
+		log.Printf("Invalid MEDIAMTX_MAX_RESPONSE_BYTES %d, using 8 MiB", limit)
+		limit = 8 << 20
+	}
+	return int64(limit)
+}
+
+// readMediaMTXBody reads a MediaMTX API response body up to mediamtxMaxResponseBytes.
+// Every MediaMTX client sets Timeout, which also bounds the body read, so a stalled
+// response fails here instead of hanging the caller.
+func readMediaMTXBody(resp *http.Response) ([]byte, error) {
+	limit := mediamtxMaxResponseBytes
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("MediaMTX response of %d bytes exceeds limit of %d bytes", resp.ContentLength, limit)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MediaMTX response: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("MediaMTX response exceeds limit of %d bytes", limit)
+	}
+	return body, nil
+}
+
+// decodeMediaMTXJSON decodes a size-capped MediaMTX API response body into v
+func decodeMediaMTXJSON(resp *http.Response, v any) error {
+	body, err := readMediaMTXBody(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// mediamtxErrorBody reads the start of a failed response for logs and error messages
+func mediamtxErrorBody(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, mediamtxErrorBodyLimit))
+	return string(body)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// MediaMTXInstance selects the MediaMTX server a camera's path lives on, for sharded
+// setups. Unset fields (and a nil instance) fall back to MEDIAMTX_API_URL, MEDIAMTX_URL
+// and MEDIAMTX_WEBRTC_URL.
+type MediaMTXInstance struct {
+	APIURL     string `json:"apiUrl,omitempty"`     // Control API, e.g. http://mediamtx-2:9997
+	PublishURL string `json:"publishUrl,omitempty"` // RTSP base FFmpeg publishes to, e.g. rtsp://mediamtx-2:8554
+	WebRTCURL  string `json:"webrtcUrl,omitempty"`  // WebRTC listener for playback and WHIP, e.g. http://mediamtx-2:8891
+}
+
+// apiBaseURL returns the instance's control API URL without a trailing slash
+func (m *MediaMTXInstance) apiBaseURL() string {
+	if m != nil && m.APIURL != "" {
+		return strings.TrimRight(m.APIURL, "/")
+	}
+	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
+	if mediamtxAPIURL == "" {
+		mediamtxAPIURL = "http://localhost:9997"
+	}
+	return mediamtxAPIURL
+}
+
+// publishBaseURL returns the RTSP URL re-encoded streams are published under
+func (m *MediaMTXInstance) publishBaseURL() string {
+	if m != nil && m.PublishURL != "" {
+		return strings.TrimRight(m.PublishURL, "/")
+	}
+	mediamtxURL := os.Getenv("MEDIAMTX_URL")
+	if mediamtxURL == "" {
+		mediamtxURL = "rtsp://localhost:8554"
+	}
+	return strings.TrimRight(mediamtxURL, "/")
+}
+
+// webrtcBaseURL returns the instance's WebRTC listener, which playback URLs point at
+// and WHIP offers are forwarded to
+func (m *MediaMTXInstance) webrtcBaseURL() string {
+	if m != nil && m.WebRTCURL != "" {
+		return strings.TrimRight(m.WebRTCURL, "/")
+	}
+	mediamtxWebRTCURL := os.Getenv("MEDIAMTX_WEBRTC_URL")
+	if mediamtxWebRTCURL == "" {
+		mediamtxWebRTCURL = "http://localhost:8891"
+	}
+	return strings.TrimRight(mediamtxWebRTCURL, "/")
+}
+
+// IsDefault reports whether the instance is the one configured by the environment
+func (m *MediaMTXInstance) IsDefault() bool {
+	return m == nil || (m.APIURL == "" && m.PublishURL == "" && m.WebRTCURL == "")
+}
+
+// Validate checks the overrides are URLs of the right kind
+func (m *MediaMTXInstance) Validate() error {
+	if m == nil {
+		return nil
+	}
+	if m.APIURL != "" {
+		parsed, err := url.Parse(m.APIURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("mediamtxApiUrl %q is not a valid http(s):// URL", m.APIURL)
+		}
+		if err := validateURLHost(parsed); err != nil {
+			return fmt.Errorf("mediamtxApiUrl: %w", err)
+		}
+	}
+	if m.PublishURL != "" {
+		parsed, err := url.Parse(m.PublishURL)
+		if err != nil || (parsed.Scheme != "rtsp" && parsed.Scheme != "rtsps") || parsed.Host == "" {
+			return fmt.Errorf("mediamtxPublishUrl %q is not a valid rtsp:// URL", m.PublishURL)
+		}
+		if err := validateURLHost(parsed); err != nil {
+			return fmt.Errorf("mediamtxPublishUrl: %w", err)
+		}
+	}
+	if m.WebRTCURL != "" {
+		parsed, err := url.Parse(m.WebRTCURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("mediamtxWebrtcUrl %q is not a valid http(s):// URL", m.WebRTCURL)
+		}
+		if err := validateURLHost(parsed); err != nil {
+			return fmt.Errorf("mediamtxWebrtcUrl: %w", err)
+		}
+	}
+	return nil
+}
+
+// merge overlays the fields set in override onto m
+func (m *MediaMTXInstance) merge(override *MediaMTXInstance) *MediaMTXInstance {
+	if override == nil {
+		return m
+	}
+	merged := MediaMTXInstance{}
+	if m != nil {
+		merged = *m
+	}
+	if override.APIURL != "" {
+		merged.APIURL = override.APIURL
+	}
+	if override.PublishURL != "" {
+		merged.PublishURL = override.PublishURL
+	}
+	if override.WebRTCURL != "" {
+		merged.WebRTCURL = override.WebRTCURL
+	}
+	return &merged
+}
+
+// activeMediaMTX returns the instance the camera's running process publishes to, or
+// nil (the default instance) when it isn't running
+func activeMediaMTX(cameraID string) *MediaMTXInstance {
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+
+	if process, exists := activeProcesses[cameraID]; exists {
+		return process.Options.MediaMTX
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Outcomes of POST /mediamtx/orphans/cleanup for one path
+const (
+	orphanActionDeleted = "deleted"
+	orphanActionSkipped = "skipped"
+	orphanActionFailed  = "failed"
+)
+
+// OrphanCleanupResult is what POST /mediamtx/orphans/cleanup did with one path
+type OrphanCleanupResult struct {
+	PathName string `json:"pathName"`
+	CameraID string `json:"cameraId,omitempty"`
+	Action   string `json:"action"` // deleted | skipped | failed
+	Reason   string `json:"reason,omitempty"`
+}
+
+// findOrphanedMediaMTXPaths lists the default MediaMTX instance's paths that carry the
+// worker's prefix but belong to no running process and no enabled camera, left behind
+// by crashes or cameras disabled without a stop. Unlike the reconcile plan, a
+// configured path of a disabled camera counts. Without a database every path with no
+// process does. errs lists the states that couldn't be read, in which case nothing is
+// reported rather than risk listing an enabled camera's pre-configured path.
+func findOrphanedMediaMTXPaths(store CameraStore) (orphans []ReconcilePath, errs []string) {
+	orphans = []ReconcilePath{}
+
+	var cameras map[string]CameraRecord
+	if store.Available() {
+		records, err := store.ListCameras()
+		if err != nil {
+			return orphans, []string{fmt.Sprintf("camera store: %v", err)}
+		}
+		cameras = make(map[string]CameraRecord, len(records))
+		for _, camera := range records {
+			cameras[camera.ID] = camera
+		}
+	}
+
+	paths, err := listMediaMTXPathStates()
+	if err != nil {
+		return orphans, []string{fmt.Sprintf("MediaMTX: %v", err)}
+	}
+
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+	for pathName, state := range paths {
+		cameraID, ok := getCorrespondingCameraID(pathName)
+		if !ok {
+			continue
+		}
+		if _, running := activeProcesses[cameraID]; running {
+			continue
+		}
+
+		reason := "no running process"
+		if cameras != nil {
+			camera, known := cameras[cameraID]
+			switch {
+			case !known:
+				reason = "no running process and no camera record"
+			case camera.Enabled:
+				continue // Pre-configured, or about to be restarted
+			default:
+				reason = "no running process and the camera is disabled"
+			}
+		}
+		orphans = append(orphans, ReconcilePath{
+			PathName:   pathName,
+			CameraID:   cameraID,
+			Configured: state.Configured,
+			Ready:      state.Ready,
+			Reason:     reason,
+		})
+	}
+
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].PathName < orphans[j].PathName })
+	return orphans, nil
+}
+
+// cleanupOrphanedMediaMTXPaths deletes the orphaned paths through
+// forceCleanupMediaMTXPath, only those listed in pathNames when it isn't empty. A path
+// is re-checked right before its delete so a camera started in the meantime keeps it,
+// and a deleted path of a known camera is recorded as unconfigured.
+func cleanupOrphanedMediaMTXPaths(store CameraStore, pathNames []string) ([]OrphanCleanupResult, []string) {
+	orphans, errs := findOrphanedMediaMTXPaths(store)
+	if len(errs) > 0 {
+		return []OrphanCleanupResult{}, errs
+	}
+
+	byPath := make(map[string]ReconcilePath, len(orphans))
+	for _, orphan := range orphans {
+		byPath[orphan.PathName] = orphan
+	}
+	if len(pathNames) == 0 {
+		for _, orphan := range orphans {
+			pathNames = append(pathNames, orphan.PathName)
+		}
+	}
+
+	results := make([]OrphanCleanupResult, 0, len(pathNames))
+	for _, pathName := range pathNames {
+		orphan, isOrphan := byPath[pathName]
+		if !isOrphan {
+			results = append(results, OrphanCleanupResult{PathName: pathName, Action: orphanActionSkipped, Reason: "not an orphaned path"})
+			continue
+		}
+
+		processMutex.RLock()
+		_, running := activeProcesses[orphan.CameraID]
+		processMutex.RUnlock()
+		if running {
+			results = append(results, OrphanCleanupResult{PathName: pathName, CameraID: orphan.CameraID,
+				Action: orphanActionSkipped, Reason: "camera started since the path was listed"})
+			continue
+		}
+
+		if err := forceCleanupMediaMTXPath(nil, pathName); err != nil {
+			log.Printf("Failed to delete orphaned MediaMTX path %s: %v", pathName, err)
+			results = append(results, OrphanCleanupResult{PathName: pathName, CameraID: orphan.CameraID,
+				Action: orphanActionFailed, Reason: err.Error()})
+			continue
+		}
+		log.Printf("Deleted orphaned MediaMTX path %s (%s)", pathName, orphan.Reason)
+		store.UpdateCameraPathInfo(orphan.CameraID, pathName, false)
+		results = append(results, OrphanCleanupResult{PathName: pathName, CameraID: orphan.CameraID, Action: orphanActionDeleted})
+	}
+	return results, nil
+}
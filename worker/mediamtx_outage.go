@@ -0,0 +1,292 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MediaMTXOutageConfig controls how the worker rides out a restart of the default
+// MediaMTX instance
+type MediaMTXOutageConfig struct {
+	Interval         time.Duration // How often the MediaMTX API is polled; 0 disables outage handling
+	Grace            time.Duration // Publish failures this long after MediaMTX came back are still blamed on the outage
+	RepublishStagger time.Duration // Pause between cameras when re-publishing after an outage
+}
+
+// loadMediaMTXOutageConfig reads MEDIAMTX_HEALTH_INTERVAL, MEDIAMTX_OUTAGE_GRACE and
+// MEDIAMTX_REPUBLISH_STAGGER
+func loadMediaMTXOutageConfig() MediaMTXOutageConfig {
+	return MediaMTXOutageConfig{
+		Interval:         getEnvDuration("MEDIAMTX_HEALTH_INTERVAL", 5*time.Second),
+		Grace:            getEnvDuration("MEDIAMTX_OUTAGE_GRACE", 30*time.Second),
+		RepublishStagger: getEnvDuration("MEDIAMTX_REPUBLISH_STAGGER", time.Second),
+	}
+}
+
+// deferredRepublish is a camera whose FFmpeg lost MediaMTX during an outage and waits
+// for the coordinated re-publish instead of restarting on its own
+type deferredRepublish struct {
+	store     CameraStore
+	sourceURL string
+	options   StreamOptions
+}
+
+// MediaMTXOutageMonitor watches the default MediaMTX instance. A restart drops every
+// publisher at once; without coordination each camera's monitor would count the
+// failure against its circuit breaker and restart on its own, so all breakers trip
+// together and the recovery arrives as a storm. While MediaMTX is down (and for Grace
+// after it returns) output failures are instead parked here, and once it is back the
+// parked cameras, plus running ones whose path vanished with the restart, are
+// re-published one at a time.
+type MediaMTXOutageMonitor struct {
+	config MediaMTXOutageConfig
+
+	down         bool
+	downSince    time.Time
+	recoveredAt  time.Time
+	lastReady    int // Worker paths with a publisher at the previous poll
+	deferred     map[string]deferredRepublish
+	republishing bool
+
+	outages     uint64
+	restarts    uint64 // Recoveries where MediaMTX came back without the worker's paths
+	suppressed  uint64 // Circuit breaker failures not recorded
+	republished uint64
+	mu          sync.Mutex
+}
+
+// NewMediaMTXOutageMonitor creates a monitor; Run starts polling
+func NewMediaMTXOutageMonitor(config MediaMTXOutageConfig) *MediaMTXOutageMonitor {
+	return &MediaMTXOutageMonitor{config: config, deferred: make(map[string]deferredRepublish)}
+}
+
+var mediamtxOutage = NewMediaMTXOutageMonitor(MediaMTXOutageConfig{})
+
+// Run polls MediaMTX until the process exits
+func (m *MediaMTXOutageMonitor) Run() {
+	if m.config.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.poll()
+	}
+}
+
+// poll reads the path list, reusing the reconcile pass's reader, and handles the
+// transitions: unreachable marks an outage; reachable again with none of the worker's
+// paths live (or live paths all gone without an observed outage) is a restart
+func (m *MediaMTXOutageMonitor) poll() {
+	paths, err := listMediaMTXPathStates()
+	if err != nil {
+		m.markDown(err)
+		return
+	}
+
+	ready := 0
+	for pathName, state := range paths {
+		if _, ok := getCorrespondingCameraID(pathName); ok && state.Ready {
+			ready++
+		}
+	}
+	publishing := len(mediamtxPublishers())
+
+	m.mu.Lock()
+	wasDown := m.down
+	// A last camera being stopped also empties the list, so something must still publish
+	restarted := ready == 0 && publishing > 0 && (wasDown || m.lastReady > 0)
+	m.lastReady = ready
+	if wasDown {
+		m.down = false
+		m.recoveredAt = time.Now()
+		log.Printf("MediaMTX is reachable again after %v (%d worker paths live)", time.Since(m.downSince).Round(time.Second), ready)
+	}
+	if restarted {
+		m.restarts++
+		if !wasDown {
+			// Restarted between two polls; failures still arriving belong to it
+			m.recoveredAt = time.Now()
+		}
+	}
+	start := (wasDown || restarted || len(m.deferred) > 0) && !m.republishing
+	if start {
+		m.republishing = true
+	}
+	m.mu.Unlock()
+
+	if start {
+		go m.republish(paths, restarted)
+	}
+}
+
+// markDown records that MediaMTX is unreachable
+func (m *MediaMTXOutageMonitor) markDown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return
+	}
+	m.down = true
+	m.downSince = time.Now()
+	m.outages++
+	log.Printf("MediaMTX API unreachable, treating it as an outage: %v", err)
+}
+
+// ExplainsFailure reports whether an FFmpeg failure should be blamed on a MediaMTX
+// outage rather than the camera. Only output failures towards the default instance
+// qualify; MediaMTX is probed right away in case the failure beat the next poll.
+func (m *MediaMTXOutageMonitor) ExplainsFailure(instance *MediaMTXInstance, reason string) bool {
+	if m.config.Interval <= 0 || reason != ffmpegFailureOutput || !instance.IsDefault() {
+		return false
+	}
+
+	m.mu.Lock()
+	explained := m.down || (!m.recoveredAt.IsZero() && time.Since(m.recoveredAt) < m.config.Grace)
+	m.mu.Unlock()
+	if explained {
+		return true
+	}
+
+	if _, err := listMediaMTXPathStates(); err != nil {
+		m.markDown(err)
+		return true
+	}
+	return false
+}
+
+// Defer parks a camera until MediaMTX is back, counting the breaker failure it skipped
+func (m *MediaMTXOutageMonitor) Defer(store CameraStore, cameraID, sourceURL string, options StreamOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deferred[cameraID] = deferredRepublish{store: store, sourceURL: sourceURL, options: options}
+	m.suppressed++
+}
+
+// republish restarts the parked cameras and, after a restart, the running cameras
+// whose path has no publisher, paced by the fleet restart limiter and the stagger
+func (m *MediaMTXOutageMonitor) republish(paths map[string]mediamtxPathState, restarted bool) {
+	defer func() {
+		m.mu.Lock()
+		m.republishing = false
+		m.mu.Unlock()
+	}()
+
+	m.mu.Lock()
+	cameras := make(map[string]deferredRepublish, len(m.deferred))
+	for cameraID, camera := range m.deferred {
+		cameras[cameraID] = camera
+	}
+	m.deferred = make(map[string]deferredRepublish)
+	m.mu.Unlock()
+
+	// Running processes may not have noticed yet that their publish connection is dead
+	if restarted {
+		for cameraID, process := range mediamtxPublishers() {
+			if !paths[cameraPathName(cameraID)].Ready {
+				cameras[cameraID] = deferredRepublish{store: process.Store, sourceURL: process.SourceURL, options: process.Options}
+			}
+		}
+	}
+	if len(cameras) == 0 {
+		return
+	}
+
+	cameraIDs := make([]string, 0, len(cameras))
+	for cameraID := range cameras {
+		cameraIDs = append(cameraIDs, cameraID)
+	}
+	sort.Strings(cameraIDs)
+	log.Printf("Re-publishing %d camera(s) to MediaMTX after its outage", len(cameraIDs))
+
+	for i, cameraID := range cameraIDs {
+		if i > 0 {
+			time.Sleep(m.config.RepublishStagger)
+		}
+		restartLimiter.Wait()
+
+		// A camera stopped, or started by hand, during the outage is left alone
+		camera := cameras[cameraID]
+		_, pathName, configured, err := camera.store.GetCameraInfo(cameraID)
+		if err != nil || !configured {
+			continue
+		}
+		processMutex.RLock()
+		process, running := activeProcesses[cameraID]
+		processMutex.RUnlock()
+		if running && paths[cameraPathName(cameraID)].Ready {
+			continue
+		}
+		if running && process.StartedAt.After(m.lastRecovery()) {
+			continue
+		}
+
+		if err := startReencodingProcess(camera.store, cameraID, camera.sourceURL, camera.options); err != nil {
+			log.Printf("Failed to re-publish camera %s after MediaMTX outage: %v", cameraID, err)
+			camera.store.UpdateCameraPathInfo(cameraID, pathName, false)
+			continue
+		}
+		m.mu.Lock()
+		m.republished++
+		m.mu.Unlock()
+	}
+}
+
+// mediamtxPublishers returns the running processes publishing to the default instance
+func mediamtxPublishers() map[string]*ReencodingProcess {
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+
+	publishers := make(map[string]*ReencodingProcess)
+	for cameraID, process := range activeProcesses {
+		if process.Output.Type() == outputTypeRTSP && process.Options.MediaMTX.IsDefault() {
+			publishers[cameraID] = process
+		}
+	}
+	return publishers
+}
+
+// lastRecovery returns when MediaMTX last came back
+func (m *MediaMTXOutageMonitor) lastRecovery() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.recoveredAt
+}
+
+// MediaMTXOutageStats is the mediamtxOutage entry of /metrics
+type MediaMTXOutageStats struct {
+	Down               bool       `json:"down"`
+	DownSince          *time.Time `json:"downSince,omitempty"`
+	RecoveredAt        *time.Time `json:"recoveredAt,omitempty"`
+	Deferred           int        `json:"deferred"` // Cameras waiting to be re-published
+	Outages            uint64     `json:"outages"`
+	Restarts           uint64     `json:"restarts"`
+	SuppressedFailures uint64     `json:"suppressedFailures"`
+	Republished        uint64     `json:"republished"`
+}
+
+// Stats returns the monitor's state and counters
+func (m *MediaMTXOutageMonitor) Stats() MediaMTXOutageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := MediaMTXOutageStats{
+		Down:               m.down,
+		Deferred:           len(m.deferred),
+		Outages:            m.outages,
+		Restarts:           m.restarts,
+		SuppressedFailures: m.suppressed,
+		Republished:        m.republished,
+	}
+	if m.down {
+		downSince := m.downSince
+		stats.DownSince = &downSince
+	}
+	if !m.recoveredAt.IsZero() {
+		recoveredAt := m.recoveredAt
+		stats.RecoveredAt = &recoveredAt
+	}
+	return stats
+}
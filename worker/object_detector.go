@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"gocv.io/x/gocv"
+)
+
+// cocoClassNames are the labels of the 80-class COCO models YOLO exports ship with
+var cocoClassNames = []string{
+	"person", "bicycle", "car", "motorcycle", "airplane", "bus", "train", "truck", "boat",
+	"traffic light", "fire hydrant", "stop sign", "parking meter", "bench", "bird", "cat",
+	"dog", "horse", "sheep", "cow", "elephant", "bear", "zebra", "giraffe", "backpack",
+	"umbrella", "handbag", "tie", "suitcase", "frisbee", "skis", "snowboard", "sports ball",
+	"kite", "baseball bat", "baseball glove", "skateboard", "surfboard", "tennis racket",
+	"bottle", "wine glass", "cup", "fork", "knife", "spoon", "bowl", "banana", "apple",
+	"sandwich", "orange", "broccoli", "carrot", "hot dog", "pizza", "donut", "cake", "chair",
+	"couch", "potted plant", "bed", "dining table", "toilet", "tv", "laptop", "mouse",
+	"remote", "keyboard", "cell phone", "microwave", "oven", "toaster", "sink",
+	"refrigerator", "book", "clock", "vase", "scissors", "teddy bear", "hair drier",
+	"toothbrush",
+}
+
+// ObjectDetection is one classified box in a frame
+type ObjectDetection struct {
+	Class      string  `json:"class"`
+	Confidence float64 `json:"confidence"`
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+}
+
+// ObjectDetectionAlert is published when a camera's classes of interest are detected
+type ObjectDetectionAlert struct {
+	EventID    string            `json:"eventId"`
+	TenantID   string            `json:"tenantId,omitempty"`
+	SiteID     string            `json:"siteId,omitempty"`
+	CameraID   string            `json:"cameraId"`
+	CameraName string            `json:"cameraName"`
+	Objects    []ObjectDetection `json:"objects"`
+	ImageData  string            `json:"imageData"` // base64 encoded annotated thumbnail
+	DetectedAt time.Time         `json:"detectedAt"`
+	LocalTime  string            `json:"localTime"`
+	Timezone   string            `json:"timezone"`
+}
+
+// ObjectDetector classifies people, vehicles, etc. with a YOLO-style DNN. It runs on
+// the frames the face detection loop already reads, so it opens no capture of its own.
+type ObjectDetector struct {
+	net            gocv.Net
+	enabled        bool
+	classNames     []string // Model output index -> class label
+	defaultClasses []string // Classes of interest for cameras without their own
+	threshold      float32
+	nmsThreshold   float32
+	inputSize      int
+	maxImageBytes  int
+	writer         *kafka.Writer
+	alertQueue     *AlertQueue
+	mu             sync.Mutex // gocv.Net is not safe for concurrent use
+}
+
+// NewObjectDetector loads OBJECT_DETECTION_MODEL (an ONNX YOLOv8-style export) when
+// OBJECT_DETECTION_ENABLED is true; alerts go to KAFKA_OBJECT_EVENTS_TOPIC
+func NewObjectDetector(kafkaProducer *KafkaProducer) (*ObjectDetector, error) {
+	if os.Getenv("OBJECT_DETECTION_ENABLED") != "true" {
+		log.Println("Object detection is disabled")
+		return &ObjectDetector{enabled: false}, nil
+	}
+
+	modelPath := os.Getenv("OBJECT_DETECTION_MODEL")
+	if modelPath == "" {
+		modelPath = "/app/models/yolov8n.onnx"
+	}
+	net := gocv.ReadNet(modelPath, os.Getenv("OBJECT_DETECTION_MODEL_CONFIG"))
+	if net.Empty() {
+		return nil, fmt.Errorf("failed to load object detection model from %s", modelPath)
+	}
+	net.SetPreferableBackend(gocv.NetBackendDefault)
+	net.SetPreferableTarget(gocv.NetTargetCPU)
+
+	classNames := cocoClassNames
+	if labelsPath := os.Getenv("OBJECT_DETECTION_LABELS_FILE"); labelsPath != "" {
+		loaded, err := loadClassNames(labelsPath)
+		if err != nil {
+			net.Close()
+			return nil, err
+		}
+		classNames = loaded
+	}
+
+	defaultClasses := parseObjectClasses(os.Getenv("OBJECT_DETECTION_CLASSES"))
+	if defaultClasses == nil {
+		defaultClasses = []string{"person", "bicycle", "car", "motorcycle", "bus", "truck"}
+	}
+
+	threshold, _ := strconv.ParseFloat(os.Getenv("OBJECT_DETECTION_CONFIDENCE_THRESHOLD"), 64)
+	if threshold <= 0 || threshold > 1 {
+		threshold = 0.5
+	}
+	nmsThreshold, _ := strconv.ParseFloat(os.Getenv("OBJECT_DETECTION_NMS_THRESHOLD"), 64)
+	if nmsThreshold <= 0 || nmsThreshold > 1 {
+		nmsThreshold = 0.45
+	}
+	inputSize := getEnvInt("OBJECT_DETECTION_INPUT_SIZE", 640)
+	if inputSize < 32 {
+		inputSize = 640
+	}
+
+	detector := &ObjectDetector{
+		net:            net,
+		enabled:        true,
+		classNames:     classNames,
+		defaultClasses: defaultClasses,
+		threshold:      float32(threshold),
+		nmsThreshold:   float32(nmsThreshold),
+		inputSize:      inputSize,
+		maxImageBytes:  getEnvInt("FACE_DETECTION_MAX_IMAGE_BYTES", 768*1024),
+	}
+
+	if kafkaProducer != nil && kafkaProducer.writer != nil {
+		topic := os.Getenv("KAFKA_OBJECT_EVENTS_TOPIC")
+		if topic == "" {
+			topic = "object-events"
+		}
+		detector.writer = &kafka.Writer{
+			Addr:         kafkaProducer.writer.Addr,
+			Topic:        topic,
+			Balancer:     &kafka.Hash{}, // Keep a camera's alerts ordered on one partition
+			BatchTimeout: 10 * time.Millisecond,
+			RequiredAcks: kafka.RequireOne,
+		}
+		detector.alertQueue = NewAlertQueue()
+		log.Printf("Object detection alerts will be published to Kafka topic '%s'", topic)
+	}
+
+	log.Printf("Object detector initialized: model=%s, classes=%d, default interest=%v, threshold=%.2f, input=%dpx",
+		modelPath, len(classNames), defaultClasses, threshold, inputSize)
+	return detector, nil
+}
+
+// loadClassNames reads one class label per line, in model output order
+func loadClassNames(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object detection labels: %w", err)
+	}
+	defer file.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if name := strings.ToLower(strings.TrimSpace(scanner.Text())); name != "" {
+			names = append(names, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read object detection labels: %w", err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("object detection labels file %s is empty", path)
+	}
+	return names, nil
+}
+
+// parseObjectClasses splits a comma-separated class list. It returns nil for an empty
+// value (use the default) and an empty slice for "none" (detect nothing).
+func parseObjectClasses(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if strings.EqualFold(value, "none") {
+		return []string{}
+	}
+	classes := []string{}
+	for _, class := range strings.Split(value, ",") {
+		if class = strings.ToLower(strings.TrimSpace(class)); class != "" {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// Detect runs the model on a frame and returns boxes for the wanted classes
+func (od *ObjectDetector) Detect(img gocv.Mat, wanted map[string]bool) []ObjectDetection {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	blob := gocv.BlobFromImage(img, 1.0/255.0, image.Pt(od.inputSize, od.inputSize), gocv.NewScalar(0, 0, 0, 0), true, false)
+	defer blob.Close()
+	od.net.SetInput(blob, "")
+	output := od.net.Forward("")
+	defer output.Close()
+
+	// YOLOv8 output is [1, 4+classes, candidates]: cx, cy, w, h then per-class scores
+	dims := output.Size()
+	if len(dims) != 3 || dims[1] < 5 {
+		log.Printf("[ObjectDetector] Unexpected model output shape %v", dims)
+		return nil
+	}
+	attributes, candidates := dims[1], dims[2]
+	data, err := output.DataPtrFloat32()
+	if err != nil || len(data) < attributes*candidates {
+		log.Printf("[ObjectDetector] Failed to read model output: %v", err)
+		return nil
+	}
+
+	xScale := float32(img.Cols()) / float32(od.inputSize)
+	yScale := float32(img.Rows()) / float32(od.inputSize)
+
+	boxes := []image.Rectangle{}
+	scores := []float32{}
+	classIDs := []int{}
+	for i := 0; i < candidates; i++ {
+		bestClass, bestScore := -1, float32(0)
+		for c := 4; c < attributes; c++ {
+			if score := data[c*candidates+i]; score > bestScore {
+				bestClass, bestScore = c-4, score
+			}
+		}
+		if bestScore < od.threshold || bestClass >= len(od.classNames) || !wanted[od.classNames[bestClass]] {
+			continue
+		}
+
+		cx, cy := data[i]*xScale, data[candidates+i]*yScale
+		w, h := data[2*candidates+i]*xScale, data[3*candidates+i]*yScale
+		boxes = append(boxes, image.Rect(int(cx-w/2), int(cy-h/2), int(cx+w/2), int(cy+h/2)))
+		scores = append(scores, bestScore)
+		classIDs = append(classIDs, bestClass)
+	}
+	if len(boxes) == 0 {
+		return nil
+	}
+
+	detections := []ObjectDetection{}
+	for _, index := range gocv.NMSBoxes(boxes, scores, od.threshold, od.nmsThreshold) {
+		box := boxes[index].Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+		if box.Empty() {
+			continue
+		}
+		detections = append(detections, ObjectDetection{
+			Class:      od.classNames[classIDs[index]],
+			Confidence: float64(scores[index]),
+			X:          box.Min.X,
+			Y:          box.Min.Y,
+			Width:      box.Dx(),
+			Height:     box.Dy(),
+		})
+	}
+	return detections
+}
+
+// ProcessFrame detects the camera's classes of interest and publishes an alert when any
+// are found inside its ROI. settings is the same per-camera policy face detection uses.
+func (od *ObjectDetector) ProcessFrame(cameraID, cameraName string, frame gocv.Mat, settings FaceDetectionSettings) {
+	if !od.enabled {
+		return
+	}
+
+	classes := settings.ObjectClasses
+	if classes == nil {
+		classes = od.defaultClasses
+	}
+	if len(classes) == 0 {
+		return
+	}
+	wanted := make(map[string]bool, len(classes))
+	for _, class := range classes {
+		wanted[class] = true
+	}
+
+	objects := []ObjectDetection{}
+	rects := []image.Rectangle{}
+	for _, object := range od.Detect(frame, wanted) {
+		rect := image.Rect(object.X, object.Y, object.X+object.Width, object.Y+object.Height)
+		if settings.ROI.Contains(rect, frame.Cols(), frame.Rows()) {
+			objects = append(objects, object)
+			rects = append(rects, rect)
+		}
+	}
+	if len(objects) == 0 {
+		return
+	}
+
+	log.Printf("Detected %d object(s) in camera %s", len(objects), cameraID)
+	detectedAt := time.Now().UTC()
+	cue := newDetectionCue(cameraID, "object", rects, frame.Cols(), frame.Rows(), detectedAt, settings.Interval)
+	for i := range cue.Boxes {
+		cue.Boxes[i].Label = objects[i].Class
+		cue.Boxes[i].Confidence = objects[i].Confidence
+	}
+	detectionMetadata.Publish(cue)
+	eventRecorder.Trigger(cameraID, detectedAt)
+
+	if od.alertQueue == nil {
+		log.Printf("Kafka producer not available, skipping object alert for camera %s (objects detected: %d)", cameraID, len(objects))
+		return
+	}
+
+	annotated := annotationMats.CopyOf(frame)
+	defer annotationMats.Put(annotated)
+	for i, rect := range rects {
+		gocv.Rectangle(&annotated, rect, color.RGBA{255, 165, 0, 0}, 2)
+		gocv.PutText(&annotated, fmt.Sprintf("%s %.0f%%", objects[i].Class, objects[i].Confidence*100),
+			image.Pt(rect.Min.X, rect.Min.Y-4), gocv.FontHersheySimplex, 0.5, color.RGBA{255, 165, 0, 0}, 1)
+	}
+	imageData := ""
+	if buf, err := gocv.IMEncode(".jpg", annotated); err != nil {
+		log.Printf("Failed to encode frame: %v", err)
+	} else {
+		var imageSize int
+		imageData, imageSize = encodeThumbnail(buf.GetBytes(), od.maxImageBytes)
+		buf.Close()
+		if imageData == "" && imageSize > 0 {
+			log.Printf("Thumbnail for camera %s is %d bytes (limit %d), sending object alert without it", cameraID, imageSize, od.maxImageBytes)
+		}
+	}
+
+	alert := ObjectDetectionAlert{
+		EventID:    newEventID(),
+		TenantID:   settings.TenantID,
+		SiteID:     settings.SiteID,
+		CameraID:   cameraID,
+		CameraName: cameraName,
+		Objects:    objects,
+		ImageData:  imageData,
+		DetectedAt: detectedAt,
+		LocalTime:  localTimestamp(detectedAt, settings.Location),
+		Timezone:   settings.Timezone,
+	}
+	od.alertQueue.Enqueue(cameraID, func() error { return od.publish(alert) })
+}
+
+// publish writes an alert to the object events topic
+func (od *ObjectDetector) publish(alert ObjectDetectionAlert) error {
+	value, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object alert: %w", err)
+	}
+
+	message := kafka.Message{
+		Key:   []byte(alert.CameraID),
+		Value: value,
+		Time:  alert.DetectedAt,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte("application/json")},
+			{Key: "event-id", Value: []byte(alert.EventID)},
+		},
+	}
+	if alert.TenantID != "" {
+		message.Headers = append(message.Headers, kafka.Header{Key: "tenant-id", Value: []byte(alert.TenantID)})
+	}
+	if alert.SiteID != "" {
+		message.Headers = append(message.Headers, kafka.Header{Key: "site-id", Value: []byte(alert.SiteID)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := od.writer.WriteMessages(ctx, message); err != nil {
+		return fmt.Errorf("failed to write object alert to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes queued alerts and releases the model
+func (od *ObjectDetector) Close() {
+	if od.alertQueue != nil {
+		od.alertQueue.Close()
+	}
+	if od.writer != nil {
+		od.writer.Close()
+	}
+	if od.enabled {
+		od.net.Close()
+	}
+}
+
+// objectDetectorAlertQueueStats reports the object detector's queue, if it has one
+func objectDetectorAlertQueueStats() AlertQueueStats {
+	if objectDetector == nil {
+		return AlertQueueStats{}
+	}
+	return objectDetector.alertQueue.Stats()
+}
+
+// faceDetectionEnabled reports whether the face detector loaded
+func faceDetectionEnabled() bool {
+	return faceDetector != nil && faceDetector.enabled
+}
+
+// objectDetectionEnabled reports whether the object detector loaded
+func objectDetectionEnabled() bool {
+	return objectDetector != nil && objectDetector.enabled
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Output target types
+const (
+	outputTypeRTSP  = "rtsp"   // Publish to the camera's MediaMTX path (default)
+	outputTypeHLS   = "hls"    // MPEG-TS HLS segments written to a directory
+	outputTypeLLHLS = "ll-hls" // Short fMP4 HLS segments for lower latency
+	outputTypeSRT   = "srt"    // MPEG-TS pushed over SRT
+)
+
+// OutputOptions selects where a camera's re-encoded stream is published
+type OutputOptions struct {
+	Type           string `json:"type,omitempty"`           // rtsp | hls | ll-hls | srt
+	URL            string `json:"url,omitempty"`            // srt://host:port for srt; HLS directory override
+	SegmentSeconds int    `json:"segmentSeconds,omitempty"` // HLS segment length
+}
+
+// resolvedType returns the output type, defaulting to RTSP
+func (o *OutputOptions) resolvedType() string {
+	if o == nil || o.Type == "" {
+		return outputTypeRTSP
+	}
+	return strings.ToLower(o.Type)
+}
+
+// containerFormat is the muxer family, used to check codec compatibility
+func (o *OutputOptions) containerFormat() string {
+	switch o.resolvedType() {
+	case outputTypeHLS, outputTypeLLHLS:
+		return "hls"
+	case outputTypeSRT:
+		return "mpegts"
+	default:
+		return "rtsp"
+	}
+}
+
+// Validate checks the output settings
+func (o *OutputOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	switch o.resolvedType() {
+	case outputTypeRTSP:
+		if o.URL != "" {
+			return fmt.Errorf("rtsp output always publishes to MediaMTX and does not take a url")
+		}
+	case outputTypeHLS, outputTypeLLHLS:
+		if o.SegmentSeconds < 0 || o.SegmentSeconds > 30 {
+			return fmt.Errorf("segmentSeconds %d out of range (1-30)", o.SegmentSeconds)
+		}
+	case outputTypeSRT:
+		parsed, err := url.Parse(o.URL)
+		if err != nil || parsed.Scheme != "srt" || parsed.Host == "" {
+			return fmt.Errorf("srt output requires an srt://host:port url")
+		}
+		if err := validateURLHost(parsed); err != nil {
+			return fmt.Errorf("srt output url: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported output type %q (expected rtsp, hls, ll-hls or srt)", o.Type)
+	}
+	return nil
+}
+
+// OutputTarget is where FFmpeg writes a camera's re-encoded stream
+type OutputTarget interface {
+	// Type is one of the output type constants
+	Type() string
+	// URL is the FFmpeg output URL or file
+	URL() string
+	// MuxerArgs are the FFmpeg output arguments selecting and tuning the muxer
+	MuxerArgs() ffmpeg.KwArgs
+	// WaitReady blocks until the output is consumable or the timeout passes
+	WaitReady(timeout time.Duration) error
+	// Cleanup removes whatever the output left behind once FFmpeg has stopped
+	Cleanup() error
+}
+
+// newOutputTarget builds the target for a camera's output options; RTSP output is
+// published to the given MediaMTX instance
+func newOutputTarget(cameraID string, options *OutputOptions, mediamtx *MediaMTXInstance) (OutputTarget, error) {
+	switch options.resolvedType() {
+	case outputTypeRTSP:
+		return &rtspOutputTarget{cameraID: cameraID, mediamtx: mediamtx, url: getReencodedStreamURL(mediamtx, cameraID)}, nil
+	case outputTypeHLS, outputTypeLLHLS:
+		dir := options.URL
+		if dir == "" {
+			baseDir := os.Getenv("HLS_OUTPUT_DIR")
+			if baseDir == "" {
+				baseDir = "./hls"
+			}
+			dir = filepath.Join(baseDir, cameraPathName(cameraID))
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create HLS output directory: %w", err)
+		}
+		return &hlsOutputTarget{
+			dir:            dir,
+			lowLatency:     options.resolvedType() == outputTypeLLHLS,
+			segmentSeconds: options.SegmentSeconds,
+		}, nil
+	case outputTypeSRT:
+		return &srtOutputTarget{cameraID: cameraID, url: options.URL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output type %q", options.Type)
+	}
+}
+
+// rtspOutputTarget publishes to the camera's MediaMTX path
+type rtspOutputTarget struct {
+	cameraID string
+	mediamtx *MediaMTXInstance
+	url      string
+}
+
+func (t *rtspOutputTarget) Type() string { return outputTypeRTSP }
+
+func (t *rtspOutputTarget) URL() string { return t.url }
+
+func (t *rtspOutputTarget) MuxerArgs() ffmpeg.KwArgs {
+	return ffmpeg.KwArgs{
+		"f":              "rtsp",     // Output format
+		"rtsp_transport": "tcp",      // Use TCP transport
+		"timeout":        "60000000", // 30s Output I/O timeout (increased)
+	}
+}
+
+// WaitReady waits for MediaMTX to report the path has an active stream
+func (t *rtspOutputTarget) WaitReady(timeout time.Duration) error {
+	return waitForPathWithStream(t.mediamtx, cameraPathName(t.cameraID), timeout)
+}
+
+// Cleanup is a no-op; the MediaMTX path is managed separately from the process
+func (t *rtspOutputTarget) Cleanup() error { return nil }
+
+// hlsOutputTarget writes an HLS playlist and segments to a directory
+type hlsOutputTarget struct {
+	dir            string
+	lowLatency     bool
+	segmentSeconds int
+}
+
+func (t *hlsOutputTarget) Type() string {
+	if t.lowLatency {
+		return outputTypeLLHLS
+	}
+	return outputTypeHLS
+}
+
+func (t *hlsOutputTarget) URL() string { return filepath.Join(t.dir, "index.m3u8") }
+
+func (t *hlsOutputTarget) MuxerArgs() ffmpeg.KwArgs {
+	segmentSeconds := t.segmentSeconds
+	if segmentSeconds == 0 {
+		segmentSeconds = 2
+		if t.lowLatency {
+			segmentSeconds = 1
+		}
+	}
+
+	args := ffmpeg.KwArgs{
+		"f":             "hls",
+		"hls_time":      strconv.Itoa(segmentSeconds),
+		"hls_list_size": "6",
+		"hls_flags":     "delete_segments+independent_segments+program_date_time",
+	}
+	if t.lowLatency {
+		// FFmpeg's muxer has no partial segments, so approximate LL-HLS with short fMP4 segments
+		args["hls_segment_type"] = "fmp4"
+		args["hls_fmp4_init_filename"] = "init.mp4"
+		args["hls_segment_filename"] = filepath.Join(t.dir, "seg_%05d.m4s")
+	} else {
+		args["hls_segment_filename"] = filepath.Join(t.dir, "seg_%05d.ts")
+	}
+	return args
+}
+
+// WaitReady waits until the playlist lists at least one segment
+func (t *hlsOutputTarget) WaitReady(timeout time.Duration) error {
+	ready := waitForCondition(timeout, 500*time.Millisecond, func() bool {
+		playlist, err := os.ReadFile(t.URL())
+		return err == nil && strings.Contains(string(playlist), "#EXTINF")
+	})
+	if !ready {
+		return fmt.Errorf("HLS playlist %s has no segments after %v", t.URL(), timeout)
+	}
+	return nil
+}
+
+// Cleanup removes the playlist and segments
+func (t *hlsOutputTarget) Cleanup() error {
+	return os.RemoveAll(t.dir)
+}
+
+// srtOutputTarget pushes MPEG-TS to an SRT listener
+type srtOutputTarget struct {
+	cameraID string
+	url      string
+}
+
+func (t *srtOutputTarget) Type() string { return outputTypeSRT }
+
+func (t *srtOutputTarget) URL() string { return t.url }
+
+func (t *srtOutputTarget) MuxerArgs() ffmpeg.KwArgs {
+	return ffmpeg.KwArgs{"f": "mpegts"}
+}
+
+// WaitReady confirms FFmpeg is still running a few seconds after connecting; the
+// remote listener gives the worker no readiness signal of its own
+func (t *srtOutputTarget) WaitReady(timeout time.Duration) error {
+	settle := 3 * time.Second
+	if timeout < settle {
+		settle = timeout
+	}
+	deadline := time.Now().Add(settle)
+	for time.Now().Before(deadline) {
+		if !isProcessRunning(t.cameraID) {
+			return fmt.Errorf("FFmpeg exited while connecting to %s", t.url)
+		}
+		time.Sleep(timingConfig.conditionPollInterval)
+	}
+	return nil
+}
+
+// Cleanup is a no-op; nothing is left behind locally
+func (t *srtOutputTarget) Cleanup() error { return nil }
+
+// isProcessRunning reports whether the camera has an active re-encoding process
+func isProcessRunning(cameraID string) bool {
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+	_, exists := activeProcesses[cameraID]
+	return exists
+}
+
+// waitForOutputReady waits on the readiness check for the camera's current output target
+func waitForOutputReady(cameraID string, timeout time.Duration) error {
+	processMutex.RLock()
+	process, exists := activeProcesses[cameraID]
+	processMutex.RUnlock()
+	if !exists || process.Output == nil {
+		return fmt.Errorf("no active re-encoding process for camera %s", cameraID)
+	}
+	return process.Output.WaitReady(timeout)
+}
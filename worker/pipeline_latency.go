@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FFmpegLatencyEstimate is the worker's share of a camera's latency on the re-encode
+// path, derived from FFmpeg's -progress reports. Glass-to-glass latency also includes
+// the camera, MediaMTX and the viewer, which the worker can't see.
+type FFmpegLatencyEstimate struct {
+	// StartupMs is the time from starting FFmpeg to its first encoded frame
+	StartupMs int64 `json:"startupMs"`
+	// LagMs is how far the output clock (out_time) has fallen behind wall time since the
+	// first frame: near 0 while FFmpeg keeps up with the camera, growing while it buffers
+	LagMs     int64     `json:"lagMs"`
+	OutTimeMs int64     `json:"outTimeMs"`
+	Speed     string    `json:"speed,omitempty"` // FFmpeg's encode speed, e.g. "1x"
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ffmpegProgressArgs makes FFmpeg write its progress reports to the first extra file
+var ffmpegProgressArgs = []string{"-progress", "pipe:3"}
+
+// outputBytePollInterval is how often the output is measured while FFmpeg reports no
+// total_size
+const outputBytePollInterval = 5 * time.Second
+
+// outputByteCounter measures what a process has published when FFmpeg's total_size is
+// N/A, which it is for RTSP and HLS since neither writes a single output file: RTSP
+// output is counted by MediaMTX's bytesReceived for the path, HLS output by the sizes
+// of the segments written, including those the muxer has since deleted.
+type outputByteCounter struct {
+	cameraID string
+	output   OutputTarget
+	mediamtx *MediaMTXInstance
+
+	segments map[string]uint64 // Segment name -> size when last seen
+	deleted  uint64            // Bytes of segments no longer on disk
+}
+
+func newOutputByteCounter(process *ReencodingProcess) *outputByteCounter {
+	return &outputByteCounter{
+		cameraID: process.CameraID,
+		output:   process.Output,
+		mediamtx: process.Options.MediaMTX,
+		segments: map[string]uint64{},
+	}
+}
+
+// Bytes returns the output's byte count, or false when it can't be measured
+func (c *outputByteCounter) Bytes() (uint64, bool) {
+	switch c.output.Type() {
+	case outputTypeRTSP:
+		info, exists, err := getMediaMTXPathInfo(c.mediamtx, cameraPathName(c.cameraID))
+		if err != nil || !exists {
+			return 0, false
+		}
+		return info.BytesReceived, true
+	case outputTypeHLS, outputTypeLLHLS:
+		entries, err := os.ReadDir(filepath.Dir(c.output.URL()))
+		if err != nil {
+			return 0, false
+		}
+		current := make(map[string]uint64, len(entries))
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), "seg_") {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				current[entry.Name()] = uint64(info.Size())
+			}
+		}
+		for name, size := range c.segments {
+			if _, ok := current[name]; !ok {
+				c.deleted += size
+			}
+		}
+		c.segments = current
+		total := c.deleted
+		for _, size := range current {
+			total += size
+		}
+		return total, true
+	default:
+		return 0, false
+	}
+}
+
+// runFFmpegProgress reads FFmpeg's key=value progress reports until the process exits,
+// keeping the camera's frame and byte counts and latency estimate in metrics current.
+// While total_size is N/A the byte count comes from fallback, if given, at most every
+// outputBytePollInterval.
+func runFFmpegProgress(metrics *StreamMetrics, startedAt time.Time, progress io.ReadCloser, fallback *outputByteCounter) {
+	defer progress.Close()
+
+	var frames, bytes uint64
+	var sizeReported bool // Whether this report's total_size was a number
+	var outTime, firstOutTime time.Duration
+	var speed string
+	var firstOutputAt, polledAt time.Time
+
+	scanner := bufio.NewScanner(progress)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			frames, _ = strconv.ParseUint(value, 10, 64)
+		case "total_size": // Bytes muxed so far; N/A for some outputs until the first packet
+			size, err := strconv.ParseUint(value, 10, 64)
+			if sizeReported = err == nil; sizeReported {
+				bytes = size
+			}
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil { // N/A before the first frame
+				outTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			speed = value
+		case "progress": // Ends each report
+			now := time.Now()
+			if firstOutputAt.IsZero() && outTime > 0 {
+				firstOutputAt, firstOutTime = now, outTime
+			}
+			if !sizeReported && fallback != nil && now.Sub(polledAt) >= outputBytePollInterval {
+				polledAt = now
+				if size, ok := fallback.Bytes(); ok {
+					bytes = size
+				}
+			}
+
+			streamMetricsMutex.Lock()
+			if frames > metrics.FramesProcessed {
+				metrics.LastFrameTime = now
+			}
+			metrics.FramesProcessed = frames
+			metrics.BytesProcessed = bytes
+			if !firstOutputAt.IsZero() {
+				lag := now.Sub(firstOutputAt) - (outTime - firstOutTime)
+				metrics.Latency = &FFmpegLatencyEstimate{
+					StartupMs: firstOutputAt.Sub(startedAt).Milliseconds(),
+					LagMs:     max(lag, 0).Milliseconds(),
+					OutTimeMs: outTime.Milliseconds(),
+					Speed:     speed,
+					UpdatedAt: now,
+				}
+			}
+			streamMetricsMutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputByteCounterHLS(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int) {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counter := &outputByteCounter{output: &hlsOutputTarget{dir: dir}, segments: map[string]uint64{}}
+
+	write("index.m3u8", 50) // The playlist isn't counted
+	write("seg_00000.ts", 100)
+	write("seg_00001.ts", 40) // Still being written
+	if got, ok := counter.Bytes(); !ok || got != 140 {
+		t.Fatalf("Bytes() = %d, %v; want 140", got, ok)
+	}
+
+	// The muxer finishes seg_00001 and deletes seg_00000
+	write("seg_00001.ts", 120)
+	write("seg_00002.ts", 10)
+	if err := os.Remove(filepath.Join(dir, "seg_00000.ts")); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := counter.Bytes(); !ok || got != 230 {
+		t.Fatalf("Bytes() after deletion = %d, %v; want 230", got, ok)
+	}
+}
+
+func TestRunFFmpegProgressFallsBackWhenTotalSizeNA(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "seg_00000.ts"), make([]byte, 300), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fallback := &outputByteCounter{output: &hlsOutputTarget{dir: dir}, segments: map[string]uint64{}}
+
+	report := "frame=25\ntotal_size=N/A\nout_time_us=1000000\nspeed=1x\nprogress=continue\n"
+	metrics := &StreamMetrics{}
+	runFFmpegProgress(metrics, time.Now(), io.NopCloser(strings.NewReader(report)), fallback)
+	if metrics.BytesProcessed != 300 {
+		t.Fatalf("BytesProcessed = %d, want the 300 bytes from the output", metrics.BytesProcessed)
+	}
+
+	// A numeric total_size wins over the fallback
+	report = "frame=25\ntotal_size=4096\nprogress=continue\n"
+	metrics = &StreamMetrics{}
+	runFFmpegProgress(metrics, time.Now(), io.NopCloser(strings.NewReader(report)), fallback)
+	if metrics.BytesProcessed != 4096 {
+		t.Fatalf("BytesProcessed = %d, want FFmpeg's 4096", metrics.BytesProcessed)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// cameraStatusEvicted marks a camera stopped to make room for a higher-priority one
+const cameraStatusEvicted = "EVICTED"
+
+// evictionVictim is a running stream chosen to make room for a new one
+type evictionVictim struct {
+	CameraID  string
+	Priority  int
+	Weight    int
+	startedAt time.Time
+}
+
+// findEvictionVictims picks the running streams to evict so a new stream with the given
+// priority gets needed capacity: lowest priority strictly below it first, preferring
+// the most recently started on ties since they have the least uptime to lose. enough is
+// false, and nothing should be evicted, when all of them together don't free needed.
+func findEvictionVictims(priority int, excludeCameraID string, needed int) (victims []evictionVictim, enough bool) {
+	processMutex.RLock()
+	candidates := []evictionVictim{}
+	for id, process := range activeProcesses {
+		if id == excludeCameraID || process.Options.priority() >= priority {
+			continue
+		}
+		candidates = append(candidates, evictionVictim{
+			CameraID:  id,
+			Priority:  process.Options.priority(),
+			Weight:    streamWeight(process.Options),
+			startedAt: process.StartedAt,
+		})
+	}
+	processMutex.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority < candidates[j].Priority
+		}
+		return candidates[i].startedAt.After(candidates[j].startedAt)
+	})
+	freed := 0
+	for _, candidate := range candidates {
+		if freed >= needed {
+			break
+		}
+		victims = append(victims, candidate)
+		freed += candidate.Weight
+	}
+	if freed < needed {
+		return nil, false
+	}
+	return victims, true
+}
+
+// evictStream gracefully stops a running stream, records it as evicted, and emits an
+// event so it can be rescheduled
+func evictStream(cameraID string, victimPriority int, forCameraID string, forPriority int) error {
+	log.Printf("Evicting camera %s (priority %d) to make room for camera %s (priority %d)",
+		cameraID, victimPriority, forCameraID, forPriority)
+
+	// The process monitor records the status once FFmpeg has exited
+	processMutex.Lock()
+	if process, exists := activeProcesses[cameraID]; exists {
+		process.StopReason = cameraStatusEvicted
+	}
+	processMutex.Unlock()
+
+	if !stopReencodingProcess(cameraID) {
+		return fmt.Errorf("camera %s was no longer running", cameraID)
+	}
+
+	streamEvents.Publish(StreamEvent{
+		Type:     streamEventEvicted,
+		CameraID: cameraID,
+		Reason:   fmt.Sprintf("evicted for higher-priority camera %s", forCameraID),
+		Details: map[string]interface{}{
+			"priority":           victimPriority,
+			"evictedFor":         forCameraID,
+			"evictedForPriority": forPriority,
+		},
+	})
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindEvictionVictims(t *testing.T) {
+	now := time.Now()
+	processMutex.Lock()
+	saved := activeProcesses
+	activeProcesses = map[string]*ReencodingProcess{
+		"low-old":  {Options: StreamOptions{Priority: intPtr(1), Weight: 1}, StartedAt: now.Add(-time.Hour)},
+		"low-new":  {Options: StreamOptions{Priority: intPtr(1), Weight: 1}, StartedAt: now},
+		"mid":      {Options: StreamOptions{Priority: intPtr(5), Weight: 2}, StartedAt: now},
+		"high":     {Options: StreamOptions{Priority: intPtr(9), Weight: 4}, StartedAt: now},
+		"starting": {Options: StreamOptions{Weight: 1}, StartedAt: now},
+	}
+	processMutex.Unlock()
+	defer func() {
+		processMutex.Lock()
+		activeProcesses = saved
+		processMutex.Unlock()
+	}()
+
+	victims, enough := findEvictionVictims(6, "starting", 3)
+	if !enough {
+		t.Fatal("expected enough capacity from priorities below 6")
+	}
+	got := []string{}
+	for _, victim := range victims {
+		got = append(got, victim.CameraID)
+	}
+	want := []string{"low-new", "low-old", "mid"}
+	if len(got) != len(want) {
+		t.Fatalf("victims %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("victims %v, want %v", got, want)
+		}
+	}
+
+	// Everything below priority 6 frees 4; asking for 5 must evict nobody
+	if victims, enough := findEvictionVictims(6, "starting", 5); enough || len(victims) != 0 {
+		t.Fatalf("got victims %v (enough=%v), want none", victims, enough)
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestMergeResetsPriority(t *testing.T) {
+	stored := StreamOptions{Priority: intPtr(7)}
+	if got := stored.Merge(StreamOptions{}).priority(); got != 7 {
+		t.Fatalf("merging no priority gave %d, want the stored 7", got)
+	}
+	if got := stored.Merge(StreamOptions{Priority: intPtr(0)}).priority(); got != 0 {
+		t.Fatalf("merging priority 0 gave %d, want 0", got)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FFmpeg process states reported by POST /kill/:cameraId
+const (
+	processStateRunning = "running"
+	processStateZombie  = "zombie" // Exited but not yet reaped by the monitor goroutine
+	processStateGone    = "gone"
+)
+
+// Termination steps, in escalation order
+const (
+	killMethodSIGTERM      = "sigterm"
+	killMethodSIGKILL      = "sigkill"
+	killMethodProcessGroup = "process-group"
+)
+
+// killStepTimeout is how long each termination step gets before escalating
+const killStepTimeout = 2 * time.Second
+
+// killStep is one termination step of killProcessEscalating
+type killStep struct {
+	method string
+	signal func() error
+}
+
+// trackedFFmpeg is a started FFmpeg process that hasn't been reaped yet
+type trackedFFmpeg struct {
+	CameraID  string
+	PID       int
+	StartedAt time.Time
+}
+
+// FFmpegProcessTracker remembers every FFmpeg process until its Wait returns. Stopping
+// a camera forgets its ReencodingProcess right away, so this is how a process that
+// survived the stop can still be found and killed. An unreaped PID can't be reused, so
+// signalling a tracked PID never hits an unrelated process.
+type FFmpegProcessTracker struct {
+	processes map[string][]*trackedFFmpeg // By camera; more than one after a stuck restart
+	mu        sync.Mutex
+}
+
+// NewFFmpegProcessTracker creates an empty tracker
+func NewFFmpegProcessTracker() *FFmpegProcessTracker {
+	return &FFmpegProcessTracker{processes: make(map[string][]*trackedFFmpeg)}
+}
+
+var ffmpegProcesses = NewFFmpegProcessTracker()
+
+// Track records a started process; the returned handle is passed to Exited once reaped
+func (t *FFmpegProcessTracker) Track(cameraID string, pid int) *trackedFFmpeg {
+	tracked := &trackedFFmpeg{CameraID: cameraID, PID: pid, StartedAt: time.Now()}
+	t.mu.Lock()
+	t.processes[cameraID] = append(t.processes[cameraID], tracked)
+	t.mu.Unlock()
+	return tracked
+}
+
+// Exited forgets a process whose Wait has returned
+func (t *FFmpegProcessTracker) Exited(tracked *trackedFFmpeg) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	processes := t.processes[tracked.CameraID]
+	for i, candidate := range processes {
+		if candidate == tracked {
+			processes = append(processes[:i], processes[i+1:]...)
+			break
+		}
+	}
+	if len(processes) == 0 {
+		delete(t.processes, tracked.CameraID)
+	} else {
+		t.processes[tracked.CameraID] = processes
+	}
+}
+
+// Processes returns the camera's unreaped processes, oldest first
+func (t *FFmpegProcessTracker) Processes(cameraID string) []trackedFFmpeg {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	processes := make([]trackedFFmpeg, 0, len(t.processes[cameraID]))
+	for _, tracked := range t.processes[cameraID] {
+		processes = append(processes, *tracked)
+	}
+	return processes
+}
+
+// processState reads a PID's state from /proc: running, zombie or gone. procState is
+// the kernel's state letter, e.g. D for a process stuck in uninterruptible I/O.
+func processState(pid int) (state, procState string) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return processStateGone, ""
+	}
+	// The command name is parenthesised and may contain spaces; the state follows it
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) == 0 {
+		return processStateRunning, ""
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return processStateZombie, fields[0]
+	}
+	return processStateRunning, fields[0]
+}
+
+// ProcessKillResult reports how one FFmpeg process was terminated
+type ProcessKillResult struct {
+	PID         int       `json:"pid"`
+	StartedAt   time.Time `json:"startedAt"`
+	StateBefore string    `json:"stateBefore"`
+	ProcState   string    `json:"procState,omitempty"` // Kernel state letter before termination
+	Attempts    []string  `json:"attempts"`
+	Method      string    `json:"method,omitempty"` // The step that ended it; empty if none did
+	StateAfter  string    `json:"stateAfter"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Terminated reports whether the process is no longer running
+func (r ProcessKillResult) Terminated() bool {
+	return r.StateAfter != processStateRunning
+}
+
+// killProcessEscalating sends SIGTERM, then SIGKILL, then SIGKILL to the process group,
+// stopping at the first step after which the process has exited. A zombie has already
+// exited and only needs reaping, so it isn't signalled.
+func killProcessEscalating(tracked trackedFFmpeg) ProcessKillResult {
+	result := ProcessKillResult{PID: tracked.PID, StartedAt: tracked.StartedAt, Attempts: []string{}}
+	result.StateBefore, result.ProcState = processState(tracked.PID)
+	result.StateAfter = result.StateBefore
+	if result.StateBefore != processStateRunning {
+		return result
+	}
+
+	var errs []string
+	for _, step := range killSteps(tracked.PID) {
+		result.Attempts = append(result.Attempts, step.method)
+		if err := step.signal(); err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, fmt.Sprintf("%s: %v", step.method, err))
+		}
+
+		deadline := time.Now().Add(killStepTimeout)
+		for {
+			result.StateAfter, _ = processState(tracked.PID)
+			if result.StateAfter != processStateRunning || !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if result.Terminated() {
+			result.Method = step.method
+			break
+		}
+	}
+
+	if !result.Terminated() {
+		errs = append(errs, "process survived every termination step")
+	}
+	result.Error = strings.Join(errs, "; ")
+	return result
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// startInProcessGroup is a no-op without Unix process groups; context cancellation
+// keeps exec's default of killing the FFmpeg process itself
+func startInProcessGroup(cmd *exec.Cmd) {}
+
+// killSteps has a single step without Unix signals: killing the process outright
+func killSteps(pid int) []killStep {
+	return []killStep{
+		{killMethodSIGKILL, func() error {
+			process, err := os.FindProcess(pid)
+			if err != nil {
+				return err
+			}
+			return process.Kill()
+		}},
+	}
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startInProcessGroup makes the FFmpeg child lead its own process group, so killing the
+// group also reaches anything it spawned, and makes context cancellation kill the group
+func startInProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// killSteps signals the process with SIGTERM, then SIGKILL, then SIGKILL to its group
+func killSteps(pid int) []killStep {
+	return []killStep{
+		{killMethodSIGTERM, func() error { return syscall.Kill(pid, syscall.SIGTERM) }},
+		{killMethodSIGKILL, func() error { return syscall.Kill(pid, syscall.SIGKILL) }},
+		{killMethodProcessGroup, func() error { return syscall.Kill(-pid, syscall.SIGKILL) }},
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// circuitBreakerStateValues maps breaker states to the skylark_circuit_breaker_state gauge
+var circuitBreakerStateValues = map[string]int{"closed": 0, "half-open": 1, "open": 2}
+
+// prometheusStreamMetrics renders the stream gauges and the per-camera frame, byte and
+// error counters from streamMetrics in the Prometheus text format. Frame and byte
+// counts start over with each FFmpeg run, which Prometheus treats as a counter reset;
+// errors are the camera's FFmpeg failures across runs, as in ffmpeg_restarts_total.
+func prometheusStreamMetrics() string {
+	used := usedCapacity()
+	processMutex.RLock()
+	streamMetricsMutex.RLock()
+	activeCount := len(activeProcesses)
+	cameraIDs := make([]string, 0, len(streamMetrics))
+	frames := make(map[string]uint64, len(streamMetrics))
+	bytes := make(map[string]uint64, len(streamMetrics))
+	for cameraID, metrics := range streamMetrics {
+		cameraIDs = append(cameraIDs, cameraID)
+		frames[cameraID], bytes[cameraID] = metrics.FramesProcessed, metrics.BytesProcessed
+	}
+	streamMetricsMutex.RUnlock()
+	processMutex.RUnlock()
+	sort.Strings(cameraIDs)
+
+	var b strings.Builder
+	b.WriteString("# HELP skylark_active_streams Streams being re-encoded.\n")
+	b.WriteString("# TYPE skylark_active_streams gauge\n")
+	fmt.Fprintf(&b, "skylark_active_streams %d\n", activeCount)
+	b.WriteString("# HELP skylark_max_streams MAX_CONCURRENT_STREAMS, the capacity streams' weights count against.\n")
+	b.WriteString("# TYPE skylark_max_streams gauge\n")
+	fmt.Fprintf(&b, "skylark_max_streams %d\n", currentWorkerConfig().MaxConcurrentStreams)
+	b.WriteString("# HELP skylark_used_capacity Total weight of the running streams.\n")
+	b.WriteString("# TYPE skylark_used_capacity gauge\n")
+	fmt.Fprintf(&b, "skylark_used_capacity %d\n", used)
+
+	b.WriteString("# HELP skylark_frames_processed Frames FFmpeg has output in the camera's current run.\n")
+	b.WriteString("# TYPE skylark_frames_processed counter\n")
+	for _, cameraID := range cameraIDs {
+		fmt.Fprintf(&b, "skylark_frames_processed{camera=\"%s\"} %d\n", prometheusLabelValue(cameraID), frames[cameraID])
+	}
+	b.WriteString("# HELP skylark_bytes_processed Bytes FFmpeg has written in the camera's current run.\n")
+	b.WriteString("# TYPE skylark_bytes_processed counter\n")
+	for _, cameraID := range cameraIDs {
+		fmt.Fprintf(&b, "skylark_bytes_processed{camera=\"%s\"} %d\n", prometheusLabelValue(cameraID), bytes[cameraID])
+	}
+	b.WriteString("# HELP skylark_errors_total FFmpeg failures of the camera across its runs.\n")
+	b.WriteString("# TYPE skylark_errors_total counter\n")
+	for _, cameraID := range cameraIDs {
+		var failures uint64
+		for _, count := range ffmpegRestartReasons(cameraID) {
+			failures += count
+		}
+		fmt.Fprintf(&b, "skylark_errors_total{camera=\"%s\"} %d\n", prometheusLabelValue(cameraID), failures)
+	}
+	return b.String()
+}
+
+// prometheusCircuitBreakers renders each camera's breaker state as a gauge:
+// 0 closed, 1 half-open, 2 open
+func prometheusCircuitBreakers() string {
+	circuitBreakersMutex.RLock()
+	breakers := make(map[string]*CircuitBreaker, len(circuitBreakers))
+	cameraIDs := make([]string, 0, len(circuitBreakers))
+	for cameraID, cb := range circuitBreakers {
+		breakers[cameraID] = cb
+		cameraIDs = append(cameraIDs, cameraID)
+	}
+	circuitBreakersMutex.RUnlock()
+	sort.Strings(cameraIDs)
+
+	var b strings.Builder
+	b.WriteString("# HELP skylark_circuit_breaker_state Camera circuit breaker state: 0 closed, 1 half-open, 2 open.\n")
+	b.WriteString("# TYPE skylark_circuit_breaker_state gauge\n")
+	for _, cameraID := range cameraIDs {
+		fmt.Fprintf(&b, "skylark_circuit_breaker_state{camera=\"%s\"} %d\n",
+			prometheusLabelValue(cameraID), circuitBreakerStateValues[breakers[cameraID].Snapshot().State])
+	}
+	return b.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusSeriesUseCameraLabel(t *testing.T) {
+	const cameraID = "cam-prom"
+	streamMetricsMutex.Lock()
+	streamMetrics[cameraID] = &StreamMetrics{CameraID: cameraID, StartTime: time.Now(), FramesProcessed: 42, BytesProcessed: 1024}
+	streamMetricsMutex.Unlock()
+	claimCircuitBreaker(cameraID, StreamOptions{})
+	t.Cleanup(func() {
+		streamMetricsMutex.Lock()
+		delete(streamMetrics, cameraID)
+		streamMetricsMutex.Unlock()
+		circuitBreakersMutex.Lock()
+		delete(circuitBreakers, cameraID)
+		circuitBreakersMutex.Unlock()
+	})
+
+	exposition := prometheusStreamMetrics() + prometheusCircuitBreakers()
+	for _, series := range []string{
+		`skylark_frames_processed{camera="cam-prom"} 42`,
+		`skylark_bytes_processed{camera="cam-prom"} 1024`,
+		`skylark_errors_total{camera="cam-prom"} 0`,
+		`skylark_circuit_breaker_state{camera="cam-prom"} 0`,
+	} {
+		if !strings.Contains(exposition, series+"\n") {
+			t.Errorf("missing %s in:\n%s", series, exposition)
+		}
+	}
+	if strings.Contains(exposition, "camera_id=") {
+		t.Errorf("skylark series still labelled camera_id:\n%s", exposition)
+	}
+}
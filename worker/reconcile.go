@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// ReconcilePath is a MediaMTX path the worker owns but nothing uses
+type ReconcilePath struct {
+	PathName   string `json:"pathName"`
+	CameraID   string `json:"cameraId"`
+	Configured bool   `json:"configured"` // Listed in MediaMTX's path configuration
+	Ready      bool   `json:"ready"`      // Has a live publisher
+	Reason     string `json:"reason"`
+}
+
+// ReconcileCamera is a camera marked as processing with no FFmpeg process
+type ReconcileCamera struct {
+	CameraID string `json:"cameraId"`
+	PathName string `json:"pathName,omitempty"`
+	Status   string `json:"status"`
+}
+
+// ReconcileProcess is a running process whose camera has no database record
+type ReconcileProcess struct {
+	CameraID  string    `json:"cameraId"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// ReconcilePlan is what a reconciliation pass would change; building it changes nothing
+type ReconcilePlan struct {
+	GeneratedAt        time.Time          `json:"generatedAt"`
+	OrphanedPaths      []ReconcilePath    `json:"orphanedPaths"`      // Would be deleted from MediaMTX
+	CamerasToStart     []ReconcileCamera  `json:"camerasToStart"`     // Would be started
+	UntrackedProcesses []ReconcileProcess `json:"untrackedProcesses"` // Would be stopped
+	IgnoredPaths       []string           `json:"ignoredPaths"`       // Created outside the worker; left alone
+	// Errors lists states that couldn't be read; the sections depending on them are empty
+	Errors []string `json:"errors,omitempty"`
+}
+
+// mediamtxPathState is a path as seen by the MediaMTX API, merged from the live and
+// configured path lists
+type mediamtxPathState struct {
+	Configured bool
+	Ready      bool
+}
+
+// listMediaMTXPathStates reads MediaMTX's live paths and its path configuration
+func listMediaMTXPathStates() (map[string]mediamtxPathState, error) {
+	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
+	if mediamtxAPIURL == "" {
+		mediamtxAPIURL = "http://localhost:9997"
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	states := make(map[string]mediamtxPathState)
+	for _, endpoint := range []string{"/v3/paths/list", "/v3/config/paths/list"} {
+		resp, err := mediamtxGet(client, mediamtxAPIURL+endpoint+"?itemsPerPage=1000")
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Items []struct {
+				Name  string `json:"name"`
+				Ready bool   `json:"ready"`
+			} `json:"items"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("MediaMTX API returned status %d for %s", resp.StatusCode, endpoint)
+		}
+		err = decodeMediaMTXJSON(resp, &list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Items {
+			state := states[item.Name]
+			if endpoint == "/v3/config/paths/list" {
+				state.Configured = true
+			} else {
+				state.Ready = item.Ready
+			}
+			states[item.Name] = state
+		}
+	}
+	return states, nil
+}
+
+// buildReconcilePlan compares MediaMTX paths, running processes and the camera store.
+// Pre-configured paths (recorded as configured in the store) aren't orphans even
+// without a process, and paths outside the worker's prefix are never touched.
+func buildReconcilePlan(store CameraStore) ReconcilePlan {
+	plan := ReconcilePlan{
+		GeneratedAt:        time.Now(),
+		OrphanedPaths:      []ReconcilePath{},
+		CamerasToStart:     []ReconcileCamera{},
+		UntrackedProcesses: []ReconcileProcess{},
+		IgnoredPaths:       []string{},
+	}
+
+	processMutex.RLock()
+	running := make(map[string]time.Time, len(activeProcesses))
+	for cameraID, process := range activeProcesses {
+		running[cameraID] = process.StartedAt
+	}
+	processMutex.RUnlock()
+
+	var cameras map[string]CameraRecord
+	if !store.Available() {
+		plan.Errors = append(plan.Errors, "camera store: database not available")
+	} else if records, err := store.ListCameras(); err != nil {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("camera store: %v", err))
+	} else {
+		cameras = make(map[string]CameraRecord, len(records))
+		for _, camera := range records {
+			cameras[camera.ID] = camera
+		}
+	}
+
+	paths, err := listMediaMTXPathStates()
+	if err != nil {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("MediaMTX: %v", err))
+	}
+	for pathName, state := range paths {
+		cameraID, ok := getCorrespondingCameraID(pathName)
+		if !ok {
+			plan.IgnoredPaths = append(plan.IgnoredPaths, pathName)
+			continue
+		}
+		if _, isRunning := running[cameraID]; isRunning {
+			continue
+		}
+
+		reason := "no running process"
+		if cameras != nil {
+			camera, known := cameras[cameraID]
+			switch {
+			case !known:
+				reason = "no running process and no camera record"
+			case camera.Configured:
+				continue // Pre-configured, or a camera about to be restarted
+			default:
+				reason = "no running process and the camera isn't configured"
+			}
+		} else if store.Available() {
+			continue // Without the camera records a pre-configured path can't be told apart
+		}
+		plan.OrphanedPaths = append(plan.OrphanedPaths, ReconcilePath{
+			PathName:   pathName,
+			CameraID:   cameraID,
+			Configured: state.Configured,
+			Ready:      state.Ready,
+			Reason:     reason,
+		})
+	}
+
+	if cameras != nil {
+		for cameraID, camera := range cameras {
+			if _, isRunning := running[cameraID]; !isRunning && camera.Enabled && camera.Status == "PROCESSING" {
+				plan.CamerasToStart = append(plan.CamerasToStart, ReconcileCamera{
+					CameraID: cameraID,
+					PathName: camera.PathName,
+					Status:   camera.Status,
+				})
+			}
+		}
+		for cameraID, startedAt := range running {
+			if _, known := cameras[cameraID]; !known {
+				plan.UntrackedProcesses = append(plan.UntrackedProcesses, ReconcileProcess{CameraID: cameraID, StartedAt: startedAt})
+			}
+		}
+	}
+
+	sort.Slice(plan.OrphanedPaths, func(i, j int) bool { return plan.OrphanedPaths[i].PathName < plan.OrphanedPaths[j].PathName })
+	sort.Slice(plan.CamerasToStart, func(i, j int) bool { return plan.CamerasToStart[i].CameraID < plan.CamerasToStart[j].CameraID })
+	sort.Slice(plan.UntrackedProcesses, func(i, j int) bool {
+		return plan.UntrackedProcesses[i].CameraID < plan.UntrackedProcesses[j].CameraID
+	})
+	sort.Strings(plan.IgnoredPaths)
+	return plan
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+const (
+	// recordSegmentLayout matches the %Y-%m-%d_%H-%M-%S part of recordPath; the -%f
+	// microseconds after it aren't a fraction Go's layouts can parse
+	recordSegmentLayout = "2006-01-02_15-04-05"
+	// clipFlushDelay gives MediaMTX time to flush the last fMP4 part to disk
+	clipFlushDelay = 3 * time.Second
+	// maxClipLength caps how far back-to-back detections can extend one clip
+	maxClipLength      = 2 * time.Minute
+	clipExtractTimeout = 2 * time.Minute
+)
+
+// RecordingConfig controls MediaMTX segment recording and detection clip export
+type RecordingConfig struct {
+	Enabled         bool
+	Mode            string // continuous (MediaMTX records everything) or event
+	Dir             string // Where MediaMTX writes segments; must be readable by the worker
+	SegmentDuration time.Duration
+	DeleteAfter     time.Duration
+	ClipDir         string
+	ClipBefore      time.Duration
+	ClipAfter       time.Duration
+	EventPreRoll    time.Duration // Event mode: footage kept from before the first detection
+	EventTrailing   time.Duration // Event mode: recording continues this long after the last detection
+	EventMaxLength  time.Duration // Event mode: longest single event recording
+}
+
+// loadRecordingConfig reads RECORDING_* and CLIP_* from the environment
+func loadRecordingConfig() RecordingConfig {
+	config := RecordingConfig{
+		Enabled:         os.Getenv("RECORDING_ENABLED") == "true",
+		Mode:            recordingModeFromEnv(),
+		Dir:             os.Getenv("RECORDING_DIR"),
+		SegmentDuration: getEnvDuration("RECORDING_SEGMENT_DURATION", time.Minute),
+		DeleteAfter:     getEnvDuration("RECORDING_DELETE_AFTER", 24*time.Hour),
+		ClipDir:         os.Getenv("CLIP_DIR"),
+		ClipBefore:      getEnvDuration("CLIP_BEFORE", 10*time.Second),
+		ClipAfter:       getEnvDuration("CLIP_AFTER", 10*time.Second),
+		EventPreRoll:    getEnvDuration("RECORDING_EVENT_PRE_ROLL", 5*time.Second),
+		EventTrailing:   getEnvDuration("RECORDING_EVENT_TRAILING", 10*time.Second),
+		EventMaxLength:  getEnvDuration("RECORDING_EVENT_MAX_DURATION", 10*time.Minute),
+	}
+	if config.Dir == "" {
+		config.Dir = "./recordings"
+	}
+	if config.ClipDir == "" {
+		config.ClipDir = "./clips"
+	}
+	return config
+}
+
+// mediamtxPathSettings returns the record settings to merge into a path config
+func (c RecordingConfig) mediamtxPathSettings() map[string]any {
+	if !c.continuous() {
+		return nil
+	}
+	return map[string]any{
+		"record":                true,
+		"recordPath":            filepath.Join(c.Dir, "%path", "%Y-%m-%d_%H-%M-%S-%f"),
+		"recordFormat":          "fmp4",
+		"recordSegmentDuration": c.SegmentDuration.String(),
+		"recordDeleteAfter":     c.DeleteAfter.String(),
+	}
+}
+
+// recordingSegment is one recorded file and the time its first frame was captured
+type recordingSegment struct {
+	Path  string
+	Start time.Time
+}
+
+// listRecordingSegments returns a path's segments, oldest first
+func listRecordingSegments(dir, pathName string) ([]recordingSegment, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, pathName))
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]recordingSegment, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".mp4" {
+			continue
+		}
+		start, err := parseSegmentStart(strings.TrimSuffix(name, ".mp4"))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, recordingSegment{Path: filepath.Join(dir, pathName, name), Start: start})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start.Before(segments[j].Start) })
+	return segments, nil
+}
+
+// parseSegmentStart parses a segment name MediaMTX wrote with %Y-%m-%d_%H-%M-%S-%f,
+// e.g. 2024-05-12_14-30-05-123456, in local time
+func parseSegmentStart(name string) (time.Time, error) {
+	stamp, micros, found := strings.Cut(name[min(len(name), len(recordSegmentLayout)):], "-")
+	if !found || stamp != "" || len(micros) != 6 {
+		return time.Time{}, fmt.Errorf("segment name %q doesn't match %%Y-%%m-%%d_%%H-%%M-%%S-%%f", name)
+	}
+	start, err := time.ParseInLocation(recordSegmentLayout, name[:len(recordSegmentLayout)], time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	us, err := strconv.Atoi(micros)
+	if err != nil || us < 0 {
+		return time.Time{}, fmt.Errorf("segment name %q has invalid microseconds %q", name, micros)
+	}
+	return start.Add(time.Duration(us) * time.Microsecond), nil
+}
+
+// formatSegmentName names a file after t the way MediaMTX names segments
+func formatSegmentName(t time.Time) string {
+	return fmt.Sprintf("%s-%06d", t.Format(recordSegmentLayout), t.Nanosecond()/int(time.Microsecond))
+}
+
+// segmentsCovering picks the segments overlapping [from, to). A segment runs until the
+// next one starts; the newest is assumed to still be recording.
+func segmentsCovering(segments []recordingSegment, from, to time.Time) []recordingSegment {
+	covering := []recordingSegment{}
+	for i, segment := range segments {
+		end := time.Now()
+		if i+1 < len(segments) {
+			end = segments[i+1].Start
+		}
+		if segment.Start.Before(to) && end.After(from) {
+			covering = append(covering, segment)
+		}
+	}
+	return covering
+}
+
+// pendingClip is a clip waiting for its end time to pass before being cut
+type pendingClip struct {
+	path  string
+	start time.Time
+	end   time.Time
+}
+
+// continuous reports whether MediaMTX records every camera around the clock
+func (c RecordingConfig) continuous() bool {
+	return c.Enabled && c.Mode != recordingModeEvent
+}
+
+// ClipExporter cuts clips around detection events from the recorded segments.
+// Detections while a clip is pending extend it rather than starting a new one.
+type ClipExporter struct {
+	config  RecordingConfig
+	pending map[string]*pendingClip
+	mu      sync.Mutex
+}
+
+// NewClipExporter creates an exporter; it does nothing unless recording is enabled
+func NewClipExporter(config RecordingConfig) *ClipExporter {
+	return &ClipExporter{
+		config:  config,
+		pending: make(map[string]*pendingClip),
+	}
+}
+
+// Schedule arranges a clip around a detection and returns the path it will be written
+// to, or "" unless continuous recording is enabled
+func (e *ClipExporter) Schedule(cameraID string, detectedAt time.Time) string {
+	if !e.config.continuous() {
+		return ""
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if clip, exists := e.pending[cameraID]; exists && !detectedAt.After(clip.end) {
+		if end := detectedAt.Add(e.config.ClipAfter); end.After(clip.end) {
+			clip.end = end
+			if limit := clip.start.Add(maxClipLength); clip.end.After(limit) {
+				clip.end = limit
+			}
+		}
+		return clip.path
+	}
+
+	clip := &pendingClip{
+		path:  filepath.Join(e.config.ClipDir, cameraID, formatSegmentName(detectedAt)+".mp4"),
+		start: detectedAt.Add(-e.config.ClipBefore),
+		end:   detectedAt.Add(e.config.ClipAfter),
+	}
+	e.pending[cameraID] = clip
+	go e.exportWhenReady(cameraID, clip)
+	return clip.path
+}
+
+// exportWhenReady waits until the clip's (possibly extended) end has been recorded, then cuts it
+func (e *ClipExporter) exportWhenReady(cameraID string, clip *pendingClip) {
+	for {
+		e.mu.Lock()
+		end := clip.end
+		e.mu.Unlock()
+
+		wait := time.Until(end.Add(clipFlushDelay))
+		if wait <= 0 {
+			break
+		}
+		time.Sleep(wait)
+	}
+
+	e.mu.Lock()
+	if e.pending[cameraID] == clip {
+		delete(e.pending, cameraID)
+	}
+	start, end := clip.start, clip.end
+	e.mu.Unlock()
+
+	event := StreamEvent{
+		Type:     streamEventClipReady,
+		CameraID: cameraID,
+		Details: map[string]interface{}{
+			"clipPath": clip.path,
+			"start":    start,
+			"end":      end,
+		},
+	}
+	if err := e.extract(cameraPathName(cameraID), clip.path, start, end); err != nil {
+		log.Printf("Failed to export clip for camera %s: %v", cameraID, err)
+		event.Type = streamEventClipFailed
+		event.Reason = err.Error()
+	} else {
+		log.Printf("Exported clip %s for camera %s (%v)", clip.path, cameraID, end.Sub(start).Round(time.Second))
+	}
+	streamEvents.Publish(event)
+}
+
+// extract cuts [start, end) from the path's segments with stream copy. Copy mode can
+// only cut on keyframes, so the clip may begin slightly before start.
+func (e *ClipExporter) extract(pathName, outputPath string, start, end time.Time) error {
+	segments, err := listRecordingSegments(e.config.Dir, pathName)
+	if err != nil {
+		return fmt.Errorf("failed to list recordings: %w", err)
+	}
+	covering := segmentsCovering(segments, start, end)
+	if len(covering) == 0 {
+		return fmt.Errorf("no recorded segments cover %s to %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	return concatSegments(covering, outputPath, start, end)
+}
+
+// concatSegments joins segments into outputPath with stream copy, trimming the first
+// to start and the last to end; zero times keep the segments whole
+func concatSegments(segments []recordingSegment, outputPath string, start, end time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create clip directory: %w", err)
+	}
+
+	// The concat demuxer joins segments and trims the first and last with inpoint/outpoint
+	var list strings.Builder
+	for i, segment := range segments {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(absPath(segment.Path), "'", `'\''`))
+		if i == 0 && !start.IsZero() && start.After(segment.Start) {
+			fmt.Fprintf(&list, "inpoint %.3f\n", start.Sub(segment.Start).Seconds())
+		}
+		if i == len(segments)-1 && !end.IsZero() && end.After(segment.Start) {
+			fmt.Fprintf(&list, "outpoint %.3f\n", end.Sub(segment.Start).Seconds())
+		}
+	}
+
+	listFile, err := os.CreateTemp("", "clip-*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to create concat list: %w", err)
+	}
+	defer os.Remove(listFile.Name())
+	if _, err := listFile.WriteString(list.String()); err != nil {
+		listFile.Close()
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+	listFile.Close()
+
+	compiled := ffmpeg.Input(listFile.Name(), ffmpeg.KwArgs{
+		"f":    "concat",
+		"safe": "0", // Segment paths are absolute
+	}).
+		Output(outputPath, ffmpeg.KwArgs{
+			"c":        "copy",
+			"movflags": "+faststart",
+		}).
+		OverWriteOutput().
+		Compile()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clipExtractTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, compiled.Args[0], compiled.Args[1:]...).CombinedOutput()
+	if err != nil {
+		tail := output
+		if len(tail) > 512 {
+			tail = tail[len(tail)-512:]
+		}
+		return fmt.Errorf("ffmpeg clip export failed: %w: %s", err, strings.TrimSpace(string(tail)))
+	}
+	return nil
+}
+
+// absPath resolves path against the working directory, falling back to path itself
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSegmentStart(t *testing.T) {
+	// A name as MediaMTX writes it for recordPath %Y-%m-%d_%H-%M-%S-%f
+	start, err := parseSegmentStart("2024-05-12_14-30-05-123456")
+	if err != nil {
+		t.Fatalf("parseSegmentStart: %v", err)
+	}
+	want := time.Date(2024, 5, 12, 14, 30, 5, 123456000, time.Local)
+	if !start.Equal(want) {
+		t.Fatalf("got %v, want %v", start, want)
+	}
+	if name := formatSegmentName(start); name != "2024-05-12_14-30-05-123456" {
+		t.Fatalf("formatSegmentName round trip gave %q", name)
+	}
+
+	for _, name := range []string{
+		"2024-05-12_14-30-05",
+		"2024-05-12_14-30-05-12345",
+		"2024-05-12_14-30-05-12345x",
+		"2024-05-12_14-30-05_123456",
+		"short",
+		"",
+	} {
+		if _, err := parseSegmentStart(name); err == nil {
+			t.Errorf("parseSegmentStart(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestListRecordingSegments(t *testing.T) {
+	dir := t.TempDir()
+	pathDir := filepath.Join(dir, "camera_1")
+	if err := os.MkdirAll(pathDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"2024-05-12_14-31-05-000042.mp4",
+		"2024-05-12_14-30-05-123456.mp4",
+		"notes.txt",
+		"garbage.mp4",
+	} {
+		if err := os.WriteFile(filepath.Join(pathDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, err := listRecordingSegments(dir, "camera_1")
+	if err != nil {
+		t.Fatalf("listRecordingSegments: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2: %+v", len(segments), segments)
+	}
+	if filepath.Base(segments[0].Path) != "2024-05-12_14-30-05-123456.mp4" ||
+		filepath.Base(segments[1].Path) != "2024-05-12_14-31-05-000042.mp4" {
+		t.Fatalf("segments not oldest first: %+v", segments)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// liveReloadSettings are applied by /reload without touching running streams.
+// Labels are read per lookup; the rest are pushed into their owners by applyReloadedConfig.
+var liveReloadSettings = []string{
+	"FACE_DETECTION_INTERVAL",
+	"FACE_DETECTION_CONFIDENCE_THRESHOLD",
+	"FACE_DETECTION_MIN_FACE_RATIO",
+	"FACE_DETECTION_MAX_FACE_RATIO",
+	"FACE_DETECTION_MODE",
+	"FACE_DETECTION_MAX_IMAGE_BYTES",
+	"MAX_CONCURRENT_STREAMS",
+	"CIRCUIT_BREAKER_MAX_FAILURES",
+	"CIRCUIT_BREAKER_RESET_TIMEOUT",
+	"CIRCUIT_BREAKER_WARMUP",
+	"AUTO_RESTART_RATE",
+	"AUTO_RESTART_BURST",
+	"SOURCE_MAX_CONNECTIONS",
+	"STREAM_CAPACITY_MODE",
+	"STREAM_QUEUE_TIMEOUT",
+	"STREAM_QUEUE_MAX_DEPTH",
+	"ALERT_TENANT_LABEL",
+	"ALERT_SITE_LABEL",
+	"CAMERA_TIMEZONE_LABEL",
+}
+
+// restartOnlySettings are wired into connections, clients or running streams at
+// startup. /reload reports changes to them but keeps the running values.
+var restartOnlySettings = []string{
+	"PORT",
+	"DATABASE_URL",
+	"DB_MAX_OPEN_CONNS",
+	"DB_MAX_IDLE_CONNS",
+	"DB_CONN_MAX_LIFETIME",
+	"DB_CONN_MAX_IDLE_TIME",
+	"DB_QUERY_TIMEOUT",
+	"MEDIAMTX_API_URL",
+	"MEDIAMTX_WEBRTC_URL",
+	"WEBRTC_ICE_SERVERS",
+	"RTSP_CREDENTIAL_KEYS",
+	"RTSP_CREDENTIAL_PLAINTEXT",
+	"WEBRTC_TURN_USERNAME",
+	"WEBRTC_TURN_CREDENTIAL",
+	"WEBRTC_TURN_SECRET",
+	"WEBRTC_TURN_CREDENTIAL_TTL",
+	"WHEP_MAX_SESSIONS",
+	"MEDIAMTX_API_USER",
+	"MEDIAMTX_API_PASS",
+	"MEDIAMTX_API_TOKEN",
+	"MEDIAMTX_TOKEN_URL",
+	"MEDIAMTX_CLIENT_ID",
+	"MEDIAMTX_CLIENT_SECRET",
+	"MEDIAMTX_TOKEN_SCOPE",
+	"MEDIAMTX_PATH_CONFLICT_POLICY",
+	"MEDIAMTX_MAX_RESPONSE_BYTES",
+	"MEDIAMTX_PATH_PREFIX",
+	"MEDIAMTX_HEALTH_INTERVAL",
+	"MEDIAMTX_OUTAGE_GRACE",
+	"MEDIAMTX_REPUBLISH_STAGGER",
+	"OBSERVER_RTSP_BASE_URL",
+	"RTSP_DROP_UNTIL_KEYFRAME",
+	"RTSP_COPY_FRAMES",
+	"RTSP_FRAME_TIMESTAMP_SOURCE",
+	"RTSP_RECONNECT_MAX_ATTEMPTS",
+	"RTSP_RECONNECT_INITIAL_DELAY",
+	"RTSP_RECONNECT_MAX_DELAY",
+	"HLS_OUTPUT_DIR",
+	"DEV_MODE",
+	"KAFKA_BROKERS",
+	"KAFKA_STREAM_EVENTS_TOPIC",
+	"KAFKA_SERIALIZATION_FORMAT",
+	"KAFKA_HEALTH_CHECK_INTERVAL",
+	"SCHEMA_REGISTRY_URL",
+	"SCHEMA_REGISTRY_USER",
+	"SCHEMA_REGISTRY_PASS",
+	"FACE_DETECTION_ENABLED",
+	"FACE_DETECTION_MODEL_PATH",
+	"FACE_DETECTION_BACKEND",
+	"FACE_DETECTION_STABILIZE_DELAY",
+	"FRAME_PROCESSORS",
+	"FRAME_QUALITY_MIN_BRIGHTNESS",
+	"FRAME_QUALITY_MAX_BRIGHTNESS",
+	"FRAME_QUALITY_MIN_SHARPNESS",
+	"FRAME_MOTION_THRESHOLD",
+	"ALERT_QUEUE_SIZE",
+	"ALERT_SUMMARY_ENABLED",
+	"ALERT_SUMMARY_WINDOW",
+	"ALERT_SUMMARY_ONLY",
+	"KAFKA_ALERT_SUMMARY_TOPIC",
+	"DETECTION_STORE_ENABLED",
+	"SNAPSHOT_CONCURRENCY",
+	"SNAPSHOT_CACHE_TTL",
+	"STOP_CONFIRM_TIMEOUT",
+	"STREAM_START_CONFIRM",
+	"STREAM_START_CONFIRM_TIMEOUT",
+	"MEDIAMTX_PATH_CLEANUP_TIMEOUT",
+	"RESTORE_STARTUP_TIMEOUT",
+	"WATCHDOG_INTERVAL",
+	"WATCHDOG_STALL_TIMEOUT",
+	"STATE_CLEANUP_INTERVAL",
+	"CIRCUIT_BREAKER_IDLE_TTL",
+	"STREAM_FRAME_STALL_THRESHOLD",
+	"ADAPTIVE_BITRATE_ENABLED",
+	"ADAPTIVE_BITRATE_MIN_KBPS",
+	"ADAPTIVE_BITRATE_MAX_KBPS",
+	"ADAPTIVE_BITRATE_STEP_PERCENT",
+	"ADAPTIVE_BITRATE_LOSS_HIGH",
+	"ADAPTIVE_BITRATE_LOSS_LOW",
+	"ADAPTIVE_BITRATE_INTERVAL",
+	"ADAPTIVE_BITRATE_SUSTAIN",
+	"ADAPTIVE_BITRATE_COOLDOWN",
+	"RECORDING_ENABLED",
+	"RECORDING_MODE",
+	"RECORDING_EVENT_PRE_ROLL",
+	"RECORDING_EVENT_TRAILING",
+	"RECORDING_EVENT_MAX_DURATION",
+	"RECORDING_DIR",
+	"RECORDING_SEGMENT_DURATION",
+	"RECORDING_DELETE_AFTER",
+	"CLIP_DIR",
+	"CLIP_BEFORE",
+	"CLIP_AFTER",
+	"VIDEO_MODE",
+	"VIDEO_COPY_MAX_KBPS",
+	"CPU_AFFINITY_ENABLED",
+	"FREEZE_DETECTION_ENABLED",
+	"FREEZE_CHECK_INTERVAL",
+	"FREEZE_DIFF_THRESHOLD",
+	"FREEZE_DURATION",
+	"FREEZE_RESTART",
+}
+
+// sensitiveSettings have their values masked in the reload report
+var sensitiveSettings = map[string]bool{
+	"DATABASE_URL":              true,
+	"MEDIAMTX_API_PASS":         true,
+	"MEDIAMTX_API_TOKEN":        true,
+	"MEDIAMTX_CLIENT_SECRET":    true,
+	"RTSP_CREDENTIAL_KEYS":      true,
+	"RTSP_CREDENTIAL_PLAINTEXT": true,
+	"SCHEMA_REGISTRY_PASS":      true,
+	"WEBRTC_TURN_CREDENTIAL":    true,
+	"WEBRTC_TURN_SECRET":        true,
+}
+
+// reloadMutex serializes reloads so two requests can't interleave env rewrites
+var reloadMutex sync.Mutex
+
+// SettingChange is one environment setting whose value differs after a reload
+type SettingChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// ReloadResult reports what /reload changed
+type ReloadResult struct {
+	Applied         []SettingChange `json:"applied"`
+	RequiresRestart []SettingChange `json:"requiresRestart"`
+	EnvFileLoaded   bool            `json:"envFileLoaded"`
+}
+
+// reloadConfig re-reads .env over the process environment, applies the live settings
+// and reports restart-only changes without applying them
+func reloadConfig() (ReloadResult, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	result := ReloadResult{Applied: []SettingChange{}, RequiresRestart: []SettingChange{}}
+
+	tracked := append(append([]string{}, liveReloadSettings...), restartOnlySettings...)
+	before := make(map[string]*string, len(tracked))
+	for _, key := range tracked {
+		if value, ok := os.LookupEnv(key); ok {
+			before[key] = &value
+		}
+	}
+
+	// Overload lets .env replace values loaded at startup; a missing file just means
+	// the process environment (which can't change under a running process) is the source
+	if err := godotenv.Overload(); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return result, fmt.Errorf("failed to read .env: %w", err)
+		}
+	} else {
+		result.EnvFileLoaded = true
+	}
+
+	for _, key := range restartOnlySettings {
+		if change, changed := settingChange(key, before[key]); changed {
+			result.RequiresRestart = append(result.RequiresRestart, change)
+			// Keep the running value so code reading the env lazily doesn't pick it up halfway
+			if before[key] != nil {
+				os.Setenv(key, *before[key])
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	}
+	for _, key := range liveReloadSettings {
+		if change, changed := settingChange(key, before[key]); changed {
+			result.Applied = append(result.Applied, change)
+		}
+	}
+
+	applyReloadedConfig()
+
+	sort.Slice(result.Applied, func(i, j int) bool { return result.Applied[i].Setting < result.Applied[j].Setting })
+	sort.Slice(result.RequiresRestart, func(i, j int) bool {
+		return result.RequiresRestart[i].Setting < result.RequiresRestart[j].Setting
+	})
+	log.Printf("Config reloaded: %d setting(s) applied, %d require a restart", len(result.Applied), len(result.RequiresRestart))
+	return result, nil
+}
+
+// settingChange compares a setting's current env value with its value before the reload
+func settingChange(key string, before *string) (SettingChange, bool) {
+	oldValue, newValue := "", ""
+	if before != nil {
+		oldValue = *before
+	}
+	current, exists := os.LookupEnv(key)
+	if exists {
+		newValue = current
+	}
+	if (before != nil) == exists && oldValue == newValue {
+		return SettingChange{}, false
+	}
+
+	if sensitiveSettings[key] {
+		oldValue, newValue = maskSetting(oldValue), maskSetting(newValue)
+	}
+	return SettingChange{Setting: key, Old: oldValue, New: newValue}, true
+}
+
+// maskSetting hides a secret while still showing whether it is set
+func maskSetting(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "********"
+}
+
+// applyReloadedConfig pushes the live settings into their owners. Running streams and
+// detection loops keep their settings; cameras started afterwards use the new ones.
+func applyReloadedConfig() {
+	config := loadWorkerConfig()
+	workerConfigMutex.Lock()
+	workerConfig = config
+	workerConfigMutex.Unlock()
+
+	restartLimiter.Reconfigure(restartLimitsFromEnv())
+	sourceConnections.SetDefaultLimit(sourceConnectionLimitFromEnv())
+
+	if faceDetector != nil {
+		faceDetector.Reload()
+	}
+
+	// Breakers outlive their streams, so refresh the ones for cameras not running now
+	processMutex.RLock()
+	circuitBreakersMutex.RLock()
+	for cameraID, cb := range circuitBreakers {
+		if _, running := activeProcesses[cameraID]; running {
+			continue
+		}
+		cb.mu.Lock()
+		cb.MaxFailures = config.CircuitBreakerMaxFailures
+		cb.ResetTimeout = config.CircuitBreakerResetTimeout
+		cb.mu.Unlock()
+	}
+	circuitBreakersMutex.RUnlock()
+	processMutex.RUnlock()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// cameraIDPattern limits camera IDs to characters that are safe in MediaMTX path
+// names, file names and log lines (UUIDs and slugs both fit)
+var cameraIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// FieldError is one invalid field in a 400 response
+type FieldError struct {
+	Field string `json:"field"` // JSON path, e.g. "cameras[2].rtspUrl"
+	Error string `json:"error"`
+}
+
+// registerRequestValidators adds the cameraid and rtspurl binding tags and makes
+// validation errors name fields by their JSON keys
+func registerRequestValidators() error {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return fmt.Errorf("unexpected gin validator engine %T", binding.Validator.Engine())
+	}
+
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	if err := validate.RegisterValidation("cameraid", func(fl validator.FieldLevel) bool {
+		return cameraIDPattern.MatchString(fl.Field().String())
+	}); err != nil {
+		return err
+	}
+	return validate.RegisterValidation("rtspurl", func(fl validator.FieldLevel) bool {
+		source := fl.Field().String()
+		if devModeEnabled() && isDevSource(source) {
+			return true // Checked when the stream starts; see devSourceInput
+		}
+		if isAdapterSource(source) {
+			_, err := newSourceAdapter(source)
+			return err == nil
+		}
+		return validateRTSPURL(source) == nil
+	})
+}
+
+// validateRTSPURL checks the URL is rtsp:// or rtsps:// with a host
+func validateRTSPURL(raw string) error {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if parsed.Scheme != "rtsp" && parsed.Scheme != "rtsps" {
+		return fmt.Errorf("scheme must be rtsp or rtsps, got %q", parsed.Scheme)
+	}
+	return validateURLHost(parsed)
+}
+
+// validateURLHost checks the URL's host and port. IPv6 literals must be bracketed, as in
+// rtsp://[2001:db8::1]:554/stream: url.Parse reads an unbracketed one as a host ending
+// in a colon followed by a port, so the address would silently change.
+func validateURLHost(parsed *url.URL) error {
+	hostname := parsed.Hostname()
+	if hostname == "" {
+		return fmt.Errorf("URL has no host")
+	}
+	if strings.HasPrefix(parsed.Host, "[") {
+		// A zone (fe80::1%25eth0) isn't part of the address
+		address, _, _ := strings.Cut(hostname, "%")
+		if !strings.Contains(address, ":") || net.ParseIP(address) == nil {
+			return fmt.Errorf("%q in brackets is not an IPv6 address", hostname)
+		}
+	} else if strings.Contains(hostname, ":") {
+		return fmt.Errorf("IPv6 address in %q must be in brackets, e.g. [2001:db8::1]:554", parsed.Host)
+	}
+	if port := parsed.Port(); port != "" {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return fmt.Errorf("port %q out of range (1-65535)", port)
+		}
+	}
+	return nil
+}
+
+// warnInvalidConfiguredURLs logs the MediaMTX and observer base URLs from the
+// environment whose host wouldn't pass validateURLHost, e.g. an unbracketed IPv6
+// address, since the worker builds every publish and playback URL from them
+func warnInvalidConfiguredURLs() {
+	for _, key := range []string{"MEDIAMTX_URL", "MEDIAMTX_API_URL", "MEDIAMTX_WEBRTC_URL", "OBSERVER_RTSP_BASE_URL"} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		parsed, err := url.Parse(value)
+		if err == nil {
+			err = validateURLHost(parsed)
+		}
+		if err != nil {
+			log.Printf("Warning: %s %q is not a usable URL: %v", key, value, err)
+		}
+	}
+}
+
+// bindJSON binds and validates the request body, writing a 400 listing every invalid
+// field when it fails
+func bindJSON(c *gin.Context, req any) bool {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return true
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request: %v", err),
+		})
+		return false
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		fields = append(fields, FieldError{
+			Field: fieldPath(fieldErr, req),
+			Error: fieldErrorMessage(fieldErr),
+		})
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":  fmt.Sprintf("Invalid request: %d invalid field(s)", len(fields)),
+		"fields": fields,
+	})
+	return false
+}
+
+// fieldPath drops the struct name the validator prefixes to the namespace of named
+// request types (anonymous request structs have none)
+func fieldPath(fieldErr validator.FieldError, req any) string {
+	typ := reflect.TypeOf(req)
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Name() == "" {
+		return fieldErr.Namespace()
+	}
+	return strings.TrimPrefix(fieldErr.Namespace(), typ.Name()+".")
+}
+
+// fieldErrorMessage describes a failed validation tag in words
+func fieldErrorMessage(fieldErr validator.FieldError) string {
+	isString := fieldErr.Kind() == reflect.String
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return fmt.Sprintf("is required when %s is not set", strings.ToLower(fieldErr.Param()[:1])+fieldErr.Param()[1:])
+	case "cameraid":
+		return "must be 1-64 letters, digits, '-' or '_'"
+	case "rtspurl":
+		return "must be an rtsp://, rtsps:// or mjpeg+http(s):// URL with a host"
+	case "max", "lte":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters", fieldErr.Param())
+		}
+		if fieldErr.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at most %s entries", fieldErr.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fieldErr.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fieldErr.Param())
+	case "min", "gte":
+		if fieldErr.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at least %s entries", fieldErr.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fieldErr.Param())
+	default:
+		return fmt.Sprintf("failed %q validation", fieldErr.Tag())
+	}
+}
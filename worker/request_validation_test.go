@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateRTSPURLHosts(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"rtsp://camera.local:554/stream", false},
+		{"rtsp://10.0.0.1/stream", false},
+		{"rtsp://[::1]/stream", false},
+		{"rtsp://[::1]:8554/live", false},
+		{"rtsps://[2001:db8::1]:322/stream", false},
+		{"rtsp://[fe80::1%25eth0]:8554/live", false},
+		{"rtsp://user:pass@[2001:db8::1]/stream", false},
+		{"rtsp://2001:db8::1/stream", true}, // Would be host "2001:db8:" port 1
+		{"rtsp://::1/stream", true},
+		{"rtsp://fe80::1%25eth0/live", true},
+		{"rtsp://[10.0.0.1]/stream", true}, // Brackets around an IPv4 address
+		{"rtsp://[camera.local]/stream", true},
+		{"rtsp://[::1]:0/stream", true},
+		{"rtsp://[::1]:70000/stream", true},
+		{"rtsp:///stream", true},
+		{"http://[::1]/stream", true},
+	}
+	for _, tt := range tests {
+		err := validateRTSPURL(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateRTSPURL(%q) = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMediaMTXInstanceValidateIPv6(t *testing.T) {
+	tests := []struct {
+		instance MediaMTXInstance
+		wantErr  bool
+	}{
+		{MediaMTXInstance{APIURL: "http://[::1]:9997", PublishURL: "rtsp://[::1]:8554", WebRTCURL: "http://[::1]:8891"}, false},
+		{MediaMTXInstance{PublishURL: "rtsp://[fe80::1%25eth0]:8554"}, false},
+		{MediaMTXInstance{APIURL: "http://::1:9997"}, true},
+		{MediaMTXInstance{PublishURL: "rtsp://2001:db8::2:8554"}, true},
+		{MediaMTXInstance{WebRTCURL: "http://fe80::1:8891"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.instance.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Validate() = %v, wantErr %v", tt.instance, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPublishAndObserverURLsKeepIPv6Brackets(t *testing.T) {
+	path := cameraPathName("cam-1")
+	tests := []struct {
+		name     string
+		build    func() string
+		want     string
+		hostname string
+		port     string
+	}{
+		{
+			name: "publish on a loopback instance",
+			build: func() string {
+				return getReencodedStreamURL(&MediaMTXInstance{PublishURL: "rtsp://[::1]:8554/"}, "cam-1")
+			},
+			want:     "rtsp://[::1]:8554/" + path,
+			hostname: "::1",
+			port:     "8554",
+		},
+		{
+			name: "publish on a zoned link-local instance",
+			build: func() string {
+				return getReencodedStreamURL(&MediaMTXInstance{PublishURL: "rtsp://[fe80::1%25eth0]:8554"}, "cam-1")
+			},
+			want:     "rtsp://[fe80::1%25eth0]:8554/" + path,
+			hostname: "fe80::1%eth0",
+			port:     "8554",
+		},
+		{
+			name: "observer from the sharded instance",
+			build: func() string {
+				return getObserverURL(&MediaMTXInstance{PublishURL: "rtsp://[2001:db8::2]:8554"}, "cam-1")
+			},
+			want:     "rtsp://[2001:db8::2]:8554/" + path,
+			hostname: "2001:db8::2",
+			port:     "8554",
+		},
+		{
+			name: "observer from OBSERVER_RTSP_BASE_URL",
+			build: func() string {
+				t.Setenv("OBSERVER_RTSP_BASE_URL", "rtsp://[2001:db8::5]/")
+				return getObserverURL(nil, "cam-1")
+			},
+			want:     "rtsp://[2001:db8::5]/" + path,
+			hostname: "2001:db8::5",
+		},
+		{
+			name: "publish from MEDIAMTX_URL",
+			build: func() string {
+				t.Setenv("MEDIAMTX_URL", "rtsp://[::1]:8554")
+				return getReencodedStreamURL(nil, "cam-1")
+			},
+			want:     "rtsp://[::1]:8554/" + path,
+			hostname: "::1",
+			port:     "8554",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.build()
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			parsed, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validateURLHost(parsed); err != nil {
+				t.Fatalf("built URL %q doesn't validate: %v", got, err)
+			}
+			if parsed.Hostname() != tt.hostname || parsed.Port() != tt.port {
+				t.Fatalf("%q parses as host %q port %q, want %q %q", got, parsed.Hostname(), parsed.Port(), tt.hostname, tt.port)
+			}
+		})
+	}
+}
+
+func TestWebRTCOfferReportsInvalidFields(t *testing.T) {
+	router := newRouter(NewMemoryCameraStore())
+	body := `{"cameraId": "bad id!", "rtspUrl": "http://10.0.0.1/stream"}`
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webrtc/offer", strings.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("POST /webrtc/offer = %d: %s, want 400", recorder.Code, recorder.Body)
+	}
+
+	var resp struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	invalid := map[string]bool{}
+	for _, field := range resp.Fields {
+		invalid[field.Field] = true
+	}
+	if len(resp.Fields) != 2 || !invalid["cameraId"] || !invalid["rtspUrl"] {
+		t.Fatalf("fields %+v, want cameraId and rtspUrl", resp.Fields)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the fleet-wide auto-restart rate limiter
+const (
+	defaultRestartRate  = 1.0 // Restarts per second across all cameras
+	defaultRestartBurst = 5   // Restarts allowed back-to-back before pacing kicks in
+)
+
+// RestartLimiter is a token bucket shared by every auto-restart attempt so a fleet-wide
+// failure recovers at a steady pace instead of spawning all FFmpeg processes at once
+type RestartLimiter struct {
+	rate     float64 // Tokens added per second
+	burst    float64 // Bucket capacity
+	tokens   float64 // May go negative while callers are queued
+	last     time.Time
+	waiting  int
+	granted  uint64
+	maxDelay time.Duration
+	mu       sync.Mutex
+}
+
+// RestartLimiterStats is the limiter view returned by /metrics
+type RestartLimiterStats struct {
+	RatePerSecond   float64 `json:"ratePerSecond"`
+	Burst           int     `json:"burst"`
+	AvailableTokens float64 `json:"availableTokens"`
+	Waiting         int     `json:"waiting"`
+	Granted         uint64  `json:"granted"`
+	MaxWait         string  `json:"maxWait"`
+}
+
+// NewRestartLimiter creates a full bucket allowing rate restarts/sec with the given burst
+func NewRestartLimiter(rate float64, burst int) *RestartLimiter {
+	if rate <= 0 {
+		rate = defaultRestartRate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RestartLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// NewRestartLimiterFromEnv reads AUTO_RESTART_RATE and AUTO_RESTART_BURST
+func NewRestartLimiterFromEnv() *RestartLimiter {
+	return NewRestartLimiter(restartLimitsFromEnv())
+}
+
+// restartLimitsFromEnv parses AUTO_RESTART_RATE and AUTO_RESTART_BURST, falling back to the defaults
+func restartLimitsFromEnv() (float64, int) {
+	rate := defaultRestartRate
+	if value := os.Getenv("AUTO_RESTART_RATE"); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed > 0 {
+			rate = parsed
+		} else {
+			log.Printf("Invalid AUTO_RESTART_RATE %q, using %.1f/s", value, defaultRestartRate)
+		}
+	}
+
+	burst := defaultRestartBurst
+	if value := os.Getenv("AUTO_RESTART_BURST"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			burst = parsed
+		} else {
+			log.Printf("Invalid AUTO_RESTART_BURST %q, using %d", value, defaultRestartBurst)
+		}
+	}
+
+	return rate, burst
+}
+
+// Reconfigure changes the rate and burst in place; callers already queued keep their slot
+func (l *RestartLimiter) Reconfigure(rate float64, burst int) {
+	if rate <= 0 {
+		rate = defaultRestartRate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = rate
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// refill adds tokens for the time elapsed since the last update; caller holds mu
+func (l *RestartLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// Wait blocks until the caller may restart and returns how long it waited.
+// Each call reserves a token up front, so queued callers are served in arrival order.
+func (l *RestartLimiter) Wait() time.Duration {
+	l.mu.Lock()
+	now := time.Now()
+	l.refill(now)
+	l.tokens--
+	l.granted++
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	if delay > l.maxDelay {
+		l.maxDelay = delay
+	}
+	if delay > 0 {
+		l.waiting++
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}
+	return delay
+}
+
+// Stats returns a snapshot of the limiter state
+func (l *RestartLimiter) Stats() RestartLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	return RestartLimiterStats{
+		RatePerSecond:   l.rate,
+		Burst:           int(l.burst),
+		AvailableTokens: l.tokens,
+		Waiting:         l.waiting,
+		Granted:         l.granted,
+		MaxWait:         l.maxDelay.Round(time.Millisecond).String(),
+	}
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,90 +20,374 @@ import (
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 )
 
-// Frame represents a processed video frame
+// FrameKind is the source track a frame came from
+type FrameKind int
+
+const (
+	FrameKindVideo FrameKind = iota // H.264; the zero value, so frames are video unless marked
+	FrameKindAudio                  // The source's audio track, see RTSPStreamManager.AudioFormat
+)
+
+// Frame represents a processed video or audio frame. Timestamp is when it was handed to
+// the subscribers; consumers pacing by the source's own clock use Time instead.
 type Frame struct {
+	Kind       FrameKind
 	Data       []byte
 	Timestamp  time.Time
 	Duration   time.Duration
-	IsKeyFrame bool
+	IsKeyFrame bool // Always set on audio frames, which decode on their own
+
+	// ArrivalTime is when the packet reached the worker
+	ArrivalTime time.Time
+	// RTPTimestamp is the source packet's RTP timestamp, set when HasRTPTimestamp is.
+	// RTPPosition is it unwrapped, as time since RTPStart (the arrival of the stream's
+	// first packet), so jitter between the two clocks shows up as a drift of
+	// RTPStart+RTPPosition from ArrivalTime.
+	RTPTimestamp    uint32
+	RTPPosition     time.Duration
+	RTPStart        time.Time
+	HasRTPTimestamp bool
+}
+
+// UnsupportedCodecError reports that a source has no track in a codec the worker can handle
+type UnsupportedCodecError struct {
+	URL    string
+	Codecs []string // Formats the source offered instead
+}
+
+func (e *UnsupportedCodecError) Error() string {
+	if len(e.Codecs) == 0 {
+		return fmt.Sprintf("H.264 track not found in stream %s", e.URL)
+	}
+	return fmt.Sprintf("H.264 track not found in stream %s (source offers: %s)", e.URL, strings.Join(e.Codecs, ", "))
+}
+
+// FrameDistributionConfig controls how distributeFrame treats slow subscribers
+type FrameDistributionConfig struct {
+	// DropUntilKeyframe skips a subscriber's delta frames after a drop until the next
+	// keyframe, since the decoder can't use them across the gap anyway
+	DropUntilKeyframe bool
+	// CopyPayload copies each RTP payload before handing it out. gortsplib allocates a
+	// fresh buffer per packet and subscribers only read it, so sharing it is safe.
+	CopyPayload bool
+	// TimestampSource is the clock direct-WebRTC streamers pace RTP timestamps by:
+	// frameTimestampWallClock or frameTimestampRTP
+	TimestampSource string
+}
+
+// frameDistribution is reloaded from the environment at startup
+var frameDistribution = FrameDistributionConfig{DropUntilKeyframe: true, TimestampSource: frameTimestampWallClock}
+
+// loadFrameDistributionConfig reads RTSP_DROP_UNTIL_KEYFRAME, RTSP_COPY_FRAMES and
+// RTSP_FRAME_TIMESTAMP_SOURCE
+func loadFrameDistributionConfig() FrameDistributionConfig {
+	return FrameDistributionConfig{
+		DropUntilKeyframe: os.Getenv("RTSP_DROP_UNTIL_KEYFRAME") != "false",
+		CopyPayload:       os.Getenv("RTSP_COPY_FRAMES") == "true",
+		TimestampSource:   frameTimestampSourceFromEnv(),
+	}
+}
+
+// RTSPRetryPolicy controls how a stream manager (re)connects to its source
+type RTSPRetryPolicy struct {
+	MaxAttempts  int // Connection attempts before the manager gives up; 0 retries forever
+	InitialDelay time.Duration
+	MaxDelay     time.Duration // Cap for the doubling delay between attempts
+}
+
+// streamManagerRetry is the policy GetOrCreateStreamManager uses, reloaded at startup
+var streamManagerRetry = RTSPRetryPolicy{MaxAttempts: 3, InitialDelay: 5 * time.Second, MaxDelay: 5 * time.Minute}
+
+// loadRTSPRetryPolicy reads RTSP_RECONNECT_* from the environment
+func loadRTSPRetryPolicy() RTSPRetryPolicy {
+	policy := RTSPRetryPolicy{
+		MaxAttempts:  getEnvInt("RTSP_RECONNECT_MAX_ATTEMPTS", 3),
+		InitialDelay: getEnvDuration("RTSP_RECONNECT_INITIAL_DELAY", 5*time.Second),
+		MaxDelay:     getEnvDuration("RTSP_RECONNECT_MAX_DELAY", 5*time.Minute),
+	}
+	if policy.MaxAttempts < 0 {
+		policy.MaxAttempts = 0
+	}
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = 5 * time.Second
+	}
+	if policy.MaxDelay < policy.InitialDelay {
+		policy.MaxDelay = policy.InitialDelay
+	}
+	return policy
+}
+
+// ErrStreamManagerStopped is the close reason of subscribers whose manager was stopped
+var ErrStreamManagerStopped = errors.New("RTSP stream manager stopped")
+
+// StreamRetriesExhaustedError is the close reason of subscribers whose manager gave up
+// reconnecting to its source
+type StreamRetriesExhaustedError struct {
+	URL      string
+	Attempts int
+	Err      error // The last connection error
+}
+
+func (e *StreamRetriesExhaustedError) Error() string {
+	return fmt.Sprintf("gave up on RTSP stream %s after %d attempt(s): %v", e.URL, e.Attempts, e.Err)
+}
+
+func (e *StreamRetriesExhaustedError) Unwrap() error { return e.Err }
+
+// subscriberQueueSize is how many frames a subscriber may fall behind before drops start
+const subscriberQueueSize = 100
+
+// subscriberDrainTimeout bounds how long Stop waits for subscribers to write out the
+// frames already queued for them before their channels are closed
+const subscriberDrainTimeout = 500 * time.Millisecond
+
+// frameSubscriber is one consumer of a stream manager's frames. The channel is its
+// bounded queue, drained by the subscriber's own goroutine (WebRTCStreamer.streamLoop),
+// so distribution never spawns goroutines per frame. Audio gets a parallel queue, so a
+// burst of video never crowds out audio and the keyframe logic only sees video.
+type frameSubscriber struct {
+	frames           chan *Frame
+	audio            chan *Frame // nil unless the subscriber opted in to audio
+	awaitingKeyframe bool        // A frame was dropped; deltas are skipped until the next keyframe
+	dropped          uint64
+	audioDropped     uint64
+	params           parameterSetVersions // Versions of the SPS/PPS this subscriber has been sent
+}
+
+// close closes the subscriber's channels. Caller holds the manager's lock.
+func (s *frameSubscriber) close() {
+	close(s.frames)
+	if s.audio != nil {
+		close(s.audio)
+	}
+}
+
+// parameterSetVersions counts changes to a stream's cached SPS and PPS
+type parameterSetVersions struct {
+	sps, pps uint64
+}
+
+// offer queues frame without blocking and reports whether it was queued. A keyframe
+// arriving at a full queue evicts the oldest frame, since it lets the viewer recover
+// and a stale delta doesn't. Caller holds the manager's lock.
+func (s *frameSubscriber) offer(frame *Frame, dropUntilKeyframe bool) bool {
+	if dropUntilKeyframe && s.awaitingKeyframe {
+		if !frame.IsKeyFrame {
+			s.dropped++
+			return false
+		}
+		s.awaitingKeyframe = false
+	}
+
+	select {
+	case s.frames <- frame:
+		return true
+	default:
+	}
+
+	if frame.IsKeyFrame {
+		select {
+		case <-s.frames:
+			s.dropped++
+		default: // The subscriber drained it meanwhile
+		}
+		select {
+		case s.frames <- frame:
+			return true
+		default:
+		}
+	}
+
+	s.dropped++
+	s.awaitingKeyframe = dropUntilKeyframe
+	return false
 }
 
 // RTSPStreamManager manages RTSP connections and frame distribution
 type RTSPStreamManager struct {
-	url           string
-	client        *gortsplib.Client
-	frameChannels map[string]chan *Frame
-	mu            sync.RWMutex
-	ctx           context.Context
-	cancel        context.CancelFunc
-	isRunning     bool
-	frameCount    uint64
-	spsData       []byte // Store SPS parameter set
-	ppsData       []byte // Store PPS parameter set
+	url         string
+	client      *gortsplib.Client
+	subscribers map[string]*frameSubscriber
+	mu          sync.RWMutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	isRunning   bool
+	frameCount  uint64
+	spsData     []byte               // Latest SPS, replaced (never modified) when it changes
+	ppsData     []byte               // Latest PPS, likewise
+	params      parameterSetVersions // Bumped whenever spsData or ppsData changes
+	timeline    rtpTimeline          // Source RTP timestamps, unwrapped
+	audioFormat format.Format        // The source's audio track; nil without one or before Start
+	audioClock  rtpTimeline          // The audio track's RTP timestamps, unwrapped
+	ready       chan struct{}        // Closed once the first connection attempt has resolved
+	readyOnce   sync.Once
+	startErr    error // Result of the most recent connection attempt
+	retry       RTSPRetryPolicy
+	closeReason error // Why the subscriber channels were closed; nil while the manager lives
+	draining    bool  // Stop has begun; no new frames are queued while subscribers catch up
 }
 
 // NewRTSPStreamManager creates a new RTSP stream manager
-func NewRTSPStreamManager(url string) *RTSPStreamManager {
+func NewRTSPStreamManager(url string, retry RTSPRetryPolicy) *RTSPStreamManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &RTSPStreamManager{
-		url:           url,
-		frameChannels: make(map[string]chan *Frame),
-		ctx:           ctx,
-		cancel:        cancel,
+		url:         url,
+		subscribers: make(map[string]*frameSubscriber),
+		ctx:         ctx,
+		cancel:      cancel,
+		ready:       make(chan struct{}),
+		retry:       retry,
 	}
 }
 
-// Subscribe creates a new channel for receiving frames
-func (rsm *RTSPStreamManager) Subscribe(subscriberID string) <-chan *Frame {
+// CloseReason tells a subscriber why its frame channel closed: ErrStreamManagerStopped,
+// a *StreamRetriesExhaustedError, or nil if it was unsubscribed while the manager lives
+func (rsm *RTSPStreamManager) CloseReason() error {
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.closeReason
+}
+
+// closeSubscribers closes every frame channel, recording reason for CloseReason
+func (rsm *RTSPStreamManager) closeSubscribers(reason error) {
 	rsm.mu.Lock()
 	defer rsm.mu.Unlock()
 
-	frameChan := make(chan *Frame, 100) // Buffer for 100 frames
-	rsm.frameChannels[subscriberID] = frameChan
-
-	// Send cached SPS/PPS to new subscriber if available
-	go func() {
-		if len(rsm.spsData) > 0 {
-			spsFrame := &Frame{
-				Data:       make([]byte, len(rsm.spsData)),
-				Timestamp:  time.Now(),
-				Duration:   0,
-				IsKeyFrame: true,
-			}
-			copy(spsFrame.Data, rsm.spsData)
+	if rsm.closeReason == nil {
+		rsm.closeReason = reason
+	}
+	for subscriberID, subscriber := range rsm.subscribers {
+		subscriber.close()
+		delete(rsm.subscribers, subscriberID)
+		log.Printf("Closed frame channel for subscriber %s: %v", subscriberID, reason)
+	}
+}
 
-			select {
-			case frameChan <- spsFrame:
-				log.Printf("Sent cached SPS to subscriber %s", subscriberID)
-			case <-time.After(100 * time.Millisecond):
-				log.Printf("Failed to send cached SPS to subscriber %s", subscriberID)
-			}
+// drainSubscribers stops queueing new frames and waits, up to timeout, for every
+// subscriber to take the frames already queued, so a streamer finishes the frame it is
+// writing instead of finding its channel closed mid-stream. The channels stay open;
+// closeSubscribers closes them afterwards.
+func (rsm *RTSPStreamManager) drainSubscribers(timeout time.Duration) {
+	rsm.mu.Lock()
+	rsm.draining = true
+	rsm.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		rsm.mu.RLock()
+		pending := 0
+		for _, subscriber := range rsm.subscribers {
+			pending += len(subscriber.frames) + len(subscriber.audio)
 		}
+		rsm.mu.RUnlock()
 
-		if len(rsm.ppsData) > 0 {
-			ppsFrame := &Frame{
-				Data:       make([]byte, len(rsm.ppsData)),
-				Timestamp:  time.Now(),
-				Duration:   0,
-				IsKeyFrame: true,
-			}
-			copy(ppsFrame.Data, rsm.ppsData)
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Closing subscribers of %s with %d frame(s) still queued", rsm.url, pending)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
 
-			select {
-			case frameChan <- ppsFrame:
-				log.Printf("Sent cached PPS to subscriber %s", subscriberID)
-			case <-time.After(100 * time.Millisecond):
-				log.Printf("Failed to send cached PPS to subscriber %s", subscriberID)
-			}
+// Ready returns a channel that is closed once the first connection attempt has resolved
+func (rsm *RTSPStreamManager) Ready() <-chan struct{} {
+	return rsm.ready
+}
+
+// Err returns the result of the most recent connection attempt
+func (rsm *RTSPStreamManager) Err() error {
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.startErr
+}
+
+// recordStartResult stores an attempt's outcome and resolves Ready on the first call
+func (rsm *RTSPStreamManager) recordStartResult(err error) {
+	rsm.mu.Lock()
+	rsm.startErr = err
+	rsm.mu.Unlock()
+	rsm.readyOnce.Do(func() { close(rsm.ready) })
+}
+
+// Subscribe creates a new channel for receiving video frames
+func (rsm *RTSPStreamManager) Subscribe(subscriberID string) <-chan *Frame {
+	return rsm.subscribe(subscriberID, false).frames
+}
+
+// SubscribeWithAudio is Subscribe with a second channel for the source's audio frames.
+// The audio channel stays empty while the source has no audio track (see AudioFormat),
+// and is closed along with the video one.
+func (rsm *RTSPStreamManager) SubscribeWithAudio(subscriberID string) (video, audio <-chan *Frame) {
+	subscriber := rsm.subscribe(subscriberID, true)
+	return subscriber.frames, subscriber.audio
+}
+
+// subscribe registers a subscriber, with an audio queue when withAudio is set
+func (rsm *RTSPStreamManager) subscribe(subscriberID string, withAudio bool) *frameSubscriber {
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+
+	subscriber := &frameSubscriber{frames: make(chan *Frame, subscriberQueueSize)}
+	if withAudio {
+		subscriber.audio = make(chan *Frame, subscriberQueueSize)
+	}
+	if rsm.closeReason != nil {
+		subscriber.close() // The manager is gone; CloseReason says why
+		return subscriber
+	}
+	rsm.subscribers[subscriberID] = subscriber
+
+	// Queue cached SPS/PPS first so the new subscriber can start decoding; the queue is
+	// empty, so these never block
+	for _, params := range [][]byte{rsm.spsData, rsm.ppsData} {
+		if len(params) == 0 {
+			continue
 		}
-	}()
+		now := time.Now()
+		subscriber.frames <- &Frame{
+			Data:        append([]byte(nil), params...),
+			Timestamp:   now,
+			IsKeyFrame:  true,
+			ArrivalTime: now,
+		}
+	}
+	subscriber.params = rsm.params
+	if withAudio {
+		log.Printf("Subscriber %s added to RTSP stream %s with audio", subscriberID, rsm.url)
+	} else {
+		log.Printf("Subscriber %s added to RTSP stream %s", subscriberID, rsm.url)
+	}
+	return subscriber
+}
+
+// AudioFormat returns the source's audio track format (*format.MPEG4Audio, *format.G711
+// or *format.Opus), or nil if the source has none or the manager hasn't connected yet
+func (rsm *RTSPStreamManager) AudioFormat() format.Format {
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.audioFormat
+}
+
+// ProfileLevelID returns the H.264 profile-level-id of the stream's current SPS, or
+// false before the manager has seen one
+func (rsm *RTSPStreamManager) ProfileLevelID() (string, bool) {
+	rsm.mu.RLock()
+	spsData := rsm.spsData
+	rsm.mu.RUnlock()
 
-	log.Printf("Subscriber %s added to RTSP stream %s", subscriberID, rsm.url)
-	return frameChan
+	var sps h264.SPS
+	if len(spsData) == 0 || sps.Unmarshal(spsData) != nil {
+		return "", false
+	}
+	return h264ProfileLevelID(&sps), true
 }
 
 // Unsubscribe removes a frame channel
@@ -102,10 +395,11 @@ func (rsm *RTSPStreamManager) Unsubscribe(subscriberID string) {
 	rsm.mu.Lock()
 	defer rsm.mu.Unlock()
 
-	if frameChan, exists := rsm.frameChannels[subscriberID]; exists {
-		close(frameChan)
-		delete(rsm.frameChannels, subscriberID)
-		log.Printf("Subscriber %s removed from RTSP stream %s", subscriberID, rsm.url)
+	if subscriber, exists := rsm.subscribers[subscriberID]; exists {
+		subscriber.close()
+		delete(rsm.subscribers, subscriberID)
+		log.Printf("Subscriber %s removed from RTSP stream %s (%d frames, %d audio frames dropped)",
+			subscriberID, rsm.url, subscriber.dropped, subscriber.audioDropped)
 	}
 }
 
@@ -123,8 +417,12 @@ func (rsm *RTSPStreamManager) Start() error {
 		Transport: &transport, // Use TCP transport for better reliability
 	}
 
-	// Parse URL
-	parsedURL, err := base.ParseURL(rsm.url)
+	// Parse URL, decrypting stored credentials
+	dialURL, err := openSourceURL(rsm.url)
+	if err != nil {
+		return err
+	}
+	parsedURL, err := base.ParseURL(dialURL)
 	if err != nil {
 		return fmt.Errorf("failed to parse RTSP URL: %w", err)
 	}
@@ -151,14 +449,22 @@ func (rsm *RTSPStreamManager) Start() error {
 	// Find H.264 video track
 	var videoMedia *description.Media
 	var videoFormat *format.H264
+	var offeredCodecs []string
 	for i, media := range desc.Medias {
 		log.Printf("Media %d: %s", i, media.Type)
 		for j, formatCandidate := range media.Formats {
 			log.Printf("  Format %d: %T", j, formatCandidate)
+			offeredCodecs = append(offeredCodecs, formatCandidate.Codec())
 			if h264Format, ok := formatCandidate.(*format.H264); ok {
 				videoMedia = media
 				videoFormat = h264Format
 				log.Printf("Found H.264 video track in media %d", i)
+				// Seed the cache from sprop-parameter-sets, for cameras that rarely send them in-band
+				sps, pps := h264Format.SafeParams()
+				rsm.mu.Lock()
+				rsm.updateParameterSets(sps, pps)
+				rsm.timeline = rtpTimeline{}
+				rsm.mu.Unlock()
 				break
 			}
 		}
@@ -169,7 +475,7 @@ func (rsm *RTSPStreamManager) Start() error {
 
 	if videoFormat == nil {
 		rsm.client.Close()
-		return fmt.Errorf("H.264 track not found in stream")
+		return &UnsupportedCodecError{URL: rsm.url, Codecs: offeredCodecs}
 	}
 
 	log.Printf("Setting up video track")
@@ -188,6 +494,25 @@ func (rsm *RTSPStreamManager) Start() error {
 		rsm.distributeFrame(pkt)
 	})
 
+	// Audio is optional: a source without a usable track, or one refusing its setup,
+	// still streams video
+	audioMedia, audioFormat := findAudioTrack(desc.Medias)
+	if audioFormat != nil {
+		if _, err := rsm.client.Setup(desc.BaseURL, audioMedia, 0, 0); err != nil {
+			log.Printf("Audio track setup failed for %s, streaming video only: %v", rsm.url, err)
+			audioFormat = nil
+		} else {
+			log.Printf("Audio track setup successful (%s)", audioFormat.Codec())
+			rsm.client.OnPacketRTP(audioMedia, audioFormat, func(pkt *rtp.Packet) {
+				rsm.distributeAudio(pkt, audioFormat.ClockRate())
+			})
+		}
+	}
+	rsm.mu.Lock()
+	rsm.audioFormat = audioFormat
+	rsm.audioClock = rtpTimeline{}
+	rsm.mu.Unlock()
+
 	log.Printf("Starting playback")
 
 	// Start playing
@@ -208,8 +533,10 @@ func (rsm *RTSPStreamManager) Start() error {
 
 // distributeFrame sends frames to all subscribers
 func (rsm *RTSPStreamManager) distributeFrame(pkt *rtp.Packet) {
+	arrival := time.Now()
 	// Improved H.264 NAL unit type detection
 	isKeyFrame := false
+	startsIDR := false // First packet of an IDR picture
 	if len(pkt.Payload) > 0 {
 		nalType := pkt.Payload[0] & 0x1F
 
@@ -219,16 +546,9 @@ func (rsm *RTSPStreamManager) distributeFrame(pkt *rtp.Packet) {
 			isKeyFrame = false
 		case 5: // IDR coded slice (keyframe)
 			isKeyFrame = true
-		case 7: // SPS (Sequence Parameter Set)
-			isKeyFrame = true
-			// Store SPS data for new subscribers
-			rsm.spsData = make([]byte, len(pkt.Payload))
-			copy(rsm.spsData, pkt.Payload)
-		case 8: // PPS (Picture Parameter Set)
+			startsIDR = true
+		case 7, 8: // SPS/PPS (parameter sets), cached below
 			isKeyFrame = true
-			// Store PPS data for new subscribers
-			rsm.ppsData = make([]byte, len(pkt.Payload))
-			copy(rsm.ppsData, pkt.Payload)
 		case 24: // STAP-A (Single Time Aggregation Packet)
 			// Check first NAL unit in aggregation
 			if len(pkt.Payload) > 3 {
@@ -243,6 +563,7 @@ func (rsm *RTSPStreamManager) distributeFrame(pkt *rtp.Packet) {
 				if isStart {
 					fragmentedNalType := fuHeader & 0x1F
 					isKeyFrame = fragmentedNalType == 5 || fragmentedNalType == 7 || fragmentedNalType == 8
+					startsIDR = fragmentedNalType == 5
 				}
 			}
 		default:
@@ -250,7 +571,11 @@ func (rsm *RTSPStreamManager) distributeFrame(pkt *rtp.Packet) {
 		}
 	}
 
-	// Log keyframes and occasionally log regular frames
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+
+	// Log keyframes and occasionally log regular frames; frameCount is shared by
+	// concurrent callers, so it's counted under the lock
 	if isKeyFrame {
 		log.Printf("KEYFRAME %d: Size=%d bytes, NAL=%d, Marker=%v, Timestamp=%d",
 			rsm.frameCount, len(pkt.Payload), pkt.Payload[0]&0x1F, pkt.Marker, pkt.Timestamp)
@@ -260,88 +585,210 @@ func (rsm *RTSPStreamManager) distributeFrame(pkt *rtp.Packet) {
 	}
 	rsm.frameCount++
 
-	frame := &Frame{
-		Data:       make([]byte, len(pkt.Payload)), // Copy payload to avoid races
-		Timestamp:  time.Now(),
-		Duration:   33 * time.Millisecond, // Assume 30 FPS
-		IsKeyFrame: isKeyFrame,
+	// Keep the cached parameter sets current, so late joiners get the ones in use
+	sps, pps, aggregatedIDR := inspectNALUnits(pkt.Payload)
+	startsIDR = startsIDR || aggregatedIDR
+	if rsm.updateParameterSets(sps, pps) && (rsm.params.sps > 1 || rsm.params.pps > 1) {
+		log.Printf("H.264 parameter sets changed on %s (SPS v%d, PPS v%d)", rsm.url, rsm.params.sps, rsm.params.pps)
 	}
-	copy(frame.Data, pkt.Payload)
 
-	rsm.mu.RLock()
-	subscriberCount := len(rsm.frameChannels)
-	rsm.mu.RUnlock()
+	// Tracked without subscribers too, so the unwrapping never misses a wrap
+	position := rsm.timeline.position(pkt.Timestamp, arrival, h264ClockRate)
 
-	if subscriberCount == 0 {
-		return // No subscribers, skip processing
+	if len(rsm.subscribers) == 0 || rsm.draining {
+		return // No subscribers, or Stop is draining them; skip processing
 	}
 
-	rsm.mu.RLock()
-	defer rsm.mu.RUnlock()
+	config := frameDistribution
+	frame := &Frame{
+		Data:            pkt.Payload,
+		Timestamp:       time.Now(),
+		Duration:        33 * time.Millisecond, // Assume 30 FPS
+		IsKeyFrame:      isKeyFrame,
+		ArrivalTime:     arrival,
+		RTPTimestamp:    pkt.Timestamp,
+		RTPPosition:     position,
+		RTPStart:        rsm.timeline.firstArrival,
+		HasRTPTimestamp: true,
+	}
+	if config.CopyPayload {
+		frame.Data = append([]byte(nil), pkt.Payload...)
+	}
+
+	// Every subscriber gets the same read-only frame; a full queue drops instead of blocking
+	for subscriberID, subscriber := range rsm.subscribers {
+		// A subscriber that missed a parameter set change gets the current ones ahead of
+		// the next IDR, which can't be decoded without them
+		if startsIDR && subscriber.params != rsm.params {
+			rsm.resendParameterSets(subscriber, config.DropUntilKeyframe)
+		}
+
+		queued := subscriber.offer(frame, config.DropUntilKeyframe)
+		if queued && len(sps) > 0 {
+			subscriber.params.sps = rsm.params.sps
+		}
+		if queued && len(pps) > 0 {
+			subscriber.params.pps = rsm.params.pps
+		}
+		if !queued && (subscriber.dropped == 1 || subscriber.dropped%100 == 0) {
+			log.Printf("Dropped frame for subscriber %s (queue full, %d dropped so far)", subscriberID, subscriber.dropped)
+		}
+	}
+}
 
-	// Distribute to all subscribers concurrently
-	for subscriberID, frameChan := range rsm.frameChannels {
-		go func(id string, ch chan *Frame, f *Frame) {
-			select {
-			case ch <- f:
-				// Frame sent successfully
-			case <-time.After(5 * time.Millisecond):
-				// Drop frame if channel is full to prevent blocking
-				log.Printf("Dropped frame for subscriber %s (channel full)", id)
+// findAudioTrack returns the first AAC or G.711 track, or an Opus one, which is what the
+// re-encoded MediaMTX output carries with audio.codec opus
+func findAudioTrack(medias []*description.Media) (*description.Media, format.Format) {
+	for _, media := range medias {
+		for _, formatCandidate := range media.Formats {
+			switch formatCandidate.(type) {
+			case *format.MPEG4Audio, *format.G711, *format.Opus:
+				return media, formatCandidate
 			}
-		}(subscriberID, frameChan, frame)
+		}
 	}
+	return nil, nil
 }
 
-// monitor keeps the connection alive and handles errors
-func (rsm *RTSPStreamManager) monitor() {
-	log.Printf("Starting RTSP monitor for %s", rsm.url)
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// distributeAudio hands an audio packet to the subscribers that opted in to audio. Each
+// packet decodes on its own, so a full queue simply drops it.
+func (rsm *RTSPStreamManager) distributeAudio(pkt *rtp.Packet, clockRate int) {
+	arrival := time.Now()
 
-	for {
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+
+	position := rsm.audioClock.position(pkt.Timestamp, arrival, clockRate)
+	if len(rsm.subscribers) == 0 || rsm.draining {
+		return
+	}
+
+	frame := &Frame{
+		Kind:            FrameKindAudio,
+		Data:            pkt.Payload,
+		Timestamp:       time.Now(),
+		IsKeyFrame:      true,
+		ArrivalTime:     arrival,
+		RTPTimestamp:    pkt.Timestamp,
+		RTPPosition:     position,
+		RTPStart:        rsm.audioClock.firstArrival,
+		HasRTPTimestamp: true,
+	}
+	if frameDistribution.CopyPayload {
+		frame.Data = append([]byte(nil), pkt.Payload...)
+	}
+
+	for subscriberID, subscriber := range rsm.subscribers {
+		if subscriber.audio == nil {
+			continue
+		}
 		select {
-		case <-rsm.ctx.Done():
-			log.Printf("RTSP monitor stopped for %s", rsm.url)
-			return
-		case <-ticker.C:
-			// Check if client is still running
-			if rsm.client != nil {
-				// Wait for client errors
-				go func() {
-					err := rsm.client.Wait()
-					if err != nil {
-						log.Printf("RTSP client error for %s: %v", rsm.url, err)
-						// Mark as not running so it can be restarted
-						rsm.isRunning = false
-					}
-				}()
+		case subscriber.audio <- frame:
+		default:
+			subscriber.audioDropped++
+			if subscriber.audioDropped == 1 || subscriber.audioDropped%100 == 0 {
+				log.Printf("Dropped audio frame for subscriber %s (queue full, %d dropped so far)", subscriberID, subscriber.audioDropped)
+			}
+		}
+	}
+}
+
+// updateParameterSets caches sps and pps when set and different from the cached ones,
+// reporting whether either changed. Caller holds the manager's lock.
+func (rsm *RTSPStreamManager) updateParameterSets(sps, pps []byte) bool {
+	changed := false
+	if len(sps) > 0 && !bytes.Equal(sps, rsm.spsData) {
+		rsm.spsData = append([]byte(nil), sps...)
+		rsm.params.sps++
+		changed = true
+	}
+	if len(pps) > 0 && !bytes.Equal(pps, rsm.ppsData) {
+		rsm.ppsData = append([]byte(nil), pps...)
+		rsm.params.pps++
+		changed = true
+	}
+	return changed
+}
+
+// resendParameterSets queues the cached SPS and PPS for a subscriber. The cached slices
+// are never modified, so the frames can share them. Caller holds the manager's lock.
+func (rsm *RTSPStreamManager) resendParameterSets(subscriber *frameSubscriber, dropUntilKeyframe bool) {
+	resend := func(params []byte) bool {
+		now := time.Now()
+		frame := &Frame{Data: params, Timestamp: now, IsKeyFrame: true, ArrivalTime: now}
+		return len(params) == 0 || subscriber.offer(frame, dropUntilKeyframe)
+	}
+	if resend(rsm.spsData) {
+		subscriber.params.sps = rsm.params.sps
+	}
+	if resend(rsm.ppsData) {
+		subscriber.params.pps = rsm.params.pps
+	}
+}
+
+// inspectNALUnits returns the SPS and PPS in an RTP payload carrying a single NAL unit
+// or a STAP-A aggregate, and whether it holds an IDR slice
+func inspectNALUnits(payload []byte) (sps, pps []byte, idr bool) {
+	if len(payload) == 0 {
+		return nil, nil, false
+	}
+
+	units := [][]byte{payload}
+	if payload[0]&0x1F == 24 { // STAP-A: a header byte, then 16-bit size-prefixed NAL units
+		units = units[:0]
+		for rest := payload[1:]; len(rest) >= 2; {
+			size := int(binary.BigEndian.Uint16(rest))
+			if size == 0 || len(rest) < 2+size {
+				break
 			}
+			units = append(units, rest[2:2+size])
+			rest = rest[2+size:]
+		}
+	}
+
+	for _, unit := range units {
+		switch unit[0] & 0x1F {
+		case 5:
+			idr = true
+		case 7:
+			sps = unit
+		case 8:
+			pps = unit
 		}
 	}
+	return sps, pps, idr
+}
+
+// monitor waits for the connection to end and reconnects under the manager's retry
+// policy unless the manager was stopped
+func (rsm *RTSPStreamManager) monitor() {
+	log.Printf("Starting RTSP monitor for %s", rsm.url)
+	err := rsm.client.Wait()
+	if rsm.ctx.Err() != nil {
+		log.Printf("RTSP monitor stopped for %s", rsm.url)
+		return
+	}
+
+	log.Printf("RTSP client error for %s: %v, reconnecting", rsm.url, err)
+	rsm.client.Close()
+	rsm.isRunning = false // Mark as not running so it can be restarted
+	startStreamManagerWithRetry(rsm.url, rsm)
 }
 
-// Stop stops the RTSP stream processing
+// Stop stops the RTSP stream processing, including any pending reconnection. Subscribers
+// get a short drain to write out their queued frames before their channels close.
 func (rsm *RTSPStreamManager) Stop() error {
+	rsm.cancel()
+	rsm.drainSubscribers(subscriberDrainTimeout)
+	rsm.closeSubscribers(ErrStreamManagerStopped)
 	if !rsm.isRunning {
 		return nil
 	}
 
-	rsm.cancel()
-
 	if rsm.client != nil {
 		rsm.client.Close()
 	}
 
-	// Close all frame channels
-	rsm.mu.Lock()
-	for subscriberID, frameChan := range rsm.frameChannels {
-		close(frameChan)
-		delete(rsm.frameChannels, subscriberID)
-		log.Printf("Closed frame channel for subscriber %s", subscriberID)
-	}
-	rsm.mu.Unlock()
-
 	rsm.isRunning = false
 	log.Printf("RTSP stream stopped: %s", rsm.url)
 	return nil
@@ -351,28 +798,273 @@ func (rsm *RTSPStreamManager) Stop() error {
 func (rsm *RTSPStreamManager) GetSubscriberCount() int {
 	rsm.mu.RLock()
 	defer rsm.mu.RUnlock()
-	return len(rsm.frameChannels)
+	return len(rsm.subscribers)
 }
 
 // WebRTCStreamer handles streaming frames to WebRTC peers
 type WebRTCStreamer struct {
-	track       *webrtc.TrackLocalStaticRTP
-	framesChan  <-chan *Frame
-	ctx         context.Context
-	cancel      context.CancelFunc
-	isStreaming bool
-	mu          sync.Mutex
+	track        *webrtc.TrackLocalStaticRTP
+	framesChan   <-chan *Frame
+	payloadType  uint8
+	ssrc         uint32
+	ssrcReleased bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	isStreaming  bool
+	stopped      chan struct{} // Closed when the streaming loop exits
+	stats        StreamerStats
+	mu           sync.Mutex
+
+	timestampSource string // Clock the outgoing RTP timestamps follow
+
+	// The optional audio track, set by AttachAudio
+	audioTrack       *webrtc.TrackLocalStaticRTP
+	audioChan        <-chan *Frame
+	audioPayloadType uint8
+	audioSSRC        uint32
+	audioClockRate   int
+}
+
+// StreamerStats counts a streamer's RTP writes so flaky peers show up in /metrics
+type StreamerStats struct {
+	SSRC              uint32     `json:"ssrc"`
+	PacketsWritten    uint64     `json:"packetsWritten"`
+	WriteErrors       uint64     `json:"writeErrors"`       // Transient errors; the packet was dropped
+	ConsecutiveErrors int        `json:"consecutiveErrors"` // Resets on the next successful write
+	LastError         string     `json:"lastError,omitempty"`
+	LastErrorAt       *time.Time `json:"lastErrorAt,omitempty"`
+	// Time from distributeFrame queueing a frame to its WriteRTP: a moving average and
+	// the worst seen, the worker's share of latency on the direct RTSP path
+	HandoffLatencyMs    float64 `json:"handoffLatencyMs"`
+	MaxHandoffLatencyMs float64 `json:"maxHandoffLatencyMs"`
+	// Audio track writes, for streamers with one; errors there never end the stream
+	AudioSSRC           uint32 `json:"audioSsrc,omitempty"`
+	AudioPacketsWritten uint64 `json:"audioPacketsWritten,omitempty"`
+	AudioWriteErrors    uint64 `json:"audioWriteErrors,omitempty"`
+}
+
+// handoffLatencyWeight is the weight of the newest sample in the handoff latency average
+const handoffLatencyWeight = 0.1
+
+// maxConsecutiveWriteErrors ends a stream whose writes keep failing even though the
+// peer hasn't reported the connection closed
+const maxConsecutiveWriteErrors = 100
+
+// isFatalWriteError reports whether a WriteRTP error means the peer is gone for good.
+// The track joins the errors of its bindings in an error that supports errors.Is, so
+// one closed binding is enough.
+func isFatalWriteError(err error) bool {
+	return errors.Is(err, webrtc.ErrConnectionClosed) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed)
+}
+
+// Streamers currently running, for per-streamer metrics
+var (
+	activeStreamers      = make(map[*WebRTCStreamer]struct{})
+	activeStreamersMutex sync.Mutex
+)
+
+// webRTCStreamerStats returns a stats snapshot of every running streamer
+func webRTCStreamerStats() []StreamerStats {
+	activeStreamersMutex.Lock()
+	streamers := make([]*WebRTCStreamer, 0, len(activeStreamers))
+	for streamer := range activeStreamers {
+		streamers = append(streamers, streamer)
+	}
+	activeStreamersMutex.Unlock()
+
+	stats := make([]StreamerStats, 0, len(streamers))
+	for _, streamer := range streamers {
+		stats = append(stats, streamer.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].SSRC < stats[j].SSRC })
+	return stats
+}
+
+// defaultH264PayloadType is the dynamic payload type used when none was negotiated
+const defaultH264PayloadType = 96
+
+// defaultOpusPayloadType is the dynamic payload type Opus audio gets when none was negotiated
+const defaultOpusPayloadType = 111
+
+// webrtcAudioCodec returns the WebRTC codec an audio track of the source is forwarded
+// as. Browsers decode Opus and G.711 but not AAC, so AAC audio isn't forwarded;
+// re-encoding it to Opus is what audio.codec opus on the camera is for.
+func webrtcAudioCodec(audioFormat format.Format) (webrtc.RTPCodecCapability, bool) {
+	switch f := audioFormat.(type) {
+	case *format.Opus:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}, true
+	case *format.G711:
+		if f.SampleRate != 8000 || f.ChannelCount != 1 {
+			return webrtc.RTPCodecCapability{}, false // Only narrowband mono has a WebRTC payload type
+		}
+		if f.MULaw {
+			return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, true
+		}
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, true
+	}
+	return webrtc.RTPCodecCapability{}, false
+}
+
+// SSRCs currently held by streamers, so tracks sharing a PeerConnection never collide
+var (
+	allocatedSSRCs = make(map[uint32]bool)
+	ssrcMutex      sync.Mutex
+)
+
+// allocateSSRC reserves a random SSRC not used by any other streamer
+func allocateSSRC() uint32 {
+	ssrcMutex.Lock()
+	defer ssrcMutex.Unlock()
+
+	for {
+		var b [4]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		ssrc := binary.BigEndian.Uint32(b[:])
+		if ssrc != 0 && !allocatedSSRCs[ssrc] {
+			allocatedSSRCs[ssrc] = true
+			return ssrc
+		}
+	}
+}
+
+// reserveSSRC claims a specific SSRC, returning false if another streamer holds it
+func reserveSSRC(ssrc uint32) bool {
+	ssrcMutex.Lock()
+	defer ssrcMutex.Unlock()
+
+	if allocatedSSRCs[ssrc] {
+		return false
+	}
+	allocatedSSRCs[ssrc] = true
+	return true
 }
 
-// NewWebRTCStreamer creates a new WebRTC streamer
-func NewWebRTCStreamer(track *webrtc.TrackLocalStaticRTP, framesChan <-chan *Frame) *WebRTCStreamer {
+// releaseSSRC returns an SSRC to the pool
+func releaseSSRC(ssrc uint32) {
+	ssrcMutex.Lock()
+	defer ssrcMutex.Unlock()
+	delete(allocatedSSRCs, ssrc)
+}
+
+// NewWebRTCStreamer creates a new WebRTC streamer. payloadType should be the
+// negotiated payload type for the track's codec (0 uses the H.264 default of 96).
+// ssrc of 0, or one already in use by another streamer, gets a fresh random SSRC.
+func NewWebRTCStreamer(track *webrtc.TrackLocalStaticRTP, framesChan <-chan *Frame, payloadType uint8, ssrc uint32) *WebRTCStreamer {
+	if payloadType == 0 {
+		payloadType = defaultH264PayloadType
+	}
+	if ssrc == 0 || !reserveSSRC(ssrc) {
+		ssrc = allocateSSRC()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &WebRTCStreamer{
-		track:      track,
-		framesChan: framesChan,
-		ctx:        ctx,
-		cancel:     cancel,
+		track:       track,
+		framesChan:  framesChan,
+		payloadType: payloadType,
+		ssrc:        ssrc,
+		ctx:         ctx,
+		cancel:      cancel,
+		stopped:     make(chan struct{}),
+		stats:       StreamerStats{SSRC: ssrc},
+
+		timestampSource: frameDistribution.TimestampSource,
+	}
+}
+
+// NewWebRTCStreamerForSender creates a streamer using the payload type and SSRC
+// negotiated for the given sender
+func NewWebRTCStreamerForSender(sender *webrtc.RTPSender, track *webrtc.TrackLocalStaticRTP, framesChan <-chan *Frame) *WebRTCStreamer {
+	payloadType, ssrc := senderParameters(sender, track)
+	return NewWebRTCStreamer(track, framesChan, payloadType, ssrc)
+}
+
+// senderParameters returns the payload type and SSRC negotiated for the sender's track
+func senderParameters(sender *webrtc.RTPSender, track *webrtc.TrackLocalStaticRTP) (payloadType uint8, ssrc uint32) {
+	params := sender.GetParameters()
+	if len(params.Encodings) > 0 {
+		payloadType = uint8(params.Encodings[0].PayloadType)
+		ssrc = uint32(params.Encodings[0].SSRC)
+	}
+	if payloadType == 0 {
+		// Pick the first negotiated codec matching the track's MIME type
+		for _, codec := range params.Codecs {
+			if strings.EqualFold(codec.MimeType, track.Codec().MimeType) {
+				payloadType = uint8(codec.PayloadType)
+				break
+			}
+		}
+	}
+	return payloadType, ssrc
+}
+
+// AttachAudio gives the streamer an audio track, fed by the audio channel of
+// RTSPStreamManager.SubscribeWithAudio. Call it before Start.
+func (ws *WebRTCStreamer) AttachAudio(sender *webrtc.RTPSender, track *webrtc.TrackLocalStaticRTP, audioChan <-chan *Frame) {
+	// Without a negotiated payload type, Opus gets the usual dynamic one and G.711 its
+	// static one (PCMU is 0 already)
+	payloadType, ssrc := senderParameters(sender, track)
+	if payloadType == 0 && strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus) {
+		payloadType = defaultOpusPayloadType
+	}
+	if payloadType == 0 && strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypePCMA) {
+		payloadType = 8
+	}
+	if ssrc == 0 || !reserveSSRC(ssrc) {
+		ssrc = allocateSSRC()
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.audioTrack, ws.audioChan = track, audioChan
+	ws.audioPayloadType, ws.audioSSRC = payloadType, ssrc
+	ws.audioClockRate = int(track.Codec().ClockRate)
+	ws.stats.AudioSSRC = ssrc
+}
+
+// PayloadType returns the RTP payload type written by this streamer
+func (ws *WebRTCStreamer) PayloadType() uint8 {
+	return ws.payloadType
+}
+
+// SSRC returns the RTP SSRC written by this streamer
+func (ws *WebRTCStreamer) SSRC() uint32 {
+	return ws.ssrc
+}
+
+// Stats returns a snapshot of the streamer's write counters
+func (ws *WebRTCStreamer) Stats() StreamerStats {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.stats
+}
+
+// recordWrite updates the write counters and returns the consecutive error count.
+// latency is how long the frame waited between distribution and the write.
+func (ws *WebRTCStreamer) recordWrite(err error, latency time.Duration) int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err == nil {
+		ms := float64(latency) / float64(time.Millisecond)
+		if ws.stats.PacketsWritten == 0 {
+			ws.stats.HandoffLatencyMs = ms
+		} else {
+			ws.stats.HandoffLatencyMs += handoffLatencyWeight * (ms - ws.stats.HandoffLatencyMs)
+		}
+		ws.stats.MaxHandoffLatencyMs = max(ws.stats.MaxHandoffLatencyMs, ms)
+		ws.stats.PacketsWritten++
+		ws.stats.ConsecutiveErrors = 0
+		return 0
 	}
+	ws.stats.WriteErrors++
+	ws.stats.ConsecutiveErrors++
+	ws.stats.LastError = err.Error()
+	now := time.Now()
+	ws.stats.LastErrorAt = &now
+	return ws.stats.ConsecutiveErrors
 }
 
 // Start begins streaming frames to WebRTC
@@ -388,57 +1080,155 @@ func (ws *WebRTCStreamer) Start() {
 	go ws.streamLoop()
 }
 
+// Done is closed once the streamer has stopped, whether by Stop or because its frame
+// channel was closed
+func (ws *WebRTCStreamer) Done() <-chan struct{} {
+	return ws.stopped
+}
+
 // streamLoop processes frames and sends them via WebRTC
 func (ws *WebRTCStreamer) streamLoop() {
 	log.Printf("Starting WebRTC streaming loop")
+	defer close(ws.stopped)
+
+	activeStreamersMutex.Lock()
+	activeStreamers[ws] = struct{}{}
+	activeStreamersMutex.Unlock()
+	defer func() {
+		activeStreamersMutex.Lock()
+		delete(activeStreamers, ws)
+		activeStreamersMutex.Unlock()
+	}()
 
-	var sequenceNumber uint16
-	var rtpTimestamp uint32
+	var sequenceNumber, audioSequenceNumber uint16
 	startTime := time.Now()
+	audioChan := ws.audioChan // nil without an audio track, which never receives
 
 	for {
+		var frame *Frame
 		select {
 		case <-ws.ctx.Done():
 			log.Printf("WebRTC streaming stopped")
 			return
-		case frame, ok := <-ws.framesChan:
+		case video, ok := <-ws.framesChan:
 			if !ok {
 				log.Printf("Frame channel closed, stopping WebRTC stream")
 				return
 			}
-
-			// Calculate RTP timestamp (90kHz clock for H.264)
-			elapsed := time.Since(startTime)
-			rtpTimestamp = uint32(elapsed.Nanoseconds() / 1000 * 90 / 1000000) // Convert to 90kHz
-
-			// Create RTP packet from frame data (already in RTP format from RTSP)
-			packet := &rtp.Packet{
-				Header: rtp.Header{
-					Version:        2,
-					Padding:        false,
-					Extension:      false,
-					Marker:         frame.IsKeyFrame || (len(frame.Data) > 0 && (frame.Data[0]&0x80) != 0), // Use original marker or keyframe
-					PayloadType:    96,            // H.264
-					SequenceNumber: sequenceNumber,
-					Timestamp:      rtpTimestamp,
-					SSRC:           uint32(12345), // Static SSRC
-				},
-				Payload: frame.Data,
+			frame = video
+		case audio, ok := <-audioChan:
+			if !ok {
+				log.Printf("Audio channel closed (SSRC %d), continuing with video only", ws.audioSSRC)
+				audioChan = nil
+				continue
 			}
+			frame = audio
+		}
 
-			sequenceNumber++
-
-			// Send packet via WebRTC track
-			if err := ws.track.WriteRTP(packet); err != nil {
-				if err.Error() != "connection closed" {
-					log.Printf("Failed to write RTP packet: %v", err)
-				}
-				return
-			}
+		// Each frame goes to the track of its kind
+		var keepStreaming bool
+		switch frame.Kind {
+		case FrameKindAudio:
+			keepStreaming = ws.writeAudio(frame, &audioSequenceNumber, startTime)
+		default:
+			keepStreaming = ws.writeVideo(frame, &sequenceNumber, startTime)
+		}
+		if !keepStreaming {
+			return
 		}
 	}
 }
 
+// rtpElapsed is the frame's time since the stream started, from the write time or,
+// with the rtp source, the frame's own RTP timing. Frames queued before the stream
+// started, or reordered behind the source's first packet, count from 0 rather than
+// going negative and wrapping the 32-bit RTP timestamp.
+func (ws *WebRTCStreamer) rtpElapsed(frame *Frame, startTime time.Time) time.Duration {
+	if ws.timestampSource == frameTimestampRTP {
+		return max(frame.Time(frameTimestampRTP).Sub(startTime), 0)
+	}
+	return time.Since(startTime)
+}
+
+// writeVideo writes a video frame to the track, reporting whether the stream goes on
+func (ws *WebRTCStreamer) writeVideo(frame *Frame, sequenceNumber *uint16, startTime time.Time) bool {
+	// Calculate RTP timestamp (90kHz clock for H.264)
+	elapsed := ws.rtpElapsed(frame, startTime)
+	rtpTimestamp := uint32(elapsed.Nanoseconds() / 1000 * 90 / 1000000) // Convert to 90kHz
+
+	// Create RTP packet from frame data (already in RTP format from RTSP)
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Padding:        false,
+			Extension:      false,
+			Marker:         frame.IsKeyFrame || (len(frame.Data) > 0 && (frame.Data[0]&0x80) != 0), // Use original marker or keyframe
+			PayloadType:    ws.payloadType,
+			SequenceNumber: *sequenceNumber,
+			Timestamp:      rtpTimestamp,
+			SSRC:           ws.ssrc,
+		},
+		Payload: frame.Data,
+	}
+
+	*sequenceNumber++
+
+	// Send packet via WebRTC track. Only a closed peer or a sustained run of
+	// failures ends the stream; a transient failure just drops the packet.
+	err := ws.track.WriteRTP(packet)
+	if err != nil && isFatalWriteError(err) {
+		log.Printf("WebRTC peer closed (SSRC %d), stopping stream", ws.ssrc)
+		return false
+	}
+	consecutive := ws.recordWrite(err, time.Since(frame.Timestamp))
+	if err == nil {
+		return true
+	}
+	if consecutive >= maxConsecutiveWriteErrors {
+		log.Printf("Stopping WebRTC stream (SSRC %d) after %d consecutive write errors: %v", ws.ssrc, consecutive, err)
+		return false
+	}
+	if consecutive == 1 || consecutive%10 == 0 {
+		log.Printf("Transient RTP write error (SSRC %d, %d in a row), dropping packet: %v", ws.ssrc, consecutive, err)
+	}
+	return true
+}
+
+// writeAudio writes an audio frame to the audio track, on the track's own clock. A
+// streamer without an audio track drops the frame; only a closed peer ends the stream,
+// since the video writes already catch a peer that stopped taking packets.
+func (ws *WebRTCStreamer) writeAudio(frame *Frame, sequenceNumber *uint16, startTime time.Time) bool {
+	if ws.audioTrack == nil {
+		return true
+	}
+	elapsed := ws.rtpElapsed(frame, startTime)
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    ws.audioPayloadType,
+			SequenceNumber: *sequenceNumber,
+			Timestamp:      uint32(elapsed.Microseconds() * int64(ws.audioClockRate) / 1000000),
+			SSRC:           ws.audioSSRC,
+		},
+		Payload: frame.Data,
+	}
+	*sequenceNumber++
+
+	err := ws.audioTrack.WriteRTP(packet)
+	if err != nil && isFatalWriteError(err) {
+		log.Printf("WebRTC peer closed (audio SSRC %d), stopping stream", ws.audioSSRC)
+		return false
+	}
+	ws.mu.Lock()
+	if err == nil {
+		ws.stats.AudioPacketsWritten++
+	} else {
+		ws.stats.AudioWriteErrors++
+	}
+	ws.mu.Unlock()
+	return true
+}
+
 // Stop stops the WebRTC streaming
 func (ws *WebRTCStreamer) Stop() {
 	ws.mu.Lock()
@@ -448,6 +1238,13 @@ func (ws *WebRTCStreamer) Stop() {
 		ws.cancel()
 		ws.isStreaming = false
 	}
+	if !ws.ssrcReleased {
+		releaseSSRC(ws.ssrc)
+		if ws.audioSSRC != 0 {
+			releaseSSRC(ws.audioSSRC)
+		}
+		ws.ssrcReleased = true
+	}
 }
 
 // Global stream managers pool
@@ -456,47 +1253,99 @@ var (
 	streamMutex    sync.RWMutex
 )
 
-// GetOrCreateStreamManager gets or creates an RTSP stream manager for a URL
-func GetOrCreateStreamManager(url string) *RTSPStreamManager {
+// streamManagerStartTimeout bounds how long GetOrCreateStreamManager waits for the first connection
+const streamManagerStartTimeout = 15 * time.Second
+
+// GetOrCreateStreamManager gets or creates an RTSP stream manager for a URL and
+// waits for the first connection attempt. If the source has no usable codec the
+// manager is discarded and an *UnsupportedCodecError is returned with a nil
+// manager; other first-attempt errors are returned alongside the manager, which
+// keeps retrying in the background.
+func GetOrCreateStreamManager(url string) (*RTSPStreamManager, error) {
+	return GetOrCreateStreamManagerWithPolicy(url, streamManagerRetry)
+}
+
+// GetOrCreateStreamManagerWithPolicy is GetOrCreateStreamManager with a per-camera
+// retry policy, e.g. unlimited attempts for a camera that sleeps. The policy only
+// applies when the manager is created.
+func GetOrCreateStreamManagerWithPolicy(url string, retry RTSPRetryPolicy) (*RTSPStreamManager, error) {
 	streamMutex.Lock()
-	defer streamMutex.Unlock()
+	manager, exists := streamManagers[url]
+	if !exists {
+		manager = NewRTSPStreamManager(url, retry)
+		streamManagers[url] = manager
+		go startStreamManagerWithRetry(url, manager)
+	}
+	streamMutex.Unlock()
 
-	if manager, exists := streamManagers[url]; exists {
-		return manager
+	select {
+	case <-manager.Ready():
+	case <-time.After(streamManagerStartTimeout):
+		return manager, fmt.Errorf("timed out after %v waiting for RTSP stream %s", streamManagerStartTimeout, url)
+	}
+
+	err := manager.Err()
+	var codecErr *UnsupportedCodecError
+	if errors.As(err, &codecErr) {
+		return nil, err
 	}
+	return manager, err
+}
 
-	manager := NewRTSPStreamManager(url)
-	streamManagers[url] = manager
+// startStreamManagerWithRetry starts the stream under the manager's retry policy. Once
+// the attempts run out the manager is removed and its subscribers' channels are closed
+// with a *StreamRetriesExhaustedError.
+func startStreamManagerWithRetry(url string, manager *RTSPStreamManager) {
+	policy := manager.retry
+	retryDelay := policy.InitialDelay
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		if policy.MaxAttempts > 0 {
+			log.Printf("Starting RTSP stream %s (attempt %d/%d)", url, attempt, policy.MaxAttempts)
+		} else {
+			log.Printf("Starting RTSP stream %s (attempt %d, retrying indefinitely)", url, attempt)
+		}
 
-	// Start the stream with retry logic
-	go func() {
-		maxRetries := 3
-		retryDelay := 5 * time.Second
+		err = manager.Start()
+		manager.recordStartResult(err)
+		if err == nil {
+			log.Printf("Successfully started RTSP stream %s on attempt %d", url, attempt)
+			return
+		}
 
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			log.Printf("Starting RTSP stream %s (attempt %d/%d)", url, attempt, maxRetries)
+		log.Printf("Failed to start RTSP stream %s on attempt %d: %v", url, attempt, err)
 
-			if err := manager.Start(); err != nil {
-				log.Printf("Failed to start RTSP stream %s on attempt %d: %v", url, attempt, err)
+		// Retrying won't make the camera change codec
+		var codecErr *UnsupportedCodecError
+		if errors.As(err, &codecErr) {
+			log.Printf("RTSP stream %s has no supported codec, removing manager", url)
+			break
+		}
 
-				if attempt < maxRetries {
-					log.Printf("Retrying in %v...", retryDelay)
-					time.Sleep(retryDelay)
-					retryDelay *= 2 // Exponential backoff
-				} else {
-					log.Printf("All attempts failed for RTSP stream %s, removing manager", url)
-					streamMutex.Lock()
-					delete(streamManagers, url)
-					streamMutex.Unlock()
-				}
-			} else {
-				log.Printf("Successfully started RTSP stream %s on attempt %d", url, attempt)
-				break
-			}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			log.Printf("All attempts failed for RTSP stream %s, removing manager", url)
+			break
 		}
-	}()
 
-	return manager
+		log.Printf("Retrying in %v...", retryDelay)
+		select {
+		case <-manager.ctx.Done():
+			log.Printf("RTSP stream %s stopped while waiting to reconnect", url)
+			return
+		case <-time.After(retryDelay):
+		}
+		retryDelay = min(retryDelay*2, policy.MaxDelay) // Exponential backoff, capped
+	}
+
+	manager.closeSubscribers(&StreamRetriesExhaustedError{URL: url, Attempts: attempt, Err: err})
+
+	streamMutex.Lock()
+	if streamManagers[url] == manager {
+		delete(streamManagers, url)
+	}
+	streamMutex.Unlock()
 }
 
 // CleanupStreamManager removes a stream manager if no subscribers
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// bitWriter writes the bit fields and Exp-Golomb codes of an H.264 RBSP
+type bitWriter struct {
+	buf   []byte
+	nbits int
+}
+
+func (w *bitWriter) bit(b uint) {
+	if w.nbits%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if b != 0 {
+		w.buf[len(w.buf)-1] |= 0x80 >> (w.nbits % 8)
+	}
+	w.nbits++
+}
+
+func (w *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit((v >> i) & 1)
+	}
+}
+
+func (w *bitWriter) ue(v uint) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+// testSPS builds a baseline-profile SPS NAL unit for a width x height picture
+func testSPS(t *testing.T, width, height int) []byte {
+	t.Helper()
+	w := &bitWriter{}
+	w.bits(0x67, 8) // NAL header: nal_ref_idc 3, type 7
+	w.bits(66, 8)   // profile_idc: baseline
+	w.bits(0, 8)    // constraint flags
+	w.bits(30, 8)   // level_idc 3.0
+	w.ue(0)         // seq_parameter_set_id
+	w.ue(0)         // log2_max_frame_num_minus4
+	w.ue(2)         // pic_order_cnt_type
+	w.ue(1)         // max_num_ref_frames
+	w.bit(0)        // gaps_in_frame_num_value_allowed_flag
+	w.ue(uint(width/16 - 1))
+	w.ue(uint(height/16 - 1))
+	w.bit(1) // frame_mbs_only_flag
+	w.bit(1) // direct_8x8_inference_flag
+	w.bit(0) // frame_cropping_flag
+	w.bit(0) // vui_parameters_present_flag
+	w.bit(1) // rbsp_stop_one_bit, then zero bits to the byte boundary
+	for w.nbits%8 != 0 {
+		w.bit(0)
+	}
+
+	var sps h264.SPS
+	if err := sps.Unmarshal(w.buf); err != nil {
+		t.Fatalf("test SPS doesn't parse: %v", err)
+	}
+	if sps.Width() != width || sps.Height() != height {
+		t.Fatalf("test SPS is %dx%d, want %dx%d", sps.Width(), sps.Height(), width, height)
+	}
+	return w.buf
+}
+
+// spsResolution parses an SPS NAL unit's picture size
+func spsResolution(t *testing.T, nalu []byte) (int, int) {
+	t.Helper()
+	var sps h264.SPS
+	if err := sps.Unmarshal(nalu); err != nil {
+		t.Fatalf("subscriber got an unparsable SPS: %v", err)
+	}
+	return sps.Width(), sps.Height()
+}
+
+// drainFrames returns everything queued on a subscriber's channel
+func drainFrames(frames <-chan *Frame) [][]byte {
+	var payloads [][]byte
+	for {
+		select {
+		case frame := <-frames:
+			payloads = append(payloads, frame.Data)
+		default:
+			return payloads
+		}
+	}
+}
+
+// nalTypes lists the NAL unit type of each payload
+func nalTypes(payloads [][]byte) []byte {
+	types := make([]byte, len(payloads))
+	for i, payload := range payloads {
+		types[i] = payload[0] & 0x1F
+	}
+	return types
+}
+
+func TestParameterSetsFollowMidStreamSPSChange(t *testing.T) {
+	sps480 := testSPS(t, 640, 480)
+	sps720 := testSPS(t, 1280, 720)
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+	slice := []byte{0x41, 0x9a, 0x02, 0x00}
+
+	manager := NewRTSPStreamManager("rtsp://camera.test/stream", RTSPRetryPolicy{})
+	send := func(payloads ...[]byte) {
+		for i, payload := range payloads {
+			manager.distributeFrame(&rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i), Timestamp: 3000}, Payload: payload})
+		}
+	}
+
+	early := manager.Subscribe("early")
+	send(sps480, pps, idr, slice)
+	if got := nalTypes(drainFrames(early)); !bytes.Equal(got, []byte{7, 8, 5, 1}) {
+		t.Fatalf("early subscriber got NAL types %v, want [7 8 5 1]", got)
+	}
+
+	// The camera switches to 720p in-band: the new SPS reaches the early subscriber once,
+	// with no stale 480p one resent ahead of the IDR
+	send(sps720, pps, idr, slice)
+	got := drainFrames(early)
+	if types := nalTypes(got); !bytes.Equal(types, []byte{7, 8, 5, 1}) {
+		t.Fatalf("early subscriber got NAL types %v after the change, want [7 8 5 1]", types)
+	}
+	if w, h := spsResolution(t, got[0]); w != 1280 || h != 720 {
+		t.Fatalf("early subscriber's SPS after the change is %dx%d, want 1280x720", w, h)
+	}
+
+	// A late joiner starts from the cached 720p SPS, not the 480p one
+	late := manager.Subscribe("late")
+	got = drainFrames(late)
+	if types := nalTypes(got); !bytes.Equal(types, []byte{7, 8}) {
+		t.Fatalf("late subscriber got NAL types %v, want the cached [7 8]", types)
+	}
+	if w, h := spsResolution(t, got[0]); w != 1280 || h != 720 {
+		t.Fatalf("late subscriber's cached SPS is %dx%d, want 1280x720", w, h)
+	}
+
+	// A reconnect brings a 480p SPS in the SDP only, as Start seeds it; both subscribers
+	// get it re-injected right before the next IDR, and only then
+	manager.mu.Lock()
+	manager.updateParameterSets(sps480, pps)
+	manager.mu.Unlock()
+	send(slice, idr)
+	for name, frames := range map[string]<-chan *Frame{"early": early, "late": late} {
+		got := drainFrames(frames)
+		if types := nalTypes(got); !bytes.Equal(types, []byte{1, 7, 8, 5}) {
+			t.Fatalf("%s subscriber got NAL types %v after the SDP change, want [1 7 8 5]", name, types)
+		}
+		if w, h := spsResolution(t, got[1]); w != 640 || h != 480 {
+			t.Fatalf("%s subscriber's re-injected SPS is %dx%d, want 640x480", name, w, h)
+		}
+	}
+
+	// Once delivered, the parameter sets aren't repeated before every IDR
+	send(idr)
+	if types := nalTypes(drainFrames(early)); !bytes.Equal(types, []byte{5}) {
+		t.Fatalf("early subscriber got NAL types %v for a plain IDR, want [5]", types)
+	}
+}
+
+func TestRTPElapsedNeverNegative(t *testing.T) {
+	manager := NewRTSPStreamManager("rtsp://camera.test/stream", RTSPRetryPolicy{})
+	frames := manager.Subscribe("viewer")
+	slice := []byte{0x41, 0x9a, 0x02, 0x00}
+	for _, ts := range []uint32{
+		90000,                 // First packet; anchors the timeline
+		90000 - 9000,          // Reordered 100ms behind it
+		90000 + h264ClockRate, // One second in
+	} {
+		manager.distributeFrame(&rtp.Packet{Header: rtp.Header{Timestamp: ts}, Payload: slice})
+	}
+	startTime := time.Now() // The streamer starts after the frames queued up
+
+	streamer := &WebRTCStreamer{timestampSource: frameTimestampRTP}
+	first, reordered, later := <-frames, <-frames, <-frames
+	for name, frame := range map[string]*Frame{"first": first, "reordered": reordered} {
+		elapsed := streamer.rtpElapsed(frame, startTime)
+		if elapsed != 0 {
+			t.Errorf("%s frame: rtpElapsed = %v, want 0", name, elapsed)
+		}
+		if ts := uint32(elapsed.Nanoseconds() / 1000 * 90 / 1000000); ts != 0 {
+			t.Errorf("%s frame: RTP timestamp %d, want 0 rather than a wrapped value", name, ts)
+		}
+	}
+	if elapsed := streamer.rtpElapsed(later, startTime); elapsed <= 900*time.Millisecond || elapsed > time.Second {
+		t.Errorf("frame one second in: rtpElapsed = %v, want just under 1s", elapsed)
+	}
+
+	wallclock := &WebRTCStreamer{timestampSource: frameTimestampWallClock}
+	if elapsed := wallclock.rtpElapsed(reordered, startTime); elapsed < 0 {
+		t.Errorf("wallclock rtpElapsed = %v, want >= 0", elapsed)
+	}
+}
+
+// Run with -race: distributeFrame and distributeAudio from several goroutines, with
+// subscribers joining and leaving, while Stop drains and closes the channels
+func TestDistributeFrameDuringStop(t *testing.T) {
+	const (
+		producers   = 4
+		subscribers = 6
+	)
+	manager := NewRTSPStreamManager("rtsp://camera.test/stream", RTSPRetryPolicy{})
+
+	var readers sync.WaitGroup
+	read := func(frames, audio <-chan *Frame) {
+		defer readers.Done()
+		for frames != nil || audio != nil {
+			select {
+			case _, ok := <-frames:
+				if !ok {
+					frames = nil
+				}
+			case _, ok := <-audio:
+				if !ok {
+					audio = nil
+				}
+			}
+		}
+	}
+	for i := 0; i < subscribers; i++ {
+		video, audio := manager.SubscribeWithAudio(fmt.Sprintf("viewer-%d", i))
+		readers.Add(1)
+		go read(video, audio)
+	}
+
+	done := make(chan struct{})
+	var producing sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		producing.Add(1)
+		go func(i int) {
+			defer producing.Done()
+			for n := uint32(0); ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				payload := []byte{0x41, 0x9a, byte(n), 0x00}
+				if n%30 == 0 {
+					payload[0] = 0x65 // An IDR now and then
+				}
+				manager.distributeFrame(&rtp.Packet{Header: rtp.Header{Timestamp: n * 3000}, Payload: payload})
+				manager.distributeAudio(&rtp.Packet{Header: rtp.Header{Timestamp: n * 960}, Payload: []byte{0xfc}}, 48000)
+			}
+		}(i)
+	}
+
+	// A subscriber churning in and out while frames flow and Stop runs
+	producing.Add(1)
+	go func() {
+		defer producing.Done()
+		for n := 0; ; n++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			id := fmt.Sprintf("churn-%d", n)
+			frames := manager.Subscribe(id)
+			readers.Add(1)
+			go read(frames, nil)
+			manager.Unsubscribe(id)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := manager.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond) // Producers keep distributing into the stopped manager
+	close(done)
+	producing.Wait()
+
+	finished := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber channels weren't all closed after Stop")
+	}
+
+	if !errors.Is(manager.CloseReason(), ErrStreamManagerStopped) {
+		t.Fatalf("CloseReason() = %v, want ErrStreamManagerStopped", manager.CloseReason())
+	}
+	if count := manager.GetSubscriberCount(); count != 0 {
+		t.Fatalf("%d subscribers left after Stop", count)
+	}
+	if _, ok := <-manager.Subscribe("after-stop"); ok {
+		t.Fatal("a subscriber joining after Stop got an open channel")
+	}
+}
+
+// negotiateH264 answers a viewer offering H.264 only under payloadType, the way
+// startWHEPSession does, with one track per sender on the same connection
+func negotiateH264(t *testing.T, payloadType webrtc.PayloadType, tracks int) ([]*webrtc.RTPSender, []*webrtc.TrackLocalStaticRTP, string) {
+	t.Helper()
+	engine := &webrtc.MediaEngine{}
+	if err := engine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		},
+		PayloadType: payloadType,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := webrtc.NewAPI(webrtc.WithMediaEngine(engine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { viewer.Close() })
+	for i := 0; i < tracks; i++ {
+		if _, err := viewer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offer, err := viewer.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := viewer.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+
+	worker, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { worker.Close() })
+	var senders []*webrtc.RTPSender
+	var localTracks []*webrtc.TrackLocalStaticRTP
+	for i := 0; i < tracks; i++ {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		}, fmt.Sprintf("video-%d", i), "camera")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender, err := worker.AddTrack(track)
+		if err != nil {
+			t.Fatal(err)
+		}
+		senders = append(senders, sender)
+		localTracks = append(localTracks, track)
+	}
+	if err := worker.SetRemoteDescription(*viewer.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := worker.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	return senders, localTracks, answer.SDP
+}
+
+func TestStreamersFollowNegotiatedPayloadTypeAndSSRC(t *testing.T) {
+	const negotiated = 121 // Not the H.264 default of 96
+	senders, tracks, answer := negotiateH264(t, negotiated, 2)
+	if !strings.Contains(answer, fmt.Sprintf("a=rtpmap:%d H264/90000", negotiated)) {
+		t.Fatalf("answer doesn't use payload type %d for H.264:\n%s", negotiated, answer)
+	}
+
+	var streamers []*WebRTCStreamer
+	for i, sender := range senders {
+		streamer := NewWebRTCStreamerForSender(sender, tracks[i], make(chan *Frame))
+		defer streamer.Stop()
+		streamers = append(streamers, streamer)
+
+		if streamer.PayloadType() != negotiated {
+			t.Errorf("streamer %d writes payload type %d, want the negotiated %d", i, streamer.PayloadType(), negotiated)
+		}
+		if streamer.SSRC() == 0 {
+			t.Errorf("streamer %d has SSRC 0", i)
+		}
+		// The SSRC is the one the answer announced for the sender's track
+		if want := uint32(sender.GetParameters().Encodings[0].SSRC); streamer.SSRC() != want {
+			t.Errorf("streamer %d SSRC = %d, want the sender's %d", i, streamer.SSRC(), want)
+		}
+		if !strings.Contains(answer, fmt.Sprintf("a=ssrc:%d ", streamer.SSRC())) {
+			t.Errorf("streamer %d SSRC %d isn't in the answer", i, streamer.SSRC())
+		}
+	}
+	if streamers[0].SSRC() == streamers[1].SSRC() {
+		t.Fatalf("two streamers on one connection share SSRC %d", streamers[0].SSRC())
+	}
+}
+
+func TestStreamerSSRCsAreUnique(t *testing.T) {
+	first := NewWebRTCStreamer(nil, nil, 0, 4242)
+	defer first.Stop()
+	if first.SSRC() != 4242 || first.PayloadType() != defaultH264PayloadType {
+		t.Fatalf("first streamer: SSRC %d, payload type %d; want 4242 and %d", first.SSRC(), first.PayloadType(), defaultH264PayloadType)
+	}
+
+	// The SSRC is taken, so the second streamer gets a fresh one
+	second := NewWebRTCStreamer(nil, nil, 100, 4242)
+	if second.SSRC() == 4242 || second.SSRC() == 0 {
+		t.Fatalf("second streamer reused SSRC %d", second.SSRC())
+	}
+	if second.PayloadType() != 100 {
+		t.Fatalf("second streamer payload type %d, want 100", second.PayloadType())
+	}
+
+	// A stopped streamer gives its SSRC back
+	first.Stop()
+	third := NewWebRTCStreamer(nil, nil, 0, 4242)
+	defer third.Stop()
+	second.Stop()
+	if third.SSRC() != 4242 {
+		t.Fatalf("third streamer SSRC %d, want the released 4242", third.SSRC())
+	}
+}
+
+// benchmarkSlowSubscriber distributes frames to a subscriber that keeps up and one that
+// never reads. The stalled one only ever holds its queue's worth of frames, so it can't
+// hold up the other, and the goroutine count and retained heap stay flat however many
+// frames go out.
+func benchmarkSlowSubscriber(b *testing.B, copyPayload bool) {
+	saved := frameDistribution
+	frameDistribution.CopyPayload = copyPayload
+	log.SetOutput(io.Discard) // Keyframe logging would dominate the timing
+	b.Cleanup(func() {
+		frameDistribution = saved
+		log.SetOutput(os.Stderr)
+	})
+
+	manager := NewRTSPStreamManager("rtsp://camera.test/stream", RTSPRetryPolicy{})
+	fast := manager.Subscribe("fast")
+	manager.Subscribe("stalled")
+
+	idr := append([]byte{0x65}, make([]byte, 1400)...)
+	slice := append([]byte{0x41}, make([]byte, 1400)...)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		payload := slice
+		if i%30 == 0 {
+			payload = idr
+		}
+		manager.distributeFrame(&rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(i) * 3000}, Payload: payload})
+		select {
+		case <-fast:
+		default:
+			b.Fatalf("frame %d didn't reach the subscriber that keeps up", i)
+		}
+	}
+	b.StopTimer()
+
+	if grown := runtime.NumGoroutine() - goroutines; grown > 0 {
+		b.Fatalf("%d goroutines left behind by distribution", grown)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	manager.mu.RLock()
+	dropped := manager.subscribers["stalled"].dropped
+	manager.mu.RUnlock()
+	if want := uint64(max(b.N-subscriberQueueSize, 0)); dropped != want {
+		b.Fatalf("stalled subscriber dropped %d frames, want the %d beyond its queue", dropped, want)
+	}
+	b.ReportMetric(float64(int64(after.HeapInuse)-int64(before.HeapInuse)), "retained-B")
+}
+
+func BenchmarkDistributeFrameSlowSubscriberShared(b *testing.B) {
+	benchmarkSlowSubscriber(b, false)
+}
+
+func BenchmarkDistributeFrameSlowSubscriberCopied(b *testing.B) {
+	benchmarkSlowSubscriber(b, true)
+}
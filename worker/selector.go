@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CameraSelector selects cameras by pattern instead of enumerating IDs. Labels narrow
+// down the glob or regex match, or select on their own.
+type CameraSelector struct {
+	Glob   string            `json:"glob,omitempty"`   // shell-style glob over camera ID, e.g. "site-a-*"
+	Regex  string            `json:"regex,omitempty"`  // regular expression over camera ID
+	Labels map[string]string `json:"labels,omitempty"` // every key=value must match, as with GET /cameras?label=
+}
+
+// errSelectorNeedsDatabase is returned for selectors that can only be resolved against
+// the store, while the database is down
+var errSelectorNeedsDatabase = errors.New("selector requires the database, which is not available")
+
+// InvalidSelectorError is a selector the request got wrong
+type InvalidSelectorError struct {
+	Reason string
+}
+
+func (e *InvalidSelectorError) Error() string {
+	return e.Reason
+}
+
+// respondSelectorError answers a request whose selector couldn't be resolved: 400 for a
+// bad selector, 503 without the database and 500 if the store failed
+func respondSelectorError(c *gin.Context, err error) {
+	var invalidErr *InvalidSelectorError
+	switch {
+	case errors.As(err, &invalidErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid selector: %v", err)})
+	case errors.Is(err, errSelectorNeedsDatabase):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// matcher compiles the selector into a predicate over camera IDs
+func (s *CameraSelector) matcher() (func(string) bool, error) {
+	if s.Glob != "" && s.Regex != "" {
+		return nil, &InvalidSelectorError{Reason: "selector must specify either glob or regex, not both"}
+	}
+	for key := range s.Labels {
+		if key == "" {
+			return nil, &InvalidSelectorError{Reason: "selector labels must not have an empty key"}
+		}
+	}
+
+	if s.Glob != "" {
+		// Validate the pattern up front; path.Match only reports ErrBadPattern lazily
+		if _, err := path.Match(s.Glob, ""); err != nil {
+			return nil, &InvalidSelectorError{Reason: fmt.Sprintf("invalid glob %q: %v", s.Glob, err)}
+		}
+		return func(cameraID string) bool {
+			matched, _ := path.Match(s.Glob, cameraID)
+			return matched
+		}, nil
+	}
+
+	if s.Regex != "" {
+		re, err := regexp.Compile(s.Regex)
+		if err != nil {
+			return nil, &InvalidSelectorError{Reason: fmt.Sprintf("invalid regex %q: %v", s.Regex, err)}
+		}
+		return re.MatchString, nil
+	}
+
+	if len(s.Labels) > 0 {
+		return func(string) bool { return true }, nil // Labels alone; every ID passes
+	}
+	return nil, &InvalidSelectorError{Reason: "selector must specify glob, regex or labels"}
+}
+
+// selectActiveCameras resolves a selector against a single snapshot of activeProcesses,
+// looking up labels in the store. A nil selector selects every active camera.
+func selectActiveCameras(store CameraStore, selector *CameraSelector) ([]string, error) {
+	match := func(string) bool { return true }
+	if selector != nil {
+		var err error
+		if match, err = selector.matcher(); err != nil {
+			return nil, err
+		}
+	}
+
+	processMutex.RLock()
+	cameraIDs := make([]string, 0, len(activeProcesses))
+	for cameraID := range activeProcesses {
+		if match(cameraID) {
+			cameraIDs = append(cameraIDs, cameraID)
+		}
+	}
+	processMutex.RUnlock()
+
+	if selector != nil && len(selector.Labels) > 0 {
+		labeled, err := labeledCameras(store, selector.Labels)
+		if err != nil {
+			return nil, err
+		}
+		kept := cameraIDs[:0]
+		for _, cameraID := range cameraIDs {
+			if labeled[cameraID] {
+				kept = append(kept, cameraID)
+			}
+		}
+		cameraIDs = kept
+	}
+
+	sort.Strings(cameraIDs)
+	return cameraIDs, nil
+}
+
+// labeledCameras is the set of stored cameras carrying every label in selector
+func labeledCameras(store CameraStore, selector map[string]string) (map[string]bool, error) {
+	if !store.Available() {
+		return nil, errSelectorNeedsDatabase
+	}
+	cameras, err := store.ListCameras()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cameras: %w", err)
+	}
+	labeled := make(map[string]bool, len(cameras))
+	for _, camera := range cameras {
+		if camera.MatchesLabels(selector) {
+			labeled[camera.ID] = true
+		}
+	}
+	return labeled, nil
+}
+
+// selectStoredCameras resolves a selector against the cameras known to the store
+func selectStoredCameras(store CameraStore, selector *CameraSelector) ([]CameraRecord, error) {
+	match, err := selector.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if !store.Available() {
+		return nil, errSelectorNeedsDatabase
+	}
+
+	cameras, err := store.ListCameras()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cameras: %w", err)
+	}
+
+	selected := make([]CameraRecord, 0, len(cameras))
+	for _, camera := range cameras {
+		if match(camera.ID) && camera.MatchesLabels(selector.Labels) {
+			selected = append(selected, camera)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectStoredCamerasByLabel(t *testing.T) {
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "site-a-1", Labels: map[string]string{"site": "a", "floor": "1"}})
+	store.AddCamera(CameraRecord{ID: "site-a-2", Labels: map[string]string{"site": "a", "floor": "2"}})
+	store.AddCamera(CameraRecord{ID: "site-b-1", Labels: map[string]string{"site": "b", "floor": "2"}})
+
+	tests := []struct {
+		name     string
+		selector CameraSelector
+		want     []string
+	}{
+		{"labels alone", CameraSelector{Labels: map[string]string{"site": "a"}}, []string{"site-a-1", "site-a-2"}},
+		{"every label must match", CameraSelector{Labels: map[string]string{"site": "a", "floor": "2"}}, []string{"site-a-2"}},
+		{"labels narrow a glob", CameraSelector{Glob: "site-*", Labels: map[string]string{"floor": "2"}}, []string{"site-a-2", "site-b-1"}},
+		{"labels narrow a regex", CameraSelector{Regex: "-1$", Labels: map[string]string{"site": "b"}}, []string{"site-b-1"}},
+		{"no camera carries the label", CameraSelector{Labels: map[string]string{"site": "c"}}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectStoredCameras(store, &tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, camera := range selected {
+				got = append(got, camera.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var invalidErr *InvalidSelectorError
+	for _, selector := range []CameraSelector{{}, {Labels: map[string]string{"": "a"}}, {Glob: "[", Labels: map[string]string{"site": "a"}}} {
+		if _, err := selectStoredCameras(store, &selector); !errors.As(err, &invalidErr) {
+			t.Errorf("selector %+v: err = %v, want an InvalidSelectorError", selector, err)
+		}
+	}
+	if _, err := selectStoredCameras(NewSQLCameraStore(nil, 0), &CameraSelector{Glob: "*"}); !errors.Is(err, errSelectorNeedsDatabase) {
+		t.Errorf("without a database: err = %v, want errSelectorNeedsDatabase", err)
+	}
+}
+
+func TestStopAllSelectsAndDecodesBody(t *testing.T) {
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "site-a-1", Labels: map[string]string{"site": "a"}})
+	store.AddCamera(CameraRecord{ID: "site-b-1", Labels: map[string]string{"site": "b"}})
+	router := newRouter(store)
+	for _, cameraID := range []string{"site-a-1", "site-b-1"} {
+		startTransientCamera(cameraID)
+	}
+	t.Cleanup(func() {
+		for _, cameraID := range []string{"site-a-1", "site-b-1"} {
+			stopReencodingProcess(cameraID)
+		}
+		circuitBreakersMutex.Lock()
+		delete(circuitBreakers, "site-a-1")
+		delete(circuitBreakers, "site-b-1")
+		circuitBreakersMutex.Unlock()
+		sweepCameraState(0)
+	})
+
+	stopAll := func(router http.Handler, body io.Reader, contentLength int64) (int, []string) {
+		req := httptest.NewRequest(http.MethodPost, "/stop-all", body)
+		req.ContentLength = contentLength // -1 is a chunked body
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp struct {
+			Cameras []string `json:"cameras"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Cameras
+	}
+
+	// A label selector without the database can't be resolved
+	body := `{"selector": {"labels": {"site": "b"}}}`
+	if code, _ := stopAll(newRouter(NewSQLCameraStore(nil, 0)), strings.NewReader(body), int64(len(body))); code != http.StatusServiceUnavailable {
+		t.Fatalf("label selector without a database: status %d, want 503", code)
+	}
+	if code, _ := stopAll(router, strings.NewReader(`{"selector": {}}`), -1); code != http.StatusBadRequest {
+		t.Fatalf("empty selector: status %d, want 400", code)
+	}
+
+	code, cameras := stopAll(router, strings.NewReader(body), -1)
+	if code != http.StatusOK || !reflect.DeepEqual(cameras, []string{"site-b-1"}) {
+		t.Fatalf("label selector: status %d, cameras %v; want 200 and [site-b-1]", code, cameras)
+	}
+
+	// An empty chunked body stops everything that's left, like an empty fixed-length one
+	code, cameras = stopAll(router, strings.NewReader(""), -1)
+	if code != http.StatusOK || !reflect.DeepEqual(cameras, []string{"site-a-1"}) {
+		t.Fatalf("empty chunked body: status %d, cameras %v; want 200 and [site-a-1]", code, cameras)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// snapshotVersion is bumped when the snapshot format changes incompatibly
+const snapshotVersion = 1
+
+// importStartWorkers is how many cameras POST /import?start=true starts at once; each
+// start probes the source and configures its MediaMTX path
+const importStartWorkers = 5
+
+// What POST /import does with a camera that is already streaming
+const (
+	importRunningSkip    = "skip"    // Leave the stream as it is (default)
+	importRunningRestart = "restart" // Restart it with the imported settings
+)
+
+// WorkerSnapshot is the worker's camera set as returned by GET /export and accepted by
+// POST /import. Groups are matched by name on import, since group IDs are generated.
+type WorkerSnapshot struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	Cameras    []SnapshotCamera `json:"cameras" binding:"dive"`
+	Groups     []SnapshotGroup  `json:"groups" binding:"dive"`
+}
+
+// SnapshotCamera is one camera with everything needed to recreate it
+type SnapshotCamera struct {
+	ID            string              `json:"id" binding:"required,cameraid"`
+	Name          string              `json:"name" binding:"max=128"`
+	RTSPURL       string              `json:"rtspUrl" binding:"required,rtspurl"`
+	Enabled       bool                `json:"enabled"`
+	Configured    bool                `json:"configured"` // Has a MediaMTX path
+	Streaming     bool                `json:"streaming"`  // Was streaming at export; started on import with start=true
+	Labels        map[string]string   `json:"labels,omitempty"`
+	Group         string              `json:"group,omitempty"` // Group name
+	FaceDetection FaceDetectionPolicy `json:"faceDetection"`   // The camera's own overrides
+	StreamOptions StreamOptions       `json:"streamOptions"`
+}
+
+// SnapshotGroup is a camera group's face detection policy
+type SnapshotGroup struct {
+	Name          string              `json:"name" binding:"required,max=128"`
+	FaceDetection FaceDetectionPolicy `json:"faceDetection"`
+}
+
+// buildSnapshot reads every camera and group from the store. A camera counts as
+// streaming when it has a process here or the store says it is processing.
+func buildSnapshot(store CameraStore) (WorkerSnapshot, error) {
+	snapshot := WorkerSnapshot{
+		Version:    snapshotVersion,
+		ExportedAt: time.Now(),
+		Cameras:    []SnapshotCamera{},
+		Groups:     []SnapshotGroup{},
+	}
+
+	groups, err := store.ListGroups()
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to list groups: %w", err)
+	}
+	for _, group := range groups {
+		snapshot.Groups = append(snapshot.Groups, SnapshotGroup{Name: group.Name, FaceDetection: group.FaceDetection})
+	}
+
+	cameras, err := store.ListCameras()
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to list cameras: %w", err)
+	}
+
+	processMutex.RLock()
+	running := make(map[string]bool, len(activeProcesses))
+	for cameraID := range activeProcesses {
+		running[cameraID] = true
+	}
+	processMutex.RUnlock()
+
+	for _, camera := range cameras {
+		entry := SnapshotCamera{
+			ID:         camera.ID,
+			Name:       camera.Name,
+			RTSPURL:    camera.RTSPURL,
+			Enabled:    camera.Enabled,
+			Configured: camera.Configured,
+			Streaming:  running[camera.ID] || (camera.Enabled && camera.Status == "PROCESSING"),
+			Labels:     camera.Labels,
+		}
+		policy, group, err := store.GetFaceDetectionPolicies(camera.ID)
+		if err != nil {
+			return snapshot, fmt.Errorf("failed to read face detection settings of camera %s: %w", camera.ID, err)
+		}
+		entry.FaceDetection = policy
+		if group != nil {
+			entry.Group = group.Name
+		}
+		if entry.StreamOptions, err = store.GetStreamOptions(camera.ID); err != nil {
+			return snapshot, fmt.Errorf("failed to read stream options of camera %s: %w", camera.ID, err)
+		}
+		snapshot.Cameras = append(snapshot.Cameras, entry)
+	}
+	return snapshot, nil
+}
+
+// Validate checks the snapshot before anything is written
+func (s WorkerSnapshot) Validate() error {
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (expected %d)", s.Version, snapshotVersion)
+	}
+
+	groups := make(map[string]bool, len(s.Groups))
+	for _, group := range s.Groups {
+		if groups[group.Name] {
+			return fmt.Errorf("group %q is listed twice", group.Name)
+		}
+		groups[group.Name] = true
+		if err := group.FaceDetection.Validate(); err != nil {
+			return fmt.Errorf("group %q: %w", group.Name, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(s.Cameras))
+	for _, camera := range s.Cameras {
+		if seen[camera.ID] {
+			return fmt.Errorf("camera %s is listed twice", camera.ID)
+		}
+		seen[camera.ID] = true
+		if camera.Group != "" && !groups[camera.Group] {
+			return fmt.Errorf("camera %s: group %q is not in the snapshot", camera.ID, camera.Group)
+		}
+		if err := camera.FaceDetection.Validate(); err != nil {
+			return fmt.Errorf("camera %s: %w", camera.ID, err)
+		}
+		if err := camera.StreamOptions.Validate(camera.StreamOptions.Output.containerFormat()); err != nil {
+			return fmt.Errorf("camera %s: %w", camera.ID, err)
+		}
+	}
+	return nil
+}
+
+// ImportResult is what POST /import did with one camera
+type ImportResult struct {
+	CameraID string `json:"cameraId"`
+	Action   string `json:"action"` // registered | started | restarted | skipped | failed
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Import outcomes
+const (
+	importActionRegistered = "registered"
+	importActionStarted    = "started"
+	importActionRestarted  = "restarted"
+	importActionSkipped    = "skipped"
+	importActionFailed     = "failed"
+)
+
+// importSnapshot writes the snapshot's groups and cameras to the store, then, with
+// start, starts the cameras that were streaming, importStartWorkers at a time in
+// snapshot order. A camera already
+// streaming is skipped or restarted per running. Starts that would exceed
+// MAX_CONCURRENT_STREAMS are skipped, the camera staying registered.
+func importSnapshot(store CameraStore, snapshot WorkerSnapshot, start bool, running string) []ImportResult {
+	results := make([]ImportResult, len(snapshot.Cameras))
+
+	groupIDs := make(map[string]string, len(snapshot.Groups))
+	for _, group := range snapshot.Groups {
+		saved, err := store.SaveGroup(CameraGroup{Name: group.Name, FaceDetection: group.FaceDetection})
+		if err != nil {
+			log.Printf("Import: failed to save group %q: %v", group.Name, err)
+			continue
+		}
+		groupIDs[group.Name] = saved.ID
+	}
+
+	var toStart []int
+	for i, camera := range snapshot.Cameras {
+		results[i] = ImportResult{CameraID: camera.ID, Action: importActionRegistered}
+		if err := importCamera(store, camera, groupIDs); err != nil {
+			log.Printf("Import: failed to register camera %s: %v", camera.ID, err)
+			results[i] = ImportResult{CameraID: camera.ID, Action: importActionFailed, Reason: err.Error()}
+			continue
+		}
+		if start && camera.Streaming && camera.Enabled {
+			toStart = append(toStart, i)
+		}
+	}
+
+	// Claim capacity in snapshot order before starting anything, so the outcome doesn't
+	// depend on which start finishes first
+	used := usedCapacity()
+	limit := currentWorkerConfig().MaxConcurrentStreams
+	var starts []int
+	for _, i := range toStart {
+		camera := snapshot.Cameras[i]
+		processMutex.RLock()
+		process, isRunning := activeProcesses[camera.ID]
+		processMutex.RUnlock()
+
+		if isRunning && running != importRunningRestart {
+			results[i] = ImportResult{CameraID: camera.ID, Action: importActionSkipped, Reason: "already streaming"}
+			continue
+		}
+		weight := streamWeight(camera.StreamOptions)
+		if isRunning {
+			weight -= streamWeight(process.Options) // Replaces its own slot
+		}
+		if used+weight > limit {
+			results[i] = ImportResult{CameraID: camera.ID, Action: importActionSkipped,
+				Reason: fmt.Sprintf("max concurrent streams reached (%d/%d, stream weight %d)", used, limit, streamWeight(camera.StreamOptions))}
+			continue
+		}
+		used += weight
+		starts = append(starts, i)
+	}
+
+	runWorkers(len(starts), importStartWorkers, func(n int) {
+		i := starts[n]
+		camera := snapshot.Cameras[i]
+
+		processMutex.RLock()
+		_, wasRunning := activeProcesses[camera.ID]
+		processMutex.RUnlock()
+		pathName := cameraPathName(camera.ID)
+		if wasRunning {
+			previousMediaMTX := activeMediaMTX(camera.ID)
+			if stopReencodingProcess(camera.ID) {
+				waitForStreamStopped(previousMediaMTX, pathName)
+			}
+		}
+
+		if err := startReencodingProcess(store, camera.ID, camera.RTSPURL, loadStreamOptions(store, camera.ID)); err != nil {
+			log.Printf("Import: failed to start camera %s: %v", camera.ID, err)
+			results[i] = ImportResult{CameraID: camera.ID, Action: importActionFailed, Reason: err.Error()}
+			return
+		}
+		store.UpdateCameraPathInfo(camera.ID, pathName, true)
+		results[i].Action = importActionStarted
+		if wasRunning {
+			results[i].Action = importActionRestarted
+		}
+	})
+	return results
+}
+
+// importCamera writes one camera and its settings. Configured cameras get their MediaMTX
+// path recorded like POST /register does.
+func importCamera(store CameraStore, camera SnapshotCamera, groupIDs map[string]string) error {
+	if err := store.UpsertCamera(CameraRecord{
+		ID:      camera.ID,
+		Name:    camera.Name,
+		RTSPURL: camera.RTSPURL,
+		Enabled: camera.Enabled,
+		Labels:  camera.Labels,
+	}); err != nil {
+		return err
+	}
+
+	// The snapshot is the whole policy: overrides it leaves out are cleared, not kept
+	if err := store.SaveCameraFaceDetection(camera.ID, camera.FaceDetection, policyReplace); err != nil {
+		return fmt.Errorf("face detection settings: %w", err)
+	}
+	if err := store.SaveStreamOptions(camera.ID, camera.StreamOptions); err != nil {
+		return fmt.Errorf("stream options: %w", err)
+	}
+
+	groupID := ""
+	if camera.Group != "" {
+		var exists bool
+		if groupID, exists = groupIDs[camera.Group]; !exists {
+			return fmt.Errorf("group %q could not be saved", camera.Group)
+		}
+	}
+	if err := store.AssignCamerasToGroup(groupID, []string{camera.ID}); err != nil {
+		return fmt.Errorf("group: %w", err)
+	}
+
+	if camera.Configured {
+		store.UpdateCameraPathInfo(camera.ID, cameraPathName(camera.ID), true)
+		// Recording the path marks the camera processing; one that wasn't streaming
+		// stays pre-configured, so a worker restart doesn't start it
+		if !camera.Streaming {
+			if err := store.UpdateCameraStatus(camera.ID, "OFFLINE"); err != nil {
+				return fmt.Errorf("status: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// importSummary counts the results by action
+func importSummary(results []ImportResult) map[string]int {
+	summary := map[string]int{}
+	for _, result := range results {
+		summary[result.Action]++
+	}
+	return summary
+}
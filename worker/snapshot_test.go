@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestImportClearsOmittedFaceDetectionOverrides(t *testing.T) {
+	store := NewMemoryCameraStore()
+	store.AddCamera(CameraRecord{ID: "cam-1", RTSPURL: "rtsp://10.0.0.1/stream"})
+	interval, threshold := 500, 0.7
+	overrides := FaceDetectionPolicy{IntervalMs: &interval, Threshold: &threshold, ROI: &RegionOfInterest{Width: 0.5, Height: 0.5}}
+	if err := store.SaveCameraFaceDetection("cam-1", overrides, policyMerge); err != nil {
+		t.Fatal(err)
+	}
+
+	// A toggle only sets what it's given
+	if err := store.SaveCameraFaceDetection("cam-1", FaceDetectionPolicy{Enabled: boolPtr(true)}, policyMerge); err != nil {
+		t.Fatal(err)
+	}
+	policy, _, err := store.GetFaceDetectionPolicies("cam-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.IntervalMs == nil || policy.Threshold == nil || policy.ROI == nil {
+		t.Fatalf("merge dropped stored overrides: %+v", policy)
+	}
+
+	// An import restores the snapshot's policy exactly, clearing what it leaves out
+	snapshot := WorkerSnapshot{
+		Version: snapshotVersion,
+		Cameras: []SnapshotCamera{{
+			ID:            "cam-1",
+			RTSPURL:       "rtsp://10.0.0.1/stream",
+			Enabled:       true,
+			FaceDetection: FaceDetectionPolicy{Enabled: boolPtr(false), Threshold: &threshold},
+		}},
+	}
+	results := importSnapshot(store, snapshot, false, importRunningSkip)
+	if len(results) != 1 || results[0].Action != importActionRegistered {
+		t.Fatalf("import results %+v, want cam-1 registered", results)
+	}
+	policy, _, err = store.GetFaceDetectionPolicies("cam-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Enabled == nil || *policy.Enabled || policy.Threshold == nil || *policy.Threshold != threshold {
+		t.Fatalf("imported policy %+v lost the snapshot's enabled=false and threshold", policy)
+	}
+	if policy.IntervalMs != nil || policy.ROI != nil {
+		t.Fatalf("imported policy %+v kept overrides the snapshot cleared", policy)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultSnapshotTimeout    = 10 * time.Second
+	maxSnapshotTimeout        = 60 * time.Second
+	defaultSnapshotWorkers    = 8
+	defaultSheetTileWidth     = 320
+	contactSheetTileGap       = 4
+	contactSheetJPEGQuality   = 80
+	snapshotConnectionPurpose = "snapshot"
+
+	// GET /snapshot/:cameraId
+	defaultSnapshotCacheTTL = 5 * time.Second
+	snapshotOpenRetries     = 2
+	snapshotOpenRetryDelay  = time.Second
+	snapshotWarmupFrames    = 5  // Decoded and discarded; the first ones can predate a keyframe
+	snapshotReadAttempts    = 10 // Reads after the warmup before giving up on an empty frame
+)
+
+// cameraStatusError marks a camera whose registration snapshot failed
+const cameraStatusError = "ERROR"
+
+// errUnknownCamera is a camera that isn't streaming while there is no database to look
+// it up in; without one the streaming cameras are the only ones the worker knows
+var errUnknownCamera = errors.New("camera is not streaming and the database is not available")
+
+// Snapshot is one camera's captured frame
+type Snapshot struct {
+	CameraID   string    `json:"-"`
+	Image      []byte    `json:"image"` // JPEG, base64 in JSON
+	CapturedAt time.Time `json:"capturedAt"`
+	Source     string    `json:"source"` // "stream" (MediaMTX output) or "camera" (direct RTSP)
+}
+
+// snapshotTarget picks where to grab a camera's frame from. A running stream is read
+// from its MediaMTX output so no extra connection is opened to the camera.
+func snapshotTarget(store CameraStore, cameraID string) (url, source string, err error) {
+	processMutex.RLock()
+	process, active := activeProcesses[cameraID]
+	// An SRT push can't be read back, so those cameras are read directly
+	active = active && (process.Output == nil || process.Output.Type() != outputTypeSRT)
+	if active {
+		url = process.TargetURL
+	}
+	processMutex.RUnlock()
+	if active {
+		return url, "stream", nil
+	}
+
+	if !store.Available() {
+		return "", "", errUnknownCamera
+	}
+	rtspURL, _, _, err := store.GetCameraInfo(cameraID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to look up camera: %w", err)
+	}
+	if rtspURL == "" {
+		return "", "", fmt.Errorf("camera has no RTSP URL")
+	}
+	return rtspURL, "camera", nil
+}
+
+// grabSnapshot captures one frame; direct camera reads count against the source
+// connection limit and fail fast rather than queueing
+func grabSnapshot(ctx context.Context, store CameraStore, cameraID string) (Snapshot, error) {
+	return grabSnapshotWith(ctx, store, cameraID, func(ctx context.Context, _, url string) ([]byte, error) {
+		return sampleKeyframe(ctx, url)
+	})
+}
+
+// grabSnapshotWith is grabSnapshot with the frame read by grab, which returns a JPEG
+func grabSnapshotWith(ctx context.Context, store CameraStore, cameraID string, grab func(ctx context.Context, cameraID, url string) ([]byte, error)) (Snapshot, error) {
+	url, source, err := snapshotTarget(store, cameraID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if source == "camera" {
+		release, err := sourceConnections.TryAcquire(cameraID, snapshotConnectionPurpose)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		defer release()
+	}
+
+	if url, err = openSourceURL(url); err != nil {
+		return Snapshot{}, err
+	}
+	frame, err := grab(ctx, cameraID, url)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{CameraID: cameraID, Image: frame, CapturedAt: time.Now(), Source: source}, nil
+}
+
+// captureSnapshotFrame reads one frame through a short-lived gocv capture, opened with
+// face detection's retries, and encodes it as JPEG
+func captureSnapshotFrame(ctx context.Context, cameraID, url string) ([]byte, error) {
+	capture, err := openVideoCaptureWithRetry(ctx, cameraID, url, "snapshot",
+		RTSPRetryPolicy{MaxAttempts: snapshotOpenRetries, InitialDelay: snapshotOpenRetryDelay, MaxDelay: snapshotOpenRetryDelay})
+	if err != nil {
+		return nil, err
+	}
+	defer capture.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+	for i := 0; i < snapshotWarmupFrames; i++ {
+		capture.Read(&img)
+	}
+	for attempt := 0; img.Empty() && attempt < snapshotReadAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		capture.Read(&img)
+	}
+	if img.Empty() {
+		return nil, fmt.Errorf("no frame decoded from the stream")
+	}
+
+	buf, err := gocv.IMEncode(".jpg", img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	defer buf.Close()
+	return bytes.Clone(buf.GetBytes()), nil
+}
+
+// SnapshotCache keeps each camera's last single snapshot for a few seconds, so a
+// dashboard refreshing its thumbnails doesn't open a capture per refresh. Concurrent
+// requests for a camera share one capture; failures aren't cached.
+type SnapshotCache struct {
+	ttl     time.Duration
+	entries map[string]*snapshotCacheEntry
+	mu      sync.Mutex
+}
+
+// snapshotCacheEntry is a camera's last snapshot, or the capture still taking it
+type snapshotCacheEntry struct {
+	ready    chan struct{} // Closed once the capture finished
+	snapshot Snapshot
+	err      error
+}
+
+// snapshotCache serves GET /snapshot/:cameraId, with SNAPSHOT_CACHE_TTL read at startup
+var snapshotCache = NewSnapshotCache(defaultSnapshotCacheTTL)
+
+// NewSnapshotCache creates a cache holding snapshots for ttl
+func NewSnapshotCache(ttl time.Duration) *SnapshotCache {
+	return &SnapshotCache{ttl: ttl, entries: make(map[string]*snapshotCacheEntry)}
+}
+
+// Get returns the camera's snapshot and whether it came from the cache. The capture
+// runs on its own timeout, so a caller giving up doesn't fail the others waiting on it.
+func (sc *SnapshotCache) Get(ctx context.Context, store CameraStore, cameraID string) (Snapshot, bool, error) {
+	sc.mu.Lock()
+	entry, exists := sc.entries[cameraID]
+	if exists {
+		select {
+		case <-entry.ready:
+			if entry.err != nil || time.Since(entry.snapshot.CapturedAt) >= sc.ttl {
+				exists = false // Stale or failed; capture afresh
+			}
+		default: // A capture is in flight; wait for it
+		}
+	}
+	if !exists {
+		entry = &snapshotCacheEntry{ready: make(chan struct{})}
+		sc.entries[cameraID] = entry
+		go sc.capture(store, cameraID, entry)
+	}
+	sc.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return Snapshot{}, false, ctx.Err()
+	}
+	return entry.snapshot, exists, entry.err
+}
+
+// Forget drops the camera's snapshot, e.g. once it stopped streaming. A capture in
+// flight still answers the requests waiting on it.
+func (sc *SnapshotCache) Forget(cameraID string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.entries, cameraID)
+}
+
+// forgetExpired drops the snapshots older than the TTL, which Get would capture afresh
+// anyway, and reports how many it dropped
+func (sc *SnapshotCache) forgetExpired() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	forgotten := 0
+	for cameraID, entry := range sc.entries {
+		select {
+		case <-entry.ready:
+			if time.Since(entry.snapshot.CapturedAt) >= sc.ttl {
+				delete(sc.entries, cameraID)
+				forgotten++
+			}
+		default: // Still capturing
+		}
+	}
+	return forgotten
+}
+
+// Len is the number of cameras with a cached or in-flight snapshot
+func (sc *SnapshotCache) Len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.entries)
+}
+
+// capture takes the entry's snapshot, dropping the entry again if it fails
+func (sc *SnapshotCache) capture(store CameraStore, cameraID string, entry *snapshotCacheEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSnapshotTimeout)
+	defer cancel()
+
+	entry.snapshot, entry.err = grabSnapshotWith(ctx, store, cameraID, captureSnapshotFrame)
+	if entry.err != nil {
+		log.Printf("Snapshot of camera %s failed: %v", cameraID, entry.err)
+		sc.mu.Lock()
+		if sc.entries[cameraID] == entry {
+			delete(sc.entries, cameraID)
+		}
+		sc.mu.Unlock()
+	}
+	close(entry.ready)
+}
+
+// grabSnapshots captures cameras concurrently with at most concurrency grabs in flight.
+// It returns when all grabs finish or ctx expires; cameras still pending are reported
+// as timed out so the caller can return partial results.
+func grabSnapshots(ctx context.Context, store CameraStore, cameraIDs []string, concurrency int) (map[string]Snapshot, map[string]string) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	snapshots := make(map[string]Snapshot, len(cameraIDs))
+	failures := make(map[string]string)
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(cameraIDs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cameraID := range jobs {
+				snapshot, err := grabSnapshot(ctx, store, cameraID)
+				mu.Lock()
+				if err != nil {
+					failures[cameraID] = err.Error()
+				} else {
+					snapshots[cameraID] = snapshot
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, cameraID := range cameraIDs {
+			select {
+			case jobs <- cameraID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	resultSnapshots := make(map[string]Snapshot, len(snapshots))
+	resultFailures := make(map[string]string, len(cameraIDs)-len(snapshots))
+	for _, cameraID := range cameraIDs {
+		if snapshot, ok := snapshots[cameraID]; ok {
+			resultSnapshots[cameraID] = snapshot
+		} else if reason, ok := failures[cameraID]; ok {
+			resultFailures[cameraID] = reason
+		} else {
+			resultFailures[cameraID] = "timed out"
+		}
+	}
+	return resultSnapshots, resultFailures
+}
+
+// buildContactSheet stitches the snapshots into a grid, in cameraIDs order. Missing
+// cameras get a blank tile so the grid position of each camera stays predictable.
+func buildContactSheet(cameraIDs []string, snapshots map[string]Snapshot, columns, tileWidth int) ([]byte, error) {
+	if len(cameraIDs) == 0 {
+		return nil, fmt.Errorf("no cameras to render")
+	}
+	if columns <= 0 {
+		columns = 1
+		for columns*columns < len(cameraIDs) {
+			columns++
+		}
+	}
+	if columns > len(cameraIDs) {
+		columns = len(cameraIDs)
+	}
+	if tileWidth <= 0 {
+		tileWidth = defaultSheetTileWidth
+	}
+	tileHeight := tileWidth * 9 / 16
+	rows := (len(cameraIDs) + columns - 1) / columns
+
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		columns*tileWidth+(columns+1)*contactSheetTileGap,
+		rows*tileHeight+(rows+1)*contactSheetTileGap))
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{color.RGBA{16, 16, 16, 255}}, image.Point{}, draw.Src)
+
+	for i, cameraID := range cameraIDs {
+		x := contactSheetTileGap + (i%columns)*(tileWidth+contactSheetTileGap)
+		y := contactSheetTileGap + (i/columns)*(tileHeight+contactSheetTileGap)
+		tile := image.Rect(x, y, x+tileWidth, y+tileHeight)
+
+		snapshot, ok := snapshots[cameraID]
+		if !ok {
+			draw.Draw(sheet, tile, &image.Uniform{color.RGBA{48, 48, 48, 255}}, image.Point{}, draw.Src)
+			continue
+		}
+		frame, err := jpeg.Decode(bytes.NewReader(snapshot.Image))
+		if err != nil {
+			draw.Draw(sheet, tile, &image.Uniform{color.RGBA{48, 48, 48, 255}}, image.Point{}, draw.Src)
+			continue
+		}
+		drawScaled(sheet, tile, frame)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: contactSheetJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode contact sheet: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// drawScaled draws src into dst's rect with nearest-neighbour scaling, letterboxed to
+// keep the aspect ratio
+func drawScaled(dst *image.RGBA, rect image.Rectangle, src image.Image) {
+	srcBounds := src.Bounds()
+	if srcBounds.Dx() == 0 || srcBounds.Dy() == 0 {
+		return
+	}
+
+	width, height := rect.Dx(), rect.Dy()
+	if srcBounds.Dx()*height > srcBounds.Dy()*width {
+		height = srcBounds.Dy() * width / srcBounds.Dx()
+	} else {
+		width = srcBounds.Dx() * height / srcBounds.Dy()
+	}
+	offsetX := rect.Min.X + (rect.Dx()-width)/2
+	offsetY := rect.Min.Y + (rect.Dy()-height)/2
+
+	for y := 0; y < height; y++ {
+		srcY := srcBounds.Min.Y + y*srcBounds.Dy()/height
+		for x := 0; x < width; x++ {
+			srcX := srcBounds.Min.X + x*srcBounds.Dx()/width
+			dst.Set(offsetX+x, offsetY+y, src.At(srcX, srcY))
+		}
+	}
+}
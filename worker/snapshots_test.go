@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cacheSnapshot stores a finished snapshot taken at capturedAt
+func cacheSnapshot(sc *SnapshotCache, cameraID string, capturedAt time.Time) {
+	entry := &snapshotCacheEntry{ready: make(chan struct{}), snapshot: Snapshot{CameraID: cameraID, CapturedAt: capturedAt}}
+	close(entry.ready)
+	sc.mu.Lock()
+	sc.entries[cameraID] = entry
+	sc.mu.Unlock()
+}
+
+func TestSnapshotCacheEvictsStoppedAndExpiredCameras(t *testing.T) {
+	previous := snapshotCache
+	snapshotCache = NewSnapshotCache(time.Minute)
+	t.Cleanup(func() { snapshotCache = previous })
+
+	startTransientCamera("cam-snapshot-stopped")
+	t.Cleanup(func() {
+		circuitBreakersMutex.Lock()
+		delete(circuitBreakers, "cam-snapshot-stopped")
+		circuitBreakersMutex.Unlock()
+		sweepCameraState(0)
+	})
+	cacheSnapshot(snapshotCache, "cam-snapshot-stopped", time.Now())
+	cacheSnapshot(snapshotCache, "cam-snapshot-fresh", time.Now())
+	cacheSnapshot(snapshotCache, "cam-snapshot-expired", time.Now().Add(-2*time.Minute))
+
+	if !stopReencodingProcess("cam-snapshot-stopped") {
+		t.Fatal("cam-snapshot-stopped wasn't running")
+	}
+	if got := snapshotCache.Len(); got != 2 {
+		t.Fatalf("%d cached snapshots after the stop, want 2", got)
+	}
+
+	sweepCameraState(time.Minute)
+	snapshotCache.mu.Lock()
+	_, fresh := snapshotCache.entries["cam-snapshot-fresh"]
+	_, expired := snapshotCache.entries["cam-snapshot-expired"]
+	snapshotCache.mu.Unlock()
+	if !fresh || expired {
+		t.Fatalf("after the sweep: fresh kept %v, expired kept %v; want only the fresh one", fresh, expired)
+	}
+	if got := trackedStateSizes().Snapshots; got != 1 {
+		t.Fatalf("trackedStateSizes().Snapshots = %d, want 1", got)
+	}
+}
+
+func TestSnapshotOfUnknownCameraWithoutDatabase(t *testing.T) {
+	router := newRouter(NewSQLCameraStore(nil, 0))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/snapshot/cam-unknown", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("GET /snapshot/cam-unknown = %d: %s, want 404", recorder.Code, recorder.Body)
+	}
+}
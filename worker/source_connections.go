@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Purposes for which the worker opens a connection to a camera's RTSP source
+const (
+	sourceConnReencode      = "reencode"
+	sourceConnFaceDetection = "face-detection"
+	sourceConnProbe         = "probe" // The smart copy DESCRIBE
+)
+
+// SourceConnectionLimitError is returned when a camera already has its maximum
+// number of open source connections
+type SourceConnectionLimitError struct {
+	CameraID string
+	Purpose  string
+	Limit    int
+	Holders  map[string]int
+}
+
+func (e *SourceConnectionLimitError) Error() string {
+	return fmt.Sprintf("camera %s allows at most %d source connection(s), all in use (%v); cannot open %s connection",
+		e.CameraID, e.Limit, e.Holders, e.Purpose)
+}
+
+// cameraConnections is the semaphore state for one camera
+type cameraConnections struct {
+	limit   int            // 0 means the default limit applies
+	holders map[string]int // purpose -> open connections
+	inUse   int
+	changed chan struct{} // Closed and replaced whenever a slot is released
+}
+
+// SourceConnectionLimiter caps concurrent connections the worker opens to each camera
+type SourceConnectionLimiter struct {
+	defaultLimit int // 0 means unlimited
+	cameras      map[string]*cameraConnections
+	mu           sync.Mutex
+}
+
+// NewSourceConnectionLimiter creates a limiter; defaultLimit 0 disables limiting
+// for cameras without an explicit limit
+func NewSourceConnectionLimiter(defaultLimit int) *SourceConnectionLimiter {
+	return &SourceConnectionLimiter{
+		defaultLimit: defaultLimit,
+		cameras:      make(map[string]*cameraConnections),
+	}
+}
+
+// NewSourceConnectionLimiterFromEnv reads SOURCE_MAX_CONNECTIONS (default: unlimited)
+func NewSourceConnectionLimiterFromEnv() *SourceConnectionLimiter {
+	return NewSourceConnectionLimiter(sourceConnectionLimitFromEnv())
+}
+
+// sourceConnectionLimitFromEnv parses SOURCE_MAX_CONNECTIONS; 0 means unlimited
+func sourceConnectionLimitFromEnv() int {
+	defaultLimit := 0
+	if value := os.Getenv("SOURCE_MAX_CONNECTIONS"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			defaultLimit = parsed
+		} else {
+			log.Printf("Invalid SOURCE_MAX_CONNECTIONS %q, source connections will not be limited", value)
+		}
+	}
+	return defaultLimit
+}
+
+// SetDefaultLimit changes the limit for cameras without their own. Connections already
+// open are kept; the new limit applies to the next acquire.
+func (l *SourceConnectionLimiter) SetDefaultLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.defaultLimit = limit
+	for _, conns := range l.cameras {
+		l.notify(conns) // A raised limit may unblock waiters
+	}
+}
+
+// camera returns the state for cameraID, creating it if needed; caller holds mu
+func (l *SourceConnectionLimiter) camera(cameraID string) *cameraConnections {
+	conns, exists := l.cameras[cameraID]
+	if !exists {
+		conns = &cameraConnections{
+			holders: make(map[string]int),
+			changed: make(chan struct{}),
+		}
+		l.cameras[cameraID] = conns
+	}
+	return conns
+}
+
+// effectiveLimit returns the limit for a camera; caller holds mu
+func (l *SourceConnectionLimiter) effectiveLimit(conns *cameraConnections) int {
+	if conns.limit > 0 {
+		return conns.limit
+	}
+	return l.defaultLimit
+}
+
+// SetLimit sets a per-camera limit; 0 reverts to the default
+func (l *SourceConnectionLimiter) SetLimit(cameraID string, limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conns := l.camera(cameraID)
+	conns.limit = limit
+	l.notify(conns) // A raised limit may unblock waiters
+}
+
+// notify wakes every waiter on conns; caller holds mu
+func (l *SourceConnectionLimiter) notify(conns *cameraConnections) {
+	close(conns.changed)
+	conns.changed = make(chan struct{})
+}
+
+// tryAcquire claims a slot if one is free; caller holds mu
+func (l *SourceConnectionLimiter) tryAcquire(cameraID, purpose string) (func(), *SourceConnectionLimitError, <-chan struct{}) {
+	conns := l.camera(cameraID)
+	limit := l.effectiveLimit(conns)
+	if limit > 0 && conns.inUse >= limit {
+		holders := make(map[string]int, len(conns.holders))
+		for p, n := range conns.holders {
+			holders[p] = n
+		}
+		return nil, &SourceConnectionLimitError{CameraID: cameraID, Purpose: purpose, Limit: limit, Holders: holders}, conns.changed
+	}
+
+	conns.inUse++
+	conns.holders[purpose]++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			conns.inUse--
+			if conns.holders[purpose]--; conns.holders[purpose] <= 0 {
+				delete(conns.holders, purpose)
+			}
+			l.notify(conns)
+		})
+	}
+	return release, nil, nil
+}
+
+// TryAcquire claims a connection slot without waiting. The returned release
+// func must be called once the connection is closed.
+func (l *SourceConnectionLimiter) TryAcquire(cameraID, purpose string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	release, limitErr, _ := l.tryAcquire(cameraID, purpose)
+	if limitErr != nil {
+		return nil, limitErr
+	}
+	return release, nil
+}
+
+// Acquire waits for a connection slot until ctx is done or timeout elapses
+// (timeout 0 waits for ctx only)
+func (l *SourceConnectionLimiter) Acquire(ctx context.Context, cameraID, purpose string, timeout time.Duration) (func(), error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		l.mu.Lock()
+		release, limitErr, changed := l.tryAcquire(cameraID, purpose)
+		l.mu.Unlock()
+		if limitErr == nil {
+			return release, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, limitErr
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Holders returns the open connections per purpose for a camera
+func (l *SourceConnectionLimiter) Holders(cameraID string) (limit int, holders map[string]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conns, exists := l.cameras[cameraID]
+	if !exists {
+		return l.defaultLimit, map[string]int{}
+	}
+
+	holders = make(map[string]int, len(conns.holders))
+	for purpose, n := range conns.holders {
+		holders[purpose] = n
+	}
+	return l.effectiveLimit(conns), holders
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+)
+
+// sourceProbeTimeout bounds each RTSP request made while testing a source
+const sourceProbeTimeout = 5 * time.Second
+
+// SourceProbeResult describes an RTSP source as seen by a DESCRIBE, for POST /test-source.
+// Resolution and frame rate come from the SPS in the SDP and are omitted when the
+// camera doesn't advertise one.
+type SourceProbeResult struct {
+	Reachable  bool     `json:"reachable"` // The RTSP server answered
+	AuthOK     bool     `json:"authOk"`
+	HasVideo   bool     `json:"hasVideo"`
+	VideoCodec string   `json:"videoCodec,omitempty"`
+	Width      int      `json:"width,omitempty"`
+	Height     int      `json:"height,omitempty"`
+	FPS        float64  `json:"fps,omitempty"`
+	Codecs     []string `json:"codecs"`    // Every format the source offers
+	H264       bool     `json:"h264"`      // Direct WebRTC streaming needs an H.264 track
+	LatencyMs  int64    `json:"latencyMs"` // Time taken by the DESCRIBE
+	Error      string   `json:"error,omitempty"`
+
+	// H264Profile is the profile in the H.264 SPS, e.g. baseline or high
+	H264Profile string `json:"h264Profile,omitempty"`
+	// BitrateKbps is the video bitrate the source advertises, from the SPS's HRD
+	// parameters or the SDP's b= line; omitted when it advertises none
+	BitrateKbps int `json:"bitrateKbps,omitempty"`
+
+	// Detection is the probe of the separate detection stream, when one was given
+	Detection *SourceProbeResult `json:"detection,omitempty"`
+
+	h264SPS *h264.SPS // The parsed SPS of the H.264 track, for decideVideoMode
+}
+
+// preflightSource probes a source a camera is about to be started with, as POST
+// /test-source does, and reports why no video can be read from it. Sources not read
+// over RTSP can't be probed and pass, as does one the camera has no free connection for.
+func preflightSource(cameraID, sourceURL string) (SourceProbeResult, error) {
+	if isAdapterSource(sourceURL) || isDevSource(sourceURL) {
+		return SourceProbeResult{}, nil
+	}
+	release, err := sourceConnections.TryAcquire(cameraID, sourceConnProbe)
+	if err != nil {
+		log.Printf("Skipping the pre-flight probe for camera %s: %v", cameraID, err)
+		return SourceProbeResult{}, nil
+	}
+	probe := probeRTSPSource(sourceURL, "", "")
+	release()
+	if !probe.Reachable || !probe.AuthOK || !probe.HasVideo {
+		return probe, errors.New(probe.Error)
+	}
+	return probe, nil
+}
+
+// probeRTSPSource performs a DESCRIBE against the source without setting up or playing
+// any track, so it opens no process, MediaMTX path or database row. username and
+// password, when set, replace any credentials in the URL.
+func probeRTSPSource(rawURL, username, password string) SourceProbeResult {
+	result := SourceProbeResult{Codecs: []string{}}
+
+	rawURL, err := openSourceURL(rawURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	parsedURL, err := base.ParseURL(rawURL)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to parse RTSP URL: %v", err)
+		return result
+	}
+	if username != "" || password != "" {
+		parsedURL.User = url.UserPassword(username, password)
+	}
+
+	transport := gortsplib.TransportTCP
+	client := &gortsplib.Client{
+		Transport:    &transport,
+		ReadTimeout:  sourceProbeTimeout,
+		WriteTimeout: sourceProbeTimeout,
+	}
+	if err := client.Start(parsedURL.Scheme, parsedURL.Host); err != nil {
+		result.Error = fmt.Sprintf("failed to connect to RTSP server: %v", err)
+		return result
+	}
+	defer client.Close()
+
+	start := time.Now()
+	desc, response, err := client.Describe(parsedURL)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		var statusErr liberrors.ErrClientBadStatusCode
+		if errors.As(err, &statusErr) {
+			result.Reachable = true
+			result.AuthOK = statusErr.Code != base.StatusUnauthorized && statusErr.Code != base.StatusForbidden
+		}
+		result.Error = fmt.Sprintf("DESCRIBE request failed: %v", err)
+		return result
+	}
+	result.Reachable, result.AuthOK = true, true
+
+	// Describe the first H.264 track if there is one, since that's what direct WebRTC
+	// streaming uses, otherwise the first video track
+	var video format.Format
+	for _, media := range desc.Medias {
+		for _, candidate := range media.Formats {
+			result.Codecs = append(result.Codecs, candidate.Codec())
+			if media.Type != description.MediaTypeVideo {
+				continue
+			}
+			if _, isH264 := candidate.(*format.H264); isH264 && !result.H264 {
+				result.H264 = true
+				video = candidate
+			} else if video == nil {
+				video = candidate
+			}
+		}
+	}
+	if video != nil {
+		result.HasVideo = true
+		result.VideoCodec = video.Codec()
+		describeVideoFormat(video, &result)
+		if result.BitrateKbps == 0 && response != nil {
+			result.BitrateKbps = sdpVideoBitrateKbps(response.Body)
+		}
+	}
+	if !result.HasVideo {
+		result.Error = "source has no video track"
+	}
+	return result
+}
+
+// describeVideoFormat fills in resolution and frame rate from an H.264 or H.265 SPS
+func describeVideoFormat(videoFormat format.Format, result *SourceProbeResult) {
+	switch f := videoFormat.(type) {
+	case *format.H264:
+		spsData, _ := f.SafeParams()
+		var sps h264.SPS
+		if len(spsData) == 0 || sps.Unmarshal(spsData) != nil {
+			return
+		}
+		result.Width, result.Height, result.FPS = sps.Width(), sps.Height(), sps.FPS()
+		result.H264Profile = h264ProfileName(&sps)
+		result.BitrateKbps = h264HRDBitrateKbps(&sps)
+		result.h264SPS = &sps
+	case *format.H265:
+		_, spsData, _ := f.SafeParams()
+		var sps h265.SPS
+		if len(spsData) == 0 || sps.Unmarshal(spsData) != nil {
+			return
+		}
+		result.Width, result.Height, result.FPS = sps.Width(), sps.Height(), sps.FPS()
+	}
+}
+
+// h264ProfileName names the SPS's profile_idc
+func h264ProfileName(sps *h264.SPS) string {
+	switch sps.ProfileIdc {
+	case 66:
+		if sps.ConstraintSet1Flag {
+			return "constrained-baseline"
+		}
+		return "baseline"
+	case 77:
+		return "main"
+	case 88:
+		return "extended"
+	case 100:
+		return "high"
+	case 110:
+		return "high10"
+	case 122:
+		return "high422"
+	case 244:
+		return "high444"
+	default:
+		return fmt.Sprintf("profile-%d", sps.ProfileIdc)
+	}
+}
+
+// h264HRDBitrateKbps returns the maximum bitrate in the SPS's HRD parameters, 0 when
+// the SPS carries none
+func h264HRDBitrateKbps(sps *h264.SPS) int {
+	if sps.VUI == nil {
+		return 0
+	}
+	for _, hrd := range []*h264.SPS_HRD{sps.VUI.NalHRD, sps.VUI.VclHRD} {
+		if hrd == nil || len(hrd.BitRateValueMinus1) == 0 {
+			continue
+		}
+		bitsPerSecond := uint64(hrd.BitRateValueMinus1[0]+1) << (6 + hrd.BitRateScale)
+		return int(bitsPerSecond / 1000)
+	}
+	return 0
+}
+
+// sdpVideoBitrateKbps returns the bandwidth the SDP gives its first video media, from
+// b=AS (kbps) or b=TIAS (bps), 0 when there is none
+func sdpVideoBitrateKbps(sdp []byte) int {
+	inVideo := false
+	for _, line := range strings.Split(string(sdp), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "m=") {
+			if inVideo {
+				return 0 // Only the first video media counts
+			}
+			inVideo = strings.HasPrefix(line, "m=video ")
+			continue
+		}
+		if !inVideo {
+			continue
+		}
+		if value, found := strings.CutPrefix(line, "b=AS:"); found {
+			if kbps, err := strconv.Atoi(value); err == nil && kbps > 0 {
+				return kbps
+			}
+		}
+		if value, found := strings.CutPrefix(line, "b=TIAS:"); found {
+			if bps, err := strconv.Atoi(value); err == nil && bps > 0 {
+				return bps / 1000
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// How startReencodingProcess decides a new stream is live, selected by STREAM_START_CONFIRM
+const (
+	startConfirmData   = "data"   // Wait until media actually reaches the output (default)
+	startConfirmUptime = "uptime" // FFmpeg surviving startConfirmUptimeWindow is enough
+)
+
+// startConfirmUptimeWindow is how long FFmpeg must stay up in uptime mode
+const startConfirmUptimeWindow = 3 * time.Second
+
+// startConfirmPollInterval is how often the output is checked while confirming
+const startConfirmPollInterval = 500 * time.Millisecond
+
+// StartConfirmConfig controls how a stream start is confirmed
+type StartConfirmConfig struct {
+	Mode    string
+	Timeout time.Duration // Data mode: fail the start if no media is published within this
+}
+
+// loadStartConfirmConfig reads STREAM_START_CONFIRM and STREAM_START_CONFIRM_TIMEOUT
+func loadStartConfirmConfig() StartConfirmConfig {
+	config := StartConfirmConfig{
+		Mode:    startConfirmData,
+		Timeout: getEnvDuration("STREAM_START_CONFIRM_TIMEOUT", 15*time.Second),
+	}
+	switch mode := os.Getenv("STREAM_START_CONFIRM"); mode {
+	case "", startConfirmData:
+	case startConfirmUptime:
+		config.Mode = mode
+	default:
+		log.Printf("Unknown STREAM_START_CONFIRM %q, using %q", mode, startConfirmData)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 15 * time.Second
+	}
+	return config
+}
+
+var startConfirmConfig = StartConfirmConfig{Mode: startConfirmData, Timeout: 15 * time.Second}
+
+// outputPublished reports whether the process's output has received media. RTSP
+// outputs need a ready MediaMTX path with bytesReceived > 0, HLS a playlist written
+// since FFmpeg started. observable is false when the output can't tell (SRT, or the
+// MediaMTX API not answering), in which case FFmpeg's own frame count is used.
+func outputPublished(process *ReencodingProcess) (published, observable bool) {
+	switch process.Output.Type() {
+	case outputTypeRTSP:
+		info, exists, err := getMediaMTXPathInfo(process.Options.MediaMTX, cameraPathName(process.CameraID))
+		if err != nil {
+			return false, false
+		}
+		return exists && info.Ready && info.BytesReceived > 0, true
+	case outputTypeHLS, outputTypeLLHLS:
+		stat, err := os.Stat(process.Output.URL())
+		return err == nil && stat.ModTime().After(process.StartedAt), true
+	default:
+		return false, false
+	}
+}
+
+// confirmStreamStarted waits until the new process is live according to the
+// configured strategy. exited is closed when FFmpeg exits. In data mode a process that
+// is still running but has published nothing by the timeout counts as failed; when
+// neither the output nor FFmpeg's progress (metrics is nil without a progress pipe)
+// could be observed at all, surviving the timeout is accepted instead.
+func confirmStreamStarted(process *ReencodingProcess, metrics *StreamMetrics, exited <-chan struct{}) error {
+	config := startConfirmConfig
+	timeout := config.Timeout
+	if config.Mode == startConfirmUptime {
+		timeout = startConfirmUptimeWindow
+	}
+
+	ticker := time.NewTicker(startConfirmPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	observed := false
+	for {
+		select {
+		case <-exited:
+			return fmt.Errorf("FFmpeg process exited before the stream came up (%s), check RTSP source: %s",
+				process.Command.ProcessState.String(), process.SourceURL)
+		case <-process.Context.Done():
+			return fmt.Errorf("stream for camera %s was stopped before it came up", process.CameraID)
+		case <-deadline:
+			if config.Mode == startConfirmUptime || !observed {
+				if !observed && config.Mode == startConfirmData {
+					log.Printf("Could not observe media for camera %s, accepting the start after %v", process.CameraID, timeout)
+				}
+				return nil
+			}
+			return fmt.Errorf("no media published within %v, check RTSP source: %s", timeout, process.SourceURL)
+		case <-ticker.C:
+		}
+
+		if config.Mode == startConfirmUptime {
+			continue
+		}
+		published, observable := outputPublished(process)
+		if published {
+			return nil
+		}
+		observed = observed || observable
+		if !observable && metrics != nil {
+			observed = true
+			streamMetricsMutex.RLock()
+			frames := metrics.FramesProcessed
+			streamMetricsMutex.RUnlock()
+			if frames > 0 {
+				return nil
+			}
+		}
+	}
+}
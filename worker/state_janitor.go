@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// TrackedStateSizes reports how many entries the per-camera maps hold, for /metrics
+type TrackedStateSizes struct {
+	ActiveProcesses int `json:"activeProcesses"`
+	CircuitBreakers int `json:"circuitBreakers"`
+	StreamMetrics   int `json:"streamMetrics"`
+	FaceDetection   int `json:"faceDetection"`
+	AdaptiveBitrate int `json:"adaptiveBitrate"`
+	Snapshots       int `json:"snapshots"`
+}
+
+// touch marks the breaker as used by a stream start; caller holds circuitBreakersMutex
+func (cb *CircuitBreaker) touch() {
+	cb.mu.Lock()
+	cb.lastUsed = time.Now()
+	cb.mu.Unlock()
+}
+
+// idleSince returns when the breaker last saw a start or a failure
+func (cb *CircuitBreaker) idleSince() (time.Time, string) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	last := cb.lastUsed
+	if cb.LastFailureTime.After(last) {
+		last = cb.LastFailureTime
+	}
+	return last, cb.State
+}
+
+// runStateJanitor periodically drops per-camera state left behind by cameras that
+// are no longer streaming, so a long-running worker with transient cameras doesn't leak
+func runStateJanitor() {
+	if timingConfig.StateCleanupInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(timingConfig.StateCleanupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		breakers, metrics, detections := sweepCameraState(timingConfig.BreakerIdleTTL)
+		if breakers+metrics+detections > 0 {
+			log.Printf("State cleanup: removed %d idle circuit breaker(s), %d orphaned stream metric(s), %d orphaned face detection(s)",
+				breakers, metrics, detections)
+		}
+	}
+}
+
+// sweepCameraState removes closed breakers idle for longer than idleTTL, plus metrics
+// and face detection entries whose camera has no process (normally the process monitor
+// removes these; this catches paths that exit without reaching it)
+func sweepCameraState(idleTTL time.Duration) (breakers, metrics, detections int) {
+	// Held throughout so no camera starts mid-sweep; the other locks nest inside it,
+	// matching startReencodingProcess and stopReencodingProcess
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+
+	// Open and half-open breakers are kept so a failing camera can't dodge its cooldown
+	if idleTTL > 0 {
+		circuitBreakersMutex.Lock()
+		for cameraID, cb := range circuitBreakers {
+			if _, running := activeProcesses[cameraID]; running {
+				continue
+			}
+			if last, state := cb.idleSince(); state == "closed" && time.Since(last) > idleTTL {
+				delete(circuitBreakers, cameraID)
+				breakers++
+			}
+		}
+		circuitBreakersMutex.Unlock()
+	}
+
+	// Restart counts go with the breaker, so a camera that is still failing keeps them
+	circuitBreakersMutex.RLock()
+	forgetFFmpegRestarts()
+	circuitBreakersMutex.RUnlock()
+
+	streamMetricsMutex.Lock()
+	for cameraID := range streamMetrics {
+		if _, running := activeProcesses[cameraID]; !running {
+			delete(streamMetrics, cameraID)
+			metrics++
+		}
+	}
+	streamMetricsMutex.Unlock()
+
+	faceDetectionMutex.Lock()
+	for cameraID, cancel := range faceDetectionActive {
+		if _, running := activeProcesses[cameraID]; !running {
+			cancel()
+			delete(faceDetectionActive, cameraID)
+			detections++
+		}
+	}
+	faceDetectionMutex.Unlock()
+
+	// A stopped camera starts over at the full bitrate
+	forgetAdaptiveBitrates()
+
+	// Logs of a stopped camera are kept for one sweep interval at most
+	ffmpegLogs.forgetFFmpegLogs()
+
+	// Cached snapshots of cameras nobody asks for anymore
+	snapshotCache.forgetExpired()
+
+	return breakers, metrics, detections
+}
+
+// trackedStateSizes counts the entries in each per-camera map
+func trackedStateSizes() TrackedStateSizes {
+	var sizes TrackedStateSizes
+
+	processMutex.RLock()
+	sizes.ActiveProcesses = len(activeProcesses)
+	processMutex.RUnlock()
+
+	circuitBreakersMutex.RLock()
+	sizes.CircuitBreakers = len(circuitBreakers)
+	circuitBreakersMutex.RUnlock()
+
+	streamMetricsMutex.RLock()
+	sizes.StreamMetrics = len(streamMetrics)
+	streamMetricsMutex.RUnlock()
+
+	faceDetectionMutex.RLock()
+	sizes.FaceDetection = len(faceDetectionActive)
+	faceDetectionMutex.RUnlock()
+
+	adaptiveBitratesMutex.RLock()
+	sizes.AdaptiveBitrate = len(adaptiveBitrates)
+	adaptiveBitratesMutex.RUnlock()
+
+	sizes.Snapshots = snapshotCache.Len()
+
+	return sizes
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// startTransientCamera registers the per-camera state a stream start creates
+func startTransientCamera(cameraID string) {
+	claimCircuitBreaker(cameraID, StreamOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	processMutex.Lock()
+	activeProcesses[cameraID] = &ReencodingProcess{CameraID: cameraID, Context: ctx, Cancel: cancel}
+	streamMetricsMutex.Lock()
+	streamMetrics[cameraID] = &StreamMetrics{CameraID: cameraID, StartTime: time.Now()}
+	streamMetricsMutex.Unlock()
+	registerFaceDetection(cameraID, ctx)
+	processMutex.Unlock()
+}
+
+func TestStateMapsStayBoundedAcrossStartStopCycles(t *testing.T) {
+	const (
+		cycles     = 200
+		sweepEvery = 20
+	)
+	before := trackedStateSizes()
+
+	// One camera keeps running throughout, and one keeps failing with an open breaker
+	startTransientCamera("cam-steady")
+	failing := claimCircuitBreaker("cam-failing", StreamOptions{BreakerWarmupSeconds: -1})
+	for i := 0; i < max(failing.MaxFailures, 1); i++ {
+		failing.RecordFailure()
+	}
+	t.Cleanup(func() {
+		stopReencodingProcess("cam-steady")
+		circuitBreakersMutex.Lock()
+		delete(circuitBreakers, "cam-steady")
+		delete(circuitBreakers, "cam-failing")
+		circuitBreakersMutex.Unlock()
+		sweepCameraState(0)
+	})
+
+	for i := 0; i < cycles; i++ {
+		cameraID := fmt.Sprintf("cam-transient-%d", i)
+		startTransientCamera(cameraID)
+		if !stopReencodingProcess(cameraID) {
+			t.Fatalf("cycle %d: %s wasn't running", i, cameraID)
+		}
+
+		if (i+1)%sweepEvery != 0 {
+			continue
+		}
+		// Between sweeps only the cameras since the last one have left state behind
+		sizes := trackedStateSizes()
+		if grown := sizes.CircuitBreakers - before.CircuitBreakers; grown > sweepEvery+2 {
+			t.Fatalf("cycle %d: %d circuit breakers added, want at most %d", i, grown, sweepEvery+2)
+		}
+		sweepCameraState(time.Nanosecond)
+
+		sizes = trackedStateSizes()
+		if got, want := sizes.CircuitBreakers, before.CircuitBreakers+2; got != want {
+			t.Fatalf("cycle %d: %d circuit breakers after the sweep, want %d (steady and failing)", i, got, want)
+		}
+		if got, want := sizes.StreamMetrics, before.StreamMetrics+1; got != want {
+			t.Fatalf("cycle %d: %d stream metrics after the sweep, want %d", i, got, want)
+		}
+		if got, want := sizes.FaceDetection, before.FaceDetection+1; got != want {
+			t.Fatalf("cycle %d: %d face detections after the sweep, want %d", i, got, want)
+		}
+		if got, want := sizes.ActiveProcesses, before.ActiveProcesses+1; got != want {
+			t.Fatalf("cycle %d: %d active processes, want %d", i, got, want)
+		}
+	}
+
+	circuitBreakersMutex.RLock()
+	_, steadyKept := circuitBreakers["cam-steady"]
+	_, failingKept := circuitBreakers["cam-failing"]
+	circuitBreakersMutex.RUnlock()
+	if !steadyKept || !failingKept {
+		t.Fatalf("sweep dropped a running camera's (%v) or an open (%v) breaker", steadyKept, failingKept)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunWorkersBoundsConcurrency(t *testing.T) {
+	const (
+		count   = 40
+		workers = 5
+	)
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	done := make(map[int]int)
+	runWorkers(count, workers, func(i int) {
+		now := running.Add(1)
+		for {
+			seen := peak.Load()
+			if now <= seen || peak.CompareAndSwap(seen, now) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		mu.Lock()
+		done[i]++
+		mu.Unlock()
+	})
+
+	if got := peak.Load(); got > workers {
+		t.Fatalf("%d calls ran at once, want at most %d", got, workers)
+	}
+	for i := 0; i < count; i++ {
+		if done[i] != 1 {
+			t.Fatalf("index %d ran %d times, want once", i, done[i])
+		}
+	}
+
+	// No work, or a non-positive worker count, still returns
+	runWorkers(0, workers, func(int) { t.Fatal("called with no work") })
+	calls := 0
+	runWorkers(3, 0, func(int) { calls++ })
+	if calls != 3 {
+		t.Fatalf("with 0 workers: %d calls, want 3", calls)
+	}
+}
+
+func TestStopAllDrainsStreams(t *testing.T) {
+	var cameraIDs []string
+	for i := 0; i < 3*streamDrainWorkers; i++ {
+		cameraID := fmt.Sprintf("cam-drain-%02d", i)
+		cameraIDs = append(cameraIDs, cameraID)
+		startTransientCamera(cameraID)
+	}
+	t.Cleanup(func() {
+		circuitBreakersMutex.Lock()
+		for _, cameraID := range cameraIDs {
+			delete(circuitBreakers, cameraID)
+		}
+		circuitBreakersMutex.Unlock()
+		sweepCameraState(0)
+	})
+
+	recorder := httptest.NewRecorder()
+	body := `{"selector": {"glob": "cam-drain-*"}}`
+	newRouter(NewMemoryCameraStore()).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/stop-all", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("POST /stop-all = %d: %s", recorder.Code, recorder.Body)
+	}
+	var resp struct {
+		Total       int      `json:"total"`
+		Cameras     []string `json:"cameras"`
+		Stopped     int      `json:"stopped"`
+		ForceKilled []string `json:"forceKilled"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != len(cameraIDs) || resp.Stopped != len(cameraIDs) || len(resp.ForceKilled) != 0 {
+		t.Fatalf("response %+v, want all %d cameras stopped and none force-killed", resp, len(cameraIDs))
+	}
+
+	processMutex.RLock()
+	defer processMutex.RUnlock()
+	for _, cameraID := range cameraIDs {
+		if _, running := activeProcesses[cameraID]; running {
+			t.Errorf("%s still running after /stop-all", cameraID)
+		}
+	}
+}
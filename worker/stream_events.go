@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Stream lifecycle event types
+const (
+	streamEventEvicted        = "stream.evicted"
+	streamEventStalled        = "stream.stalled"
+	streamEventFrozen         = "stream.frozen"
+	streamEventUnfrozen       = "stream.unfrozen"
+	streamEventBitrateChanged = "stream.bitrate_changed"
+	streamEventClipReady      = "clip.ready"
+	streamEventClipFailed     = "clip.failed"
+
+	streamEventRecordingStarted = "recording.started"
+	streamEventRecordingReady   = "recording.ready"
+	streamEventRecordingFailed  = "recording.failed"
+
+	streamEventAudioMuted   = "audio.muted"
+	streamEventAudioUnmuted = "audio.unmuted"
+)
+
+// streamEventHistory is how many recent events GET /events can return
+const streamEventHistory = 500
+
+// StreamEvent is a lifecycle event other services can react to, e.g. to reschedule
+// an evicted camera on another worker
+type StreamEvent struct {
+	Type      string                 `json:"type"`
+	CameraID  string                 `json:"cameraId"`
+	Reason    string                 `json:"reason,omitempty"`
+	At        time.Time              `json:"at"`        // UTC
+	LocalTime string                 `json:"localTime"` // At in the camera's timezone
+	Timezone  string                 `json:"timezone"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// StreamEventBus keeps recent events in memory and forwards them to Kafka when available.
+// Events go to their own topic so the alert consumers never see them.
+type StreamEventBus struct {
+	recent []StreamEvent // Oldest first
+	writer *kafka.Writer
+	mu     sync.Mutex
+}
+
+// NewStreamEventBus creates an in-memory-only bus; call AttachKafka to forward events
+func NewStreamEventBus() *StreamEventBus {
+	return &StreamEventBus{}
+}
+
+// AttachKafka forwards events to KAFKA_STREAM_EVENTS_TOPIC (default "stream-events")
+// on the producer's brokers
+func (b *StreamEventBus) AttachKafka(producer *KafkaProducer) {
+	if producer == nil || producer.writer == nil {
+		return
+	}
+
+	topic := os.Getenv("KAFKA_STREAM_EVENTS_TOPIC")
+	if topic == "" {
+		topic = "stream-events"
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writer = &kafka.Writer{
+		Addr:         producer.writer.Addr,
+		Topic:        topic,
+		Balancer:     &kafka.Hash{}, // Keep a camera's events ordered on one partition
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+	}
+	log.Printf("Stream events will be published to Kafka topic '%s'", topic)
+}
+
+// Publish records an event and forwards it to Kafka in the background
+func (b *StreamEventBus) Publish(event StreamEvent) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	event.At = event.At.UTC()
+	location := cameraLocation(event.CameraID)
+	event.LocalTime = localTimestamp(event.At, location)
+	event.Timezone = location.String()
+
+	b.mu.Lock()
+	b.recent = append(b.recent, event)
+	if excess := len(b.recent) - streamEventHistory; excess > 0 {
+		b.recent = append(b.recent[:0], b.recent[excess:]...)
+	}
+	writer := b.writer
+	b.mu.Unlock()
+
+	log.Printf("Stream event %s for camera %s: %s", event.Type, event.CameraID, event.Reason)
+
+	if writer == nil {
+		return
+	}
+	go func() {
+		value, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to marshal stream event: %v", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := writer.WriteMessages(ctx, kafka.Message{Key: []byte(event.CameraID), Value: value, Time: event.At}); err != nil {
+			log.Printf("Failed to publish stream event %s for camera %s: %v", event.Type, event.CameraID, err)
+		}
+	}()
+}
+
+// Recent returns events newer than since, optionally filtered by camera
+func (b *StreamEventBus) Recent(cameraID string, since time.Time) []StreamEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := []StreamEvent{}
+	for _, event := range b.recent {
+		if event.At.After(since) && (cameraID == "" || event.CameraID == cameraID) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Close closes the Kafka writer, if any
+func (b *StreamEventBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.writer != nil {
+		return b.writer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// StreamOptions holds per-camera settings for the re-encoding pipeline.
+// Unset fields fall back to the worker defaults.
+type StreamOptions struct {
+	Audio    *AudioOptions    `json:"audio,omitempty"`
+	Observer *ObserverOptions `json:"observer,omitempty"`
+	Output   *OutputOptions   `json:"output,omitempty"`
+
+	// Input sizes FFmpeg's RTSP read buffer and demuxer delay for the camera
+	Input *InputOptions `json:"input,omitempty"`
+
+	// SourceRetry is how the worker's own readers of the camera (WHEP, detection)
+	// reconnect to it (nil = RTSP_RECONNECT_*)
+	SourceRetry *SourceRetryOptions `json:"sourceRetry,omitempty"`
+
+	// Filters deinterlace, denoise, crop or scale the picture before it is encoded
+	Filters *VideoFilterOptions `json:"filters,omitempty"`
+
+	// Encoding overrides the libx264 settings: profile, bitrate, keyframe interval, preset
+	Encoding *EncodingProfile `json:"encodingProfile,omitempty"`
+
+	// VideoMode is transcode, or auto to copy video the source already sends in a
+	// WebRTC-compatible form (empty = VIDEO_MODE); see decideVideoMode
+	VideoMode string `json:"videoMode,omitempty"`
+
+	// Dual-stream cameras: ViewingRTSPURL is re-encoded in place of the camera's rtspUrl
+	// (typically the main stream) and face detection reads DetectionRTSPURL (typically the
+	// low-res sub stream). Empty means the camera's rtspUrl.
+	ViewingRTSPURL   string `json:"viewingRtspUrl,omitempty"`
+	DetectionRTSPURL string `json:"detectionRtspUrl,omitempty"`
+
+	// MediaMTX picks the MediaMTX instance the camera publishes to (nil = MEDIAMTX_API_URL/MEDIAMTX_URL)
+	MediaMTX *MediaMTXInstance `json:"mediamtx,omitempty"`
+
+	// MaxSourceConnections caps connections the worker opens to the camera (0 = SOURCE_MAX_CONNECTIONS)
+	MaxSourceConnections int `json:"maxSourceConnections,omitempty"`
+
+	// Priority decides which streams may be evicted at capacity; higher wins (nil = 0).
+	// A pointer so an update can set a camera back to 0.
+	Priority *int `json:"priority,omitempty"`
+
+	// CPUAffinity pins the camera's FFmpeg to these CPUs, e.g. "2-3" (needs
+	// CPU_AFFINITY_ENABLED=true on Linux; ignored otherwise)
+	CPUAffinity string `json:"cpuAffinity,omitempty"`
+
+	// WatchdogStallSeconds restarts the stream when output stops advancing this long
+	// (0 = WATCHDOG_STALL_TIMEOUT, negative disables)
+	WatchdogStallSeconds int `json:"watchdogStallSeconds,omitempty"`
+
+	// BreakerWarmupSeconds is how long after the camera's circuit breaker is created its
+	// failures aren't counted (0 = CIRCUIT_BREAKER_WARMUP, negative disables)
+	BreakerWarmupSeconds int `json:"breakerWarmupSeconds,omitempty"`
+
+	// FrameProcessors is the camera's detection chain in order (empty = FRAME_PROCESSORS)
+	FrameProcessors []string `json:"frameProcessors,omitempty"`
+
+	// Weight is how many MAX_CONCURRENT_STREAMS slots the stream takes (0 = derived from
+	// its outputs; see streamWeight)
+	Weight int `json:"weight,omitempty"`
+}
+
+// AudioOptions controls how the source audio track is handled
+type AudioOptions struct {
+	Codec      string `json:"codec,omitempty"`      // aac | opus | copy | none
+	Bitrate    string `json:"bitrate,omitempty"`    // e.g. "64k"
+	SampleRate int    `json:"sampleRate,omitempty"` // Hz, e.g. 44100
+
+	// MuteSchedule drops audio (-an) during these windows, for privacy compliance;
+	// the encoder restarts at each boundary
+	MuteSchedule []AudioMuteWindow `json:"muteSchedule,omitempty"`
+}
+
+// ObserverOptions tees the re-encoded output to a secondary endpoint for QA.
+// An empty URL disables the tee.
+type ObserverOptions struct {
+	URL    string `json:"url"`              // rtsp(s)://... or an HLS playlist (.m3u8) path/URL
+	Format string `json:"format,omitempty"` // rtsp | hls; inferred from URL when empty
+}
+
+// InputOptions tune how FFmpeg reads the camera over RTSP. A bigger buffer and delay
+// ride out bursts and reordering on high-bitrate or lossy links at the cost of
+// latency; a LAN camera can use smaller ones for a faster picture. Zero fields keep
+// the defaults.
+type InputOptions struct {
+	BufferSizeKB int `json:"bufferSizeKb,omitempty"` // Socket receive buffer (FFmpeg buffer_size)
+	MaxDelayMs   int `json:"maxDelayMs,omitempty"`   // Demuxer reorder/jitter delay (FFmpeg max_delay)
+}
+
+// SourceRetryOptions overrides the RTSP_RECONNECT_* policy for one camera, e.g.
+// retrying forever for a solar-powered camera that sleeps. Zero fields keep the
+// defaults.
+type SourceRetryOptions struct {
+	MaxAttempts         int `json:"maxAttempts,omitempty"` // -1 retries forever
+	InitialDelaySeconds int `json:"initialDelaySeconds,omitempty"`
+	MaxDelaySeconds     int `json:"maxDelaySeconds,omitempty"` // Cap for the doubling delay
+}
+
+// Validate checks the retry settings
+func (r *SourceRetryOptions) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.MaxAttempts < -1 {
+		return fmt.Errorf("sourceRetry maxAttempts must be -1 (forever) or more")
+	}
+	if r.InitialDelaySeconds < 0 || r.MaxDelaySeconds < 0 {
+		return fmt.Errorf("sourceRetry delays must not be negative")
+	}
+	if r.InitialDelaySeconds > 0 && r.MaxDelaySeconds > 0 && r.MaxDelaySeconds < r.InitialDelaySeconds {
+		return fmt.Errorf("sourceRetry maxDelaySeconds must be at least initialDelaySeconds")
+	}
+	return nil
+}
+
+// policy returns streamManagerRetry with the fields set in r applied
+func (r *SourceRetryOptions) policy() RTSPRetryPolicy {
+	policy := streamManagerRetry
+	if r == nil {
+		return policy
+	}
+	switch {
+	case r.MaxAttempts == -1:
+		policy.MaxAttempts = 0
+	case r.MaxAttempts > 0:
+		policy.MaxAttempts = r.MaxAttempts
+	}
+	if r.InitialDelaySeconds > 0 {
+		policy.InitialDelay = time.Duration(r.InitialDelaySeconds) * time.Second
+	}
+	if r.MaxDelaySeconds > 0 {
+		policy.MaxDelay = time.Duration(r.MaxDelaySeconds) * time.Second
+	}
+	policy.MaxDelay = max(policy.MaxDelay, policy.InitialDelay)
+	return policy
+}
+
+// Default and allowed input settings; the defaults match the original hardcoded
+// FFmpeg arguments
+const (
+	defaultInputBufferSizeKB = 4000 // 4MB buffer (increased for unstable streams)
+	defaultInputMaxDelayMs   = 5000 // 5 second max demux delay
+	minInputBufferSizeKB     = 64
+	maxInputBufferSizeKB     = 65536
+	minInputMaxDelayMs       = 10
+	maxInputMaxDelayMs       = 10000
+)
+
+// Default audio settings, matching the original hardcoded FFmpeg arguments
+const (
+	defaultAudioCodec      = "aac"
+	defaultAudioBitrate    = "64k"
+	defaultAudioSampleRate = 44100
+)
+
+// Opus only supports these sample rates; 48000 is what browsers decode natively
+var opusSampleRates = map[int]bool{8000: true, 12000: true, 16000: true, 24000: true, 48000: true}
+
+// audioCodecsByFormat lists the audio codecs each output container can carry
+var audioCodecsByFormat = map[string]map[string]bool{
+	"rtsp":   {"aac": true, "opus": true, "copy": true, "none": true},
+	"mpegts": {"aac": true, "copy": true, "none": true},
+	"hls":    {"aac": true, "copy": true, "none": true},
+}
+
+// withDefaults returns a copy with unset fields filled in
+func (a *AudioOptions) withDefaults() AudioOptions {
+	resolved := AudioOptions{Codec: defaultAudioCodec}
+	if a != nil {
+		resolved = *a
+	}
+	resolved.Codec = strings.ToLower(resolved.Codec)
+	if resolved.Codec == "" {
+		resolved.Codec = defaultAudioCodec
+	}
+
+	switch resolved.Codec {
+	case "aac":
+		if resolved.Bitrate == "" {
+			resolved.Bitrate = defaultAudioBitrate
+		}
+		if resolved.SampleRate == 0 {
+			resolved.SampleRate = defaultAudioSampleRate
+		}
+	case "opus":
+		if resolved.Bitrate == "" {
+			resolved.Bitrate = defaultAudioBitrate
+		}
+		if resolved.SampleRate == 0 {
+			resolved.SampleRate = 48000
+		}
+	}
+	return resolved
+}
+
+// Validate checks the audio settings against the output container format
+func (a *AudioOptions) Validate(outputFormat string) error {
+	resolved := a.withDefaults()
+	for i, window := range resolved.MuteSchedule {
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("audio muteSchedule[%d]: %w", i, err)
+		}
+	}
+
+	switch resolved.Codec {
+	case "aac", "opus":
+		if err := validateBitrate(resolved.Bitrate, 8, 512); err != nil {
+			return fmt.Errorf("audio bitrate: %w", err)
+		}
+		if resolved.SampleRate < 8000 || resolved.SampleRate > 96000 {
+			return fmt.Errorf("audio sample rate %d out of range (8000-96000)", resolved.SampleRate)
+		}
+		if resolved.Codec == "opus" && !opusSampleRates[resolved.SampleRate] {
+			return fmt.Errorf("opus does not support sample rate %d (use 48000)", resolved.SampleRate)
+		}
+	case "copy", "none":
+		if a != nil && (a.Bitrate != "" || a.SampleRate != 0) {
+			return fmt.Errorf("audio codec %q does not accept bitrate or sample rate", resolved.Codec)
+		}
+	default:
+		return fmt.Errorf("unsupported audio codec %q (expected aac, opus, copy or none)", resolved.Codec)
+	}
+
+	if codecs, known := audioCodecsByFormat[outputFormat]; known && !codecs[resolved.Codec] {
+		return fmt.Errorf("audio codec %q cannot be used with %s output", resolved.Codec, outputFormat)
+	}
+	return nil
+}
+
+// ffmpegArgs returns the FFmpeg output arguments for the audio settings
+func (a *AudioOptions) ffmpegArgs() ffmpeg.KwArgs {
+	resolved := a.withDefaults()
+
+	switch resolved.Codec {
+	case "none":
+		return ffmpeg.KwArgs{"an": ""} // Drop audio entirely
+	case "copy":
+		return ffmpeg.KwArgs{"c:a": "copy"}
+	case "opus":
+		return ffmpeg.KwArgs{
+			"c:a": "libopus",
+			"b:a": resolved.Bitrate,
+			"ar":  strconv.Itoa(resolved.SampleRate),
+		}
+	default:
+		return ffmpeg.KwArgs{
+			"c:a": "aac",
+			"b:a": resolved.Bitrate,
+			"ar":  strconv.Itoa(resolved.SampleRate),
+		}
+	}
+}
+
+// Enabled reports whether a secondary observer output is configured
+func (o *ObserverOptions) Enabled() bool {
+	return o != nil && o.URL != ""
+}
+
+// resolvedFormat returns the explicit format or infers it from the URL
+func (o *ObserverOptions) resolvedFormat() string {
+	if o.Format != "" {
+		return strings.ToLower(o.Format)
+	}
+	if strings.HasSuffix(strings.ToLower(o.URL), ".m3u8") {
+		return "hls"
+	}
+	return "rtsp"
+}
+
+// Validate checks the observer endpoint is something FFmpeg's tee muxer can write to
+func (o *ObserverOptions) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if strings.Contains(o.URL, "|") {
+		return fmt.Errorf("observer url must not contain '|'")
+	}
+
+	switch o.resolvedFormat() {
+	case "rtsp":
+		parsed, err := url.Parse(o.URL)
+		if err != nil || (parsed.Scheme != "rtsp" && parsed.Scheme != "rtsps") || parsed.Host == "" {
+			return fmt.Errorf("observer url %q is not a valid rtsp:// URL", o.URL)
+		}
+		if err := validateURLHost(parsed); err != nil {
+			return fmt.Errorf("observer url: %w", err)
+		}
+		// The tee muxer only reads options from a leading [...], so an IPv6 host's
+		// brackets are fine; anywhere else they're likely a mangled spec
+		if strings.ContainsAny(parsed.User.String()+parsed.Path+parsed.RawQuery, "[]") {
+			return fmt.Errorf("observer url must not contain '[' or ']' outside an IPv6 host")
+		}
+	case "hls":
+		if strings.ContainsAny(o.URL, "[]") {
+			return fmt.Errorf("observer hls url must not contain '[' or ']'")
+		}
+		if !strings.HasSuffix(strings.ToLower(o.URL), ".m3u8") {
+			return fmt.Errorf("observer hls url %q must point to a .m3u8 playlist", o.URL)
+		}
+	default:
+		return fmt.Errorf("unsupported observer format %q (expected rtsp or hls)", o.Format)
+	}
+	return nil
+}
+
+// teeTarget builds the tee muxer output spec writing to primaryURL and the observer.
+// The observer slave uses onfail=ignore so a broken QA endpoint never stops the main stream.
+func (o *ObserverOptions) teeTarget(primaryURL string) string {
+	primary := fmt.Sprintf("[f=rtsp:rtsp_transport=tcp]%s", primaryURL)
+
+	var observer string
+	switch o.resolvedFormat() {
+	case "hls":
+		observer = fmt.Sprintf("[f=hls:hls_time=2:hls_list_size=5:hls_flags=delete_segments:onfail=ignore]%s", o.URL)
+	default:
+		observer = fmt.Sprintf("[f=rtsp:rtsp_transport=tcp:onfail=ignore]%s", o.URL)
+	}
+	return primary + "|" + observer
+}
+
+// Validate checks the input settings are in range
+func (i *InputOptions) Validate() error {
+	if i == nil {
+		return nil
+	}
+	if i.BufferSizeKB != 0 && (i.BufferSizeKB < minInputBufferSizeKB || i.BufferSizeKB > maxInputBufferSizeKB) {
+		return fmt.Errorf("input bufferSizeKb %d out of range (%d-%d)", i.BufferSizeKB, minInputBufferSizeKB, maxInputBufferSizeKB)
+	}
+	if i.MaxDelayMs != 0 && (i.MaxDelayMs < minInputMaxDelayMs || i.MaxDelayMs > maxInputMaxDelayMs) {
+		return fmt.Errorf("input maxDelayMs %d out of range (%d-%d)", i.MaxDelayMs, minInputMaxDelayMs, maxInputMaxDelayMs)
+	}
+	return nil
+}
+
+// ffmpegArgs returns the buffer_size and max_delay input arguments, with the defaults
+// for unset fields
+func (i *InputOptions) ffmpegArgs() ffmpeg.KwArgs {
+	bufferSizeKB, maxDelayMs := defaultInputBufferSizeKB, defaultInputMaxDelayMs
+	if i != nil && i.BufferSizeKB != 0 {
+		bufferSizeKB = i.BufferSizeKB
+	}
+	if i != nil && i.MaxDelayMs != 0 {
+		maxDelayMs = i.MaxDelayMs
+	}
+	return ffmpeg.KwArgs{
+		"buffer_size": strconv.Itoa(bufferSizeKB * 1000), // Bytes
+		"max_delay":   strconv.Itoa(maxDelayMs * 1000),   // Microseconds
+	}
+}
+
+// getObserverURL returns the read-only RTSP URL an operator can pull the worker's
+// re-encoded output from. OBSERVER_RTSP_BASE_URL overrides the host for remote access;
+// cameras on another MediaMTX instance report that instance's publish URL.
+func getObserverURL(instance *MediaMTXInstance, cameraID string) string {
+	baseURL := os.Getenv("OBSERVER_RTSP_BASE_URL")
+	if baseURL == "" || !instance.IsDefault() {
+		return getReencodedStreamURL(instance, cameraID)
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimRight(baseURL, "/"), cameraPathName(cameraID))
+}
+
+// validateBitrate checks an FFmpeg bitrate string like "64k" lies within [minK, maxK] kbit/s
+func validateBitrate(bitrate string, minK, maxK int) error {
+	kbps, err := parseBitrateKbps(bitrate)
+	if err != nil {
+		return err
+	}
+	if kbps < minK || kbps > maxK {
+		return fmt.Errorf("bitrate %q out of range (%dk-%dk)", bitrate, minK, maxK)
+	}
+	return nil
+}
+
+// parseBitrateKbps converts an FFmpeg bitrate string like "64k" or "2M" to kbit/s
+func parseBitrateKbps(bitrate string) (int, error) {
+	value := strings.ToLower(strings.TrimSpace(bitrate))
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "k"):
+		value = strings.TrimSuffix(value, "k")
+	case strings.HasSuffix(value, "m"):
+		value = strings.TrimSuffix(value, "m")
+		multiplier = 1000
+	default:
+		return 0, fmt.Errorf("invalid bitrate %q (expected a value like 64k or 2M)", bitrate)
+	}
+
+	kbps, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bitrate %q (expected a value like 64k or 2M)", bitrate)
+	}
+	return kbps * multiplier, nil
+}
+
+// Merge overlays the fields set in override onto o
+func (o StreamOptions) Merge(override StreamOptions) StreamOptions {
+	if override.Audio != nil {
+		o.Audio = override.Audio
+	}
+	if override.Observer != nil {
+		o.Observer = override.Observer
+	}
+	if override.Output != nil {
+		o.Output = override.Output
+	}
+	if override.Input != nil {
+		o.Input = override.Input
+	}
+	if override.SourceRetry != nil {
+		o.SourceRetry = override.SourceRetry
+	}
+	if override.Filters != nil {
+		o.Filters = override.Filters
+	}
+	if override.Encoding != nil {
+		o.Encoding = override.Encoding
+	}
+	if override.VideoMode != "" {
+		o.VideoMode = override.VideoMode
+	}
+	if override.ViewingRTSPURL != "" {
+		o.ViewingRTSPURL = override.ViewingRTSPURL
+	}
+	if override.DetectionRTSPURL != "" {
+		o.DetectionRTSPURL = override.DetectionRTSPURL
+	}
+	o.MediaMTX = o.MediaMTX.merge(override.MediaMTX)
+	if override.MaxSourceConnections != 0 {
+		o.MaxSourceConnections = override.MaxSourceConnections
+	}
+	if override.Priority != nil {
+		o.Priority = override.Priority
+	}
+	if override.CPUAffinity != "" {
+		o.CPUAffinity = override.CPUAffinity
+	}
+	if override.WatchdogStallSeconds != 0 {
+		o.WatchdogStallSeconds = override.WatchdogStallSeconds
+	}
+	if override.BreakerWarmupSeconds != 0 {
+		o.BreakerWarmupSeconds = override.BreakerWarmupSeconds
+	}
+	if len(override.FrameProcessors) > 0 {
+		o.FrameProcessors = override.FrameProcessors
+	}
+	if override.Weight != 0 {
+		o.Weight = override.Weight
+	}
+	return o
+}
+
+// priority returns the camera's eviction priority, 0 when unset
+func (o StreamOptions) priority() int {
+	if o.Priority == nil {
+		return 0
+	}
+	return *o.Priority
+}
+
+// IsZero reports whether no option is set
+func (o StreamOptions) IsZero() bool {
+	return o.Audio == nil && o.Observer == nil && o.Output == nil && o.Input == nil && o.SourceRetry == nil && o.Filters == nil && o.Encoding == nil && o.VideoMode == "" && o.MaxSourceConnections == 0 && o.Priority == nil && o.CPUAffinity == "" &&
+		o.WatchdogStallSeconds == 0 && o.MediaMTX == nil && o.ViewingRTSPURL == "" &&
+		o.DetectionRTSPURL == "" && o.Weight == 0 && o.BreakerWarmupSeconds == 0 && len(o.FrameProcessors) == 0
+}
+
+// Validate checks every option for the given output format
+func (o StreamOptions) Validate(outputFormat string) error {
+	if err := o.Audio.Validate(outputFormat); err != nil {
+		return err
+	}
+	if err := o.Observer.Validate(); err != nil {
+		return err
+	}
+	if err := o.Output.Validate(); err != nil {
+		return err
+	}
+	if err := o.Input.Validate(); err != nil {
+		return err
+	}
+	if err := o.SourceRetry.Validate(); err != nil {
+		return err
+	}
+	if err := o.MediaMTX.Validate(); err != nil {
+		return err
+	}
+	if err := o.Filters.Validate(); err != nil {
+		return fmt.Errorf("filters: %w", err)
+	}
+	if err := o.Encoding.Validate(); err != nil {
+		return fmt.Errorf("encodingProfile: %w", err)
+	}
+	if err := validateVideoMode(o.VideoMode); err != nil {
+		return err
+	}
+	if o.ViewingRTSPURL != "" {
+		if err := validateRTSPURL(o.ViewingRTSPURL); err != nil {
+			return fmt.Errorf("viewingRtspUrl: %w", err)
+		}
+	}
+	if o.DetectionRTSPURL != "" {
+		if err := validateRTSPURL(o.DetectionRTSPURL); err != nil {
+			return fmt.Errorf("detectionRtspUrl: %w", err)
+		}
+	}
+	if o.Observer.Enabled() && o.Output.resolvedType() != outputTypeRTSP {
+		return fmt.Errorf("observer output is only supported with rtsp output")
+	}
+	if o.MaxSourceConnections < 0 {
+		return fmt.Errorf("maxSourceConnections must not be negative")
+	}
+	if err := validateCPUAffinity(o.CPUAffinity); err != nil {
+		return fmt.Errorf("cpuAffinity: %w", err)
+	}
+	if o.BreakerWarmupSeconds > 3600 {
+		return fmt.Errorf("breakerWarmupSeconds must be at most 3600")
+	}
+	if len(o.FrameProcessors) > 0 {
+		if _, err := parseFrameProcessors(o.FrameProcessors); err != nil {
+			return fmt.Errorf("frameProcessors: %w", err)
+		}
+	}
+	if o.Weight < 0 || o.Weight > maxStreamWeight {
+		return fmt.Errorf("weight must be between 0 and %d", maxStreamWeight)
+	}
+	return nil
+}
+
+// resolveStreamOptions merges request overrides onto the camera's stored options,
+// validates the result, and persists it when the request changed anything
+func resolveStreamOptions(store CameraStore, cameraID string, override StreamOptions) (StreamOptions, error) {
+	options := loadStreamOptions(store, cameraID).Merge(override)
+
+	if err := options.Validate(options.Output.containerFormat()); err != nil {
+		return options, err
+	}
+
+	if !override.IsZero() && store.Available() {
+		if err := store.SaveStreamOptions(cameraID, options); err != nil {
+			log.Printf("Warning: Failed to persist stream options for camera %s: %v", cameraID, err)
+		}
+	}
+	return options, nil
+}
+
+// loadStreamOptions returns the camera's stored options, or the defaults if unavailable
+func loadStreamOptions(store CameraStore, cameraID string) StreamOptions {
+	if !store.Available() {
+		return StreamOptions{}
+	}
+
+	options, err := store.GetStreamOptions(cameraID)
+	if err != nil {
+		log.Printf("Failed to load stream options for camera %s, using defaults: %v", cameraID, err)
+		return StreamOptions{}
+	}
+	return options
+}
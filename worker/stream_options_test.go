@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSourceRetryPolicy(t *testing.T) {
+	defaults := RTSPRetryPolicy{MaxAttempts: 3, InitialDelay: 5 * time.Second, MaxDelay: 5 * time.Minute}
+	saved := streamManagerRetry
+	streamManagerRetry = defaults
+	defer func() { streamManagerRetry = saved }()
+
+	tests := []struct {
+		name    string
+		options *SourceRetryOptions
+		want    RTSPRetryPolicy
+	}{
+		{"unset", nil, defaults},
+		{"zero fields keep defaults", &SourceRetryOptions{}, defaults},
+		{"forever", &SourceRetryOptions{MaxAttempts: -1, MaxDelaySeconds: 600},
+			RTSPRetryPolicy{MaxAttempts: 0, InitialDelay: 5 * time.Second, MaxDelay: 10 * time.Minute}},
+		{"delay past the default cap", &SourceRetryOptions{MaxAttempts: 5, InitialDelaySeconds: 600},
+			RTSPRetryPolicy{MaxAttempts: 5, InitialDelay: 10 * time.Minute, MaxDelay: 10 * time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := tt.options.policy(); got != tt.want {
+			t.Errorf("%s: policy() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []SourceRetryOptions{{MaxAttempts: -2}, {InitialDelaySeconds: -1}, {InitialDelaySeconds: 10, MaxDelaySeconds: 5}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted invalid settings", invalid)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// invalidTimezones remembers rejected label values so each is only logged once
+var (
+	invalidTimezones   = make(map[string]bool)
+	invalidTimezonesMu sync.Mutex
+)
+
+// timezoneLabelKey is the camera label holding its IANA timezone, e.g. "Europe/Berlin"
+func timezoneLabelKey() string {
+	if label := os.Getenv("CAMERA_TIMEZONE_LABEL"); label != "" {
+		return label
+	}
+	return "timezone"
+}
+
+// resolveTimezone validates an IANA timezone name, falling back to UTC when it is
+// empty or unknown
+func resolveTimezone(cameraID, name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(name)
+	if err == nil {
+		return location
+	}
+
+	invalidTimezonesMu.Lock()
+	logged := invalidTimezones[name]
+	invalidTimezones[name] = true
+	invalidTimezonesMu.Unlock()
+	if !logged {
+		log.Printf("Camera %s has invalid timezone %q, using UTC: %v", cameraID, name, err)
+	}
+	return time.UTC
+}
+
+// cameraLocation looks up the camera's timezone label; UTC when unset, invalid or
+// the database is unavailable
+func cameraLocation(cameraID string) *time.Location {
+	if cameraStore == nil || !cameraStore.Available() {
+		return time.UTC
+	}
+	labels, err := cameraStore.GetCameraLabels(cameraID)
+	if err != nil {
+		return time.UTC
+	}
+	return resolveTimezone(cameraID, labels[timezoneLabelKey()])
+}
+
+// localTimestamp formats t in the camera's timezone with its UTC offset
+func localTimestamp(t time.Time, location *time.Location) string {
+	return t.In(location).Format(time.RFC3339Nano)
+}
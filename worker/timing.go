@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TimingConfig holds the waits that used to be hardcoded sleeps. Most are upper
+// bounds on condition-based waits rather than fixed delays.
+type TimingConfig struct {
+	StopConfirmTimeout    time.Duration // Max wait for a stopped stream's MediaMTX path to drop its publisher
+	PathCleanupTimeout    time.Duration // Max wait for a deleted MediaMTX config path to disappear
+	RestoreStartupDelay   time.Duration // Max wait for the MediaMTX API before restoring paths
+	FaceStabilizeDelay    time.Duration // Fixed delay before face detection reads its first frames
+	WatchdogInterval      time.Duration // How often the stream watchdog checks output progress
+	WatchdogStallTimeout  time.Duration // Restart a stream whose output hasn't advanced for this long; 0 disables
+	StateCleanupInterval  time.Duration // How often per-camera maps are swept for stopped cameras
+	BreakerIdleTTL        time.Duration // Closed breakers of cameras not started for this long are dropped
+	FrameStallThreshold   time.Duration // /streams reports a stream STALLED after this long without a frame; 0 disables
+	conditionPollInterval time.Duration
+}
+
+// loadTimingConfig reads the timing overrides from the environment
+func loadTimingConfig() TimingConfig {
+	return TimingConfig{
+		StopConfirmTimeout:    getEnvDuration("STOP_CONFIRM_TIMEOUT", 2*time.Second),
+		PathCleanupTimeout:    getEnvDuration("MEDIAMTX_PATH_CLEANUP_TIMEOUT", 2*time.Second),
+		RestoreStartupDelay:   getEnvDuration("RESTORE_STARTUP_TIMEOUT", 10*time.Second),
+		FaceStabilizeDelay:    getEnvDuration("FACE_DETECTION_STABILIZE_DELAY", 3*time.Second),
+		WatchdogInterval:      getEnvDuration("WATCHDOG_INTERVAL", 10*time.Second),
+		WatchdogStallTimeout:  getEnvDuration("WATCHDOG_STALL_TIMEOUT", 30*time.Second),
+		StateCleanupInterval:  getEnvDuration("STATE_CLEANUP_INTERVAL", 10*time.Minute),
+		BreakerIdleTTL:        getEnvDuration("CIRCUIT_BREAKER_IDLE_TTL", time.Hour),
+		FrameStallThreshold:   getEnvDuration("STREAM_FRAME_STALL_THRESHOLD", 10*time.Second),
+		conditionPollInterval: 100 * time.Millisecond,
+	}
+}
+
+// getEnvDuration parses a Go duration (e.g. "500ms") from the environment
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid %s %q, using %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// waitForCondition polls check until it returns true or timeout elapses
+func waitForCondition(timeout, interval time.Duration, check func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if check() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// mediamtxPathInfo is the subset of a MediaMTX runtime path the worker inspects
+type mediamtxPathInfo struct {
+	Ready         bool   `json:"ready"`
+	BytesReceived uint64 `json:"bytesReceived"`
+}
+
+// getMediaMTXPathState reports whether a runtime path exists and has a ready publisher
+func getMediaMTXPathState(instance *MediaMTXInstance, pathName string) (exists, ready bool, err error) {
+	info, exists, err := getMediaMTXPathInfo(instance, pathName)
+	return exists, info.Ready, err
+}
+
+// getMediaMTXPathInfo fetches a runtime path from the MediaMTX API
+func getMediaMTXPathInfo(instance *MediaMTXInstance, pathName string) (info mediamtxPathInfo, exists bool, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v3/paths/get/%s", instance.apiBaseURL(), pathName), nil)
+	if err != nil {
+		return info, false, err
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := mediamtxDo(client, req)
+	if err != nil {
+		return info, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return info, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return info, false, fmt.Errorf("MediaMTX API returned status %d for path %s", resp.StatusCode, pathName)
+	}
+
+	if err := decodeMediaMTXJSON(resp, &info); err != nil {
+		return info, true, err
+	}
+	return info, true, nil
+}
+
+// waitForStreamStopped waits until the camera's MediaMTX path no longer has a publisher,
+// so a restart doesn't race the old FFmpeg session
+func waitForStreamStopped(instance *MediaMTXInstance, pathName string) {
+	start := time.Now()
+	stopped := waitForCondition(timingConfig.StopConfirmTimeout, timingConfig.conditionPollInterval, func() bool {
+		_, ready, err := getMediaMTXPathState(instance, pathName)
+		return err == nil && !ready
+	})
+	if !stopped {
+		log.Printf("Path %s still has a publisher after %v, continuing anyway", pathName, timingConfig.StopConfirmTimeout)
+		return
+	}
+	log.Printf("Confirmed path %s stopped in %v", pathName, time.Since(start).Round(time.Millisecond))
+}
+
+// waitForPathRemoved waits until a deleted MediaMTX config path is gone
+func waitForPathRemoved(instance *MediaMTXInstance, pathName string) {
+	removed := waitForCondition(timingConfig.PathCleanupTimeout, timingConfig.conditionPollInterval, func() bool {
+		_, exists, err := getMediaMTXPathSource(instance, pathName)
+		return err == nil && !exists
+	})
+	if !removed {
+		log.Printf("MediaMTX path %s still present after %v, continuing anyway", pathName, timingConfig.PathCleanupTimeout)
+	}
+}
+
+// waitForMediaMTXAPI waits until the MediaMTX API answers, up to RestoreStartupDelay
+func waitForMediaMTXAPI() bool {
+	mediamtxAPIURL := os.Getenv("MEDIAMTX_API_URL")
+	if mediamtxAPIURL == "" {
+		mediamtxAPIURL = "http://localhost:9997"
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	return waitForCondition(timingConfig.RestoreStartupDelay, 500*time.Millisecond, func() bool {
+		req, err := http.NewRequest("GET", mediamtxAPIURL+"/v3/paths/list", nil)
+		if err != nil {
+			return false
+		}
+
+		resp, err := mediamtxDo(client, req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+}
+
+// sleepContext sleeps for d or until ctx is done; it reports whether the full delay elapsed
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// Video modes: transcode always re-encodes with libx264; auto ("smart copy") probes the
+// source first and copies its video untouched when WebRTC can already play it
+const (
+	videoModeTranscode = "transcode"
+	videoModeAuto      = "auto"
+	videoModeCopy      = "copy" // Only ever chosen by auto, never configured
+)
+
+// VideoCopyConfig controls the smart copy mode
+type VideoCopyConfig struct {
+	Mode    string // Default for cameras without a videoMode option
+	MaxKbps int    // Sources advertising more than this are transcoded
+}
+
+// videoCopyConfig is loaded at startup; the zero value always transcodes
+var videoCopyConfig VideoCopyConfig
+
+// loadVideoCopyConfig reads VIDEO_MODE and VIDEO_COPY_MAX_KBPS from the environment
+func loadVideoCopyConfig() VideoCopyConfig {
+	config := VideoCopyConfig{
+		Mode:    strings.ToLower(os.Getenv("VIDEO_MODE")),
+		MaxKbps: getEnvInt("VIDEO_COPY_MAX_KBPS", 4000),
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = videoModeTranscode
+	case videoModeTranscode, videoModeAuto:
+	default:
+		log.Printf("Unknown VIDEO_MODE %q, transcoding every camera", config.Mode)
+		config.Mode = videoModeTranscode
+	}
+	if config.MaxKbps <= 0 {
+		config.MaxKbps = 4000
+	}
+	return config
+}
+
+// validateVideoMode checks a camera's videoMode option
+func validateVideoMode(mode string) error {
+	switch strings.ToLower(mode) {
+	case "", videoModeTranscode, videoModeAuto:
+		return nil
+	default:
+		return fmt.Errorf("unsupported videoMode %q (expected transcode or auto)", mode)
+	}
+}
+
+// VideoModeDecision is how a camera's video is handled by its running FFmpeg process,
+// reported in /streams
+type VideoModeDecision struct {
+	Mode   string `json:"mode"`             // transcode | copy
+	Reason string `json:"reason,omitempty"` // Why auto picked the mode
+
+	unsupported    *UnsupportedCodecError // The probe found no video track FFmpeg could read
+	profileLevelID string                 // The copied source's H.264 profile-level-id
+}
+
+// encoderOnlyArgs are the output arguments that only mean something to libx264
+var encoderOnlyArgs = []string{
+	"profile:v", "level", "preset", "tune", "g", "keyint_min", "bf", "refs",
+	"maxrate", "bufsize", "pix_fmt", "x264-params",
+}
+
+// decideVideoMode picks transcode or copy for a camera. Copy needs the auto mode, an
+// RTSP source, no filters or encoding profile, and a DESCRIBE showing H.264 that WebRTC plays as is:
+// baseline or main profile, progressive, no B-frames and no more than
+// VIDEO_COPY_MAX_KBPS when the source advertises a bitrate. Anything the probe can't
+// confirm is transcoded. The DESCRIBE takes one of the camera's source connections, and
+// without a free one the camera is transcoded.
+func decideVideoMode(cameraID, sourceURL string, options StreamOptions) VideoModeDecision {
+	mode := strings.ToLower(options.VideoMode)
+	if mode == "" {
+		mode = videoCopyConfig.Mode
+	}
+	if mode != videoModeAuto {
+		return VideoModeDecision{Mode: videoModeTranscode}
+	}
+	transcode := func(reason string) VideoModeDecision {
+		return VideoModeDecision{Mode: videoModeTranscode, Reason: reason}
+	}
+
+	switch {
+	case isAdapterSource(sourceURL) || isDevSource(sourceURL):
+		return transcode("source is not read over RTSP")
+	case options.Filters.Enabled():
+		return transcode("video filters need decoded frames")
+	case options.Encoding.setsVideo():
+		return transcode("camera has an encoding profile")
+	}
+
+	releaseProbe, err := sourceConnections.TryAcquire(cameraID, sourceConnProbe)
+	if err != nil {
+		return transcode("no free source connection to probe with")
+	}
+	probe := probeRTSPSource(sourceURL, "", "")
+	releaseProbe()
+	switch {
+	case probe.Reachable && probe.AuthOK && !probe.HasVideo:
+		decision := transcode("source has no video track")
+		decision.unsupported = &UnsupportedCodecError{URL: redactedURL(sourceURL), Codecs: probe.Codecs}
+		return decision
+	case probe.Error != "":
+		return transcode("probe failed: " + probe.Error)
+	case !probe.H264:
+		return transcode(fmt.Sprintf("source video is %s, not H.264", probe.VideoCodec))
+	case probe.h264SPS == nil:
+		return transcode("source does not advertise its H.264 SPS")
+	}
+
+	sps := probe.h264SPS
+	if sps.ProfileIdc != 66 && sps.ProfileIdc != 77 {
+		return transcode(fmt.Sprintf("H.264 %s profile", probe.H264Profile))
+	}
+	if !sps.FrameMbsOnlyFlag {
+		return transcode("source is interlaced")
+	}
+	if !h264WithoutBFrames(sps) {
+		return transcode("source may use B-frames")
+	}
+	if probe.BitrateKbps > videoCopyConfig.MaxKbps {
+		return transcode(fmt.Sprintf("source bitrate %dk is above %dk", probe.BitrateKbps, videoCopyConfig.MaxKbps))
+	}
+
+	reason := fmt.Sprintf("H.264 %s without B-frames", probe.H264Profile)
+	if probe.BitrateKbps > 0 {
+		reason += fmt.Sprintf(" at %dk", probe.BitrateKbps)
+	} else {
+		reason += ", bitrate not advertised"
+	}
+	return VideoModeDecision{Mode: videoModeCopy, Reason: reason, profileLevelID: h264ProfileLevelID(sps)}
+}
+
+// h264ProfileLevelID is the SDP profile-level-id of an SPS: profile_idc, the constraint
+// set flags and level_idc, in hex
+func h264ProfileLevelID(sps *h264.SPS) string {
+	flags := 0
+	for i, set := range []bool{sps.ConstraintSet0Flag, sps.ConstraintSet1Flag, sps.ConstraintSet2Flag,
+		sps.ConstraintSet3Flag, sps.ConstraintSet4Flag, sps.ConstraintSet5Flag} {
+		if set {
+			flags |= 0x80 >> i
+		}
+	}
+	return fmt.Sprintf("%02x%02x%02x", sps.ProfileIdc, flags, sps.LevelIdc)
+}
+
+// redactedURL hides a source URL's password for errors returned to API callers
+func redactedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return parsed.Redacted()
+}
+
+// h264WithoutBFrames reports whether the SPS rules out B-frames: baseline has none,
+// otherwise the VUI must promise no reordering or the picture order must follow the
+// decoding order (pic_order_cnt_type 2)
+func h264WithoutBFrames(sps *h264.SPS) bool {
+	if sps.ProfileIdc == 66 {
+		return true
+	}
+	if sps.VUI != nil && sps.VUI.BitstreamRestriction != nil {
+		return sps.VUI.BitstreamRestriction.MaxNumReorderFrames == 0
+	}
+	return sps.PicOrderCntType == 2
+}
+
+// applyVideoCopyArgs turns a transcode's output arguments into a video copy. Without
+// x264's repeat-headers, dump_extra puts the SPS/PPS in-band ahead of every keyframe
+// instead; fMP4 (LL-HLS) keeps them in its init segment.
+func applyVideoCopyArgs(outputArgs ffmpeg.KwArgs, outputType string) {
+	outputArgs["c:v"] = "copy"
+	for _, key := range encoderOnlyArgs {
+		delete(outputArgs, key)
+	}
+	if outputType != outputTypeLLHLS {
+		outputArgs["bsf:v"] = "h264_mp4toannexb,dump_extra=freq=keyframe"
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestDecideVideoModeProbeTakesSourceConnection(t *testing.T) {
+	const cameraID = "cam-probe"
+	options := StreamOptions{VideoMode: videoModeAuto}
+	sourceConnections.SetLimit(cameraID, 1)
+	t.Cleanup(func() { sourceConnections.SetLimit(cameraID, 0) })
+
+	// The camera's only connection is taken, so there's nothing to probe with
+	release, err := sourceConnections.TryAcquire(cameraID, sourceConnReencode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decision := decideVideoMode(cameraID, "rtsp://127.0.0.1:1/stream", options)
+	release()
+	if decision.Mode != videoModeTranscode || decision.Reason != "no free source connection to probe with" {
+		t.Fatalf("with the connection taken: %+v, want transcode without probing", decision)
+	}
+
+	// With it free the probe runs, and gives the slot back afterwards
+	decision = decideVideoMode(cameraID, "rtsp://127.0.0.1:1/stream", options)
+	if !strings.HasPrefix(decision.Reason, "probe failed") {
+		t.Fatalf("with the connection free: %+v, want a failed probe", decision)
+	}
+	if _, holders := sourceConnections.Holders(cameraID); len(holders) != 0 {
+		t.Fatalf("probe left source connections held: %v", holders)
+	}
+}
+
+func TestWHEPProfileLevelID(t *testing.T) {
+	manager := NewRTSPStreamManager("rtsp://camera.test/stream", RTSPRetryPolicy{})
+	tests := []struct {
+		name    string
+		process *ReencodingProcess
+		want    string
+	}{
+		{"default re-encode", &ReencodingProcess{}, "42e01f"},
+		{"main encoding profile", &ReencodingProcess{Options: StreamOptions{Encoding: &EncodingProfile{Profile: "main"}}}, "4d401f"},
+		{"high encoding profile", &ReencodingProcess{Options: StreamOptions{Encoding: &EncodingProfile{Profile: "High"}}}, "64001f"},
+		{"copied source", &ReencodingProcess{VideoMode: VideoModeDecision{Mode: videoModeCopy, profileLevelID: "4d0028"}}, "4d0028"},
+	}
+	for _, tt := range tests {
+		if got := whepProfileLevelID(tt.process, manager); got != tt.want {
+			t.Errorf("%s: profile-level-id %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Once the stream's SPS is in, it wins: baseline, no constraint flags, level 3.0
+	manager.distributeFrame(&rtp.Packet{Payload: testSPS(t, 640, 480)})
+	process := &ReencodingProcess{VideoMode: VideoModeDecision{Mode: videoModeCopy, profileLevelID: "4d0028"}}
+	if got := whepProfileLevelID(process, manager); got != "42001e" {
+		t.Fatalf("with the stream's SPS: profile-level-id %q, want 42001e", got)
+	}
+}
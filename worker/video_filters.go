@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VideoFilterOptions are the per-camera picture fixes applied before encoding. Only
+// these filters can be configured, each built from validated numbers, so a request
+// can't inject arbitrary FFmpeg filters. They always run in the order deinterlace,
+// denoise, crop, scale.
+type VideoFilterOptions struct {
+	// Deinterlace runs yadif: "all" deinterlaces every frame, "interlaced" only frames
+	// the source flags as interlaced
+	Deinterlace string `json:"deinterlace,omitempty"`
+	// Denoise runs hqdn3d at a preset strength: light | medium | strong
+	Denoise string       `json:"denoise,omitempty"`
+	Crop    *CropFilter  `json:"crop,omitempty"`
+	Scale   *ScaleFilter `json:"scale,omitempty"`
+}
+
+// CropFilter cuts a Width x Height rectangle at X,Y out of the source picture
+type CropFilter struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+}
+
+// ScaleFilter resizes the picture; 0 for one side keeps the aspect ratio
+type ScaleFilter struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// maxFilterDimension bounds crop and scale sizes (4K UHD)
+const maxFilterDimension = 3840
+
+// yadifFilters maps deinterlace modes to yadif arguments. Mode 0 outputs one frame
+// per frame, keeping the frame rate the keyframe interval (-g 30) assumes; the field
+// doubling modes are left out for that reason.
+var yadifFilters = map[string]string{
+	"all":        "yadif=mode=0:deint=0",
+	"interlaced": "yadif=mode=0:deint=1",
+}
+
+// hqdn3dFilters maps denoise presets to hqdn3d strengths (luma/chroma spatial, luma/chroma
+// temporal); medium is the filter's default
+var hqdn3dFilters = map[string]string{
+	"light":  "hqdn3d=2:1.5:3:2.25",
+	"medium": "hqdn3d=4:3:6:4.5",
+	"strong": "hqdn3d=8:6:12:9",
+}
+
+// Enabled reports whether any filter is configured
+func (f *VideoFilterOptions) Enabled() bool {
+	return f != nil && (f.Deinterlace != "" || f.Denoise != "" || f.Crop != nil || f.Scale != nil)
+}
+
+// Validate checks every filter is allowed and its numbers are in range. Sizes must be
+// even, since the output is yuv420p.
+func (f *VideoFilterOptions) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if _, known := yadifFilters[strings.ToLower(f.Deinterlace)]; f.Deinterlace != "" && !known {
+		return fmt.Errorf("unsupported deinterlace mode %q (expected all or interlaced)", f.Deinterlace)
+	}
+	if _, known := hqdn3dFilters[strings.ToLower(f.Denoise)]; f.Denoise != "" && !known {
+		return fmt.Errorf("unsupported denoise strength %q (expected light, medium or strong)", f.Denoise)
+	}
+	if crop := f.Crop; crop != nil {
+		if crop.Width < 16 || crop.Width > maxFilterDimension || crop.Height < 16 || crop.Height > maxFilterDimension {
+			return fmt.Errorf("crop size %dx%d out of range (16-%d)", crop.Width, crop.Height, maxFilterDimension)
+		}
+		if crop.Width%2 != 0 || crop.Height%2 != 0 {
+			return fmt.Errorf("crop size %dx%d must be even", crop.Width, crop.Height)
+		}
+		if crop.X < 0 || crop.Y < 0 || crop.X > maxFilterDimension || crop.Y > maxFilterDimension {
+			return fmt.Errorf("crop offset %d,%d out of range (0-%d)", crop.X, crop.Y, maxFilterDimension)
+		}
+	}
+	if scale := f.Scale; scale != nil {
+		if scale.Width == 0 && scale.Height == 0 {
+			return fmt.Errorf("scale needs a width or a height")
+		}
+		for _, side := range []int{scale.Width, scale.Height} {
+			if side != 0 && (side < 16 || side > maxFilterDimension || side%2 != 0) {
+				return fmt.Errorf("scale size %dx%d out of range (even, 16-%d, or 0 to keep the aspect ratio)",
+					scale.Width, scale.Height, maxFilterDimension)
+			}
+		}
+	}
+	return nil
+}
+
+// ffmpegFilter returns the -vf filter chain, empty when no filter is configured
+func (f *VideoFilterOptions) ffmpegFilter() string {
+	if !f.Enabled() {
+		return ""
+	}
+
+	var filters []string
+	if f.Deinterlace != "" {
+		filters = append(filters, yadifFilters[strings.ToLower(f.Deinterlace)])
+	}
+	if f.Denoise != "" {
+		filters = append(filters, hqdn3dFilters[strings.ToLower(f.Denoise)])
+	}
+	if crop := f.Crop; crop != nil {
+		filters = append(filters, fmt.Sprintf("crop=%d:%d:%d:%d", crop.Width, crop.Height, crop.X, crop.Y))
+	}
+	if scale := f.Scale; scale != nil {
+		// -2 keeps the aspect ratio while rounding to the even size yuv420p needs
+		width, height := scale.Width, scale.Height
+		if width == 0 {
+			width = -2
+		}
+		if height == 0 {
+			height = -2
+		}
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", width, height))
+	}
+	return strings.Join(filters, ",")
+}
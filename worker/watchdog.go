@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// watchdogStallTimeout resolves the camera's stall timeout: the per-camera option
+// wins, 0 falls back to WATCHDOG_STALL_TIMEOUT, and a negative value disables it
+func watchdogStallTimeout(options StreamOptions) time.Duration {
+	switch {
+	case options.WatchdogStallSeconds < 0:
+		return 0
+	case options.WatchdogStallSeconds > 0:
+		return time.Duration(options.WatchdogStallSeconds) * time.Second
+	default:
+		return timingConfig.WatchdogStallTimeout
+	}
+}
+
+// outputProgress returns a counter that advances while the output receives media:
+// MediaMTX's bytesReceived for RTSP, the playlist's modification time for HLS.
+// ok is false when progress can't be observed right now or at all (SRT).
+func outputProgress(cameraID string, output OutputTarget, mediamtx *MediaMTXInstance) (value int64, ok bool) {
+	switch output.Type() {
+	case outputTypeRTSP:
+		info, exists, err := getMediaMTXPathInfo(mediamtx, cameraPathName(cameraID))
+		if err != nil || !exists {
+			return 0, false
+		}
+		return int64(info.BytesReceived), true
+	case outputTypeHLS, outputTypeLLHLS:
+		stat, err := os.Stat(output.URL())
+		if err != nil {
+			return 0, false
+		}
+		return stat.ModTime().UnixNano(), true
+	default:
+		return 0, false
+	}
+}
+
+// runOutputActivity stands in for FFmpeg's progress reports when their pipe couldn't be
+// opened, moving LastFrameTime forward whenever the output advances so the stall checks
+// in /streams and /health/streams don't flag a live stream. It polls every
+// WATCHDOG_INTERVAL, so LastFrameTime is only that precise.
+func runOutputActivity(ctx context.Context, process *ReencodingProcess, metrics *StreamMetrics) {
+	if process.Output.Type() == outputTypeSRT {
+		return // Nothing to observe
+	}
+
+	ticker := time.NewTicker(timingConfig.WatchdogInterval)
+	defer ticker.Stop()
+
+	var lastValue int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		value, ok := outputProgress(process.CameraID, process.Output, process.Options.MediaMTX)
+		if !ok || value == lastValue {
+			continue
+		}
+		lastValue = value
+		streamMetricsMutex.Lock()
+		metrics.LastFrameTime = time.Now()
+		streamMetricsMutex.Unlock()
+	}
+}
+
+// runStreamWatchdog restarts a stream whose output stops advancing while FFmpeg is
+// still alive, e.g. a source trickling data but producing a frozen image. Killing
+// FFmpeg hands recovery to the process monitor's normal auto-restart path.
+func runStreamWatchdog(ctx context.Context, process *ReencodingProcess) {
+	stallTimeout := watchdogStallTimeout(process.Options)
+	if stallTimeout <= 0 || process.Output.Type() == outputTypeSRT {
+		return
+	}
+
+	ticker := time.NewTicker(timingConfig.WatchdogInterval)
+	defer ticker.Stop()
+
+	var lastValue int64
+	lastChange := time.Now() // Startup counts as progress so slow sources get a full timeout
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		value, ok := outputProgress(process.CameraID, process.Output, process.Options.MediaMTX)
+		if !ok {
+			// Can't tell whether media is flowing; don't count it as a stall
+			lastChange = time.Now()
+			continue
+		}
+		if value != lastValue {
+			lastValue, lastChange = value, time.Now()
+			continue
+		}
+
+		stalled := time.Since(lastChange)
+		if stalled < stallTimeout {
+			continue
+		}
+
+		processMutex.RLock()
+		current := activeProcesses[process.CameraID]
+		processMutex.RUnlock()
+		if current != process || ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Watchdog: %s output for camera %s has not advanced for %v, restarting FFmpeg",
+			process.Output.Type(), process.CameraID, stalled.Round(time.Second))
+		streamEvents.Publish(StreamEvent{
+			Type:     streamEventStalled,
+			CameraID: process.CameraID,
+			Reason:   fmt.Sprintf("output stalled for %v while FFmpeg was running", stalled.Round(time.Second)),
+			Details: map[string]interface{}{
+				"output":       process.Output.Type(),
+				"stallTimeout": stallTimeout.String(),
+			},
+		})
+		if process.Command != nil && process.Command.Process != nil {
+			if err := process.Command.Process.Kill(); err != nil {
+				log.Printf("Watchdog: failed to kill FFmpeg for camera %s: %v", process.CameraID, err)
+			}
+		}
+		return
+	}
+}
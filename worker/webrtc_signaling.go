@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// sdpContentType is the only body type WHEP and WHIP exchange
+	sdpContentType = "application/sdp"
+	// iceGatherTimeout bounds waiting for candidates; WHEP answers carry all of them
+	// since the worker doesn't support trickle ICE
+	iceGatherTimeout   = 5 * time.Second
+	whipRequestTimeout = 10 * time.Second
+	maxSDPBytes        = 64 << 10
+	// A WHIP session is dropped once MediaMTX's path has no publisher: checked every
+	// whipWatchInterval, and allowed whipConnectTimeout for the encoder's ICE to connect
+	whipWatchInterval  = 5 * time.Second
+	whipConnectTimeout = 30 * time.Second
+)
+
+// WebRTCSignalingConfig controls the WHEP and WHIP endpoints. WHIP offers are
+// forwarded to the camera's MediaMTX instance's WebRTC listener.
+type WebRTCSignalingConfig struct {
+	MaxWHEPSessions int
+}
+
+var webrtcSignaling = WebRTCSignalingConfig{MaxWHEPSessions: 50}
+
+// loadWebRTCSignalingConfig reads WHEP_MAX_SESSIONS
+func loadWebRTCSignalingConfig() WebRTCSignalingConfig {
+	return WebRTCSignalingConfig{
+		MaxWHEPSessions: getEnvInt("WHEP_MAX_SESSIONS", 50),
+	}
+}
+
+// errNotSDP is returned for an offer that isn't sent as application/sdp
+var errNotSDP = errors.New("offer must be sent with Content-Type application/sdp")
+
+// readSDPOffer reads a WHEP/WHIP request's SDP offer
+func readSDPOffer(req *http.Request) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != sdpContentType {
+		return "", errNotSDP
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxSDPBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read offer: %w", err)
+	}
+	if len(body) > maxSDPBytes {
+		return "", fmt.Errorf("offer is larger than %d bytes", maxSDPBytes)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", fmt.Errorf("offer is empty")
+	}
+	return string(body), nil
+}
+
+// ErrWHEPSessionLimit is returned when WHEP_MAX_SESSIONS viewers are already connected
+var ErrWHEPSessionLimit = errors.New("too many WHEP sessions")
+
+// ErrWHIPPublishing is returned when re-encoding a camera an encoder publishes over WHIP
+var ErrWHIPPublishing = errors.New("camera is being published over WHIP")
+
+// WHEPSession is one viewer playing a camera through the worker's own PeerConnection.
+// Frames come from an RTSPStreamManager reading the camera's re-encoded MediaMTX
+// output, so they are H.264 without B-frames.
+type WHEPSession struct {
+	ID        string    `json:"id"`
+	CameraID  string    `json:"cameraId"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	sourceURL string
+	pc        *webrtc.PeerConnection
+	manager   *RTSPStreamManager
+	streamer  *WebRTCStreamer
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// WHIPSession is an external encoder publishing into a camera's path. The worker
+// forwards the offer to MediaMTX's WHIP endpoint and keeps the MediaMTX resource so a
+// DELETE on the worker's resource tears it down.
+type WHIPSession struct {
+	ID        string    `json:"id"`
+	CameraID  string    `json:"cameraId"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	mediamtx    *MediaMTXInstance // Instance the encoder publishes to
+	resourceURL string            // MediaMTX's session resource
+	closeOnce   sync.Once
+	done        chan struct{}
+}
+
+// Signaling sessions by ID. whepPending counts WHEP sessions that passed the
+// WHEP_MAX_SESSIONS check and are still being set up.
+var (
+	whepSessions      = make(map[string]*WHEPSession)
+	whepPending       int
+	whipSessions      = make(map[string]*WHIPSession)
+	webrtcSessionsMtx sync.Mutex
+)
+
+// whepSourceURL returns the RTSP URL a WHEP viewer of the camera reads: its re-encoded
+// output on MediaMTX. The camera must be running with rtsp output.
+func whepSourceURL(cameraID string) (*ReencodingProcess, string, error) {
+	processMutex.RLock()
+	process, exists := activeProcesses[cameraID]
+	processMutex.RUnlock()
+	if !exists {
+		return nil, "", fmt.Errorf("camera %s is not streaming", cameraID)
+	}
+	if outputType := process.Output.Type(); outputType != outputTypeRTSP {
+		return nil, "", fmt.Errorf("camera %s publishes %s output; WHEP needs rtsp output", cameraID, outputType)
+	}
+	return process, getReencodedStreamURL(process.Options.MediaMTX, cameraID), nil
+}
+
+// whepProfileLevelID is the profile-level-id to offer for the camera's video: the one
+// in the stream's SPS once the manager has it, otherwise the copied source's or the
+// camera's encoding profile's
+func whepProfileLevelID(process *ReencodingProcess, manager *RTSPStreamManager) string {
+	if profileLevelID, ok := manager.ProfileLevelID(); ok {
+		return profileLevelID
+	}
+	if process.VideoMode.Mode == videoModeCopy && process.VideoMode.profileLevelID != "" {
+		return process.VideoMode.profileLevelID
+	}
+	return process.Options.Encoding.profileLevelID()
+}
+
+// startWHEPSession answers a viewer's SDP offer with a send-only H.264 track fed from
+// the camera's stream. The answer includes every ICE candidate.
+func startWHEPSession(process *ReencodingProcess, sourceURL, offer string) (*WHEPSession, string, error) {
+	// Reserve the slot under the same lock as the check, so concurrent offers can't
+	// exceed the limit; the reservation turns into the session or is given back
+	webrtcSessionsMtx.Lock()
+	if len(whepSessions)+whepPending >= webrtcSignaling.MaxWHEPSessions {
+		webrtcSessionsMtx.Unlock()
+		return nil, "", ErrWHEPSessionLimit
+	}
+	whepPending++
+	webrtcSessionsMtx.Unlock()
+	registered := false
+	defer func() {
+		if !registered {
+			webrtcSessionsMtx.Lock()
+			whepPending--
+			webrtcSessionsMtx.Unlock()
+		}
+	}()
+
+	manager, err := GetOrCreateStreamManagerWithPolicy(sourceURL, process.Options.SourceRetry.policy())
+	if manager == nil {
+		return nil, "", fmt.Errorf("failed to read camera stream: %w", err)
+	}
+	if err != nil {
+		log.Printf("WHEP: camera %s stream not connected yet, the viewer will get frames once it is: %v", process.CameraID, err)
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: iceConfig.Servers()})
+	if err != nil {
+		CleanupStreamManager(sourceURL)
+		return nil, "", fmt.Errorf("failed to create peer connection: %w", err)
+	}
+
+	session := &WHEPSession{
+		ID:        newEventID(),
+		CameraID:  process.CameraID,
+		CreatedAt: time.Now(),
+		sourceURL: sourceURL,
+		pc:        pc,
+		manager:   manager,
+		done:      make(chan struct{}),
+	}
+	fail := func(format string, err error) (*WHEPSession, string, error) {
+		pc.Close()
+		CleanupStreamManager(sourceURL)
+		return nil, "", fmt.Errorf(format, err)
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + whepProfileLevelID(process, manager),
+	}, "video", cameraPathName(process.CameraID))
+	if err != nil {
+		return fail("failed to create video track: %w", err)
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		return fail("failed to add video track: %w", err)
+	}
+
+	// Forward audio when the camera's output carries a codec browsers decode
+	var audioTrack *webrtc.TrackLocalStaticRTP
+	var audioSender *webrtc.RTPSender
+	if audioFormat := manager.AudioFormat(); audioFormat != nil {
+		if codec, ok := webrtcAudioCodec(audioFormat); ok {
+			audioTrack, err = webrtc.NewTrackLocalStaticRTP(codec, "audio", cameraPathName(process.CameraID))
+			if err != nil {
+				return fail("failed to create audio track: %w", err)
+			}
+			if audioSender, err = pc.AddTrack(audioTrack); err != nil {
+				return fail("failed to add audio track: %w", err)
+			}
+		} else {
+			log.Printf("WHEP: camera %s audio is %s, which browsers can't decode; sending video only", process.CameraID, audioFormat.Codec())
+		}
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return fail("invalid SDP offer: %w", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fail("failed to create SDP answer: %w", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fail("failed to set SDP answer: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-time.After(iceGatherTimeout):
+		log.Printf("WHEP: ICE gathering for camera %s not complete after %v, answering with the candidates so far", process.CameraID, iceGatherTimeout)
+	}
+
+	// RTCP has to be read for the interceptors (NACK, reports) to run
+	for _, rtcpSender := range []*webrtc.RTPSender{sender, audioSender} {
+		if rtcpSender == nil {
+			continue
+		}
+		go func() {
+			for {
+				if _, _, err := rtcpSender.ReadRTCP(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			session.Close(fmt.Sprintf("peer connection %s", state))
+		}
+	})
+
+	if audioTrack != nil {
+		video, audio := manager.SubscribeWithAudio("whep-" + session.ID)
+		session.streamer = NewWebRTCStreamerForSender(sender, track, video)
+		session.streamer.AttachAudio(audioSender, audioTrack, audio)
+	} else {
+		session.streamer = NewWebRTCStreamerForSender(sender, track, manager.Subscribe("whep-"+session.ID))
+	}
+	session.streamer.Start()
+
+	webrtcSessionsMtx.Lock()
+	whepSessions[session.ID] = session
+	whepPending--
+	registered = true
+	webrtcSessionsMtx.Unlock()
+
+	go session.followCamera(process)
+	go session.followSource()
+
+	log.Printf("WHEP session %s started for camera %s", session.ID, session.CameraID)
+	return session, pc.LocalDescription().SDP, nil
+}
+
+// followSource ends the session when its stream manager closes the frame channel,
+// giving the manager's close reason, e.g. that it ran out of reconnect attempts
+func (s *WHEPSession) followSource() {
+	select {
+	case <-s.done:
+	case <-s.streamer.Done():
+		reason := "stream source closed"
+		if err := s.manager.CloseReason(); err != nil {
+			reason = err.Error()
+		}
+		s.Close(reason)
+	}
+}
+
+// followCamera ends the session when the camera stops. A restart (new bitrate, audio
+// schedule, watchdog) replaces the process but keeps the MediaMTX path, and the stream
+// manager reconnects to it, so the session carries on with the replacement.
+func (s *WHEPSession) followCamera(process *ReencodingProcess) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-process.Context.Done():
+		}
+
+		// Give a restart time to store its replacement
+		time.Sleep(time.Second)
+		processMutex.RLock()
+		replacement, exists := activeProcesses[s.CameraID]
+		processMutex.RUnlock()
+		if !exists || replacement == process {
+			s.Close("camera stopped")
+			return
+		}
+		process = replacement
+	}
+}
+
+// Close tears the session down; safe to call more than once
+func (s *WHEPSession) Close(reason string) {
+	s.closeOnce.Do(func() {
+		webrtcSessionsMtx.Lock()
+		delete(whepSessions, s.ID)
+		webrtcSessionsMtx.Unlock()
+
+		if s.streamer != nil {
+			s.streamer.Stop()
+		}
+		s.manager.Unsubscribe("whep-" + s.ID)
+		if err := s.pc.Close(); err != nil {
+			log.Printf("WHEP: failed to close peer connection of session %s: %v", s.ID, err)
+		}
+		CleanupStreamManager(s.sourceURL)
+		close(s.done)
+		log.Printf("WHEP session %s for camera %s closed: %s", s.ID, s.CameraID, reason)
+	})
+}
+
+// WHIPUpstreamError is a MediaMTX refusal of a forwarded WHIP request
+type WHIPUpstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *WHIPUpstreamError) Error() string {
+	return fmt.Sprintf("MediaMTX WHIP endpoint returned status %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// startWHIPSession forwards an encoder's offer to MediaMTX's WHIP endpoint for the
+// camera's path on instance and returns MediaMTX's answer
+func startWHIPSession(instance *MediaMTXInstance, cameraID, offer string) (*WHIPSession, string, error) {
+	whipURL := fmt.Sprintf("%s/%s/whip", instance.webrtcBaseURL(), cameraPathName(cameraID))
+	req, err := http.NewRequest(http.MethodPost, whipURL, bytes.NewReader([]byte(offer)))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", sdpContentType)
+
+	resp, err := mediamtxDo(&http.Client{Timeout: whipRequestTimeout}, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to reach MediaMTX WHIP endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, "", &WHIPUpstreamError{StatusCode: resp.StatusCode, Body: mediamtxErrorBody(resp)}
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return nil, "", fmt.Errorf("MediaMTX WHIP response has no session Location: %w", err)
+	}
+	answer, err := readMediaMTXBody(resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := &WHIPSession{
+		ID:          newEventID(),
+		CameraID:    cameraID,
+		CreatedAt:   time.Now(),
+		mediamtx:    instance,
+		resourceURL: location.String(),
+		done:        make(chan struct{}),
+	}
+	webrtcSessionsMtx.Lock()
+	whipSessions[session.ID] = session
+	webrtcSessionsMtx.Unlock()
+	go session.watch()
+
+	log.Printf("WHIP session %s started for camera %s", session.ID, cameraID)
+	return session, string(answer), nil
+}
+
+// watch forgets the session once MediaMTX's path has lost its publisher: the encoder
+// disconnected, or its PeerConnection failed or closed, without a DELETE
+func (s *WHIPSession) watch() {
+	pathName := cameraPathName(s.CameraID)
+	ticker := time.NewTicker(whipWatchInterval)
+	defer ticker.Stop()
+	published := false
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		_, ready, err := getMediaMTXPathState(s.mediamtx, pathName)
+		if err != nil {
+			continue // MediaMTX unreachable; keep the session until it answers
+		}
+		if ready {
+			published = true
+			continue
+		}
+		if published || time.Since(s.CreatedAt) > whipConnectTimeout {
+			s.forget()
+			log.Printf("WHIP session %s for camera %s closed: publisher disconnected", s.ID, s.CameraID)
+			return
+		}
+	}
+}
+
+// forget removes the session from the map and stops its watch; safe to call more than once
+func (s *WHIPSession) forget() {
+	s.closeOnce.Do(func() {
+		webrtcSessionsMtx.Lock()
+		delete(whipSessions, s.ID)
+		webrtcSessionsMtx.Unlock()
+		close(s.done)
+	})
+}
+
+// Close deletes the MediaMTX session, which disconnects the encoder
+func (s *WHIPSession) Close() error {
+	s.forget()
+
+	req, err := http.NewRequest(http.MethodDelete, s.resourceURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := mediamtxDo(&http.Client{Timeout: whipRequestTimeout}, req)
+	if err != nil {
+		return fmt.Errorf("failed to reach MediaMTX WHIP endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	// MediaMTX forgets the session once the encoder disconnects on its own
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return &WHIPUpstreamError{StatusCode: resp.StatusCode, Body: mediamtxErrorBody(resp)}
+	}
+	log.Printf("WHIP session %s for camera %s closed", s.ID, s.CameraID)
+	return nil
+}
+
+// lookupWHEPSession returns a camera's WHEP session by ID
+func lookupWHEPSession(cameraID, sessionID string) (*WHEPSession, bool) {
+	webrtcSessionsMtx.Lock()
+	defer webrtcSessionsMtx.Unlock()
+	session, exists := whepSessions[sessionID]
+	return session, exists && session.CameraID == cameraID
+}
+
+// lookupWHIPSession returns a camera's WHIP session by ID
+func lookupWHIPSession(cameraID, sessionID string) (*WHIPSession, bool) {
+	webrtcSessionsMtx.Lock()
+	defer webrtcSessionsMtx.Unlock()
+	session, exists := whipSessions[sessionID]
+	return session, exists && session.CameraID == cameraID
+}
+
+// hasWHIPSession reports whether an encoder is publishing into the camera's path
+func hasWHIPSession(cameraID string) bool {
+	webrtcSessionsMtx.Lock()
+	defer webrtcSessionsMtx.Unlock()
+	for _, session := range whipSessions {
+		if session.CameraID == cameraID {
+			return true
+		}
+	}
+	return false
+}
+
+// WebRTCSessionStats lists the signaling sessions for /metrics
+type WebRTCSessionStats struct {
+	WHEP []*WHEPSession `json:"whep"`
+	WHIP []*WHIPSession `json:"whip"`
+}
+
+// webrtcSessionStats returns the open sessions, oldest first
+func webrtcSessionStats() WebRTCSessionStats {
+	webrtcSessionsMtx.Lock()
+	defer webrtcSessionsMtx.Unlock()
+
+	stats := WebRTCSessionStats{
+		WHEP: make([]*WHEPSession, 0, len(whepSessions)),
+		WHIP: make([]*WHIPSession, 0, len(whipSessions)),
+	}
+	for _, session := range whepSessions {
+		stats.WHEP = append(stats.WHEP, session)
+	}
+	for _, session := range whipSessions {
+		stats.WHIP = append(stats.WHIP, session)
+	}
+	sort.Slice(stats.WHEP, func(i, j int) bool { return stats.WHEP[i].CreatedAt.Before(stats.WHEP[j].CreatedAt) })
+	sort.Slice(stats.WHIP, func(i, j int) bool { return stats.WHIP[i].CreatedAt.Before(stats.WHIP[j].CreatedAt) })
+	return stats
+}
+
+// closeWebRTCSessions ends every signaling session, on shutdown
+func closeWebRTCSessions() {
+	stats := webrtcSessionStats()
+	for _, session := range stats.WHEP {
+		session.Close("worker shutting down")
+	}
+	for _, session := range stats.WHIP {
+		if err := session.Close(); err != nil {
+			log.Printf("Failed to close WHIP session %s: %v", session.ID, err)
+		}
+	}
+}